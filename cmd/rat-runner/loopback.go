@@ -0,0 +1,184 @@
+package main
+
+import (
+    "net"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+    "github.com/Aanthord/remy-go/pkg/rat"
+)
+
+// echoServer is a receiver on loopback that sends every packet back to its sender
+// Packets pass through an emulated bottleneck link and propagation delay on the way, the same models the
+// simulator uses, so that senders on one machine see a real queue and round-trip time. A packet the link drops is
+// answered with a loss report instead, one round-trip time later, so that it does not hold its sender's window.
+type echoServer struct {
+    listener *net.TCPListener
+    wake     chan struct{} // Signals the scheduler that a packet was enqueued
+
+    mu      sync.Mutex
+    link    *network.Link
+    delay   *network.Delay
+    origins map[*network.Packet]echoOrigin // Connection and contents of every packet in the link or the delay
+    losses  []echoLoss                     // Dropped packets whose loss reports are not yet due
+}
+
+// echoLoss is a dropped packet and the time its loss report is due
+type echoLoss struct {
+    at     time.Time
+    origin echoOrigin
+}
+
+// echoOrigin is where a packet came from and what it contained
+type echoOrigin struct {
+    conn   *net.TCPConn
+    packet *rat.Packet
+}
+
+// answer sends the frame that answers a packet back to its sender
+func (o echoOrigin) answer(flags uint8) error {
+    return rat.SendFrame(o.conn, &rat.Frame{
+        Flags:  flags,
+        Packet: rat.Packet{SeqNo: o.packet.SeqNo, ID: o.packet.ID, FlowID: o.packet.FlowID, Sent: time.Now()},
+        Echo:   o.packet.Sent,
+    })
+}
+
+// newEchoServer starts an echo server listening on a free loopback port
+func newEchoServer(linkPPT, rtt float64, buffer int) (*echoServer, error) {
+    delay := time.Duration(rtt * float64(time.Millisecond))
+    listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        return nil, err
+    }
+    s := &echoServer{
+        listener: listener,
+        wake:     make(chan struct{}, 1),
+        link:     network.NewLink(linkPPT, buffer),
+        delay:    network.NewDelay(delay),
+        origins:  make(map[*network.Packet]echoOrigin),
+    }
+    s.link.OnDrop = func(event network.Event) {
+        // Called with mu held, from Enqueue
+        s.losses = append(s.losses, echoLoss{at: event.Time.Add(delay), origin: s.origins[event.Packet]})
+        delete(s.origins, event.Packet)
+    }
+    go s.accept()
+    go s.schedule()
+    return s, nil
+}
+
+// Addr returns the address the server listens on
+func (s *echoServer) Addr() *net.TCPAddr {
+    return s.listener.Addr().(*net.TCPAddr)
+}
+
+// Close stops accepting connections
+func (s *echoServer) Close() error {
+    return s.listener.Close()
+}
+
+// Dropped returns the number of packets the emulated link has dropped
+func (s *echoServer) Dropped() uint {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.link.Dropped()
+}
+
+// accept serves every connection made to the server until it is closed
+func (s *echoServer) accept() {
+    for {
+        conn, err := s.listener.AcceptTCP()
+        if err != nil {
+            return
+        }
+        conn.SetNoDelay(true)
+        go s.serve(conn)
+    }
+}
+
+// serve reads the packets of a connection and puts them on the link
+func (s *echoServer) serve(conn *net.TCPConn) {
+    defer conn.Close()
+    decoder := rat.NewDecoder(conn)
+    // Packets carry no payload, so a corrupt length never holds up the stream
+    decoder.SetMaxPayload(0)
+    for {
+        frame, err := decoder.Decode()
+        if err != nil {
+            return
+        }
+        packet := &frame.Packet
+
+        queued := &network.Packet{SeqNo: packet.SeqNo, Sender: packet.ID, FlowID: packet.FlowID, Sent: packet.Sent}
+        s.mu.Lock()
+        s.origins[queued] = echoOrigin{conn: conn, packet: packet}
+        s.link.Enqueue(queued, time.Now())
+        s.mu.Unlock()
+
+        select {
+        case s.wake <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// schedule moves packets from the link to the delay and echoes them once they leave the delay
+// An echo carries the send time of the packet it answers, from which the sender measures the round-trip time
+func (s *echoServer) schedule() {
+    timer := time.NewTimer(time.Hour)
+    for {
+        s.mu.Lock()
+        now := time.Now()
+        packets, departures := s.link.Dequeue(now)
+        for i, packet := range packets {
+            s.delay.Accept(packet, departures[i])
+        }
+        var echoes, lost []echoOrigin
+        for _, packet := range s.delay.Release(now) {
+            echoes = append(echoes, s.origins[packet])
+            delete(s.origins, packet)
+        }
+        pending := s.losses[:0]
+        for _, loss := range s.losses {
+            if loss.at.After(now) {
+                pending = append(pending, loss)
+            } else {
+                lost = append(lost, loss.origin)
+            }
+        }
+        s.losses = pending
+        next, ok := s.delay.NextRelease()
+        if departure, queued := s.link.NextDeparture(); queued && (!ok || departure.Before(next)) {
+            next, ok = departure, true
+        }
+        for _, loss := range s.losses {
+            if !ok || loss.at.Before(next) {
+                next, ok = loss.at, true
+            }
+        }
+        s.mu.Unlock()
+
+        // A sender that has gone away only loses its own echoes
+        for _, echo := range echoes {
+            echo.answer(rat.FlagEcho)
+        }
+        for _, echo := range lost {
+            echo.answer(rat.FlagEcho | rat.FlagLost)
+        }
+
+        wait := time.Hour
+        if ok {
+            wait = time.Until(next)
+        }
+        timer.Reset(wait)
+        select {
+        case <-timer.C:
+        case <-s.wake:
+            if !timer.Stop() {
+                <-timer.C
+            }
+        }
+    }
+}
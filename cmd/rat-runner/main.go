@@ -1,34 +1,65 @@
 package main
 
 import (
+    "context"
     "flag"
     "fmt"
-    "net"
+    "net/http"
+    "os"
     "strconv"
     "sync"
+    "time"
 
+    "github.com/Aanthord/remy-go/pkg/metrics"
+    "github.com/Aanthord/remy-go/pkg/network"
     "github.com/Aanthord/remy-go/pkg/rat"
     "github.com/Aanthord/remy-go/pkg/whisker"
 )
 
 var (
-    whiskersFile   = flag.String("if", "", "Path to the file containing the pre-trained WhiskerTree")
+    whiskersFile   = flag.String("if", "", "Path to the file containing the pre-trained WhiskerTree (binary, JSON or text format)")
     linkPPTString  = flag.String("link", "1.0", "Link packets per millisecond")
     rttString      = flag.String("rtt", "150.0", "Round-trip time in milliseconds")
     numSendersInt  = flag.Int("nsrc", 8, "Maximum number of senders")
     meanOnDuration = flag.Float64("on", 5000.0, "Mean on duration in milliseconds")
     meanOffDuration = flag.Float64("off", 5000.0, "Mean off duration in milliseconds")
+    strictTree      = flag.Bool("strict", false, "Refuse whisker trees that fail validation")
+    decisionsFile   = flag.String("decisions", "", "Path to write a trace of every whisker lookup (one record per flow per batch of ACKs, with the last acknowledged sequence number)")
+    decisionsFormat = flag.String("decisions-format", "json", "Format of the decision trace (json or proto)")
+    dumpFile        = flag.String("dump", "", "Path to write the loaded WhiskerTree, in the format implied by its extension")
+    watchInterval   = flag.Duration("watch", 0, "Interval at which to check the whiskers file and reload it when it changes, refusing trees that fail validation, or 0 to never reload")
+    probation       = flag.Duration("probation", 10*time.Second, "Time a reloaded tree runs before its health is checked")
+    maxLossIncrease = flag.Float64("max-loss-increase", 0.05, "Rise in loss rate after a reload that rolls it back")
+    bufferInt       = flag.Int("buffer", 0, "Buffer of the emulated link in packets, or 0 for an unlimited buffer")
+    duration        = flag.Duration("duration", 30*time.Second, "How long the senders run")
+    delta           = flag.Float64("delta", 1.0, "Weight of delay against throughput in the utility")
+    metricsAddr     = flag.String("metrics", "", "Address to serve Prometheus metrics of the RAT on at /metrics, such as localhost:9100")
 )
 
 func main() {
     flag.Parse()
 
     // Load whiskers from the specified file
-    whiskerTree, err := whisker.LoadWhiskers(*whiskersFile)
+    whiskerTree, issues, err := whisker.LoadWhiskersWithOptions(*whiskersFile, whisker.LoadOptions{
+        Strict:     *strictTree,
+        Validation: whisker.DefaultValidationOptions(),
+    })
     if err != nil {
         fmt.Printf("Error loading whiskers: %v\n", err)
         return
     }
+    fmt.Println(whisker.MetadataString(whiskerTree.Metadata))
+    for _, issue := range issues {
+        fmt.Printf("Warning: %v\n", issue)
+    }
+
+    // Write the tree as it was loaded if requested
+    if *dumpFile != "" {
+        if err := whisker.SaveTree(whiskerTree, *dumpFile); err != nil {
+            fmt.Printf("Error writing whiskers: %v\n", err)
+            return
+        }
+    }
 
     // Parse the link packets per millisecond
     linkPPT, err := strconv.ParseFloat(*linkPPTString, 64)
@@ -47,101 +78,90 @@ func main() {
     // Create a new RAT congestion controller with the loaded whiskers
     ratController := rat.NewRAT(whiskerTree, false)
 
-    // Start the RAT congestion controller
-    if err := ratController.Start(); err != nil {
-        fmt.Printf("Error starting RAT congestion controller: %v\n", err)
-        return
+    // Record every whisker decision if requested
+    if *decisionsFile != "" {
+        f, err := os.Create(*decisionsFile)
+        if err != nil {
+            fmt.Printf("Error creating decision log: %v\n", err)
+            return
+        }
+        defer f.Close()
+
+        var decisionLog rat.DecisionLog
+        switch *decisionsFormat {
+        case "json":
+            decisionLog = rat.NewJSONDecisionLog(f)
+        case "proto":
+            decisionLog = rat.NewProtoDecisionLog(f)
+        default:
+            fmt.Printf("Unknown decision log format: %s\n", *decisionsFormat)
+            return
+        }
+        defer decisionLog.Flush()
+        ratController.SetDecisionLog(decisionLog)
+    }
+
+    // Serve the RAT's metrics if requested
+    if *metricsAddr != "" {
+        registry := metrics.NewRegistry()
+        registry.Register(metrics.NewRATCollector("rat-runner", ratController))
+        go func() {
+            if err := http.ListenAndServe(*metricsAddr, registry.Handler()); err != nil {
+                fmt.Printf("Error serving metrics: %v\n", err)
+            }
+        }()
+    }
+
+    // Swap in the whiskers file whenever it changes, refusing trees that fail validation and rolling back trees
+    // that raise the loss rate
+    if *watchInterval > 0 {
+        reloader := rat.NewReloader(ratController, rat.LossRateCheck(*maxLossIncrease), *probation, whisker.LoadOptions{
+            Strict:     true,
+            Validation: whisker.DefaultValidationOptions(),
+        })
+        go reloader.Watch(context.Background(), *whiskersFile, *watchInterval, func(err error) {
+            fmt.Printf("Error reloading whiskers: %v\n", err)
+        })
     }
 
-    // Create a TCP connection for sending and receiving packets
-    conn, err := net.Dial("tcp", ":0")
+    // Start a receiver on loopback that echoes every packet through an emulated bottleneck
+    server, err := newEchoServer(linkPPT, rtt, *bufferInt)
     if err != nil {
-        fmt.Printf("Error creating TCP connection: %v\n", err)
+        fmt.Printf("Error starting loopback receiver: %v\n", err)
         return
     }
-    defer conn.Close()
+    defer server.Close()
 
-    // Create a WaitGroup to wait for all senders to finish
+    // Run the senders, each on its own connection, for the requested duration
+    stop := make(chan struct{})
+    senders := make([]*sender, *numSendersInt)
     var wg sync.WaitGroup
-
-    // Create senders
-    for i := 0; i < *numSendersInt; i++ {
+    for i := range senders {
+        senders[i] = newSender(i, ratController, *meanOnDuration, *meanOffDuration)
         wg.Add(1)
-        go runSender(i, ratController, conn, &wg, float64(linkPPT), float64(rtt), *meanOnDuration, *meanOffDuration)
+        go func(s *sender) {
+            defer wg.Done()
+            if err := s.run(server.Addr(), stop); err != nil {
+                fmt.Printf("Error running sender %d: %v\n", s.id, err)
+            }
+        }(senders[i])
     }
-
-    // Create a receiver
-    go runReceiver(ratController, conn)
-
-    // Wait for all senders to finish
+    time.Sleep(*duration)
+    close(stop)
     wg.Wait()
-}
-
-// runSender simulates a sender
-func runSender(id int, ratController *rat.RAT, conn net.Conn, wg *sync.WaitGroup, linkPPT, rtt, meanOnDuration, meanOffDuration float64) {
-    defer wg.Done()
-
-    // Initialize sender state
-    numPacketsSent := 0
-    numPacketsReceived := 0
-    onDuration := 0.0
-    offDuration := 0.0
-    isSending := true
-
-    for {
-        // Check if it's time to send a packet
-        if isSending {
-            // Create a packet and send it
-            packet := &rat.Packet{
-                SeqNo: numPacketsSent, // Set the sequence number
-                ID:    id,             // Set the sender ID
-            }
-            if err := ratController.Send(packet.ID, conn.(*net.TCPConn), packet.SeqNo, uint(numPacketsSent+1)); err != nil {
-                fmt.Printf("Error sending packet: %v\n", err)
-                return
-            }
-            numPacketsSent++
-        }
-
-        // Receive packets
-        packet, err := rat.ReceivePacket(conn.(*net.TCPConn))
-        if err != nil {
-            fmt.Printf("Error receiving packet: %v\n", err)
-            return
-        }
-
-        // Update the RAT state with the received packet
-        ratController.ReceivePackets([]*rat.Packet{packet})
-        numPacketsReceived++
 
-        // Update the sender state
-        if isSending {
-            onDuration += rtt
-            if onDuration >= meanOnDuration {
-                isSending = false
-                onDuration = 0.0
-            }
-        } else {
-            offDuration += rtt
-            if offDuration >= meanOffDuration {
-                isSending = true
-                offDuration = 0.0
-            }
-        }
+    // Report what every sender achieved, as the simulator does
+    config := network.Config{LinkPPT: linkPPT, RTT: rtt, NumSenders: *numSendersInt, MeanOn: *meanOnDuration, MeanOff: *meanOffDuration, Buffer: *bufferInt}
+    total := 0.0
+    for _, s := range senders {
+        stats := s.Stats()
+        utility := stats.Utility(config, *delta)
+        total += utility
+        fmt.Printf("Sender %d: %d sent, %d received, %d dropped, throughput %.4g packets/ms, average delay %.4g ms, utility %.4g\n",
+            s.id, stats.PacketsSent, stats.PacketsReceived, stats.PacketsDropped, stats.Throughput(), stats.AverageDelay(), utility)
     }
-}
-
-// runReceiver simulates a receiver
-func runReceiver(ratController *rat.RAT, conn net.Conn) {
-    for {
-        // Receive packets
-        packet, err := rat.ReceivePacket(conn.(*net.TCPConn))
-        if err != nil {
-            fmt.Printf("Error receiving packet: %v\n", err)
-            return
-        }
-
-        // Update the RAT state with the received packet
-        ratController.ReceivePackets([]*rat.Packet{packet})
+    fmt.Printf("Total utility %.4g, %d packets dropped by the link\n", total, server.Dropped())
+    if err := ratController.Err(); err != nil {
+        fmt.Printf("Warning: %d whisker lookups failed, last error: %v\n", ratController.LookupErrors(), err)
     }
 }
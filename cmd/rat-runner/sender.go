@@ -0,0 +1,199 @@
+package main
+
+import (
+    "math"
+    "math/rand"
+    "net"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+    "github.com/Aanthord/remy-go/pkg/rat"
+)
+
+// sender alternates between on periods, each a new flow of the RAT, and off periods, sending over its own connection
+type sender struct {
+    id      int
+    rat     *rat.RAT
+    meanOn  float64 // Mean duration of an on period in milliseconds
+    meanOff float64 // Mean duration of an off period in milliseconds
+    rng     *rand.Rand
+    acks    chan struct{} // Signals the send loop that a packet came back
+
+    mu      sync.Mutex
+    on      bool
+    flowID  uint      // Flow of the current on period
+    onSince time.Time // Start of the current on period
+    stats   network.SenderStats
+}
+
+// newSender is a constructor that creates a sender with its own random on and off durations
+func newSender(id int, ratController *rat.RAT, meanOn, meanOff float64) *sender {
+    return &sender{
+        id:      id,
+        rat:     ratController,
+        meanOn:  meanOn,
+        meanOff: meanOff,
+        rng:     rand.New(rand.NewSource(int64(id) + 1)),
+        acks:    make(chan struct{}, 1),
+    }
+}
+
+// exponential returns a random duration with the given mean in milliseconds
+func (s *sender) exponential(mean float64) time.Duration {
+    return time.Duration(s.rng.ExpFloat64() * mean * float64(time.Millisecond))
+}
+
+// Stats returns what the sender achieved so far
+func (s *sender) Stats() network.SenderStats {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    stats := s.stats
+    if s.on {
+        stats.OnDuration += time.Since(s.onSince)
+    }
+    return stats
+}
+
+// run connects to the receiver and sends until stop is closed
+func (s *sender) run(addr *net.TCPAddr, stop <-chan struct{}) error {
+    conn, err := net.DialTCP("tcp", nil, addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    conn.SetNoDelay(true)
+    go s.receive(conn)
+
+    next := rat.ConnNextHop{Conn: conn}
+    switchTimer := time.NewTimer(s.exponential(s.meanOff))
+    defer switchTimer.Stop()
+    sendTimer := time.NewTimer(time.Hour)
+    defer sendTimer.Stop()
+    seq := 0
+
+    for {
+        select {
+        case <-stop:
+            s.switchOff()
+            return nil
+        case <-switchTimer.C:
+            if s.toggle() {
+                switchTimer.Reset(s.exponential(s.meanOn))
+            } else {
+                switchTimer.Reset(s.exponential(s.meanOff))
+            }
+            continue
+        default:
+        }
+
+        wait := time.Hour
+        if flowID, on := s.current(); on {
+            before, _ := s.rat.Flow(flowID)
+            if err := s.rat.Send(flowID, s.id, next, seq, math.MaxUint32); err != nil {
+                return err
+            }
+            after, _ := s.rat.Flow(flowID)
+            if after.PacketsSent() > before.PacketsSent() {
+                seq++
+                s.mu.Lock()
+                s.stats.PacketsSent++
+                s.mu.Unlock()
+                continue
+            }
+            // Nothing was sent, so either the intersend time or the window holds the flow back
+            if until := time.Until(s.rat.NextEventTime(flowID)); until > 0 {
+                wait = until
+            }
+        }
+
+        sendTimer.Reset(wait)
+        select {
+        case <-stop:
+        case <-switchTimer.C:
+            if s.toggle() {
+                switchTimer.Reset(s.exponential(s.meanOn))
+            } else {
+                switchTimer.Reset(s.exponential(s.meanOff))
+            }
+        case <-s.acks:
+        case <-sendTimer.C:
+        }
+        if !sendTimer.Stop() {
+            select {
+            case <-sendTimer.C:
+            default:
+            }
+        }
+    }
+}
+
+// current returns the flow of the current on period and whether the sender is on
+func (s *sender) current() (uint, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.flowID, s.on
+}
+
+// toggle switches the sender on or off and returns whether it is now on
+// Each on period is a new flow with fresh memory and window, as in Remy
+func (s *sender) toggle() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.on {
+        s.stats.OnDuration += time.Since(s.onSince)
+        s.rat.EndFlow(s.flowID)
+        s.on = false
+        return false
+    }
+    s.flowID = s.rat.NewFlow()
+    s.onSince = time.Now()
+    s.on = true
+    return true
+}
+
+// switchOff ends the current on period, if any
+func (s *sender) switchOff() {
+    if _, on := s.current(); on {
+        s.toggle()
+    }
+}
+
+// receive reads the echoes and loss reports of the sender's packets and hands them to the RAT
+func (s *sender) receive(conn *net.TCPConn) {
+    decoder := rat.NewDecoder(conn)
+    // Echoes and loss reports carry no payload, so a corrupt length is refused at once
+    decoder.SetMaxPayload(0)
+    for {
+        frame, err := decoder.Decode()
+        if err != nil {
+            return
+        }
+        if frame.Flags&rat.FlagEcho == 0 {
+            continue
+        }
+        packet := frame.EchoedPacket()
+        if frame.Flags&rat.FlagLost != 0 {
+            // The link dropped the packet, which no longer holds a place in the window
+            s.rat.LosePackets([]*rat.Packet{packet})
+            s.mu.Lock()
+            s.stats.PacketsDropped++
+            s.mu.Unlock()
+        } else {
+            s.rat.ReceivePackets([]*rat.Packet{packet})
+
+            // Only packets that come back during the on period they were sent in count, as in the simulator
+            s.mu.Lock()
+            if s.on && packet.FlowID == s.flowID {
+                s.stats.PacketsReceived++
+                s.stats.TotalDelay += packet.Received.Sub(packet.Sent)
+            }
+            s.mu.Unlock()
+        }
+
+        select {
+        case s.acks <- struct{}{}:
+        default:
+        }
+    }
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "math/rand"
+    "net"
+    "os"
+    "os/signal"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+)
+
+var (
+    listenAddr    = flag.String("listen", "127.0.0.1:9000", "Address the clients send their datagrams to")
+    targetAddr    = flag.String("target", "", "Address of the server the datagrams are relayed to")
+    linkPPT       = flag.Float64("link", 1.0, "Link packets per millisecond")
+    rtt           = flag.Float64("rtt", 150.0, "Round-trip time in milliseconds, half of it on the way to the server and half on the way back")
+    buffer        = flag.Int("buffer", 0, "Link buffer in packets, or 0 for an unlimited buffer")
+    aqm           = flag.String("aqm", "droptail", "Queue management of the link: droptail, codel or red, with parameters as in codel:target=5ms,interval=100ms")
+    loss          = flag.Float64("loss", 0, "Probability that the link loses a packet at random")
+    jitter        = flag.Float64("jitter", 0, "Largest random delay in milliseconds added to each packet on its way to the server")
+    traceFile     = flag.String("trace", "", "Mahimahi trace the link follows instead of -link")
+    seed          = flag.Int64("seed", 1, "Seed of the random losses, drops and jitter")
+    duration      = flag.Duration("duration", 0, "How long to relay, or 0 to relay until interrupted")
+    statsInterval = flag.Duration("stats", 5*time.Second, "Interval between reports of what the relay did, or 0 for a report at the end only")
+)
+
+func main() {
+    flag.Parse()
+
+    if *targetAddr == "" {
+        fmt.Println("Usage: remy-emu -target host:port [-listen host:port] [options]")
+        flag.PrintDefaults()
+        os.Exit(2)
+    }
+
+    listen, err := net.ResolveUDPAddr("udp", *listenAddr)
+    if err != nil {
+        fmt.Printf("Error resolving listen address: %v\n", err)
+        os.Exit(1)
+    }
+    target, err := net.ResolveUDPAddr("udp", *targetAddr)
+    if err != nil {
+        fmt.Printf("Error resolving target address: %v\n", err)
+        os.Exit(1)
+    }
+
+    // Describe the link as the simulator does, so that emulated and simulated runs of a tree can be compared
+    aqmConfig, err := network.ParseAQM(*aqm)
+    if err != nil {
+        fmt.Printf("Error parsing AQM: %v\n", err)
+        os.Exit(1)
+    }
+    config := network.Config{
+        LinkPPT: *linkPPT,
+        RTT:     *rtt,
+        Buffer:  *buffer,
+        AQM:     aqmConfig,
+        Loss:    *loss,
+        Jitter:  *jitter,
+    }
+    if *traceFile != "" {
+        trace, err := network.LoadTrace(*traceFile)
+        if err != nil {
+            fmt.Printf("Error loading trace: %v\n", err)
+            os.Exit(1)
+        }
+        config.Trace = trace
+        config.LinkPPT = trace.Rate()
+    }
+    rng := rand.New(rand.NewSource(*seed))
+
+    // The simulator puts the whole round trip after the link and acknowledges at once; the relay splits it between
+    // the way to the server, which takes the jitter, and the way back
+    oneWay := config
+    oneWay.RTT = config.RTT / 2
+    back := network.NewDelay(time.Duration(oneWay.RTT * float64(time.Millisecond)))
+    r, err := newRelay(listen, target, network.NewConfiguredLink(config, rng), network.NewConfiguredDelay(oneWay, rng), back)
+    if err != nil {
+        fmt.Printf("Error starting relay: %v\n", err)
+        os.Exit(1)
+    }
+    defer r.Close()
+    fmt.Printf("Relaying %v -> %v over a %.4g packets/ms link with %g ms round trip, buffer %d, %v, loss %g, jitter %g ms\n",
+        r.Addr(), target, config.LinkPPT, config.RTT, config.Buffer, config.AQM, config.Loss, config.Jitter)
+
+    // Report periodically until the duration is up or the relay is interrupted
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt)
+    var end <-chan time.Time
+    if *duration > 0 {
+        end = time.After(*duration)
+    }
+    var ticks <-chan time.Time
+    if *statsInterval > 0 {
+        ticker := time.NewTicker(*statsInterval)
+        defer ticker.Stop()
+        ticks = ticker.C
+    }
+
+    start := time.Now()
+    for {
+        select {
+        case <-ticks:
+            fmt.Println(r.Report(time.Since(start)))
+        case <-end:
+            fmt.Println(r.Report(time.Since(start)))
+            return
+        case <-interrupt:
+            fmt.Println(r.Report(time.Since(start)))
+            return
+        }
+    }
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+)
+
+// relay forwards the datagrams of every client to the server through an emulated bottleneck
+// The clients share one link and delay, built from the same network configuration the simulator uses. Replies from
+// the server skip the link but go through a delay of their own, so the round trip is split between the two
+// directions: the delay on the way in and the delay on the way back should add up to the round-trip time.
+type relay struct {
+    conn    *net.UDPConn  // Socket the clients send to
+    target  *net.UDPAddr  // Server the datagrams are relayed to
+    wake    chan struct{} // Signals the scheduler that a packet was enqueued or a reply received
+    done    chan struct{} // Closed by Close to stop the scheduler
+    stopped chan struct{} // Closed by the scheduler once it has stopped
+    close   sync.Once
+
+    mu       sync.Mutex
+    link     *network.Link
+    delay    *network.Delay // Delay of the datagrams on their way to the server
+    back     *network.Delay // Delay of the replies on their way back to the clients
+    sessions []*session          // Clients by index, which is the Sender of their packets
+    byAddr   map[string]*session // Clients by address
+    stats    relayStats
+}
+
+// session is a client of the relay, with a socket of its own towards the server so that replies find their way back
+type session struct {
+    index    int // Position in the relay's sessions, and the Sender of the client's packets
+    client   *net.UDPAddr
+    upstream *net.UDPConn
+}
+
+// relayStats holds what the relay has done so far
+type relayStats struct {
+    Received   uint          // Datagrams received from clients
+    Forwarded  uint          // Datagrams that made it through the link and delay to the server
+    Replies    uint          // Datagrams relayed back from the server once they left the delay
+    TotalDelay time.Duration // Sum of the times the forwarded datagrams spent in the relay
+}
+
+// newRelay starts a relay listening on the given address, with delay on the way to the server and back on the way back
+func newRelay(listen, target *net.UDPAddr, link *network.Link, delay, back *network.Delay) (*relay, error) {
+    conn, err := net.ListenUDP("udp", listen)
+    if err != nil {
+        return nil, err
+    }
+    r := &relay{
+        conn:    conn,
+        target:  target,
+        wake:    make(chan struct{}, 1),
+        done:    make(chan struct{}),
+        stopped: make(chan struct{}),
+        link:    link,
+        delay:   delay,
+        back:    back,
+        byAddr:  make(map[string]*session),
+    }
+    go r.receive()
+    go r.schedule()
+    return r, nil
+}
+
+// Addr returns the address the relay listens on
+func (r *relay) Addr() net.Addr {
+    return r.conn.LocalAddr()
+}
+
+// Close stops the relay, dropping the datagrams still in the link and delays
+func (r *relay) Close() error {
+    r.close.Do(func() { close(r.done) })
+    <-r.stopped
+    r.mu.Lock()
+    for _, s := range r.sessions {
+        s.upstream.Close()
+    }
+    r.mu.Unlock()
+    return r.conn.Close()
+}
+
+// Report describes what the relay has done in the given time
+func (r *relay) Report(elapsed time.Duration) string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    averageDelay := 0.0
+    if r.stats.Forwarded > 0 {
+        averageDelay = float64(r.stats.TotalDelay) / float64(time.Millisecond) / float64(r.stats.Forwarded)
+    }
+    throughput := float64(r.stats.Forwarded) / (float64(elapsed) / float64(time.Millisecond))
+    return fmt.Sprintf("%d clients, %d received, %d forwarded (%.4g packets/ms), %d replies, average delay to the server %.4g ms, queue %d, dropped %d by the buffer, %d by the AQM, %d lost",
+        len(r.sessions), r.stats.Received, r.stats.Forwarded, throughput, r.stats.Replies, averageDelay,
+        r.link.QueueLength(), r.link.Dropped(), r.link.AQMDropped(), r.link.Lost())
+}
+
+// receive reads the datagrams of the clients and puts them on the link
+func (r *relay) receive() {
+    buf := make([]byte, 65536)
+    for {
+        n, client, err := r.conn.ReadFromUDP(buf)
+        if err != nil {
+            return
+        }
+
+        r.mu.Lock()
+        s, ok := r.byAddr[client.String()]
+        if !ok {
+            upstream, err := net.DialUDP("udp", nil, r.target)
+            if err != nil {
+                r.mu.Unlock()
+                fmt.Printf("Error connecting to %v for %v: %v\n", r.target, client, err)
+                continue
+            }
+            s = &session{index: len(r.sessions), client: client, upstream: upstream}
+            r.byAddr[client.String()] = s
+            r.sessions = append(r.sessions, s)
+            go r.reply(s)
+        }
+        now := time.Now()
+        r.stats.Received++
+        r.link.Enqueue(&network.Packet{
+            SeqNo:  int(r.stats.Received),
+            Sender: s.index,
+            Sent:   now,
+            Data:   append([]byte(nil), buf[:n]...),
+        }, now)
+        r.mu.Unlock()
+        r.notify()
+    }
+}
+
+// notify wakes the scheduler up
+func (r *relay) notify() {
+    select {
+    case r.wake <- struct{}{}:
+    default:
+    }
+}
+
+// reply puts the server's datagrams for a session in the delay back to its client
+func (r *relay) reply(s *session) {
+    buf := make([]byte, 65536)
+    for {
+        n, err := s.upstream.Read(buf)
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+            // The server may not be listening yet, which only loses a datagram
+            continue
+        }
+        now := time.Now()
+        r.mu.Lock()
+        r.back.Accept(&network.Packet{Sender: s.index, Sent: now, Data: append([]byte(nil), buf[:n]...)}, now)
+        r.mu.Unlock()
+        r.notify()
+    }
+}
+
+// schedule moves datagrams from the link to the delay, sends them to the server once they leave the delay and sends
+// replies to their clients once they leave the delay back, until the relay is closed
+func (r *relay) schedule() {
+    defer close(r.stopped)
+    timer := time.NewTimer(time.Hour)
+    defer timer.Stop()
+    for {
+        r.mu.Lock()
+        now := time.Now()
+        packets, departures := r.link.Dequeue(now)
+        for i, packet := range packets {
+            r.delay.Accept(packet, departures[i])
+        }
+        arrived := r.delay.Release(now)
+        for _, packet := range arrived {
+            r.stats.Forwarded++
+            r.stats.TotalDelay += now.Sub(packet.Sent)
+        }
+        replies := r.back.Release(now)
+        r.stats.Replies += uint(len(replies))
+        next, ok := r.delay.NextRelease()
+        for _, t := range []func() (time.Time, bool){r.link.NextDeparture, r.back.NextRelease} {
+            if at, pending := t(); pending && (!ok || at.Before(next)) {
+                next, ok = at, true
+            }
+        }
+        sessions := r.sessions
+        r.mu.Unlock()
+
+        for _, packet := range arrived {
+            sessions[packet.Sender].upstream.Write(packet.Data)
+        }
+        for _, packet := range replies {
+            r.conn.WriteToUDP(packet.Data, sessions[packet.Sender].client)
+        }
+
+        wait := time.Hour
+        if ok {
+            wait = time.Until(next)
+        }
+        timer.Reset(wait)
+        select {
+        case <-timer.C:
+        case <-r.wake:
+            if !timer.Stop() {
+                <-timer.C
+            }
+        case <-r.done:
+            return
+        }
+    }
+}
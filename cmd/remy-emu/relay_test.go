@@ -0,0 +1,147 @@
+package main
+
+import (
+    "bytes"
+    "net"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+)
+
+// echo starts a UDP server on loopback that sends every datagram back, noting when each one arrived
+func echo(t *testing.T) (*net.UDPAddr, <-chan time.Time) {
+    t.Helper()
+    conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    arrivals := make(chan time.Time, 100)
+    go func() {
+        buf := make([]byte, 65536)
+        for {
+            n, from, err := conn.ReadFromUDP(buf)
+            if err != nil {
+                return
+            }
+            arrivals <- time.Now()
+            conn.WriteToUDP(buf[:n], from)
+        }
+    }()
+    return conn.LocalAddr().(*net.UDPAddr), arrivals
+}
+
+// startRelay starts a relay to the target over a link of the given rate, with oneWay of delay in each direction
+func startRelay(t *testing.T, target *net.UDPAddr, rate float64, oneWay time.Duration) *relay {
+    t.Helper()
+    r, err := newRelay(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, target, network.NewLink(rate, 0), network.NewDelay(oneWay), network.NewDelay(oneWay))
+    if err != nil {
+        t.Fatalf("failed to start relay: %v", err)
+    }
+    t.Cleanup(func() { r.Close() })
+    return r
+}
+
+// dial returns a client socket connected to the relay
+func dial(t *testing.T, r *relay) *net.UDPConn {
+    t.Helper()
+    client, err := net.DialUDP("udp", nil, r.Addr().(*net.UDPAddr))
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    t.Cleanup(func() { client.Close() })
+    client.SetReadDeadline(time.Now().Add(5 * time.Second))
+    return client
+}
+
+func TestRelayDelaysBothWays(t *testing.T) {
+    target, arrivals := echo(t)
+    r := startRelay(t, target, 1, 20*time.Millisecond)
+    client := dial(t, r)
+
+    start := time.Now()
+    if _, err := client.Write([]byte("ping")); err != nil {
+        t.Fatal(err)
+    }
+    buf := make([]byte, 100)
+    n, err := client.Read(buf)
+    if err != nil {
+        t.Fatalf("no reply: %v", err)
+    }
+    end := time.Now()
+    if !bytes.Equal(buf[:n], []byte("ping")) {
+        t.Errorf("reply is %q, want \"ping\"", buf[:n])
+    }
+
+    // The datagram is served by the link and delayed on its way in, and its reply is delayed on its way back
+    arrived := <-arrivals
+    if in := arrived.Sub(start); in < 21*time.Millisecond {
+        t.Errorf("datagram reached the server after %v, want at least the link's 1ms and the 20ms delay", in)
+    }
+    if back := end.Sub(arrived); back < 20*time.Millisecond {
+        t.Errorf("reply came back after %v, want at least the 20ms delay", back)
+    }
+    if rtt := end.Sub(start); rtt > 200*time.Millisecond {
+        t.Errorf("round trip took %v, want about 41ms", rtt)
+    }
+    r.mu.Lock()
+    stats := r.stats
+    r.mu.Unlock()
+    if stats.Received != 1 || stats.Forwarded != 1 || stats.Replies != 1 {
+        t.Errorf("relay counted %+v, want one datagram each way", stats)
+    }
+}
+
+func TestRelayLinkRate(t *testing.T) {
+    target, _ := echo(t)
+    // A link of 0.1 packets per millisecond serves a datagram every 10ms
+    r := startRelay(t, target, 0.1, time.Millisecond)
+    client := dial(t, r)
+
+    start := time.Now()
+    for i := 0; i < 10; i++ {
+        if _, err := client.Write([]byte{byte(i)}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    buf := make([]byte, 100)
+    for i := 0; i < 10; i++ {
+        n, err := client.Read(buf)
+        if err != nil {
+            t.Fatalf("got %d of 10 replies: %v", i, err)
+        }
+        if n != 1 || buf[0] != byte(i) {
+            t.Errorf("reply %d is %v, want [%d]", i, buf[:n], i)
+        }
+    }
+    if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+        t.Errorf("10 datagrams went through a 0.1 packets/ms link in %v, want at least 100ms", elapsed)
+    }
+}
+
+func TestRelayCloseStopsScheduler(t *testing.T) {
+    target, _ := echo(t)
+    r := startRelay(t, target, 1, time.Hour)
+    client := dial(t, r)
+    if _, err := client.Write([]byte("held")); err != nil {
+        t.Fatal(err)
+    }
+
+    // The datagram sits in the hour-long delay, and Close returns once the scheduler has stopped anyway
+    closed := make(chan struct{})
+    go func() {
+        r.Close()
+        close(closed)
+    }()
+    select {
+    case <-closed:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Close did not return")
+    }
+    select {
+    case <-r.stopped:
+    default:
+        t.Error("scheduler is still running after Close")
+    }
+}
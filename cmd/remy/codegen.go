@@ -0,0 +1,82 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/Aanthord/remy-go/pkg/codegen"
+)
+
+// runCodegen implements the codegen subcommand, which compiles a whisker tree into Go or BPF C source
+func runCodegen(args []string) {
+    defaults := codegen.DefaultGoOptions()
+    fs := flag.NewFlagSet("codegen", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to compile")
+    outputFile := fs.String("o", "", "Path to write the generated source, or standard output if empty")
+    lang := fs.String("lang", "go", fmt.Sprintf("Language to generate (go, or bpf for a BPF struct_ops TCP congestion control program in C, for trees of at most %d whiskers)", codegen.MaxBPFWhiskers))
+    name := fs.String("name", codegen.DefaultBPFOptions().Name, "Name of the BPF congestion control algorithm")
+    pkg := fs.String("package", defaults.Package, "Name of the generated package")
+    style := fs.String("style", defaults.Style.String(), "Layout of the lookup (nested or table)")
+    standalone := fs.Bool("standalone", false, "Take the memory as []float64 instead of importing the memory package")
+    test := fs.Bool("test", false, "Also write a _test.go file next to the output checking the lookup against the tree")
+    points := fs.Int("points", defaults.TestPoints, "Number of random points checked by the generated test")
+    seed := fs.Int64("seed", defaults.Seed, "Seed of the random points")
+    fs.Parse(args)
+
+    if *test && (*outputFile == "" || *lang != "go") {
+        fmt.Println("Usage: remy codegen -if whiskers.pb -o lookup.go -test [options]")
+        fs.PrintDefaults()
+        os.Exit(2)
+    }
+
+    tree := loadTreeOrExit(*inputFile)
+
+    opts := defaults
+    opts.Package = *pkg
+    opts.Standalone = *standalone
+    opts.TestPoints = *points
+    opts.Seed = *seed
+    var err error
+    if opts.Style, err = codegen.ParseStyle(*style); err != nil {
+        fmt.Println("Error:", err)
+        os.Exit(2)
+    }
+
+    var source []byte
+    switch *lang {
+    case "go":
+        source, err = codegen.GenerateGo(tree, opts)
+    case "bpf":
+        source, err = codegen.GenerateBPF(tree, codegen.BPFOptions{Name: *name})
+    default:
+        fmt.Printf("Unknown language: %s\n", *lang)
+        os.Exit(2)
+    }
+    if err != nil {
+        fmt.Println("Error generating source:", err)
+        os.Exit(1)
+    }
+    if *outputFile == "" {
+        os.Stdout.Write(source)
+        return
+    }
+    if err := os.WriteFile(*outputFile, source, 0644); err != nil {
+        fmt.Println("Error writing source:", err)
+        os.Exit(1)
+    }
+
+    if *test {
+        testSource, err := codegen.GenerateGoTest(tree, opts)
+        if err != nil {
+            fmt.Println("Error generating Go test:", err)
+            os.Exit(1)
+        }
+        testFile := strings.TrimSuffix(*outputFile, ".go") + "_test.go"
+        if err := os.WriteFile(testFile, testSource, 0644); err != nil {
+            fmt.Println("Error writing Go test:", err)
+            os.Exit(1)
+        }
+    }
+}
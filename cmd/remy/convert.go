@@ -0,0 +1,82 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "google.golang.org/protobuf/proto"
+    "github.com/Aanthord/remy-go/pkg/dna"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runConvert implements the convert subcommand, which re-encodes a whisker file or configuration
+// between binary protobuf, JSON and text format
+func runConvert(args []string) {
+    fs := flag.NewFlagSet("convert", flag.ExitOnError)
+    inputFile := fs.String("in", "", "Path to the file to convert")
+    outputFile := fs.String("out", "", "Path to write the converted file")
+    from := fs.String("from", "", "Format of the input (binary, json or text); implied by its extension or content if empty")
+    to := fs.String("to", "", "Format of the output (binary, json or text); implied by its extension if empty")
+    kind := fs.String("type", "whiskers", "Type of the file (whiskers or config)")
+    fs.Parse(args)
+
+    if *inputFile == "" || *outputFile == "" {
+        fmt.Println("Usage: remy convert -in whiskers.pb -out whiskers.json [-from format] [-to format] [-type whiskers|config]")
+        fs.PrintDefaults()
+        os.Exit(2)
+    }
+
+    var message proto.Message
+    switch *kind {
+    case "whiskers":
+        message = &dna.Whiskers{}
+    case "config":
+        message = &dna.ConfigRange{}
+    default:
+        fmt.Printf("Unknown file type: %s\n", *kind)
+        os.Exit(2)
+    }
+
+    // Read the input in the given format, or the one its name or content implies
+    if *from == "" {
+        if err := whisker.ReadMessage(*inputFile, message); err != nil {
+            fmt.Println("Error reading input:", err)
+            os.Exit(1)
+        }
+    } else {
+        format, err := whisker.ParseFormat(*from)
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(2)
+        }
+        data, err := os.ReadFile(*inputFile)
+        if err != nil {
+            fmt.Println("Error reading input:", err)
+            os.Exit(1)
+        }
+        if err := whisker.Unmarshal(data, format, message); err != nil {
+            fmt.Println("Error decoding input:", err)
+            os.Exit(1)
+        }
+    }
+
+    // Write the output in the given format, or the one its name implies
+    var format whisker.Format
+    if *to != "" {
+        var err error
+        if format, err = whisker.ParseFormat(*to); err != nil {
+            fmt.Println(err)
+            os.Exit(2)
+        }
+    } else if f, ok := whisker.FormatForFile(*outputFile); ok {
+        format = f
+    } else {
+        fmt.Println("Cannot tell the output format from its name; use -to")
+        os.Exit(2)
+    }
+    if err := whisker.WriteMessage(*outputFile, message, format); err != nil {
+        fmt.Println("Error writing output:", err)
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runDiff implements the diff subcommand, which prints the structural difference between two whisker files
+func runDiff(args []string) {
+    fs := flag.NewFlagSet("diff", flag.ExitOnError)
+    summary := fs.Bool("summary", false, "Print only the summary, not every changed region")
+    tolerance := fs.Float64("tolerance", whisker.DefaultDiffOptions().ActionTolerance, "Relative difference below which two actions are considered the same")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: remy diff [-summary] [-tolerance t] old.pb new.pb")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    if fs.NArg() != 2 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    oldTree, err := whisker.LoadWhiskers(fs.Arg(0))
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+    newTree, err := whisker.LoadWhiskers(fs.Arg(1))
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+
+    diff := whisker.DiffWithOptions(oldTree, newTree, whisker.DiffOptions{ActionTolerance: *tolerance})
+    if *summary {
+        fmt.Print(diff.Summary())
+        return
+    }
+    fmt.Print(diff)
+}
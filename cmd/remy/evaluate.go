@@ -0,0 +1,99 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+)
+
+// evaluatorFlags holds the flags shared by subcommands that score trees in the simulator
+type evaluatorFlags struct {
+    linkPPT    *float64
+    rtt        *float64
+    numSenders *int
+    meanOn     *float64
+    meanOff    *float64
+    buffer     *int
+    aqm        *string
+    loss       *float64
+    jitter     *float64
+    trace      *string
+    duration   *time.Duration
+    runs       *int
+    seed       *int64
+    delta      *float64
+}
+
+// addEvaluatorFlags defines the network and evaluation flags on a flag set
+func addEvaluatorFlags(fs *flag.FlagSet) *evaluatorFlags {
+    return &evaluatorFlags{
+        linkPPT:    fs.Float64("link", 1.0, "Link packets per millisecond"),
+        rtt:        fs.Float64("rtt", 150.0, "Round-trip time in milliseconds"),
+        numSenders: fs.Int("nsrc", 2, "Number of senders"),
+        meanOn:     fs.Float64("on", 5000.0, "Mean on duration in milliseconds"),
+        meanOff:    fs.Float64("off", 5000.0, "Mean off duration in milliseconds"),
+        buffer:     fs.Int("buffer", 0, "Link buffer in packets, or 0 for an unlimited buffer"),
+        aqm:        fs.String("aqm", "droptail", "Queue management of the link: droptail, codel or red, with parameters as in codel:target=5ms,interval=100ms"),
+        loss:       fs.Float64("loss", 0, "Probability that the link loses a packet at random"),
+        jitter:     fs.Float64("jitter", 0, "Largest random delay in milliseconds added to each packet"),
+        trace:      fs.String("trace", "", "Mahimahi trace the link follows instead of -link"),
+        duration:   fs.Duration("duration", 100*time.Second, "Simulated time of each evaluation run"),
+        runs:       fs.Int("runs", 1, "Number of evaluation runs"),
+        seed:       fs.Int64("seed", 1, "Seed of the first evaluation run"),
+        delta:      fs.Float64("delta", 1.0, "Weight of delay against throughput in the utility"),
+    }
+}
+
+// config returns the network the flags describe
+func (f *evaluatorFlags) config() (network.Config, error) {
+    aqm, err := network.ParseAQM(*f.aqm)
+    if err != nil {
+        return network.Config{}, err
+    }
+    config := network.Config{
+        LinkPPT:    *f.linkPPT,
+        RTT:        *f.rtt,
+        NumSenders: *f.numSenders,
+        MeanOn:     *f.meanOn,
+        MeanOff:    *f.meanOff,
+        Buffer:     *f.buffer,
+        AQM:        aqm,
+        Loss:       *f.loss,
+        Jitter:     *f.jitter,
+    }
+    if *f.trace != "" {
+        trace, err := network.LoadTrace(*f.trace)
+        if err != nil {
+            return network.Config{}, fmt.Errorf("loading trace: %v", err)
+        }
+        config.Trace = trace
+        config.LinkPPT = trace.Rate()
+    }
+    return config, nil
+}
+
+// evaluator returns an evaluator for the network and evaluation the flags describe
+func (f *evaluatorFlags) evaluator() (*network.Evaluator, error) {
+    config, err := f.config()
+    if err != nil {
+        return nil, err
+    }
+    evaluator := network.NewEvaluator([]network.Config{config})
+    evaluator.Duration = *f.duration
+    evaluator.Runs = *f.runs
+    evaluator.Seed = *f.seed
+    evaluator.Delta = *f.delta
+    return evaluator, nil
+}
+
+// objectiveParameters returns the parameters of the objective the flags describe, as recorded in whisker metadata
+func (f *evaluatorFlags) objectiveParameters() map[string]float64 {
+    return map[string]float64{
+        "delta":    *f.delta,
+        "link_ppt": *f.linkPPT,
+        "rtt":      *f.rtt,
+        "nsrc":     float64(*f.numSenders),
+    }
+}
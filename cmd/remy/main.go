@@ -1,10 +1,9 @@
 package main
 
 import (
-    "flag"  // Import the flag package for command-line argument parsing
-    "fmt"   // Import the fmt package for formatted I/O
-    "io/ioutil" // Import the ioutil package for file I/O utilities
-    "os"    // Import the os package for operating system functionality
+    "flag" // Import the flag package for command-line argument parsing
+    "fmt"  // Import the fmt package for formatted I/O
+    "os"   // Import the os package for operating system functionality
 
     "github.com/Aanthord/remy-go/pkg/whisker" // Import the whisker package from the remy project
 )
@@ -13,33 +12,62 @@ import (
 var (
     // configFile is a string flag for the path to the configuration file
     configFile = flag.String("config", "", "Path to the configuration file")
-    // outputFile is a string flag for the path to save the generated whiskers
-    outputFile = flag.String("output", "", "Path to save the generated whiskers")
+    // outputFile is a string flag for the path to save the generated whiskers, in the format implied by its extension
+    outputFile = flag.String("output", "", "Path to save the generated whiskers (.pb, .json or .textproto)")
 )
 
 func main() {
+    // Dispatch to a subcommand if one is given
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "diff":
+            runDiff(os.Args[2:])
+            return
+        case "prune":
+            runPrune(os.Args[2:])
+            return
+        case "dot":
+            runDOT(os.Args[2:])
+            return
+        case "heatmap":
+            runHeatmap(os.Args[2:])
+            return
+        case "convert":
+            runConvert(os.Args[2:])
+            return
+        case "quantize":
+            runQuantize(os.Args[2:])
+            return
+        case "codegen":
+            runCodegen(os.Args[2:])
+            return
+        case "shadow":
+            runShadow(os.Args[2:])
+            return
+        case "simulate":
+            runSimulate(os.Args[2:])
+            return
+        }
+    }
+
     // Parse the command-line flags
     flag.Parse()
 
-    // Read the configuration file
-    configData, err := ioutil.ReadFile(*configFile)
+    // Read and parse the configuration file, which may be binary protobuf, JSON or text format
+    config, err := whisker.LoadConfig(*configFile)
     if err != nil {
         fmt.Println("Error reading config file:", err)
         os.Exit(1)
     }
 
-    // Parse the configuration data
-    config, err := whisker.ParseConfig(configData)
-    if err != nil {
-        fmt.Println("Error parsing config:", err)
-        os.Exit(1)
-    }
-
     // Generate whiskers based on the configuration
     whiskers := whisker.GenerateWhiskers(config)
 
-    // Save the generated whiskers to the specified output file
-    err = whisker.SaveWhiskers(whiskers, *outputFile)
+    // Save the generated whiskers to the specified output file, recording the configuration they came from
+    metadata := whisker.NewMetadata()
+    metadata.Config = config
+    metadata.Generations = config.Generations
+    err = whisker.SaveWhiskersWithMetadata(whiskers, metadata, *outputFile)
     if err != nil {
         fmt.Println("Error saving whiskers:", err)
         os.Exit(1)
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runDOT implements the dot subcommand, which writes the structure of a whisker tree in Graphviz DOT format
+func runDOT(args []string) {
+    fs := flag.NewFlagSet("dot", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to draw")
+    outputFile := fs.String("o", "", "Path to write the DOT file, or standard output if empty")
+    fs.Parse(args)
+
+    tree := loadTreeOrExit(*inputFile)
+
+    out := os.Stdout
+    if *outputFile != "" {
+        f, err := os.Create(*outputFile)
+        if err != nil {
+            fmt.Println("Error creating DOT file:", err)
+            os.Exit(1)
+        }
+        defer f.Close()
+        out = f
+    }
+    if err := tree.WriteDOT(out); err != nil {
+        fmt.Println("Error writing DOT file:", err)
+        os.Exit(1)
+    }
+}
+
+// runHeatmap implements the heatmap subcommand, which writes SVG heatmaps of whisker actions over two signals
+func runHeatmap(args []string) {
+    fs := flag.NewFlagSet("heatmap", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to draw")
+    outputPrefix := fs.String("o", "heatmap", "Prefix of the SVG files; the field name and .svg are appended")
+    field := fs.String("field", "all", "Action field to draw (window_multiple, window_increment, intersend or all)")
+    xName := fs.String("x", "recv_rate", "Signal along the horizontal axis")
+    yName := fs.String("y", "latest_delay", "Signal along the vertical axis")
+    xRange := fs.String("xrange", "", "Range of the horizontal axis as min:max, or the tree's finite bounds if empty")
+    yRange := fs.String("yrange", "", "Range of the vertical axis as min:max, or the tree's finite bounds if empty")
+    fixed := fs.String("fixed", "", "Values of the other signals as name=value,...; unset signals are zero")
+    cells := fs.Int("cells", 100, "Number of cells along each axis")
+    fs.Parse(args)
+
+    tree := loadTreeOrExit(*inputFile)
+
+    x, ok := memory.LookupSignal(*xName)
+    if !ok {
+        fmt.Printf("Unknown signal: %s\n", *xName)
+        os.Exit(2)
+    }
+    y, ok := memory.LookupSignal(*yName)
+    if !ok {
+        fmt.Printf("Unknown signal: %s\n", *yName)
+        os.Exit(2)
+    }
+
+    fields := whisker.ActionFields
+    if *field != "all" {
+        f, err := whisker.ParseActionField(*field)
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(2)
+        }
+        fields = []whisker.ActionField{f}
+    }
+
+    fixedMemory, err := parseFixedMemory(*fixed)
+    if err != nil {
+        fmt.Println("Error parsing fixed signals:", err)
+        os.Exit(2)
+    }
+
+    for _, f := range fields {
+        opts := tree.DefaultHeatmapOptions(f, x, y)
+        opts.Fixed = fixedMemory
+        opts.Columns = *cells
+        opts.Rows = *cells
+        if *xRange != "" {
+            if opts.XMin, opts.XMax, err = parseAxisRange(*xRange); err != nil {
+                fmt.Println("Error parsing x range:", err)
+                os.Exit(2)
+            }
+        }
+        if *yRange != "" {
+            if opts.YMin, opts.YMax, err = parseAxisRange(*yRange); err != nil {
+                fmt.Println("Error parsing y range:", err)
+                os.Exit(2)
+            }
+        }
+
+        filename := fmt.Sprintf("%s_%s.svg", *outputPrefix, f)
+        out, err := os.Create(filename)
+        if err != nil {
+            fmt.Println("Error creating heatmap:", err)
+            os.Exit(1)
+        }
+        err = tree.WriteHeatmapSVG(out, opts)
+        out.Close()
+        if err != nil {
+            fmt.Println("Error writing heatmap:", err)
+            os.Exit(1)
+        }
+        fmt.Println("Wrote", filename)
+    }
+}
+
+// loadTreeOrExit loads a whisker tree, exiting if it cannot be loaded
+func loadTreeOrExit(filename string) *whisker.WhiskerTree {
+    if filename == "" {
+        fmt.Println("Missing whiskers file (-if)")
+        os.Exit(2)
+    }
+    tree, err := whisker.LoadWhiskers(filename)
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+    return tree
+}
+
+// parseAxisRange parses a range given as min:max
+func parseAxisRange(s string) (memory.DataType, memory.DataType, error) {
+    parts := strings.Split(s, ":")
+    if len(parts) != 2 {
+        return 0, 0, fmt.Errorf("range %q is not min:max", s)
+    }
+    low, err := strconv.ParseFloat(parts[0], 64)
+    if err != nil {
+        return 0, 0, err
+    }
+    high, err := strconv.ParseFloat(parts[1], 64)
+    if err != nil {
+        return 0, 0, err
+    }
+    return memory.DataType(low), memory.DataType(high), nil
+}
+
+// parseFixedMemory parses signal values given as name=value,...
+func parseFixedMemory(s string) (*memory.Memory, error) {
+    m := memory.NewMemory()
+    if s == "" {
+        return m, nil
+    }
+    for _, pair := range strings.Split(s, ",") {
+        parts := strings.SplitN(pair, "=", 2)
+        if len(parts) != 2 {
+            return nil, fmt.Errorf("%q is not name=value", pair)
+        }
+        id, ok := memory.LookupSignal(parts[0])
+        if !ok {
+            return nil, fmt.Errorf("unknown signal %q", parts[0])
+        }
+        v, err := strconv.ParseFloat(parts[1], 64)
+        if err != nil {
+            return nil, err
+        }
+        m.Set(id, memory.DataType(v))
+    }
+    return m, nil
+}
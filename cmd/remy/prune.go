@@ -0,0 +1,69 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runPrune implements the prune subcommand, which collapses rarely used whiskers of a tree as long as
+// its simulated score does not drop by more than a tolerance
+func runPrune(args []string) {
+    fs := flag.NewFlagSet("prune", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to prune")
+    outputFile := fs.String("of", "", "Path to save the pruned whiskers")
+    eval := addEvaluatorFlags(fs)
+    minCount := fs.Uint64("min-count", 1, "Leaves used fewer times than this are considered unused")
+    actionTolerance := fs.Float64("action-tolerance", 0, "Relative difference below which sibling actions are considered the same")
+    scoreTolerance := fs.Float64("score-tolerance", 0, "Largest drop in score accepted")
+    maxEvaluations := fs.Int("max-evaluations", 0, "Limit on the number of evaluations, or 0 for no limit")
+    fs.Parse(args)
+
+    if *inputFile == "" || *outputFile == "" {
+        fmt.Println("Usage: remy prune -if whiskers.pb -of pruned.pb [options]")
+        fs.PrintDefaults()
+        os.Exit(2)
+    }
+
+    tree, err := whisker.LoadWhiskers(*inputFile)
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+
+    evaluator, err := eval.evaluator()
+    if err != nil {
+        fmt.Println("Error configuring the network:", err)
+        os.Exit(1)
+    }
+    pruned, result, err := whisker.Prune(tree, evaluator.Evaluate, whisker.PruneOptions{
+        MinCount:        *minCount,
+        ActionTolerance: *actionTolerance,
+        ScoreTolerance:  *scoreTolerance,
+        MaxEvaluations:  *maxEvaluations,
+    })
+    if err != nil {
+        fmt.Println("Error pruning whiskers:", err)
+        os.Exit(1)
+    }
+
+    // Record how the pruned tree was scored, keeping the training provenance of the original
+    metadata := whisker.NewMetadata()
+    if tree.Metadata != nil {
+        metadata.Config = tree.Metadata.Config
+        metadata.Generations = tree.Metadata.Generations
+    }
+    metadata.Objective = "remy-utility"
+    metadata.ObjectiveParameters = eval.objectiveParameters()
+    metadata.Seed = *eval.seed
+    metadata.Score = result.Score
+    pruned.Metadata = metadata
+
+    if err := whisker.SaveTree(pruned, *outputFile); err != nil {
+        fmt.Println("Error saving whiskers:", err)
+        os.Exit(1)
+    }
+    fmt.Println(result)
+}
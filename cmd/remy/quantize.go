@@ -0,0 +1,74 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runQuantize implements the quantize subcommand, which rounds a tree to fixed point and reports how much
+// its simulated score drops, with the signals still computed in floating point
+func runQuantize(args []string) {
+    defaults := whisker.DefaultQuantizeOptions()
+    fs := flag.NewFlagSet("quantize", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to quantize")
+    outputFile := fs.String("of", "", "Path to save the quantized whiskers, or nothing if empty")
+    domainShift := fs.Uint("domain-shift", defaults.DomainShift, "Fractional bits of signal values and domain bounds")
+    multipleShift := fs.Uint("multiple-shift", defaults.MultipleShift, "Fractional bits of window multiples")
+    intersendUnit := fs.Duration("intersend-unit", defaults.IntersendUnit, "Resolution of intersend times")
+    bits := fs.Uint("bits", defaults.Bits, "Width of the integers holding signal values")
+    eval := addEvaluatorFlags(fs)
+    fs.Parse(args)
+
+    if *inputFile == "" {
+        fmt.Println("Usage: remy quantize -if whiskers.pb [-of quantized.pb] [options]")
+        fs.PrintDefaults()
+        os.Exit(2)
+    }
+
+    tree, err := whisker.LoadWhiskers(*inputFile)
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+
+    evaluator, err := eval.evaluator()
+    if err != nil {
+        fmt.Println("Error configuring the network:", err)
+        os.Exit(1)
+    }
+    quantized, report, err := evaluator.EvaluateQuantized(tree, whisker.QuantizeOptions{
+        DomainShift:   *domainShift,
+        MultipleShift: *multipleShift,
+        IntersendUnit: *intersendUnit,
+        Bits:          *bits,
+    })
+    if err != nil {
+        fmt.Println("Error quantizing whiskers:", err)
+        os.Exit(1)
+    }
+    fmt.Println(report)
+
+    if *outputFile == "" {
+        return
+    }
+
+    // Record how the quantized tree was scored, keeping the training provenance of the original
+    metadata := whisker.NewMetadata()
+    if tree.Metadata != nil {
+        metadata.Config = tree.Metadata.Config
+        metadata.Generations = tree.Metadata.Generations
+    }
+    metadata.Objective = "remy-utility"
+    metadata.ObjectiveParameters = eval.objectiveParameters()
+    metadata.Seed = *eval.seed
+    metadata.Score = report.QuantizedScore
+    quantized.Metadata = metadata
+
+    if err := whisker.SaveTree(quantized, *outputFile); err != nil {
+        fmt.Println("Error saving whiskers:", err)
+        os.Exit(1)
+    }
+}
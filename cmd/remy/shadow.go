@@ -0,0 +1,198 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "sort"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/rat"
+    "github.com/Aanthord/remy-go/pkg/shadow"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runShadow implements the shadow subcommand, which sends bulk data over a TCP connection run by the kernel's
+// congestion control and reports which whiskers the tree would choose on it and how their actions compare
+// With -enforce, the whiskers' pacing is applied to the connection as well; their windows are not, since the socket
+// options it sets cannot cap the data in flight.
+func runShadow(args []string) {
+    fs := flag.NewFlagSet("shadow", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to evaluate")
+    connect := fs.String("connect", "", "Address of a TCP sink to send to, or empty for a sink on loopback")
+    congestion := fs.String("cc", "", "Kernel congestion control of the connection, such as cubic or bbr, or empty for the default")
+    duration := fs.Duration("duration", 10*time.Second, "How long to send")
+    interval := fs.Duration("interval", 100*time.Millisecond, "Interval between TCP_INFO samples")
+    outputFile := fs.String("o", "", "Path to write every sample as a JSON line, or nothing if empty")
+    verbose := fs.Bool("v", false, "Print every sample")
+    enforce := fs.Bool("enforce", false, "Apply the tree's pacing to the connection rather than only watching it; the data in flight is still bounded only by the kernel's window")
+    fs.Parse(args)
+
+    if *inputFile == "" {
+        fmt.Println("Usage: remy shadow -if whiskers.pb [-connect host:port] [options]")
+        fs.PrintDefaults()
+        os.Exit(2)
+    }
+
+    tree, err := whisker.LoadWhiskers(*inputFile)
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+
+    // Without a sink to send to, discard the data on loopback
+    address := *connect
+    if address == "" {
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            fmt.Println("Error starting loopback sink:", err)
+            os.Exit(1)
+        }
+        defer listener.Close()
+        go func() {
+            for {
+                conn, err := listener.Accept()
+                if err != nil {
+                    return
+                }
+                go func() {
+                    defer conn.Close()
+                    io.Copy(io.Discard, conn)
+                }()
+            }
+        }()
+        address = listener.Addr().String()
+    }
+
+    dialer := net.Dialer{}
+    if *congestion != "" {
+        dialer.Control = shadow.SetCongestion(*congestion)
+    }
+    conn, err := dialer.Dial("tcp", address)
+    if err != nil {
+        fmt.Println("Error connecting:", err)
+        os.Exit(1)
+    }
+    defer conn.Close()
+
+    // Collect every record, writing it out if requested
+    summary := newShadowSummary()
+    report := summary.add
+    if *outputFile != "" {
+        f, err := os.Create(*outputFile)
+        if err != nil {
+            fmt.Println("Error creating output file:", err)
+            os.Exit(1)
+        }
+        defer f.Close()
+        write := shadow.NewJSONReporter(f, func(err error) {
+            fmt.Println("Error:", err)
+        })
+        report = func(r shadow.Record) {
+            summary.add(r)
+            write(r)
+        }
+    }
+    if *verbose {
+        next := report
+        report = func(r shadow.Record) {
+            fmt.Println(r)
+            next(r)
+        }
+    }
+
+    monitor := shadow.NewMonitor(tree, *interval, report)
+    if err := monitor.Add(conn.RemoteAddr().String(), conn.(*net.TCPConn)); err != nil {
+        fmt.Println("Error reading TCP_INFO:", err)
+        os.Exit(1)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), *duration)
+    defer cancel()
+    go monitor.Run(ctx)
+
+    // Enforcing makes the connection a flow of a RAT, whose actions the socket options then carry out
+    if *enforce {
+        controller, err := rat.NewSocketController(rat.NewRAT(tree, false), conn.(*net.TCPConn))
+        if err != nil {
+            fmt.Println("Error controlling the connection:", err)
+            os.Exit(1)
+        }
+        defer controller.Close()
+        go controller.Run(ctx, *interval)
+    }
+
+    // Send as fast as the kernel lets us until the time is up
+    conn.SetWriteDeadline(time.Now().Add(*duration))
+    start := time.Now()
+    buf := make([]byte, 64*1024)
+    written := 0
+    for ctx.Err() == nil {
+        n, err := conn.Write(buf)
+        written += n
+        if err != nil {
+            break
+        }
+    }
+    <-ctx.Done()
+
+    fmt.Printf("Sent %d bytes (%.4g Mbit/s)\n", written, float64(written)*8/1e6/time.Since(start).Seconds())
+    fmt.Print(summary)
+}
+
+// shadowSummary accumulates the records of a shadow run
+type shadowSummary struct {
+    samples     int
+    uncovered   int         // Samples whose memory no whisker covers
+    uses        map[int]int // Samples per whisker index
+    windowRatio float64     // Sum of the tree's window over the kernel's
+    ratios      int         // Samples in windowRatio
+}
+
+// newShadowSummary creates an empty summary
+func newShadowSummary() *shadowSummary {
+    return &shadowSummary{uses: make(map[int]int)}
+}
+
+// add accumulates a record; the monitor calls it from one goroutine at a time
+func (s *shadowSummary) add(r shadow.Record) {
+    s.samples++
+    if r.Error != "" {
+        s.uncovered++
+        return
+    }
+    s.uses[r.Whisker]++
+    if ratio := r.WindowRatio(); ratio > 0 {
+        s.windowRatio += ratio
+        s.ratios++
+    }
+}
+
+// String reports the whiskers used, most used first, and how the tree's windows compare with the kernel's
+func (s *shadowSummary) String() string {
+    out := fmt.Sprintf("%d samples, %d not covered by the tree\n", s.samples, s.uncovered)
+    if s.ratios > 0 {
+        out += fmt.Sprintf("Tree window averages %.3g times the kernel's\n", s.windowRatio/float64(s.ratios))
+    }
+    indexes := make([]int, 0, len(s.uses))
+    for i := range s.uses {
+        indexes = append(indexes, i)
+    }
+    sort.Slice(indexes, func(a, b int) bool {
+        if s.uses[indexes[a]] != s.uses[indexes[b]] {
+            return s.uses[indexes[a]] > s.uses[indexes[b]]
+        }
+        return indexes[a] < indexes[b]
+    })
+    for _, i := range indexes {
+        if i < 0 {
+            // Only the placeholder root of a tree covers memories its whiskers do not
+            out += fmt.Sprintf("Root placeholder: %d samples\n", s.uses[i])
+            continue
+        }
+        out += fmt.Sprintf("Whisker %d: %d samples\n", i, s.uses[i])
+    }
+    return out
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/Aanthord/remy-go/pkg/network"
+    "github.com/Aanthord/remy-go/pkg/qlog"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// runSimulate implements the simulate subcommand, which runs a tree once in the simulator, reports what every
+// sender achieved and can capture every packet event and window update for standard tools
+func runSimulate(args []string) {
+    fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+    inputFile := fs.String("if", "", "Path to the whiskers to simulate")
+    pcapFile := fs.String("pcap", "", "Path to write every enqueue, drop, dequeue and ACK as a capture, pcapng if it ends in .pcapng and pcap otherwise")
+    qlogFile := fs.String("qlog", "", "Path to write every sender's packets, window updates and the queue length as a qlog JSON-SEQ trace")
+    eval := addEvaluatorFlags(fs)
+    fs.Parse(args)
+
+    if *inputFile == "" {
+        fmt.Println("Usage: remy simulate -if whiskers.pb [-pcap events.pcapng] [-qlog events.sqlog] [options]")
+        fs.PrintDefaults()
+        os.Exit(2)
+    }
+
+    tree, err := whisker.LoadWhiskers(*inputFile)
+    if err != nil {
+        fmt.Println("Error loading whiskers:", err)
+        os.Exit(1)
+    }
+    config, err := eval.config()
+    if err != nil {
+        fmt.Println("Error configuring the network:", err)
+        os.Exit(1)
+    }
+
+    sim := network.NewNetwork(tree, config, *eval.seed, false)
+    var events []network.EventLog
+    if *pcapFile != "" {
+        f, err := os.Create(*pcapFile)
+        if err != nil {
+            fmt.Println("Error creating capture:", err)
+            os.Exit(1)
+        }
+        defer f.Close()
+        events = append(events, network.NewPcapEventLog(f, network.PcapFormatFor(*pcapFile)))
+    }
+    if *qlogFile != "" {
+        f, err := os.Create(*qlogFile)
+        if err != nil {
+            fmt.Println("Error creating qlog:", err)
+            os.Exit(1)
+        }
+        defer f.Close()
+        logger := qlog.NewLogger(f, qlog.Options{
+            Title:         fmt.Sprintf("%s on a %g packets/ms link with %g ms round trip", *inputFile, config.LinkPPT, config.RTT),
+            VantagePoint:  "network",
+            ReferenceTime: sim.Now(),
+            PacketSize:    network.PcapPacketSize,
+            Whiskers:      tree,
+        })
+        events = append(events, logger.Events())
+        sim.RAT().SetDecisionLog(logger.Decisions())
+    }
+    if len(events) > 0 {
+        log := network.MultiEventLog(events...)
+        sim.SetEventLog(log)
+        defer func() {
+            if err := log.Flush(); err != nil {
+                fmt.Println("Error writing event logs:", err)
+            }
+        }()
+    }
+    sim.Run(*eval.duration)
+    if err := sim.Err(); err != nil {
+        fmt.Println("Error recording events:", err)
+    }
+
+    for i, stats := range sim.Results() {
+        fmt.Printf("Sender %d: %d sent, %d received, %d dropped, throughput %.4g packets/ms, delay %.4g ms, utility %.4g\n",
+            i, stats.PacketsSent, stats.PacketsReceived, stats.PacketsDropped, stats.Throughput(), stats.AverageDelay(),
+            stats.Utility(config, *eval.delta))
+    }
+    link := sim.Link()
+    fmt.Printf("Link dropped %d by the buffer, %d by the AQM, %d lost\n", link.Dropped(), link.AQMDropped(), link.Lost())
+    fmt.Printf("Utility: %v\n", sim.Utility(*eval.delta))
+}
@@ -0,0 +1,53 @@
+package clock
+
+import (
+    "sync"
+    "time"
+)
+
+// Clock tells the current time
+// Everything that timestamps packets or schedules sends takes its time from a Clock, so the
+// same code can run against the wall clock or inside a simulation
+type Clock interface {
+    Now() time.Time
+}
+
+// Real is a Clock backed by the system time
+type Real struct{}
+
+// Now returns the current system time
+func (Real) Now() time.Time {
+    return time.Now()
+}
+
+// Manual is a Clock that only moves when it is told to
+type Manual struct {
+    now time.Time
+    mu  sync.Mutex
+}
+
+// NewManual is a constructor that creates a Manual clock starting at the given time
+func NewManual(start time.Time) *Manual {
+    return &Manual{now: start}
+}
+
+// Now returns the current time of the clock
+func (c *Manual) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+// Set moves the clock to the given time
+func (c *Manual) Set(now time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = now
+}
+
+// Advance moves the clock forward by the given duration
+func (c *Manual) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
@@ -0,0 +1,367 @@
+package codegen
+
+import (
+    "bytes"
+    "fmt"
+    "math"
+    "text/template"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// FixedShift is the number of fractional bits of the fixed-point numbers in generated BPF programs
+// BPF programs cannot use floating point, so signal values and domain bounds are scaled by 2^FixedShift
+const FixedShift = 16
+
+// caPrivSize is the size in bytes of the per-socket private area of a congestion control algorithm (ICSK_CA_PRIV_SIZE)
+const caPrivSize = 104
+
+// MaxBPFWhiskers is the largest number of whiskers, counting internal nodes, that GenerateBPF accepts
+// The lookup scans every whisker on every ACK and the verifier walks each iteration of that loop, so larger trees
+// risk exceeding its limit of a million instructions processed per program; prune such trees first.
+const MaxBPFWhiskers = 512
+
+// BPFOptions control the BPF program generated for a whisker tree
+type BPFOptions struct {
+    Name string // Name of the congestion control algorithm, as set with TCP_CONGESTION
+}
+
+// DefaultBPFOptions returns options for an algorithm named remy, which shadow.SetCongestion("remy") selects on a socket
+func DefaultBPFOptions() BPFOptions {
+    return BPFOptions{Name: "remy"}
+}
+
+// bpfSignal describes how a generated BPF program computes a memory signal
+type bpfSignal struct {
+    Name   string
+    Gain   string // Gain as a fixed-point number
+    Ack    string // C expression of the sample taken on an ACK, or empty if the signal is not sampled on ACKs
+    Loss   string // C expression of the sample taken on a loss, or empty if the signal is not sampled on losses
+    Assign bool   // Whether the sample replaces the value outright rather than being averaged in
+}
+
+// bpfSamples are the samples of the signals a generated BPF program knows how to compute
+// On an ACK, recv_us is the time the ACK arrived, sent_us the time the acknowledged segment was sent and
+// rtt_us its round-trip time, all in microseconds; intervals are converted to fixed-point milliseconds
+var bpfSamples = map[string]struct{ ack, loss string }{
+    memory.RecvRateSignal.Name:     {ack: "remy_ms(recv_us - ca->last_recv_us)"},
+    memory.SendRateSignal.Name:     {ack: "remy_ms(sent_us - ca->last_sent_us)"},
+    memory.RTTRatioSignal.Name:     {ack: "((__u64)rtt_us << REMY_FIXED_SHIFT) / ca->min_rtt_us"},
+    memory.SlowRecvRateSignal.Name: {ack: "remy_ms(recv_us - ca->last_recv_us)"},
+    memory.SlowSendRateSignal.Name: {ack: "remy_ms(sent_us - ca->last_sent_us)"},
+    memory.LossRateSignal.Name:     {ack: "0", loss: "REMY_FIXED_ONE"},
+}
+
+// bpfWhisker is a whisker of the flattened table in a generated BPF program
+type bpfWhisker struct {
+    Index           int
+    Lower, Upper    []string
+    WindowIncrement int
+    WindowMultiple  string
+    IntersendNs     uint64
+}
+
+// bpfData is the data the BPF program template is executed with
+type bpfData struct {
+    Name       string
+    FixedShift int
+    CAPrivSize int
+    NumSignals int
+    HasLoss    bool // Whether any signal is sampled on losses
+    Signals    []bpfSignal
+    Whiskers   []bpfWhisker
+}
+
+// GenerateBPF returns the C source of a BPF struct_ops program that implements the tree as a TCP congestion
+// control algorithm
+// The program updates the memory signals from every ACK as Remy's Memory::packets_received does, treating the ACK
+// as the reception of the segment it acknowledges, and applies the action of the deepest whisker containing the
+// memory to the congestion window and pacing rate. The whiskers are flattened, deepest first, into a table in the
+// program's read-only data, which libbpf loads as an array map.
+// Values are compared in fixed point, so points within 2^-16 of a domain boundary may fall on the other side of it.
+// Trees of more than MaxBPFWhiskers whiskers are refused. The output is only compiled by the tests where clang,
+// bpftool and the kernel's BTF are installed; elsewhere it is checked against golden files alone.
+func GenerateBPF(tree *whisker.WhiskerTree, opts BPFOptions) ([]byte, error) {
+    if opts.Name == "" || len(opts.Name) >= 16 {
+        return nil, fmt.Errorf("algorithm name %q must have between 1 and 15 characters", opts.Name)
+    }
+    list, _ := entries(tree)
+    if len(list) > MaxBPFWhiskers {
+        return nil, fmt.Errorf("tree has %d whiskers, more than the %d a BPF program can look up; prune it first", len(list), MaxBPFWhiskers)
+    }
+
+    data := bpfData{Name: opts.Name, FixedShift: FixedShift, CAPrivSize: caPrivSize}
+    for _, s := range memory.Signals() {
+        sample, ok := bpfSamples[s.Name]
+        if !ok {
+            return nil, fmt.Errorf("signal %q cannot be computed in BPF", s.Name)
+        }
+        data.HasLoss = data.HasLoss || sample.loss != ""
+        data.Signals = append(data.Signals, bpfSignal{
+            Name:   s.Name,
+            Gain:   bpfFixed(float64(s.Gain)),
+            Ack:    sample.ack,
+            Loss:   sample.loss,
+            Assign: s.Gain == 1,
+        })
+    }
+    data.NumSignals = len(data.Signals)
+    if size := 8*data.NumSignals + 24; size > caPrivSize {
+        return nil, fmt.Errorf("state of %d signals takes %d bytes, more than the %d bytes the kernel keeps per socket", data.NumSignals, size, caPrivSize)
+    }
+
+    for _, e := range deepestFirst(list) {
+        w := e.node.Whisker
+        bw := bpfWhisker{
+            Index:           e.index,
+            WindowIncrement: w.WindowIncrement,
+            WindowMultiple:  bpfFixed(w.WindowMultiple),
+            IntersendNs:     uint64(math.Max(0, math.Round(w.Intersend*1e9))),
+        }
+        for i := 0; i < data.NumSignals; i++ {
+            id := memory.SignalID(i)
+            bw.Lower = append(bw.Lower, bpfFixed(float64(w.Domain.Lower.Get(id))))
+            bw.Upper = append(bw.Upper, bpfFixed(float64(w.Domain.Upper.Get(id))))
+        }
+        data.Whiskers = append(data.Whiskers, bw)
+    }
+
+    var b bytes.Buffer
+    if err := bpfTemplate.Execute(&b, data); err != nil {
+        return nil, err
+    }
+    return b.Bytes(), nil
+}
+
+// bpfFixed returns a C literal of a non-negative value in fixed point
+// Negative values become zero and values too large to represent, including +Inf, become the largest value
+func bpfFixed(v float64) string {
+    scaled := math.Round(v * (1 << FixedShift))
+    switch {
+    case math.IsNaN(scaled) || scaled <= 0:
+        return "0"
+    case scaled >= math.MaxUint64:
+        return "REMY_FIXED_MAX"
+    default:
+        return fmt.Sprintf("%dULL", uint64(scaled))
+    }
+}
+
+var bpfTemplate = template.Must(template.New("bpf").Parse(`// Code generated by remy codegen. DO NOT EDIT.
+// SPDX-License-Identifier: GPL-2.0
+
+// {{.Name}} implements a trained Remy whisker tree of {{len .Whiskers}} whiskers as a TCP congestion control
+// algorithm. Build it with clang -target bpf against a vmlinux.h generated by bpftool, and register it with
+// bpftool struct_ops register, after which it can be selected with the TCP_CONGESTION socket option.
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+char _license[] SEC("license") = "GPL";
+
+#define REMY_FIXED_SHIFT {{.FixedShift}}
+#define REMY_FIXED_ONE (1ULL << REMY_FIXED_SHIFT)
+#define REMY_FIXED_MAX (~0ULL)
+#define REMY_NUM_SIGNALS {{.NumSignals}}
+#define REMY_NUM_WHISKERS {{len .Whiskers}}
+#define REMY_MAX_WINDOW 1000000
+#define REMY_NSEC_PER_SEC 1000000000ULL
+
+/* Signals, in the order of the domain bounds:
+{{- range $i, $s := .Signals}}
+ *   {{$i}} {{$s.Name}}
+{{- end}}
+ */
+
+struct remy_whisker {
+	__u64 lower[REMY_NUM_SIGNALS];
+	__u64 upper[REMY_NUM_SIGNALS];
+	__s32 window_increment;
+	__u32 window_multiple; /* Fixed point */
+	__u64 intersend_ns;
+};
+
+/* Whiskers ordered deepest first, so the first whose domain contains the memory is the one Remy chooses */
+const volatile struct remy_whisker remy_whiskers[REMY_NUM_WHISKERS] = {
+{{- range .Whiskers}}
+	{ /* whisker {{.Index}} */
+		.lower = { {{range $i, $v := .Lower}}{{if $i}}, {{end}}{{$v}}{{end}} },
+		.upper = { {{range $i, $v := .Upper}}{{if $i}}, {{end}}{{$v}}{{end}} },
+		.window_increment = {{.WindowIncrement}},
+		.window_multiple = {{.WindowMultiple}},
+		.intersend_ns = {{.IntersendNs}}ULL,
+	},
+{{- end}}
+};
+
+/* Per-socket state, kept in the private area of the congestion control algorithm */
+struct remy_ca {
+	__u64 memory[REMY_NUM_SIGNALS]; /* Fixed point */
+	__u64 last_sent_us;
+	__u64 last_recv_us;
+	__u32 min_rtt_us;
+	__u32 primed; /* Whether an ACK has set the reference timestamps */
+};
+
+_Static_assert(sizeof(struct remy_ca) <= {{.CAPrivSize}}, "remy_ca does not fit in ICSK_CA_PRIV_SIZE");
+
+static __always_inline struct remy_ca *remy_ca(struct sock *sk)
+{
+	return (struct remy_ca *)((struct inet_connection_sock *)sk)->icsk_ca_priv;
+}
+
+static __always_inline struct tcp_sock *remy_tcp_sk(struct sock *sk)
+{
+	return (struct tcp_sock *)sk;
+}
+
+/* remy_ms converts an interval in microseconds to fixed-point milliseconds */
+static __always_inline __u64 remy_ms(__u64 us)
+{
+	return (us << REMY_FIXED_SHIFT) / 1000;
+}
+
+/* remy_ewma averages a sample into a value with a fixed-point gain */
+static __always_inline __u64 remy_ewma(__u64 value, __u64 sample, __u64 gain)
+{
+	return (value * (REMY_FIXED_ONE - gain) + sample * gain) >> REMY_FIXED_SHIFT;
+}
+
+/* remy_lookup returns the deepest whisker whose domain contains the memory, or NULL if none does */
+static __always_inline const volatile struct remy_whisker *remy_lookup(const struct remy_ca *ca)
+{
+	for (int i = 0; i < REMY_NUM_WHISKERS; i++) {
+		const volatile struct remy_whisker *w = &remy_whiskers[i];
+		int inside = 1;
+
+#pragma unroll
+		for (int j = 0; j < REMY_NUM_SIGNALS; j++) {
+			if (ca->memory[j] < w->lower[j] || (w->upper[j] != REMY_FIXED_MAX && ca->memory[j] >= w->upper[j])) {
+				inside = 0;
+				break;
+			}
+		}
+		if (inside)
+			return w;
+	}
+	return NULL;
+}
+
+/* remy_apply applies the action of the whisker chosen for the current memory to the window and pacing rate */
+static __always_inline void remy_apply(struct sock *sk, __u32 prev_window)
+{
+	struct tcp_sock *tp = remy_tcp_sk(sk);
+	const volatile struct remy_whisker *w = remy_lookup(remy_ca(sk));
+	__s64 window;
+
+	if (!w)
+		return;
+
+	window = (((__s64)prev_window * w->window_multiple) >> REMY_FIXED_SHIFT) + w->window_increment;
+	if (window < 1)
+		window = 1;
+	if (window > REMY_MAX_WINDOW)
+		window = REMY_MAX_WINDOW;
+	if (window > tp->snd_cwnd_clamp)
+		window = tp->snd_cwnd_clamp;
+	tp->snd_cwnd = window;
+
+	if (w->intersend_ns) {
+		sk->sk_pacing_rate = (__u64)tp->mss_cache * REMY_NSEC_PER_SEC / w->intersend_ns;
+		sk->sk_pacing_status = SK_PACING_NEEDED;
+	} else {
+		sk->sk_pacing_rate = ~0UL;
+	}
+}
+
+SEC("struct_ops")
+void BPF_PROG(remy_init, struct sock *sk)
+{
+	struct remy_ca *ca = remy_ca(sk);
+
+	__builtin_memset(ca, 0, sizeof(*ca));
+
+	/* As Remy does at the start of a flow, take the window from the whisker for the empty memory */
+	remy_apply(sk, 0);
+}
+
+SEC("struct_ops")
+void BPF_PROG(remy_pkts_acked, struct sock *sk, const struct ack_sample *sample)
+{
+	struct remy_ca *ca = remy_ca(sk);
+	struct tcp_sock *tp = remy_tcp_sk(sk);
+	__u64 recv_us, sent_us;
+	__u32 rtt_us;
+
+	if (sample->rtt_us <= 0)
+		return;
+
+	rtt_us = sample->rtt_us;
+	recv_us = tp->tcp_mstamp;
+	sent_us = recv_us - rtt_us;
+
+	if (!ca->primed) {
+		ca->last_sent_us = sent_us;
+		ca->last_recv_us = recv_us;
+		ca->min_rtt_us = rtt_us;
+		ca->primed = 1;
+		return;
+	}
+	if (rtt_us < ca->min_rtt_us)
+		ca->min_rtt_us = rtt_us;
+
+	/* A segment sent no later than the last one sampled, such as a retransmission, would make the intervals
+	 * negative, which wrap around as unsigned numbers, so it leaves the memory alone */
+	if (sent_us > ca->last_sent_us) {
+{{- range $i, $s := .Signals}}{{if $s.Ack}}
+		/* {{$s.Name}} */
+		ca->memory[{{$i}}] = {{if $s.Assign}}{{$s.Ack}}{{else}}remy_ewma(ca->memory[{{$i}}], {{$s.Ack}}, {{$s.Gain}}){{end}};
+{{- end}}{{end}}
+
+		ca->last_sent_us = sent_us;
+		ca->last_recv_us = recv_us;
+	}
+
+	remy_apply(sk, tp->snd_cwnd);
+}
+
+SEC("struct_ops")
+void BPF_PROG(remy_cong_avoid, struct sock *sk, __u32 ack, __u32 acked)
+{
+	/* The window is set on every ACK by remy_pkts_acked */
+}
+
+SEC("struct_ops")
+__u32 BPF_PROG(remy_ssthresh, struct sock *sk)
+{
+	struct tcp_sock *tp = remy_tcp_sk(sk);
+{{- if .HasLoss}}
+	struct remy_ca *ca = remy_ca(sk);
+{{range $i, $s := .Signals}}{{if $s.Loss}}
+	/* {{$s.Name}} */
+	ca->memory[{{$i}}] = {{if $s.Assign}}{{$s.Loss}}{{else}}remy_ewma(ca->memory[{{$i}}], {{$s.Loss}}, {{$s.Gain}}){{end}};
+{{- end}}{{end}}
+{{- end}}
+
+	/* Losses only affect the window through the memory, as in Remy */
+	return tp->snd_cwnd > 2 ? tp->snd_cwnd : 2;
+}
+
+SEC("struct_ops")
+__u32 BPF_PROG(remy_undo_cwnd, struct sock *sk)
+{
+	return remy_tcp_sk(sk)->snd_cwnd;
+}
+
+SEC(".struct_ops.link")
+struct tcp_congestion_ops remy = {
+	.init = (void *)remy_init,
+	.pkts_acked = (void *)remy_pkts_acked,
+	.cong_avoid = (void *)remy_cong_avoid,
+	.ssthresh = (void *)remy_ssthresh,
+	.undo_cwnd = (void *)remy_undo_cwnd,
+	.name = "{{.Name}}",
+};
+`))
@@ -0,0 +1,263 @@
+package codegen
+
+import (
+    "bytes"
+    "flag"
+    "math"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata with the generated output")
+
+// testWhisker returns a whisker whose domain is the whole memory space except for the given bounds of one signal
+func testWhisker(generation uint, increment int, multiple, intersend float64, bounds map[memory.SignalID][2]float64) *whisker.Whisker {
+    lower, upper := memory.MinMemory(), memory.MaxMemory()
+    for id, b := range bounds {
+        lower.Set(id, memory.DataType(b[0]))
+        upper.Set(id, memory.DataType(b[1]))
+    }
+    return whisker.NewWhisker(generation, increment, multiple, intersend, memory.NewMemoryRange(lower, upper))
+}
+
+// testTree returns a small tree with two levels of splits on different signals
+func testTree(t testing.TB) *whisker.WhiskerTree {
+    tree := whisker.NewWhiskerTree()
+    for _, w := range []*whisker.Whisker{
+        testWhisker(1, 1, 1, 0.01, nil),
+        testWhisker(2, 2, 0.5, 0.002, map[memory.SignalID][2]float64{0: {0, 10}}),
+        testWhisker(2, -1, 0.875, 0.05, map[memory.SignalID][2]float64{0: {10, math.Inf(1)}}),
+        testWhisker(3, 4, 1.25, 0.0005, map[memory.SignalID][2]float64{0: {0, 10}, 2: {0, 1.5}}),
+    } {
+        if err := tree.Insert(w); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return tree
+}
+
+// checkGolden compares generated output with a golden file in testdata, or rewrites the file with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+    t.Helper()
+    path := filepath.Join("testdata", name)
+    if *update {
+        if err := os.WriteFile(path, got, 0644); err != nil {
+            t.Fatal(err)
+        }
+        return
+    }
+    want, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatalf("%v (run go test -update to create it)", err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Errorf("generated output differs from %s; run go test -update and review the diff", path)
+    }
+}
+
+func TestGolden(t *testing.T) {
+    single := whisker.NewWhiskerTree()
+    if err := single.Insert(testWhisker(1, 1, 1, 0, nil)); err != nil {
+        t.Fatal(err)
+    }
+    trees := map[string]*whisker.WhiskerTree{"single": single, "split": testTree(t)}
+
+    for name, tree := range trees {
+        source, err := GenerateBPF(tree, DefaultBPFOptions())
+        if err != nil {
+            t.Fatalf("GenerateBPF(%s): %v", name, err)
+        }
+        checkGolden(t, name+".bpf.c", source)
+
+        for _, style := range []Style{Nested, Table} {
+            opts := DefaultGoOptions()
+            opts.Style = style
+            source, err := GenerateGo(tree, opts)
+            if err != nil {
+                t.Fatalf("GenerateGo(%s, %v): %v", name, style, err)
+            }
+            checkGolden(t, name+"."+style.String()+".go.golden", source)
+        }
+    }
+}
+
+func TestBPFRefusesLargeTrees(t *testing.T) {
+    tree := whisker.NewWhiskerTree()
+    for i := 0; i < MaxBPFWhiskers; i++ {
+        if err := tree.Insert(testWhisker(1, 1, 1, 0, map[memory.SignalID][2]float64{0: {float64(i), float64(i + 1)}})); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // With the default root, the tree has one whisker too many
+    if _, err := GenerateBPF(tree, DefaultBPFOptions()); err == nil {
+        t.Errorf("GenerateBPF accepted a tree of %d whiskers", tree.Len())
+    }
+    tree.Root.Children = tree.Root.Children[1:]
+    tree.Invalidate()
+    if _, err := GenerateBPF(tree, DefaultBPFOptions()); err != nil {
+        t.Errorf("GenerateBPF refused a tree of %d whiskers: %v", tree.Len(), err)
+    }
+}
+
+// TestBPFCompiles builds the generated program with clang against the running kernel's BTF
+// It is skipped unless clang, bpftool, the kernel's BTF and the libbpf headers are all available
+func TestBPFCompiles(t *testing.T) {
+    clang, err := exec.LookPath("clang")
+    if err != nil {
+        t.Skip("clang not found")
+    }
+    bpftool, err := exec.LookPath("bpftool")
+    if err != nil {
+        t.Skip("bpftool not found")
+    }
+    const btf = "/sys/kernel/btf/vmlinux"
+    if _, err := os.Stat(btf); err != nil {
+        t.Skip("kernel BTF not available")
+    }
+
+    dir := t.TempDir()
+    vmlinux, err := exec.Command(bpftool, "btf", "dump", "file", btf, "format", "c").Output()
+    if err != nil {
+        t.Skipf("bpftool could not dump the kernel's BTF: %v", err)
+    }
+    if err := os.WriteFile(filepath.Join(dir, "vmlinux.h"), vmlinux, 0644); err != nil {
+        t.Fatal(err)
+    }
+    compile := func(name string, source []byte) ([]byte, error) {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, source, 0644); err != nil {
+            t.Fatal(err)
+        }
+        cmd := exec.Command(clang, "-target", "bpf", "-O2", "-g", "-I", dir, "-c", path, "-o", path+".o")
+        return cmd.CombinedOutput()
+    }
+    if _, err := compile("probe.c", []byte("#include \"vmlinux.h\"\n#include <bpf/bpf_helpers.h>\n")); err != nil {
+        t.Skip("libbpf headers not available")
+    }
+
+    source, err := GenerateBPF(testTree(t), DefaultBPFOptions())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if out, err := compile("remy.bpf.c", source); err != nil {
+        t.Fatalf("clang -target bpf failed: %v\n%s", err, out)
+    }
+}
+
+// TestGeneratedGoPasses writes the Go lookup of a tree in both styles, with and without the memory package, into a
+// module of its own along with the generated test, and runs go vet and go test on it
+// The generated test checks the lookup against the whisker the tree chooses for random points and points on the
+// domains' boundaries, so passing it in every style means the styles agree with each other as well.
+func TestGeneratedGoPasses(t *testing.T) {
+    if testing.Short() {
+        t.Skip("builds generated packages")
+    }
+    goTool := filepath.Join(runtime.GOROOT(), "bin", "go")
+    if _, err := os.Stat(goTool); err != nil {
+        t.Skip("go tool not found")
+    }
+    root, err := filepath.Abs("../..")
+    if err != nil {
+        t.Fatal(err)
+    }
+    sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tree := testTree(t)
+    for _, style := range []Style{Nested, Table} {
+        for _, standalone := range []bool{true, false} {
+            opts := DefaultGoOptions()
+            opts.Style = style
+            opts.Standalone = standalone
+            opts.TestPoints = 5000
+            name := style.String()
+            if standalone {
+                name += "/standalone"
+            }
+            t.Run(name, func(t *testing.T) {
+                source, err := GenerateGo(tree, opts)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                test, err := GenerateGoTest(tree, opts)
+                if err != nil {
+                    t.Fatal(err)
+                }
+
+                // A package using the memory package gets this module through a replace directive
+                dir := t.TempDir()
+                mod := "module example.com/" + opts.Package + "\n\ngo 1.22\n"
+                if !standalone {
+                    mod += "\nrequire github.com/Aanthord/remy-go v0.0.0\n\nreplace github.com/Aanthord/remy-go => " + root + "\n"
+                    mod += "\nreplace github.com/Aanthord/remy-go/proto => " + filepath.Join(root, "proto") + "\n"
+                    if err := os.WriteFile(filepath.Join(dir, "go.sum"), sum, 0644); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+                files := map[string][]byte{"go.mod": []byte(mod), "lookup.go": source, "lookup_test.go": test}
+                for file, data := range files {
+                    if err := os.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+
+                for _, args := range [][]string{{"vet", "./..."}, {"test", "./..."}} {
+                    cmd := exec.Command(goTool, args...)
+                    cmd.Dir = dir
+                    cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "GOTOOLCHAIN=local")
+                    if out, err := cmd.CombinedOutput(); err != nil {
+                        t.Fatalf("go %s failed: %v\n%s", args[0], err, out)
+                    }
+                }
+            })
+        }
+    }
+}
+
+func TestIndicesFollowWhiskers(t *testing.T) {
+    // Without a whisker of its own, the root keeps the default whisker, which Whiskers leaves out
+    tree := whisker.NewWhiskerTree()
+    for _, w := range []*whisker.Whisker{
+        testWhisker(1, 2, 1, 0, map[memory.SignalID][2]float64{0: {0, 10}}),
+        testWhisker(1, 3, 1, 0, map[memory.SignalID][2]float64{0: {10, math.Inf(1)}}),
+    } {
+        if err := tree.Insert(w); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    whiskers := tree.Whiskers()
+    list, indexes := entries(tree)
+    if len(list) != 3 || len(indexes) != 2 {
+        t.Fatalf("entries gave %d nodes and %d indices, want 3 and 2", len(list), len(indexes))
+    }
+    for _, e := range list {
+        if e.node == tree.Root {
+            if e.index != -1 {
+                t.Errorf("default root has index %d, want -1", e.index)
+            }
+            continue
+        }
+        if e.index < 0 || whiskers[e.index] != e.node.Whisker {
+            t.Errorf("whisker %v has index %d, which Whiskers gives to another", e.node.Whisker, e.index)
+        }
+    }
+
+    source, err := GenerateGo(tree, DefaultGoOptions())
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, want := range []string{"WindowIncrement: 2, WindowMultiple: 1, Intersend: 0, Whisker: 0}", "WindowIncrement: 3, WindowMultiple: 1, Intersend: 0, Whisker: 1}"} {
+        if !bytes.Contains(source, []byte(want)) {
+            t.Errorf("generated source lacks %s", want)
+        }
+    }
+}
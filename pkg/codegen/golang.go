@@ -0,0 +1,395 @@
+package codegen
+
+import (
+    "bytes"
+    "fmt"
+    "go/format"
+    "math"
+    "math/rand"
+    "strconv"
+    "strings"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Style selects how a generated lookup function is laid out
+type Style int
+
+const (
+    Nested Style = iota // Nested comparisons that follow the structure of the tree
+    Table               // A flat table of domains scanned from the deepest whisker up
+)
+
+// String returns the name of the style
+func (s Style) String() string {
+    switch s {
+    case Nested:
+        return "nested"
+    case Table:
+        return "table"
+    default:
+        return fmt.Sprintf("style(%d)", int(s))
+    }
+}
+
+// ParseStyle returns the style with the given name
+func ParseStyle(name string) (Style, error) {
+    switch name {
+    case "nested":
+        return Nested, nil
+    case "table":
+        return Table, nil
+    default:
+        return 0, fmt.Errorf("unknown style %q", name)
+    }
+}
+
+// GoOptions control the Go source generated for a whisker tree
+type GoOptions struct {
+    Package    string // Name of the generated package
+    Style      Style
+    Standalone bool  // Take the memory as []float64 and import nothing, rather than taking a memory.Memory
+    TestPoints int   // Number of random points checked by the generated test
+    Seed       int64 // Seed of the random points
+}
+
+// DefaultGoOptions returns options for a nested lookup in package controller that depends on the memory package
+func DefaultGoOptions() GoOptions {
+    return GoOptions{
+        Package:    "controller",
+        Style:      Nested,
+        TestPoints: 1000,
+        Seed:       1,
+    }
+}
+
+// entry is a whisker of the tree along with its index in the order of WhiskerTree.Whiskers and its depth
+type entry struct {
+    node  *whisker.WhiskerNode
+    index int
+    depth int
+}
+
+// entries returns every node of the tree in depth-first order, along with the index of every whisker
+// Whiskers are numbered as WhiskerTree.Whiskers lists them, as qlog, the metrics and shadow mode number them; the
+// default root whisker of a tree built by NewWhiskerTree, which Whiskers leaves out, gets -1
+func entries(tree *whisker.WhiskerTree) ([]entry, map[*whisker.Whisker]int) {
+    indexes := make(map[*whisker.Whisker]int)
+    for i, w := range tree.Whiskers() {
+        indexes[w] = i
+    }
+    var list []entry
+    tree.Walk(func(node *whisker.WhiskerNode, depth int, parent *whisker.WhiskerNode) {
+        list = append(list, entry{node: node, index: indexOf(node.Whisker, indexes), depth: depth})
+    })
+    return list, indexes
+}
+
+// deepestFirst returns the entries ordered by decreasing depth, keeping depth-first order among equal depths
+// Scanning the result for the first domain that contains a point finds the deepest such whisker
+func deepestFirst(list []entry) []entry {
+    sorted := make([]entry, len(list))
+    copy(sorted, list)
+    for i := 1; i < len(sorted); i++ {
+        for j := i; j > 0 && sorted[j].depth > sorted[j-1].depth; j-- {
+            sorted[j], sorted[j-1] = sorted[j-1], sorted[j]
+        }
+    }
+    return sorted
+}
+
+// GenerateGo returns Go source implementing the tree as a Lookup function
+// Lookup returns the action of the deepest whisker whose domain contains the memory, exactly as
+// WhiskerTree.FindWhisker does, along with the index of that whisker in the order of WhiskerTree.Whiskers
+func GenerateGo(tree *whisker.WhiskerTree, opts GoOptions) ([]byte, error) {
+    valueType := "memory.DataType"
+    if opts.Standalone {
+        valueType = "float64"
+    }
+
+    var b bytes.Buffer
+    names := memory.SignalNames()
+    fmt.Fprintf(&b, "// Signals lists the memory signals in the order Lookup expects them\n")
+    fmt.Fprintf(&b, "var Signals = [...]string{")
+    for i, name := range names {
+        if i > 0 {
+            b.WriteString(", ")
+        }
+        b.WriteString(strconv.Quote(name))
+    }
+    fmt.Fprintf(&b, "}\n\n")
+
+    fmt.Fprintf(&b, "// Action is the action of a whisker\n")
+    fmt.Fprintf(&b, "type Action struct {\n")
+    fmt.Fprintf(&b, "WindowIncrement int\n")
+    fmt.Fprintf(&b, "WindowMultiple float64\n")
+    fmt.Fprintf(&b, "Intersend float64\n")
+    fmt.Fprintf(&b, "Whisker int // Index of the whisker in the order the whisker file lists them, or -1 if none of them covers the memory\n")
+    fmt.Fprintf(&b, "}\n\n")
+
+    if !opts.Standalone {
+        fmt.Fprintf(&b, "// Lookup returns the action for the given memory\n")
+        fmt.Fprintf(&b, "func Lookup(m memory.Memory) Action {\n")
+        fmt.Fprintf(&b, "return LookupValues(m.Values)\n")
+        fmt.Fprintf(&b, "}\n\n")
+    }
+
+    fmt.Fprintf(&b, "// LookupValues returns the action for the given signal values, laid out as in Signals\n")
+    fmt.Fprintf(&b, "func LookupValues(v []%s) Action {\n", valueType)
+    fmt.Fprintf(&b, "if len(v) < %d {\nreturn Action{Whisker: -1}\n}\n", len(names))
+
+    list, indexes := entries(tree)
+    switch opts.Style {
+    case Nested:
+        root := tree.Root
+        fmt.Fprintf(&b, "if !(%s) {\nreturn Action{Whisker: -1}\n}\n", goCondition(root.Whisker.Domain, nil))
+        writeNested(&b, root, indexes)
+    case Table:
+        writeTable(&b, valueType)
+    default:
+        return nil, fmt.Errorf("unknown style %v", opts.Style)
+    }
+    fmt.Fprintf(&b, "}\n")
+
+    if opts.Style == Table {
+        writeTableData(&b, list)
+    }
+
+    var imports []string
+    if bytes.Contains(b.Bytes(), []byte("math.")) {
+        imports = append(imports, "math")
+    }
+    if !opts.Standalone {
+        imports = append(imports, "github.com/Aanthord/remy-go/pkg/memory")
+    }
+    doc := fmt.Sprintf("// Package %s implements a trained Remy whisker tree of %d whiskers.\n", opts.Package, tree.Len())
+    source, err := format.Source(withHeader(doc, opts.Package, imports, b.Bytes()))
+    if err != nil {
+        return nil, fmt.Errorf("generated Go source does not parse: %w", err)
+    }
+    return source, nil
+}
+
+// writeNested is a recursive function that writes the body of a node: a branch for each child, then the node's own action
+func writeNested(b *bytes.Buffer, node *whisker.WhiskerNode, indexes map[*whisker.Whisker]int) {
+    for _, child := range node.Children {
+        fmt.Fprintf(b, "if %s {\n", goCondition(child.Whisker.Domain, node.Whisker.Domain))
+        writeNested(b, child, indexes)
+        fmt.Fprintf(b, "}\n")
+    }
+    fmt.Fprintf(b, "return %s\n", goAction(node.Whisker, indexOf(node.Whisker, indexes)))
+}
+
+// writeTable writes a lookup that scans the table of domains, deepest first
+func writeTable(b *bytes.Buffer, valueType string) {
+    fmt.Fprintf(b, "for _, e := range table {\n")
+    fmt.Fprintf(b, "inside := true\n")
+    fmt.Fprintf(b, "for i := range e.lower {\n")
+    fmt.Fprintf(b, "if !(%s(e.lower[i]) <= v[i] && v[i] < %s(e.upper[i])) {\ninside = false\nbreak\n}\n", valueType, valueType)
+    fmt.Fprintf(b, "}\n")
+    fmt.Fprintf(b, "if inside {\nreturn e.action\n}\n")
+    fmt.Fprintf(b, "}\n")
+    fmt.Fprintf(b, "return Action{Whisker: -1}\n")
+}
+
+// writeTableData writes the table of domains, ordered so that the first domain containing a point is the deepest
+func writeTableData(b *bytes.Buffer, list []entry) {
+    sorted := deepestFirst(list)
+    n := len(memory.SignalNames())
+    fmt.Fprintf(b, "\n// table lists the domains of the whiskers, deepest first\n")
+    fmt.Fprintf(b, "var table = [...]struct {\nlower, upper [%d]float64\naction Action\n}{\n", n)
+    for _, e := range sorted {
+        domain := e.node.Whisker.Domain
+        fmt.Fprintf(b, "{lower: [%d]float64{", n)
+        for i := 0; i < n; i++ {
+            if i > 0 {
+                b.WriteString(", ")
+            }
+            b.WriteString(goFloat(float64(domain.Lower.Get(memory.SignalID(i)))))
+        }
+        fmt.Fprintf(b, "}, upper: [%d]float64{", n)
+        for i := 0; i < n; i++ {
+            if i > 0 {
+                b.WriteString(", ")
+            }
+            b.WriteString(goFloat(float64(domain.Upper.Get(memory.SignalID(i)))))
+        }
+        fmt.Fprintf(b, "}, action: %s},\n", goAction(e.node.Whisker, e.index))
+    }
+    fmt.Fprintf(b, "}\n")
+}
+
+// goCondition returns a Go expression that checks if v lies within a domain
+// Bounds equal to those of the enclosing domain, which has already been checked, and infinite bounds are left out
+func goCondition(domain, enclosing *memory.MemoryRange) string {
+    var terms []string
+    for i := range domain.Lower.Values {
+        id := memory.SignalID(i)
+        lower, upper := domain.Lower.Get(id), domain.Upper.Get(id)
+        if !math.IsInf(float64(lower), -1) && (enclosing == nil || lower != enclosing.Lower.Get(id)) {
+            terms = append(terms, fmt.Sprintf("v[%d] >= %s", i, goFloat(float64(lower))))
+        }
+        if !math.IsInf(float64(upper), 1) && (enclosing == nil || upper != enclosing.Upper.Get(id)) {
+            terms = append(terms, fmt.Sprintf("v[%d] < %s", i, goFloat(float64(upper))))
+        }
+    }
+    if len(terms) == 0 {
+        return "true"
+    }
+    cond := terms[0]
+    for _, term := range terms[1:] {
+        cond += " && " + term
+    }
+    return cond
+}
+
+// goAction returns a Go composite literal of a whisker's action
+func goAction(w *whisker.Whisker, index int) string {
+    return fmt.Sprintf("Action{WindowIncrement: %d, WindowMultiple: %s, Intersend: %s, Whisker: %d}",
+        w.WindowIncrement, goFloat(w.WindowMultiple), goFloat(w.Intersend), index)
+}
+
+// goFloat returns a Go literal of a float that reads back as exactly the same value
+func goFloat(f float64) string {
+    switch {
+    case math.IsInf(f, 1):
+        return "math.Inf(1)"
+    case math.IsInf(f, -1):
+        return "math.Inf(-1)"
+    case math.IsNaN(f):
+        return "math.NaN()"
+    }
+    s := strconv.FormatFloat(f, 'g', -1, 64)
+    if s == "0" {
+        return "0"
+    }
+    return s
+}
+
+// GenerateGoTest returns a Go test for the source generated by GenerateGo
+// The test checks Lookup against the whisker FindWhisker chose for random points and for points on domain
+// boundaries, recorded when the test was generated, so it needs nothing but the generated package
+func GenerateGoTest(tree *whisker.WhiskerTree, opts GoOptions) ([]byte, error) {
+    _, indexes := entries(tree)
+    points := samplePoints(tree, opts.TestPoints, opts.Seed)
+
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "// lookupCases are points and the whiskers WhiskerTree.FindWhisker chose for them\n")
+    fmt.Fprintf(&b, "var lookupCases = []struct {\nvalues []float64\nwhisker int\n}{\n")
+    for _, p := range points {
+        m := memory.NewMemory()
+        m.Update(p...)
+        index := -1
+        if w, err := tree.FindWhisker(m); err == nil {
+            index = indexOf(w, indexes)
+        }
+        b.WriteString("{values: []float64{")
+        for i, v := range p {
+            if i > 0 {
+                b.WriteString(", ")
+            }
+            b.WriteString(goFloat(float64(v)))
+        }
+        fmt.Fprintf(&b, "}, whisker: %d},\n", index)
+    }
+    fmt.Fprintf(&b, "}\n\n")
+
+    fmt.Fprintf(&b, "func TestLookupAgreesWithTree(t *testing.T) {\n")
+    fmt.Fprintf(&b, "for _, c := range lookupCases {\n")
+    if opts.Standalone {
+        fmt.Fprintf(&b, "got := LookupValues(c.values)\n")
+    } else {
+        fmt.Fprintf(&b, "v := make([]memory.DataType, len(c.values))\n")
+        fmt.Fprintf(&b, "for i := range c.values {\nv[i] = memory.DataType(c.values[i])\n}\n")
+        fmt.Fprintf(&b, "got := Lookup(memory.Memory{Values: v})\n")
+    }
+    fmt.Fprintf(&b, "if got.Whisker != c.whisker {\n")
+    fmt.Fprintf(&b, "t.Errorf(\"Lookup(%%v) chose whisker %%d, the tree chose %%d\", c.values, got.Whisker, c.whisker)\n")
+    fmt.Fprintf(&b, "}\n}\n}\n")
+
+    imports := []string{"testing"}
+    if !opts.Standalone {
+        imports = append(imports, "github.com/Aanthord/remy-go/pkg/memory")
+    }
+    formatted, err := format.Source(withHeader("", opts.Package, imports, b.Bytes()))
+    if err != nil {
+        return nil, fmt.Errorf("generated Go test does not parse: %w", err)
+    }
+    return formatted, nil
+}
+
+// withHeader returns generated Go source made of the generated code notice, a package clause and imports followed by body
+func withHeader(doc, pkg string, imports []string, body []byte) []byte {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "// Code generated by remy codegen. DO NOT EDIT.\n\n")
+    fmt.Fprintf(&b, "%spackage %s\n\n", doc, pkg)
+    if len(imports) > 0 {
+        fmt.Fprintf(&b, "import (\n")
+        for i, path := range imports {
+            // Standard library imports come first, separated from the others by a blank line
+            if i > 0 && !strings.Contains(imports[i-1], ".") && strings.Contains(path, ".") {
+                b.WriteString("\n")
+            }
+            fmt.Fprintf(&b, "%s\n", strconv.Quote(path))
+        }
+        fmt.Fprintf(&b, ")\n\n")
+    }
+    b.Write(body)
+    return b.Bytes()
+}
+
+// indexOf returns the index of a whisker, or -1 if it has none
+func indexOf(w *whisker.Whisker, indexes map[*whisker.Whisker]int) int {
+    if index, ok := indexes[w]; ok {
+        return index
+    }
+    return -1
+}
+
+// samplePoints returns points spread over the finite part of the tree's domains, including the lower
+// corner of every leaf so that the half-open boundaries are exercised
+func samplePoints(tree *whisker.WhiskerTree, n int, seed int64) [][]memory.DataType {
+    dims := memory.NumSignals()
+    low := make([]float64, dims)
+    high := make([]float64, dims)
+    for i := range high {
+        low[i] = math.Inf(1)
+        high[i] = math.Inf(-1)
+    }
+    tree.Walk(func(node *whisker.WhiskerNode, depth int, parent *whisker.WhiskerNode) {
+        for i := 0; i < dims; i++ {
+            for _, v := range []float64{float64(node.Whisker.Domain.Lower.Get(memory.SignalID(i))), float64(node.Whisker.Domain.Upper.Get(memory.SignalID(i)))} {
+                if !math.IsInf(v, 0) {
+                    low[i] = math.Min(low[i], v)
+                    high[i] = math.Max(high[i], v)
+                }
+            }
+        }
+    })
+    for i := range low {
+        if math.IsInf(low[i], 0) {
+            low[i], high[i] = 0, 1
+        }
+        // Reach past the largest bound so that unbounded domains are sampled too
+        high[i] += 0.1*(high[i]-low[i]) + 1
+    }
+
+    var points [][]memory.DataType
+    for _, leaf := range tree.Leaves() {
+        p := make([]memory.DataType, dims)
+        for i := range p {
+            p[i] = leaf.Whisker.Domain.Lower.Get(memory.SignalID(i))
+        }
+        points = append(points, p)
+    }
+    rng := rand.New(rand.NewSource(seed))
+    for k := 0; k < n; k++ {
+        p := make([]memory.DataType, dims)
+        for i := range p {
+            p[i] = memory.DataType(low[i] + rng.Float64()*(high[i]-low[i]))
+        }
+        points = append(points, p)
+    }
+    return points
+}
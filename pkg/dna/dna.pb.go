@@ -333,10 +333,11 @@ type Memory struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	RecvRate         float32 `protobuf:"fixed32,1,opt,name=recv_rate,json=recvRate,proto3" json:"recv_rate,omitempty"`
-	SendRate         float32 `protobuf:"fixed32,2,opt,name=send_rate,json=sendRate,proto3" json:"send_rate,omitempty"`
-	LatestDelay      float32 `protobuf:"fixed32,3,opt,name=latest_delay,json=latestDelay,proto3" json:"latest_delay,omitempty"`
-	InterPacketDelay float32 `protobuf:"fixed32,4,opt,name=inter_packet_delay,json=interPacketDelay,proto3" json:"inter_packet_delay,omitempty"`
+	RecvRate         float32   `protobuf:"fixed32,1,opt,name=recv_rate,json=recvRate,proto3" json:"recv_rate,omitempty"`
+	SendRate         float32   `protobuf:"fixed32,2,opt,name=send_rate,json=sendRate,proto3" json:"send_rate,omitempty"`
+	LatestDelay      float32   `protobuf:"fixed32,3,opt,name=latest_delay,json=latestDelay,proto3" json:"latest_delay,omitempty"`
+	InterPacketDelay float32   `protobuf:"fixed32,4,opt,name=inter_packet_delay,json=interPacketDelay,proto3" json:"inter_packet_delay,omitempty"`
+	Values           []float32 `protobuf:"fixed32,5,rep,packed,name=values,proto3" json:"values,omitempty"`
 }
 
 func (x *Memory) Reset() {
@@ -399,12 +400,21 @@ func (x *Memory) GetInterPacketDelay() float32 {
 	return 0
 }
 
+func (x *Memory) GetValues() []float32 {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
 type Whiskers struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	Whiskers []*Whisker `protobuf:"bytes,1,rep,name=whiskers,proto3" json:"whiskers,omitempty"`
+	Signals  []string   `protobuf:"bytes,2,rep,name=signals,proto3" json:"signals,omitempty"`
+	Metadata *Metadata  `protobuf:"bytes,3,opt,name=metadata,proto3" json:"metadata,omitempty"`
 }
 
 func (x *Whiskers) Reset() {
@@ -446,6 +456,250 @@ func (x *Whiskers) GetWhiskers() []*Whisker {
 	return nil
 }
 
+func (x *Whiskers) GetSignals() []string {
+	if x != nil {
+		return x.Signals
+	}
+	return nil
+}
+
+func (x *Whiskers) GetMetadata() *Metadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type Metadata struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	FormatVersion       uint32             `protobuf:"varint,1,opt,name=format_version,json=formatVersion,proto3" json:"format_version,omitempty"`
+	Config              *ConfigRange       `protobuf:"bytes,2,opt,name=config,proto3" json:"config,omitempty"`
+	Objective           string             `protobuf:"bytes,3,opt,name=objective,proto3" json:"objective,omitempty"`
+	ObjectiveParameters map[string]float64 `protobuf:"bytes,4,rep,name=objective_parameters,json=objectiveParameters,proto3" json:"objective_parameters,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	Generations         uint32             `protobuf:"varint,5,opt,name=generations,proto3" json:"generations,omitempty"`
+	Seed                int64              `protobuf:"varint,6,opt,name=seed,proto3" json:"seed,omitempty"`
+	GitRevision         string             `protobuf:"bytes,7,opt,name=git_revision,json=gitRevision,proto3" json:"git_revision,omitempty"`
+	CreatedTimeNs       int64              `protobuf:"varint,8,opt,name=created_time_ns,json=createdTimeNs,proto3" json:"created_time_ns,omitempty"`
+	Score               float64            `protobuf:"fixed64,9,opt,name=score,proto3" json:"score,omitempty"`
+	ContentHash         string             `protobuf:"bytes,10,opt,name=content_hash,json=contentHash,proto3" json:"content_hash,omitempty"`
+}
+
+func (x *Metadata) Reset() {
+	*x = Metadata{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_dna_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metadata) ProtoMessage() {}
+
+func (x *Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_dna_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metadata.ProtoReflect.Descriptor instead.
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return file_proto_dna_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Metadata) GetFormatVersion() uint32 {
+	if x != nil {
+		return x.FormatVersion
+	}
+	return 0
+}
+
+func (x *Metadata) GetConfig() *ConfigRange {
+	if x != nil {
+		return x.Config
+	}
+	return nil
+}
+
+func (x *Metadata) GetObjective() string {
+	if x != nil {
+		return x.Objective
+	}
+	return ""
+}
+
+func (x *Metadata) GetObjectiveParameters() map[string]float64 {
+	if x != nil {
+		return x.ObjectiveParameters
+	}
+	return nil
+}
+
+func (x *Metadata) GetGenerations() uint32 {
+	if x != nil {
+		return x.Generations
+	}
+	return 0
+}
+
+func (x *Metadata) GetSeed() int64 {
+	if x != nil {
+		return x.Seed
+	}
+	return 0
+}
+
+func (x *Metadata) GetGitRevision() string {
+	if x != nil {
+		return x.GitRevision
+	}
+	return ""
+}
+
+func (x *Metadata) GetCreatedTimeNs() int64 {
+	if x != nil {
+		return x.CreatedTimeNs
+	}
+	return 0
+}
+
+func (x *Metadata) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Metadata) GetContentHash() string {
+	if x != nil {
+		return x.ContentHash
+	}
+	return ""
+}
+
+type Decision struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TimeNs    int64    `protobuf:"varint,1,opt,name=time_ns,json=timeNs,proto3" json:"time_ns,omitempty"`
+	FlowId    uint32   `protobuf:"varint,2,opt,name=flow_id,json=flowId,proto3" json:"flow_id,omitempty"`
+	LastSeqNo int32    `protobuf:"varint,3,opt,name=last_seq_no,json=lastSeqNo,proto3" json:"last_seq_no,omitempty"`
+	Memory    *Memory  `protobuf:"bytes,4,opt,name=memory,proto3" json:"memory,omitempty"`
+	Whisker   *Whisker `protobuf:"bytes,5,opt,name=whisker,proto3" json:"whisker,omitempty"`
+	OldWindow uint32   `protobuf:"varint,6,opt,name=old_window,json=oldWindow,proto3" json:"old_window,omitempty"`
+	NewWindow uint32   `protobuf:"varint,7,opt,name=new_window,json=newWindow,proto3" json:"new_window,omitempty"`
+	Intersend float64  `protobuf:"fixed64,8,opt,name=intersend,proto3" json:"intersend,omitempty"`
+	Error     string   `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *Decision) Reset() {
+	*x = Decision{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_dna_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Decision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Decision) ProtoMessage() {}
+
+func (x *Decision) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_dna_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Decision.ProtoReflect.Descriptor instead.
+func (*Decision) Descriptor() ([]byte, []int) {
+	return file_proto_dna_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Decision) GetTimeNs() int64 {
+	if x != nil {
+		return x.TimeNs
+	}
+	return 0
+}
+
+func (x *Decision) GetFlowId() uint32 {
+	if x != nil {
+		return x.FlowId
+	}
+	return 0
+}
+
+func (x *Decision) GetLastSeqNo() int32 {
+	if x != nil {
+		return x.LastSeqNo
+	}
+	return 0
+}
+
+func (x *Decision) GetMemory() *Memory {
+	if x != nil {
+		return x.Memory
+	}
+	return nil
+}
+
+func (x *Decision) GetWhisker() *Whisker {
+	if x != nil {
+		return x.Whisker
+	}
+	return nil
+}
+
+func (x *Decision) GetOldWindow() uint32 {
+	if x != nil {
+		return x.OldWindow
+	}
+	return 0
+}
+
+func (x *Decision) GetNewWindow() uint32 {
+	if x != nil {
+		return x.NewWindow
+	}
+	return 0
+}
+
+func (x *Decision) GetIntersend() float64 {
+	if x != nil {
+		return x.Intersend
+	}
+	return 0
+}
+
+func (x *Decision) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 var File_proto_dna_proto protoreflect.FileDescriptor
 
 var file_proto_dna_proto_rawDesc = []byte{
@@ -497,7 +751,7 @@ var file_proto_dna_proto_rawDesc = []byte{
 	0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x05, 0x6c, 0x6f, 0x77, 0x65, 0x72, 0x12, 0x21, 0x0a,
 	0x05, 0x75, 0x70, 0x70, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x64,
 	0x6e, 0x61, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x05, 0x75, 0x70, 0x70, 0x65, 0x72,
-	0x22, 0x93, 0x01, 0x0a, 0x06, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x12, 0x1b, 0x0a, 0x09, 0x72,
+	0x22, 0xab, 0x01, 0x0a, 0x06, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x12, 0x1b, 0x0a, 0x09, 0x72,
 	0x65, 0x63, 0x76, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x02, 0x52, 0x08,
 	0x72, 0x65, 0x63, 0x76, 0x52, 0x61, 0x74, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x65, 0x6e, 0x64,
 	0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x02, 0x52, 0x08, 0x73, 0x65, 0x6e,
@@ -506,11 +760,64 @@ var file_proto_dna_proto_rawDesc = []byte{
 	0x65, 0x73, 0x74, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x12, 0x2c, 0x0a, 0x12, 0x69, 0x6e, 0x74, 0x65,
 	0x72, 0x5f, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x18, 0x04,
 	0x20, 0x01, 0x28, 0x02, 0x52, 0x10, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x50, 0x61, 0x63, 0x6b, 0x65,
-	0x74, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x22, 0x34, 0x0a, 0x08, 0x57, 0x68, 0x69, 0x73, 0x6b, 0x65,
-	0x72, 0x73, 0x12, 0x28, 0x0a, 0x08, 0x77, 0x68, 0x69, 0x73, 0x6b, 0x65, 0x72, 0x73, 0x18, 0x01,
-	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x64, 0x6e, 0x61, 0x2e, 0x57, 0x68, 0x69, 0x73, 0x6b,
-	0x65, 0x72, 0x52, 0x08, 0x77, 0x68, 0x69, 0x73, 0x6b, 0x65, 0x72, 0x73, 0x42, 0x04, 0x5a, 0x02,
-	0x2e, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x74, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x12, 0x16, 0x0a, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73,
+	0x18, 0x05, 0x20, 0x03, 0x28, 0x02, 0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x22, 0x79,
+	0x0a, 0x08, 0x57, 0x68, 0x69, 0x73, 0x6b, 0x65, 0x72, 0x73, 0x12, 0x28, 0x0a, 0x08, 0x77, 0x68,
+	0x69, 0x73, 0x6b, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x64,
+	0x6e, 0x61, 0x2e, 0x57, 0x68, 0x69, 0x73, 0x6b, 0x65, 0x72, 0x52, 0x08, 0x77, 0x68, 0x69, 0x73,
+	0x6b, 0x65, 0x72, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x73, 0x12, 0x29,
+	0x0a, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0d, 0x2e, 0x64, 0x6e, 0x61, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52,
+	0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x22, 0xd6, 0x03, 0x0a, 0x08, 0x4d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x0e, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74,
+	0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d,
+	0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x28, 0x0a,
+	0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e,
+	0x64, 0x6e, 0x61, 0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52,
+	0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x1c, 0x0a, 0x09, 0x6f, 0x62, 0x6a, 0x65, 0x63,
+	0x74, 0x69, 0x76, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6f, 0x62, 0x6a, 0x65,
+	0x63, 0x74, 0x69, 0x76, 0x65, 0x12, 0x59, 0x0a, 0x14, 0x6f, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x69,
+	0x76, 0x65, 0x5f, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x18, 0x04, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x26, 0x2e, 0x64, 0x6e, 0x61, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61,
+	0x74, 0x61, 0x2e, 0x4f, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x50, 0x61, 0x72, 0x61,
+	0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x13, 0x6f, 0x62, 0x6a,
+	0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73,
+	0x12, 0x20, 0x0a, 0x0b, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x65, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x04, 0x73, 0x65, 0x65, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x67, 0x69, 0x74, 0x5f, 0x72, 0x65,
+	0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x67, 0x69,
+	0x74, 0x52, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x26, 0x0a, 0x0f, 0x63, 0x72, 0x65,
+	0x61, 0x74, 0x65, 0x64, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x6e, 0x73, 0x18, 0x08, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0d, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x4e,
+	0x73, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65,
+	0x6e, 0x74, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63,
+	0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x48, 0x61, 0x73, 0x68, 0x1a, 0x46, 0x0a, 0x18, 0x4f, 0x62,
+	0x6a, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02,
+	0x38, 0x01, 0x22, 0x9b, 0x02, 0x0a, 0x08, 0x44, 0x65, 0x63, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x17, 0x0a, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x6e, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x06, 0x74, 0x69, 0x6d, 0x65, 0x4e, 0x73, 0x12, 0x17, 0x0a, 0x07, 0x66, 0x6c, 0x6f, 0x77,
+	0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x66, 0x6c, 0x6f, 0x77, 0x49,
+	0x64, 0x12, 0x1e, 0x0a, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x73, 0x65, 0x71, 0x5f, 0x6e, 0x6f,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x53, 0x65, 0x71, 0x4e,
+	0x6f, 0x12, 0x23, 0x0a, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0b, 0x2e, 0x64, 0x6e, 0x61, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x06,
+	0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x12, 0x26, 0x0a, 0x07, 0x77, 0x68, 0x69, 0x73, 0x6b, 0x65,
+	0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x64, 0x6e, 0x61, 0x2e, 0x57, 0x68,
+	0x69, 0x73, 0x6b, 0x65, 0x72, 0x52, 0x07, 0x77, 0x68, 0x69, 0x73, 0x6b, 0x65, 0x72, 0x12, 0x1d,
+	0x0a, 0x0a, 0x6f, 0x6c, 0x64, 0x5f, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x09, 0x6f, 0x6c, 0x64, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x1d, 0x0a,
+	0x0a, 0x6e, 0x65, 0x77, 0x5f, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x09, 0x6e, 0x65, 0x77, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x1c, 0x0a, 0x09,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x73, 0x65, 0x6e, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x09, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x73, 0x65, 0x6e, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x42, 0x04, 0x5a, 0x02, 0x2e, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -525,7 +832,7 @@ func file_proto_dna_proto_rawDescGZIP() []byte {
 	return file_proto_dna_proto_rawDescData
 }
 
-var file_proto_dna_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_dna_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_proto_dna_proto_goTypes = []interface{}{
 	(*ConfigRange)(nil), // 0: dna.ConfigRange
 	(*Range)(nil),       // 1: dna.Range
@@ -533,21 +840,29 @@ var file_proto_dna_proto_goTypes = []interface{}{
 	(*MemoryRange)(nil), // 3: dna.MemoryRange
 	(*Memory)(nil),      // 4: dna.Memory
 	(*Whiskers)(nil),    // 5: dna.Whiskers
+	(*Metadata)(nil),    // 6: dna.Metadata
+	(*Decision)(nil),    // 7: dna.Decision
+	nil,                 // 8: dna.Metadata.ObjectiveParametersEntry
 }
 var file_proto_dna_proto_depIdxs = []int32{
-	1, // 0: dna.ConfigRange.link_ppt:type_name -> dna.Range
-	1, // 1: dna.ConfigRange.rtt:type_name -> dna.Range
-	1, // 2: dna.ConfigRange.num_senders:type_name -> dna.Range
-	3, // 3: dna.ConfigRange.domains:type_name -> dna.MemoryRange
-	3, // 4: dna.Whisker.domain:type_name -> dna.MemoryRange
-	4, // 5: dna.MemoryRange.lower:type_name -> dna.Memory
-	4, // 6: dna.MemoryRange.upper:type_name -> dna.Memory
-	2, // 7: dna.Whiskers.whiskers:type_name -> dna.Whisker
-	8, // [8:8] is the sub-list for method output_type
-	8, // [8:8] is the sub-list for method input_type
-	8, // [8:8] is the sub-list for extension type_name
-	8, // [8:8] is the sub-list for extension extendee
-	0, // [0:8] is the sub-list for field type_name
+	1,  // 0: dna.ConfigRange.link_ppt:type_name -> dna.Range
+	1,  // 1: dna.ConfigRange.rtt:type_name -> dna.Range
+	1,  // 2: dna.ConfigRange.num_senders:type_name -> dna.Range
+	3,  // 3: dna.ConfigRange.domains:type_name -> dna.MemoryRange
+	3,  // 4: dna.Whisker.domain:type_name -> dna.MemoryRange
+	4,  // 5: dna.MemoryRange.lower:type_name -> dna.Memory
+	4,  // 6: dna.MemoryRange.upper:type_name -> dna.Memory
+	2,  // 7: dna.Whiskers.whiskers:type_name -> dna.Whisker
+	6,  // 8: dna.Whiskers.metadata:type_name -> dna.Metadata
+	0,  // 9: dna.Metadata.config:type_name -> dna.ConfigRange
+	8,  // 10: dna.Metadata.objective_parameters:type_name -> dna.Metadata.ObjectiveParametersEntry
+	4,  // 11: dna.Decision.memory:type_name -> dna.Memory
+	2,  // 12: dna.Decision.whisker:type_name -> dna.Whisker
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_proto_dna_proto_init() }
@@ -628,6 +943,30 @@ func file_proto_dna_proto_init() {
 				return nil
 			}
 		}
+		file_proto_dna_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Metadata); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_dna_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Decision); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -635,7 +974,7 @@ func file_proto_dna_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_dna_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
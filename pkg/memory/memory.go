@@ -3,8 +3,8 @@ package memory
 import (
     "fmt"
     "math"
+    "strings"
     "time"
-    "unsafe"
 
     "github.com/Aanthord/remy-go/pkg/dna"
 )
@@ -13,16 +13,15 @@ import (
 type DataType float64
 
 // Define the Memory struct
+// Values holds one entry per registered signal, indexed by SignalID
 type Memory struct {
-    RecvRate         DataType // Receive rate
-    SendRate         DataType // Send rate
-    LatestDelay      DataType // Latest observed delay
-    InterPacketDelay DataType // Inter-packet delay
-    lastSentTime     time.Time
-    lastRecvTime     time.Time
-    minRTT           DataType
+    Values       []DataType
+    lastSentTime time.Time
+    lastRecvTime time.Time
+    minRTT       DataType
 }
 
+// EWMA gains used by Remy
 const (
     alpha     = 1.0 / 8.0
     slowAlpha = 1.0 / 256.0
@@ -30,35 +29,47 @@ const (
 
 // Constructor function for creating a new Memory instance
 func NewMemory() *Memory {
-    return &Memory{}
+    return &Memory{Values: make([]DataType, NumSignals())}
 }
 
-// Method to update the fields of Memory
-func (m *Memory) Update(recvRate, sendRate, latestDelay, interPacketDelay DataType) {
-    m.RecvRate = recvRate
-    m.SendRate = sendRate
-    m.LatestDelay = latestDelay
-    m.InterPacketDelay = interPacketDelay
+// Method to update the fields of Memory, in signal order
+func (m *Memory) Update(values ...DataType) {
+    copy(m.Values, values)
 }
 
-// Getter method for the RecvRate field
+// Get returns the value of the given signal
+func (m *Memory) Get(id SignalID) DataType {
+    if int(id) >= len(m.Values) {
+        return 0
+    }
+    return m.Values[id]
+}
+
+// Set sets the value of the given signal
+func (m *Memory) Set(id SignalID, value DataType) {
+    if int(id) < len(m.Values) {
+        m.Values[id] = value
+    }
+}
+
+// Getter method for the RecvRate signal
 func (m *Memory) GetRecvRate() DataType {
-    return m.RecvRate
+    return m.Get(RecvRate)
 }
 
-// Getter method for the SendRate field
+// Getter method for the SendRate signal
 func (m *Memory) GetSendRate() DataType {
-    return m.SendRate
+    return m.Get(SendRate)
 }
 
-// Getter method for the LatestDelay field
-func (m *Memory) GetLatestDelay() DataType {
-    return m.LatestDelay
+// Getter method for the RTTRatio signal
+func (m *Memory) GetRTTRatio() DataType {
+    return m.Get(RTTRatio)
 }
 
-// Getter method for the InterPacketDelay field
-func (m *Memory) GetInterPacketDelay() DataType {
-    return m.InterPacketDelay
+// Getter method for the SlowRecvRate signal
+func (m *Memory) GetSlowRecvRate() DataType {
+    return m.Get(SlowRecvRate)
 }
 
 // MemoryRange represents a range of memory values
@@ -77,107 +88,169 @@ func NewMemoryRange(lower, upper *Memory) *MemoryRange {
 
 // MinMemory returns the minimum possible memory values
 func MinMemory() *Memory {
-    return &Memory{
-        RecvRate:         0,
-        SendRate:         0,
-        LatestDelay:      0,
-        InterPacketDelay: 0,
-    }
+    return NewMemory()
 }
 
 // MaxMemory returns the maximum possible memory values
+// The bound is +Inf rather than MaxFloat64 so that it survives conversion to the float32 values of dna.Memory
 func MaxMemory() *Memory {
-    return &Memory{
-        RecvRate:         DataType(math.MaxFloat64),
-        SendRate:         DataType(math.MaxFloat64),
-        LatestDelay:      DataType(math.MaxFloat64),
-        InterPacketDelay: DataType(math.MaxFloat64),
+    m := NewMemory()
+    for i := range m.Values {
+        m.Values[i] = DataType(math.Inf(1))
     }
+    return m
 }
 
 // Contains checks if a Memory value is within the MemoryRange
+// Ranges are half-open, [Lower, Upper), so that adjacent ranges never both contain a value
 func (mr *MemoryRange) Contains(m *Memory) bool {
-    return (m.RecvRate >= mr.Lower.RecvRate && m.RecvRate <= mr.Upper.RecvRate) &&
-        (m.SendRate >= mr.Lower.SendRate && m.SendRate <= mr.Upper.SendRate) &&
-        (m.LatestDelay >= mr.Lower.LatestDelay && m.LatestDelay <= mr.Upper.LatestDelay) &&
-        (m.InterPacketDelay >= mr.Lower.InterPacketDelay && m.InterPacketDelay <= mr.Upper.InterPacketDelay)
+    for i := range mr.Lower.Values {
+        v := m.Get(SignalID(i))
+        if v < mr.Lower.Values[i] || v >= mr.Upper.Values[i] {
+            return false
+        }
+    }
+    return true
 }
 
 // Intersects checks if two MemoryRange instances intersect
+// Like Contains, this treats ranges as half-open, so ranges that only share a boundary do not intersect
 func (mr *MemoryRange) Intersects(other *MemoryRange) bool {
-    return (mr.Lower.RecvRate <= other.Upper.RecvRate && mr.Upper.RecvRate >= other.Lower.RecvRate) &&
-        (mr.Lower.SendRate <= other.Upper.SendRate && mr.Upper.SendRate >= other.Lower.SendRate) &&
-        (mr.Lower.LatestDelay <= other.Upper.LatestDelay && mr.Upper.LatestDelay >= other.Lower.LatestDelay) &&
-        (mr.Lower.InterPacketDelay <= other.Upper.InterPacketDelay && mr.Upper.InterPacketDelay >= other.Lower.InterPacketDelay)
+    for i := range mr.Lower.Values {
+        if mr.Lower.Values[i] >= other.Upper.Get(SignalID(i)) || other.Lower.Get(SignalID(i)) >= mr.Upper.Values[i] {
+            return false
+        }
+    }
+    return true
 }
 
 // FromDNAMemory converts a dna.Memory to a memory.Memory.
+// Files written before memory became a vector only carry the four default signals.
 func FromDNAMemory(dnaMem *dna.Memory) *Memory {
-    return &Memory{
-        RecvRate:         DataType(dnaMem.RecvRate),
-        SendRate:         DataType(dnaMem.SendRate),
-        LatestDelay:      DataType(dnaMem.LatestDelay),
-        InterPacketDelay: DataType(dnaMem.InterPacketDelay),
+    m := NewMemory()
+    if len(dnaMem.Values) == 0 {
+        m.Set(RecvRate, DataType(dnaMem.RecvRate))
+        m.Set(SendRate, DataType(dnaMem.SendRate))
+        m.Set(RTTRatio, DataType(dnaMem.LatestDelay))
+        m.Set(SlowRecvRate, DataType(dnaMem.InterPacketDelay))
+        return m
     }
+    for i, v := range dnaMem.Values {
+        m.Set(SignalID(i), DataType(v))
+    }
+    return m
 }
 
 // ToDNAMemory converts a memory.Memory to a dna.Memory.
+// The four default signals are also written to their dedicated fields for older readers.
 func (m *Memory) ToDNAMemory() *dna.Memory {
-    return &dna.Memory{
-        RecvRate:         float32(m.RecvRate),
-        SendRate:         float32(m.SendRate),
-        LatestDelay:      float32(m.LatestDelay),
-        InterPacketDelay: float32(m.InterPacketDelay),
+    dnaMem := &dna.Memory{
+        RecvRate:         float32(m.Get(RecvRate)),
+        SendRate:         float32(m.Get(SendRate)),
+        LatestDelay:      float32(m.Get(RTTRatio)),
+        InterPacketDelay: float32(m.Get(SlowRecvRate)),
+        Values:           make([]float32, len(m.Values)),
+    }
+    for i, v := range m.Values {
+        dnaMem.Values[i] = float32(v)
+    }
+    return dnaMem
+}
+
+// FromDNAMemoryRange converts a dna.MemoryRange whose values are laid out as the named signals
+// A nil names slice means the values are in registration order. Registered signals that are
+// missing from names span their whole range, so older trees keep working as new signals are added.
+func FromDNAMemoryRange(dnaRange *dna.MemoryRange, names []string) (*MemoryRange, error) {
+    lower := FromDNAMemory(dnaRange.Lower)
+    upper := FromDNAMemory(dnaRange.Upper)
+    if names == nil {
+        carried := len(dnaRange.Upper.Values)
+        if carried == 0 {
+            carried = int(SlowRecvRate) + 1
+        }
+        for i := carried; i < len(upper.Values); i++ {
+            upper.Values[i] = DataType(math.Inf(1))
+        }
+        return NewMemoryRange(lower, upper), nil
+    }
+
+    rangeLower := MinMemory()
+    rangeUpper := MaxMemory()
+    for i, name := range names {
+        id, ok := LookupSignal(name)
+        if !ok {
+            return nil, fmt.Errorf("unknown memory signal %q", name)
+        }
+        if i < len(dnaRange.Lower.Values) {
+            rangeLower.Set(id, DataType(dnaRange.Lower.Values[i]))
+        }
+        if i < len(dnaRange.Upper.Values) {
+            rangeUpper.Set(id, DataType(dnaRange.Upper.Values[i]))
+        }
+    }
+    return NewMemoryRange(rangeLower, rangeUpper), nil
+}
+
+// observe applies an observation to every registered signal
+func (m *Memory) observe(o *Observation) {
+    signalsMu.RLock()
+    defer signalsMu.RUnlock()
+
+    for i := range m.Values {
+        if i < len(signals) {
+            m.Values[i] = signals[i].Update(m.Values[i], o)
+        }
     }
 }
 
-// UpdateSentPacket updates the memory state with the information from the sent packet
-func (m *Memory) UpdateSentPacket(packet *Packet) {
-    if m.lastSentTime.IsZero() || m.lastRecvTime.IsZero() {
-        m.lastSentTime = packet.Sent
-        m.lastRecvTime = packet.Sent
-        m.minRTT = 0
-    } else {
-        m.SendRate = (1 - alpha) * m.SendRate + alpha*(DataType(packet.Sent.Sub(m.lastSentTime).Seconds()))
-        m.RecvRate = (1 - alpha) * m.RecvRate + alpha*(DataType(packet.Sent.Sub(m.lastRecvTime).Seconds()))
-        m.minRTT = 0
-        m.lastSentTime = packet.Sent
-        m.lastRecvTime = packet.Sent
+// Observe applies an observation to every registered signal n times, as if n packets with the same timings had
+// arrived; it is for callers that only see aggregates, such as the counters of TCP_INFO, rather than packets
+func (m *Memory) Observe(o *Observation, n int) {
+    for i := 0; i < n; i++ {
+        m.observe(o)
     }
 }
 
 // UpdateReceivedPackets updates the memory state with the received packets for the given flow ID
+// This follows Memory::packets_received in Remy: the first packet of a flow only establishes the
+// reference timestamps and the minimum RTT, and every later packet updates each signal in turn.
+// Timestamps come from the packets themselves, so the result does not depend on when it is called.
 func (m *Memory) UpdateReceivedPackets(packets []*Packet, flowID uint) {
     for _, packet := range packets {
         if packet.FlowID != flowID {
             continue
         }
 
-        rtt := DataType(packet.Received.Sub(packet.Sent).Seconds())
+        rtt := interval(packet.Sent, packet.Received)
         if m.lastSentTime.IsZero() || m.lastRecvTime.IsZero() {
             m.lastSentTime = packet.Sent
             m.lastRecvTime = packet.Received
             m.minRTT = rtt
         } else {
-            m.RecvRate = (1 - alpha) * m.RecvRate + alpha*(DataType(packet.Received.Sub(m.lastRecvTime).Seconds()))
-            m.SendRate = (1 - alpha) * m.SendRate + alpha*(DataType(packet.Sent.Sub(m.lastSentTime).Seconds()))
-            m.InterPacketDelay = (1 - slowAlpha) * m.InterPacketDelay + slowAlpha*(DataType(packet.Received.Sub(m.lastRecvTime).Seconds()))
+            m.minRTT = DataType(math.Min(float64(m.minRTT), float64(rtt)))
+            m.observe(&Observation{
+                Sent:         packet.Sent,
+                Received:     packet.Received,
+                LastSent:     m.lastSentTime,
+                LastReceived: m.lastRecvTime,
+                RTT:          rtt,
+                MinRTT:       m.minRTT,
+            })
             m.lastSentTime = packet.Sent
             m.lastRecvTime = packet.Received
-            m.minRTT = DataType(math.Min(float64(m.minRTT), float64(rtt)))
-            m.LatestDelay = rtt / m.minRTT
         }
     }
 }
 
-// UpdateRTT updates the memory state with the given round-trip time
-func (m *Memory) UpdateRTT(rtt DataType) {
-    if m.minRTT == 0 {
-        m.minRTT = rtt
-    } else {
-        m.minRTT = DataType(math.Min(float64(m.minRTT), float64(rtt)))
-    }
-    m.LatestDelay = rtt / m.minRTT
+// UpdateLostPacket updates the memory state with a packet that was lost
+func (m *Memory) UpdateLostPacket(packet *Packet) {
+    m.observe(&Observation{
+        Sent:         packet.Sent,
+        LastSent:     m.lastSentTime,
+        LastReceived: m.lastRecvTime,
+        MinRTT:       m.minRTT,
+        Lost:         true,
+    })
 }
 
 // Packet represents a network packet
@@ -192,63 +265,103 @@ type Packet struct {
 // HashCode returns a hash value for the memory state
 func (m *Memory) HashCode() uint64 {
     hash := uint64(0)
-    hash = hash*31 + *(*uint64)(unsafe.Pointer(&m.RecvRate))
-    hash = hash*31 + *(*uint64)(unsafe.Pointer(&m.SendRate))
-    hash = hash*31 + *(*uint64)(unsafe.Pointer(&m.LatestDelay))
-    hash = hash*31 + *(*uint64)(unsafe.Pointer(&m.InterPacketDelay))
+    for _, v := range m.Values {
+        hash = hash*31 + math.Float64bits(float64(v))
+    }
     return hash
 }
 
 // IsGreaterThanOrEqual checks if the current memory state is greater than or equal to another memory state
 func (m *Memory) IsGreaterThanOrEqual(other *Memory) bool {
-    return m.RecvRate >= other.RecvRate &&
-        m.SendRate >= other.SendRate &&
-        m.LatestDelay >= other.LatestDelay &&
-        m.InterPacketDelay >= other.InterPacketDelay
+    for i, v := range m.Values {
+        if v < other.Get(SignalID(i)) {
+            return false
+        }
+    }
+    return true
 }
 
 // IsLessThan checks if the current memory state is less than another memory state
 func (m *Memory) IsLessThan(other *Memory) bool {
-    return m.RecvRate < other.RecvRate &&
-        m.SendRate < other.SendRate &&
-        m.LatestDelay < other.LatestDelay &&
-        m.InterPacketDelay < other.InterPacketDelay
+    for i, v := range m.Values {
+        if v >= other.Get(SignalID(i)) {
+            return false
+        }
+    }
+    return true
 }
 
 // IsEqual checks if the current memory state is equal to another memory state
 func (m *Memory) IsEqual(other *Memory) bool {
-    return m.RecvRate == other.RecvRate &&
-        m.SendRate == other.SendRate &&
-        m.LatestDelay == other.LatestDelay &&
-        m.InterPacketDelay == other.InterPacketDelay
+    if len(m.Values) != len(other.Values) {
+        return false
+    }
+    for i, v := range m.Values {
+        if v != other.Values[i] {
+            return false
+        }
+    }
+    return true
 }
 
 // String returns a string representation of the memory state
 func (m *Memory) String() string {
-    return fmt.Sprintf("RecvRate=%f, SendRate=%f, LatestDelay=%f, InterPacketDelay=%f",
-        m.RecvRate, m.SendRate, m.LatestDelay, m.InterPacketDelay)
+    names := SignalNames()
+    parts := make([]string, len(m.Values))
+    for i, v := range m.Values {
+        name := fmt.Sprintf("signal%d", i)
+        if i < len(names) {
+            name = names[i]
+        }
+        parts[i] = fmt.Sprintf("%s=%g", name, v)
+    }
+    return strings.Join(parts, ", ")
 }
 
 // Reset resets all the memory state fields to their initial values
 func (m *Memory) Reset() {
-    m.RecvRate = 0
-    m.SendRate = 0
-    m.LatestDelay = 0
-    m.InterPacketDelay = 0
+    for i := range m.Values {
+        m.Values[i] = 0
+    }
     m.lastSentTime = time.Time{}
     m.lastRecvTime = time.Time{}
     m.minRTT = 0
 }
 
-// AdvanceTo advances the memory state to the given tick (time)
-func (m *Memory) AdvanceTo(tick uint64) {
-    if !m.lastSentTime.IsZero() {
-        m.SendRate = (1-alpha)*m.SendRate + alpha*DataType(float64(tick-uint64(m.lastSentTime.UnixNano()))/1e9)
-    }
-    if !m.lastRecvTime.IsZero() {
-        m.RecvRate = (1-alpha)*m.RecvRate + alpha*DataType(float64(tick-uint64(m.lastRecvTime.UnixNano()))/1e9)
-        m.InterPacketDelay = (1-slowAlpha)*m.InterPacketDelay + slowAlpha*DataType(float64(tick-uint64(m.lastRecvTime.UnixNano()))/1e9)
+// Clone returns a copy of the memory state that shares nothing with the original
+func (m *Memory) Clone() *Memory {
+    c := *m
+    c.Values = append([]DataType(nil), m.Values...)
+    return &c
+}
+
+// AdvanceTo advances the memory state to the given time
+// As in Remy, memory only changes when packets are received, so this does nothing; it exists so
+// that callers can advance every component of a sender uniformly
+func (m *Memory) AdvanceTo(now time.Time) {
+}
+
+// MinRTT returns the minimum round-trip time observed so far in milliseconds
+func (m *Memory) MinRTT() DataType {
+    return m.minRTT
+}
+
+// ContainsRange checks if another MemoryRange lies entirely within the MemoryRange
+func (mr *MemoryRange) ContainsRange(other *MemoryRange) bool {
+    for i := range mr.Lower.Values {
+        if other.Lower.Get(SignalID(i)) < mr.Lower.Values[i] || other.Upper.Get(SignalID(i)) > mr.Upper.Values[i] {
+            return false
+        }
     }
-    m.lastSentTime = time.Unix(0, int64(tick))
-    m.lastRecvTime = time.Unix(0, int64(tick))
+    return true
+}
+
+// Equal checks if two MemoryRange instances cover exactly the same values
+func (mr *MemoryRange) Equal(other *MemoryRange) bool {
+    return mr.Lower.IsEqual(other.Lower) && mr.Upper.IsEqual(other.Upper)
+}
+
+// String returns a string representation of the memory range
+func (mr *MemoryRange) String() string {
+    return fmt.Sprintf("{%v} to {%v}", mr.Lower, mr.Upper)
 }
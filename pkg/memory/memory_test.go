@@ -0,0 +1,99 @@
+package memory
+
+import (
+    "math"
+    "testing"
+    "time"
+)
+
+// remyTrajectory is a packet sequence and the memory after each packet, computed with Memory::packets_received of
+// Remy's memory.cc in double precision: sent and received are ticks in milliseconds, followed by rec_rec_ewma,
+// rec_send_ewma, rtt_ratio and slow_rec_rec_ewma. The first packet only sets the reference timestamps.
+var remyTrajectory = []struct {
+    sent, received                     int
+    recRec, recSend, rttRatio, slowRec float64
+}{
+    {100, 250, 0, 0, 0, 0},
+    {110, 262, 1.5, 1.25, 1.0133333333333334, 0.046875},
+    {125, 281, 3.6875, 2.96875, 1.04, 0.12091064453125},
+    {130, 300, 5.6015625, 3.22265625, 1.1333333333333333, 0.1946570873260498},
+    {170, 322, 7.6513671875, 7.81982421875, 1.0133333333333334, 0.2798342080786824},
+    {171, 330, 7.6949462890625, 6.96734619140625, 1.06, 0.30999110570337507},
+    {200, 352, 9.483078002929688, 9.721427917480469, 1.0133333333333334, 0.39471770294672126},
+    {240, 391, 13.172693252563477, 13.50624942779541, 1.0066666666666666, 0.5455195869195857},
+    {241, 420, 15.151106595993042, 11.942968249320984, 1.1933333333333334, 0.656669901033181},
+    {300, 451, 17.13221827149391, 17.82509721815586, 1.0066666666666666, 0.7751985342322701},
+}
+
+// tick returns the time of a tick of Remy's simulator
+func tick(ms int) time.Time {
+    return time.Unix(0, 0).Add(time.Duration(ms) * time.Millisecond)
+}
+
+// checkRemyMemory fails if a memory differs from a step of the trajectory
+func checkRemyMemory(t *testing.T, step int, m *Memory) {
+    t.Helper()
+    want := remyTrajectory[step]
+    for _, c := range []struct {
+        name string
+        id   SignalID
+        want float64
+    }{
+        {"rec_rec_ewma", RecvRate, want.recRec},
+        {"rec_send_ewma", SendRate, want.recSend},
+        {"rtt_ratio", RTTRatio, want.rttRatio},
+        {"slow_rec_rec_ewma", SlowRecvRate, want.slowRec},
+    } {
+        if got := float64(m.Get(c.id)); math.Abs(got-c.want) > 1e-12 {
+            t.Errorf("after packet %d, %s = %v, Remy has %v", step, c.name, got, c.want)
+        }
+    }
+}
+
+func TestObserveFollowsRemy(t *testing.T) {
+    m := NewMemory()
+    first := remyTrajectory[0]
+    lastSent, lastReceived := tick(first.sent), tick(first.received)
+    minRTT := DataType(first.received - first.sent)
+
+    for step := 1; step < len(remyTrajectory); step++ {
+        p := remyTrajectory[step]
+        rtt := DataType(p.received - p.sent)
+        minRTT = DataType(math.Min(float64(minRTT), float64(rtt)))
+        m.Observe(&Observation{
+            Sent:         tick(p.sent),
+            Received:     tick(p.received),
+            LastSent:     lastSent,
+            LastReceived: lastReceived,
+            RTT:          rtt,
+            MinRTT:       minRTT,
+        }, 1)
+        lastSent, lastReceived = tick(p.sent), tick(p.received)
+        checkRemyMemory(t, step, m)
+    }
+}
+
+func TestUpdateReceivedPacketsFollowsRemy(t *testing.T) {
+    m := NewMemory()
+    for step, p := range remyTrajectory {
+        m.UpdateReceivedPackets([]*Packet{{SeqNo: step, FlowID: 1, Sent: tick(p.sent), Received: tick(p.received)}}, 1)
+        checkRemyMemory(t, step, m)
+    }
+    if got := m.MinRTT(); got != 150 {
+        t.Errorf("MinRTT = %v, want 150", got)
+    }
+}
+
+func TestObserveRepeats(t *testing.T) {
+    o := &Observation{Sent: tick(10), Received: tick(20), LastSent: tick(0), LastReceived: tick(5), RTT: 10, MinRTT: 10}
+    once, repeated := NewMemory(), NewMemory()
+    for i := 0; i < 3; i++ {
+        once.Observe(o, 1)
+    }
+    repeated.Observe(o, 3)
+    for i := range once.Values {
+        if once.Values[i] != repeated.Values[i] {
+            t.Errorf("signal %d: Observe(o, 3) = %v, three Observe(o, 1) = %v", i, repeated.Values[i], once.Values[i])
+        }
+    }
+}
@@ -0,0 +1,208 @@
+package memory
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+// SignalID identifies a registered signal; it is the signal's index in a Memory vector
+type SignalID int
+
+// The signals registered by default, in the order they appear in every Memory
+// RTTRatio and SlowRecvRate keep the names latest_delay and inter_packet_delay in whisker files and in the dna
+// Memory fields, which older trees were written with
+const (
+    RecvRate SignalID = iota
+    SendRate
+    RTTRatio
+    SlowRecvRate
+)
+
+// Observation carries what is known about a packet when the memory signals are updated
+type Observation struct {
+    Sent         time.Time // Timestamp when the packet was sent
+    Received     time.Time // Timestamp when the packet was received (zero if it was lost)
+    LastSent     time.Time // Send timestamp of the previously received packet
+    LastReceived time.Time // Receive timestamp of the previously received packet
+    RTT          DataType  // Round-trip time of the packet in milliseconds
+    MinRTT       DataType  // Minimum round-trip time observed so far in milliseconds
+    Lost         bool      // Whether the packet was lost rather than received
+}
+
+// Signal is a named congestion signal tracked by Memory
+// Every time an observation is made, Sample computes a new sample and the signal's value
+// becomes (1-Gain)*value + Gain*sample; a Gain of 1 replaces the value outright
+type Signal struct {
+    Name   string                                 // Name of the signal, as stored in whisker files
+    Gain   DataType                               // EWMA gain applied to every new sample
+    Sample func(o *Observation) (DataType, bool) // Computes a sample; false leaves the value unchanged
+}
+
+// Update applies a sample of the signal to its current value
+func (s *Signal) Update(value DataType, o *Observation) DataType {
+    sample, ok := s.Sample(o)
+    if !ok {
+        return value
+    }
+    if s.Gain == 1 {
+        return sample
+    }
+    return (1-s.Gain)*value + s.Gain*sample
+}
+
+var (
+    signalsMu sync.RWMutex
+    signals   []*Signal
+)
+
+func init() {
+    for _, s := range []Signal{RecvRateSignal, SendRateSignal, RTTRatioSignal, SlowRecvRateSignal} {
+        if _, err := RegisterSignal(s); err != nil {
+            panic(err)
+        }
+    }
+}
+
+// RegisterSignal adds a signal to every Memory created afterwards and returns its ID
+// Signals must be registered before any Memory or whisker tree is created, typically from an init function
+func RegisterSignal(s Signal) (SignalID, error) {
+    signalsMu.Lock()
+    defer signalsMu.Unlock()
+
+    if s.Name == "" || s.Sample == nil {
+        return 0, fmt.Errorf("signal must have a name and a sample function")
+    }
+    for _, existing := range signals {
+        if existing.Name == s.Name {
+            return 0, fmt.Errorf("signal %q is already registered", s.Name)
+        }
+    }
+    signals = append(signals, &s)
+    return SignalID(len(signals) - 1), nil
+}
+
+// Signals returns the registered signals in Memory order
+func Signals() []Signal {
+    signalsMu.RLock()
+    defer signalsMu.RUnlock()
+
+    list := make([]Signal, len(signals))
+    for i, s := range signals {
+        list[i] = *s
+    }
+    return list
+}
+
+// SignalNames returns the names of the registered signals in Memory order
+func SignalNames() []string {
+    signalsMu.RLock()
+    defer signalsMu.RUnlock()
+
+    names := make([]string, len(signals))
+    for i, s := range signals {
+        names[i] = s.Name
+    }
+    return names
+}
+
+// LookupSignal returns the ID of the registered signal with the given name
+func LookupSignal(name string) (SignalID, bool) {
+    signalsMu.RLock()
+    defer signalsMu.RUnlock()
+
+    for i, s := range signals {
+        if s.Name == name {
+            return SignalID(i), true
+        }
+    }
+    return 0, false
+}
+
+// NumSignals returns the number of registered signals, i.e. the dimension of a Memory
+func NumSignals() int {
+    signalsMu.RLock()
+    defer signalsMu.RUnlock()
+    return len(signals)
+}
+
+// interval returns b - a in milliseconds, the tick unit of Remy
+func interval(a, b time.Time) DataType {
+    return DataType(b.Sub(a)) / DataType(time.Millisecond)
+}
+
+// RecvRateSignal is an EWMA of the interval between packet receptions (rec_rec_ewma in Remy)
+var RecvRateSignal = Signal{
+    Name: "recv_rate",
+    Gain: alpha,
+    Sample: func(o *Observation) (DataType, bool) {
+        if o.Lost {
+            return 0, false
+        }
+        return interval(o.LastReceived, o.Received), true
+    },
+}
+
+// SendRateSignal is an EWMA of the interval between the send times of received packets (rec_send_ewma in Remy)
+var SendRateSignal = Signal{
+    Name: "send_rate",
+    Gain: alpha,
+    Sample: func(o *Observation) (DataType, bool) {
+        if o.Lost {
+            return 0, false
+        }
+        return interval(o.LastSent, o.Sent), true
+    },
+}
+
+// RTTRatioSignal is the ratio of the latest round-trip time to the minimum round-trip time (rtt_ratio in Remy)
+// It is named latest_delay in whisker files
+var RTTRatioSignal = Signal{
+    Name: "latest_delay",
+    Gain: 1,
+    Sample: func(o *Observation) (DataType, bool) {
+        if o.Lost || o.MinRTT == 0 {
+            return 0, false
+        }
+        return o.RTT / o.MinRTT, true
+    },
+}
+
+// SlowRecvRateSignal is a slow EWMA of the interval between packet receptions (slow_rec_rec_ewma in Remy)
+// It is named inter_packet_delay in whisker files
+var SlowRecvRateSignal = Signal{
+    Name: "inter_packet_delay",
+    Gain: slowAlpha,
+    Sample: func(o *Observation) (DataType, bool) {
+        if o.Lost {
+            return 0, false
+        }
+        return interval(o.LastReceived, o.Received), true
+    },
+}
+
+// SlowSendRateSignal is a slow EWMA of the interval between the send times of received packets
+// It is not registered by default
+var SlowSendRateSignal = Signal{
+    Name: "slow_rec_send_ewma",
+    Gain: slowAlpha,
+    Sample: func(o *Observation) (DataType, bool) {
+        if o.Lost {
+            return 0, false
+        }
+        return interval(o.LastSent, o.Sent), true
+    },
+}
+
+// LossRateSignal is an EWMA of the fraction of packets that were lost
+// It is not registered by default
+var LossRateSignal = Signal{
+    Name: "loss_rate",
+    Gain: alpha,
+    Sample: func(o *Observation) (DataType, bool) {
+        if o.Lost {
+            return 1, true
+        }
+        return 0, true
+    },
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import "sort"
+
+// Buckets of the histograms the collectors keep
+var (
+    WindowBuckets    = ExponentialBuckets(1, 2, 12)     // Congestion windows from 1 to 2048 packets
+    RTTBuckets       = ExponentialBuckets(0.001, 2, 14) // Round-trip times from 1 ms to about 8 s
+    IntersendBuckets = ExponentialBuckets(0.0001, 4, 9) // Intersend times from 0.1 ms to about 6.5 s
+)
+
+// ExponentialBuckets returns count bucket bounds, the first at start and each one factor times the last
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+    buckets := make([]float64, count)
+    for i := range buckets {
+        buckets[i] = start
+        start *= factor
+    }
+    return buckets
+}
+
+// histogram counts observed values in buckets; it is not safe for concurrent use
+type histogram struct {
+    buckets []float64
+    counts  []uint64
+    count   uint64
+    sum     float64
+}
+
+// newHistogram creates a histogram with the given bucket bounds
+func newHistogram(buckets []float64) *histogram {
+    return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// observe adds a value to the histogram
+func (h *histogram) observe(v float64) {
+    h.counts[sort.SearchFloat64s(h.buckets, v)]++
+    h.count++
+    h.sum += v
+}
+
+// sample returns a snapshot of the histogram with the given labels
+func (h *histogram) sample(labels Labels) HistogramSample {
+    return HistogramSample{
+        Labels:  labels,
+        Buckets: h.buckets,
+        Counts:  append([]uint64(nil), h.counts...),
+        Count:   h.count,
+        Sum:     h.sum,
+    }
+}
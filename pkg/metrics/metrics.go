@@ -0,0 +1,265 @@
+package metrics
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Type is the type of a metric family
+type Type string
+
+const (
+    Counter   Type = "counter"
+    Gauge     Type = "gauge"
+    Histogram Type = "histogram"
+)
+
+// Labels are the labels of a sample, by name
+type Labels map[string]string
+
+// Sample is a value of a counter or gauge
+type Sample struct {
+    Labels Labels
+    Value  float64
+}
+
+// HistogramSample is the distribution of the values a histogram observed
+type HistogramSample struct {
+    Labels  Labels
+    Buckets []float64 // Upper bounds of the buckets, in increasing order, not including +Inf
+    Counts  []uint64  // Number of values in each bucket, not cumulative, with one more count for +Inf
+    Count   uint64    // Number of values observed
+    Sum     float64   // Sum of the values observed
+}
+
+// Family is a metric and all of its samples
+type Family struct {
+    Name       string
+    Help       string
+    Type       Type
+    Samples    []Sample          // Samples of a counter or gauge
+    Histograms []HistogramSample // Samples of a histogram
+}
+
+// Collector produces metric families whenever a registry is gathered
+type Collector interface {
+    Collect() []Family
+}
+
+// CollectorFunc adapts a function to a Collector
+type CollectorFunc func() []Family
+
+// Collect calls the function
+func (f CollectorFunc) Collect() []Family {
+    return f()
+}
+
+// Registry gathers the metrics of its collectors, either in process or over HTTP in the Prometheus text format
+type Registry struct {
+    mu         sync.Mutex
+    collectors []Collector
+    err        error // Error of the last scrape that could not be written
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+    return &Registry{}
+}
+
+// Register adds a collector to the registry
+func (r *Registry) Register(c Collector) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.collectors = append(r.collectors, c)
+}
+
+// Gather collects every family of every collector, sorted by name
+// Families of the same name from different collectors, such as the metrics of two RATs, are merged into one.
+func (r *Registry) Gather() []Family {
+    r.mu.Lock()
+    collectors := append([]Collector(nil), r.collectors...)
+    r.mu.Unlock()
+
+    byName := make(map[string]*Family)
+    var names []string
+    for _, c := range collectors {
+        for _, f := range c.Collect() {
+            merged, ok := byName[f.Name]
+            if !ok {
+                f := f
+                byName[f.Name] = &f
+                names = append(names, f.Name)
+                continue
+            }
+            merged.Samples = append(merged.Samples, f.Samples...)
+            merged.Histograms = append(merged.Histograms, f.Histograms...)
+        }
+    }
+    sort.Strings(names)
+    families := make([]Family, len(names))
+    for i, name := range names {
+        families[i] = *byName[name]
+    }
+    return families
+}
+
+// Value returns the value of the counter or gauge sample with the given name and labels, without a scrape
+func (r *Registry) Value(name string, labels Labels) (float64, bool) {
+    for _, f := range r.Gather() {
+        if f.Name != name {
+            continue
+        }
+        for _, s := range f.Samples {
+            if sameLabels(s.Labels, labels) {
+                return s.Value, true
+            }
+        }
+    }
+    return 0, false
+}
+
+// Histogram returns the histogram sample with the given name and labels, without a scrape
+func (r *Registry) Histogram(name string, labels Labels) (HistogramSample, bool) {
+    for _, f := range r.Gather() {
+        if f.Name != name {
+            continue
+        }
+        for _, h := range f.Histograms {
+            if sameLabels(h.Labels, labels) {
+                return h, true
+            }
+        }
+    }
+    return HistogramSample{}, false
+}
+
+// WriteText writes every family in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    for _, f := range r.Gather() {
+        writeFamily(bw, f)
+    }
+    return bw.Flush()
+}
+
+// ServeHTTP serves the registry's metrics to a Prometheus scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    w.Header().Set("Content-Type", ContentType)
+    if err := r.WriteText(w); err != nil {
+        r.mu.Lock()
+        r.err = fmt.Errorf("failed to write metrics to %s: %w", req.RemoteAddr, err)
+        r.mu.Unlock()
+    }
+}
+
+// Err returns the error of the last scrape that could not be written, or nil if there was none
+// Such a scrape usually means the scraper went away; the response is already under way, so there is no one to tell.
+func (r *Registry) Err() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.err
+}
+
+// Handler returns a handler that serves the registry at /metrics
+func (r *Registry) Handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", r)
+    return mux
+}
+
+// writeFamily writes a family, with its HELP and TYPE lines
+func writeFamily(w *bufio.Writer, f Family) {
+    if f.Help != "" {
+        fmt.Fprintf(w, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+    }
+    fmt.Fprintf(w, "# TYPE %s %s\n", f.Name, f.Type)
+    for _, s := range f.Samples {
+        writeSample(w, f.Name, s.Labels, "", "", s.Value)
+    }
+    for _, h := range f.Histograms {
+        var cumulative uint64
+        for i, bound := range h.Buckets {
+            cumulative += h.Counts[i]
+            writeSample(w, f.Name+"_bucket", h.Labels, "le", formatValue(bound), float64(cumulative))
+        }
+        writeSample(w, f.Name+"_bucket", h.Labels, "le", "+Inf", float64(h.Count))
+        writeSample(w, f.Name+"_sum", h.Labels, "", "", h.Sum)
+        writeSample(w, f.Name+"_count", h.Labels, "", "", float64(h.Count))
+    }
+}
+
+// writeSample writes a sample line, with its labels sorted by name and an optional extra label last
+func writeSample(w *bufio.Writer, name string, labels Labels, extraName, extraValue string, value float64) {
+    w.WriteString(name)
+    names := make([]string, 0, len(labels))
+    for n := range labels {
+        names = append(names, n)
+    }
+    sort.Strings(names)
+    if extraName != "" {
+        names = append(names, extraName)
+    }
+    if len(names) > 0 {
+        w.WriteByte('{')
+        for i, n := range names {
+            if i > 0 {
+                w.WriteByte(',')
+            }
+            v := extraValue
+            if i < len(labels) {
+                v = labels[n]
+            }
+            fmt.Fprintf(w, "%s=\"%s\"", n, escapeLabel(v))
+        }
+        w.WriteByte('}')
+    }
+    w.WriteByte(' ')
+    w.WriteString(formatValue(value))
+    w.WriteByte('\n')
+}
+
+// formatValue formats a sample value as the text format expects
+func formatValue(v float64) string {
+    switch {
+    case math.IsInf(v, 1):
+        return "+Inf"
+    case math.IsInf(v, -1):
+        return "-Inf"
+    case math.IsNaN(v):
+        return "NaN"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes the backslashes and newlines of a HELP line
+func escapeHelp(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes the backslashes, quotes and newlines of a label value
+func escapeLabel(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// sameLabels reports whether two label sets are equal
+func sameLabels(a, b Labels) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for n, v := range a {
+        if w, ok := b[n]; !ok || w != v {
+            return false
+        }
+    }
+    return true
+}
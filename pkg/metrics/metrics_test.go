@@ -0,0 +1,146 @@
+package metrics
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/clock"
+    "github.com/Aanthord/remy-go/pkg/rat"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// brokenResponse is a response writer whose client has gone away
+type brokenResponse struct {
+    header http.Header
+}
+
+func (w *brokenResponse) Header() http.Header {
+    return w.header
+}
+
+func (w *brokenResponse) Write([]byte) (int, error) {
+    return 0, errors.New("connection reset by peer")
+}
+
+func (w *brokenResponse) WriteHeader(int) {}
+
+func TestServeHTTPKeepsWriteErrors(t *testing.T) {
+    r := NewRegistry()
+    r.Register(CollectorFunc(func() []Family {
+        return []Family{{Name: "up", Type: Gauge, Samples: []Sample{{Labels{}, 1}}}}
+    }))
+
+    recorder := httptest.NewRecorder()
+    r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+    if body := recorder.Body.String(); !strings.Contains(body, "up 1\n") {
+        t.Errorf("served %q", body)
+    }
+    if err := r.Err(); err != nil {
+        t.Fatalf("Err() = %v after a good scrape", err)
+    }
+
+    r.ServeHTTP(&brokenResponse{header: http.Header{}}, httptest.NewRequest("GET", "/metrics", nil))
+    if err := r.Err(); err == nil {
+        t.Error("Err() = nil after a scrape that could not be written")
+    }
+}
+
+func TestRATCollectorReloads(t *testing.T) {
+    start := time.Unix(1700000000, 0)
+    r := rat.NewRAT(whisker.NewWhiskerTree(), false)
+    r.SetClock(clock.NewManual(start))
+    registry := NewRegistry()
+    registry.Register(NewRATCollector("a", r))
+    controller := Labels{"controller": "a"}
+
+    if v, ok := registry.Value("remy_rat_tree_last_swap_timestamp_seconds", controller); !ok || v != 0 {
+        t.Errorf("last swap before any = %v, %v; want 0", v, ok)
+    }
+    r.SwapWhiskers(whisker.NewWhiskerTree())
+    r.SwapWhiskers(whisker.NewWhiskerTree())
+    for name, want := range map[string]float64{
+        "remy_rat_tree_swaps_total":                     2,
+        "remy_rat_tree_rollbacks_total":                 0,
+        "remy_rat_tree_last_swap_timestamp_seconds":     1700000000,
+        "remy_rat_tree_last_rollback_timestamp_seconds": 0,
+    } {
+        if v, ok := registry.Value(name, controller); !ok || v != want {
+            t.Errorf("%s = %v, %v; want %v", name, v, ok, want)
+        }
+    }
+}
+
+// hop is a next hop that keeps the packets a RAT sends
+type hop []*rat.Packet
+
+func (h *hop) Accept(packet *rat.Packet) error {
+    *h = append(*h, packet)
+    return nil
+}
+
+func TestRATCollectorExposition(t *testing.T) {
+    c := clock.NewManual(time.Unix(1700000000, 0))
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 1, 1, 0, tree.Root.Whisker.Domain)
+    tree.Invalidate()
+    r := rat.NewRAT(tree, false)
+    r.SetClock(c)
+    registry := NewRegistry()
+    registry.Register(NewRATCollector("a", r))
+    flowID := r.NewFlow()
+
+    // Three packets come back after 1/64, 1/32 and 1/16 s, each growing the window by one, and a fourth is lost
+    for i, rtt := range []time.Duration{15625 * time.Microsecond, 31250 * time.Microsecond, 62500 * time.Microsecond, 0} {
+        var sent hop
+        if err := r.Send(flowID, 0, &sent, i, 10); err != nil || len(sent) != 1 {
+            t.Fatalf("packet %d was not sent: %v", i, err)
+        }
+        if rtt == 0 {
+            r.LosePackets(sent)
+            continue
+        }
+        c.Advance(rtt)
+        sent[0].Received = c.Now()
+        r.ReceivePackets(sent)
+    }
+
+    var b strings.Builder
+    if err := registry.WriteText(&b); err != nil {
+        t.Fatal(err)
+    }
+    text := b.String()
+    for _, line := range []string{
+        "# TYPE remy_rat_packets_sent_total counter",
+        `remy_rat_packets_sent_total{controller="a"} 4`,
+        `remy_rat_packets_received_total{controller="a"} 3`,
+        `remy_rat_packets_lost_total{controller="a"} 1`,
+        `remy_rat_active_flows{controller="a"} 1`,
+        `remy_rat_flow_congestion_window_packets{controller="a",flow="1"} 4`,
+        `remy_rat_flow_packets_lost_total{controller="a",flow="1"} 1`,
+        `remy_rat_whisker_hits_total{controller="a",whisker="root"} 3`,
+        "# TYPE remy_rat_rtt_seconds histogram",
+        `remy_rat_rtt_seconds_bucket{controller="a",le="0.008"} 0`,
+        `remy_rat_rtt_seconds_bucket{controller="a",le="0.016"} 1`,
+        `remy_rat_rtt_seconds_bucket{controller="a",le="0.032"} 2`,
+        `remy_rat_rtt_seconds_bucket{controller="a",le="0.064"} 3`,
+        `remy_rat_rtt_seconds_bucket{controller="a",le="+Inf"} 3`,
+        `remy_rat_rtt_seconds_sum{controller="a"} 0.109375`,
+        `remy_rat_rtt_seconds_count{controller="a"} 3`,
+        `remy_rat_congestion_window_packets_bucket{controller="a",le="1"} 0`,
+        `remy_rat_congestion_window_packets_bucket{controller="a",le="2"} 1`,
+        `remy_rat_congestion_window_packets_bucket{controller="a",le="4"} 3`,
+        `remy_rat_congestion_window_packets_sum{controller="a"} 9`,
+        `remy_rat_congestion_window_packets_count{controller="a"} 3`,
+    } {
+        if !strings.Contains(text, line+"\n") {
+            t.Errorf("exposition lacks %q", line)
+        }
+    }
+    if t.Failed() {
+        t.Logf("exposition:\n%s", text)
+    }
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/rat"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// RATCollector exposes the counters, flows, tree swaps and whisker choices of a RAT
+// It is the RAT's observer, so it sees every round-trip time and window update as they happen; the counters and the
+// state of the active flows are read from the RAT when the registry is gathered. Every sample carries the label
+// controller, so that several RATs can share a registry.
+type RATCollector struct {
+    name string
+    rat  *rat.RAT
+
+    mu        sync.Mutex
+    windows   *histogram               // Every window the RAT set
+    rtts      *histogram               // Every round-trip time the RAT measured, in seconds
+    intersend *histogram               // Every intersend time the RAT set, in seconds
+    tree      *whisker.WhiskerTree     // Tree the whisker indices refer to
+    index     map[*whisker.Whisker]int // Index of every whisker of the tree, in the tree's order
+    hits      map[int]uint64           // Number of window updates each whisker made, by index, or -1 for the root
+}
+
+// NewRATCollector creates a collector for a RAT under the given controller name and sets it as the RAT's observer
+func NewRATCollector(name string, r *rat.RAT) *RATCollector {
+    c := &RATCollector{
+        name:      name,
+        rat:       r,
+        windows:   newHistogram(WindowBuckets),
+        rtts:      newHistogram(RTTBuckets),
+        intersend: newHistogram(IntersendBuckets),
+        hits:      make(map[int]uint64),
+    }
+    r.SetObserver(c)
+    return c
+}
+
+// PacketAcked records the round-trip time of an acknowledged packet
+func (c *RATCollector) PacketAcked(flowID uint, rtt time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.rtts.observe(rtt.Seconds())
+}
+
+// WindowUpdated records a window update and the whisker that made it
+// When the RAT swaps in a new tree the whisker hit counts start again, since the indices refer to other whiskers.
+func (c *RATCollector) WindowUpdated(flowID uint, window uint, intersend float64, chosen *whisker.Whisker) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.windows.observe(float64(window))
+    c.intersend.observe(intersend)
+
+    if tree := c.rat.Whiskers(); tree != c.tree {
+        c.tree = tree
+        c.index = make(map[*whisker.Whisker]int)
+        for i, w := range tree.Whiskers() {
+            c.index[w] = i
+        }
+        c.hits = make(map[int]uint64)
+    }
+    i, ok := c.index[chosen]
+    if !ok {
+        i = -1
+    }
+    c.hits[i]++
+}
+
+// Collect returns the RAT's metrics
+func (c *RATCollector) Collect() []Family {
+    controller := Labels{"controller": c.name}
+    flows := c.rat.Flows()
+    ids := make([]uint, 0, len(flows))
+    for id := range flows {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    reloads := c.rat.ReloadStats()
+
+    families := []Family{
+        {
+            Name:    "remy_rat_packets_sent_total",
+            Help:    "Number of packets sent across all flows.",
+            Type:    Counter,
+            Samples: []Sample{{controller, float64(c.rat.PacketsSent())}},
+        },
+        {
+            Name:    "remy_rat_packets_received_total",
+            Help:    "Number of packets acknowledged across all flows.",
+            Type:    Counter,
+            Samples: []Sample{{controller, float64(c.rat.PacketsReceived())}},
+        },
+        {
+            Name:    "remy_rat_packets_lost_total",
+            Help:    "Number of packets reported lost across all flows.",
+            Type:    Counter,
+            Samples: []Sample{{controller, float64(c.rat.PacketsLost())}},
+        },
+        {
+            Name:    "remy_rat_active_flows",
+            Help:    "Number of flows that are currently active.",
+            Type:    Gauge,
+            Samples: []Sample{{controller, float64(len(flows))}},
+        },
+        {
+            Name:    "remy_rat_tree_swaps_total",
+            Help:    "Number of times a new whisker tree was swapped in.",
+            Type:    Counter,
+            Samples: []Sample{{controller, float64(reloads.Swaps)}},
+        },
+        {
+            Name:    "remy_rat_tree_rollbacks_total",
+            Help:    "Number of times a whisker tree swap was rolled back.",
+            Type:    Counter,
+            Samples: []Sample{{controller, float64(reloads.Rollbacks)}},
+        },
+        {
+            Name:    "remy_rat_tree_last_swap_timestamp_seconds",
+            Help:    "Unix time of the last whisker tree swap, or 0 if there was none.",
+            Type:    Gauge,
+            Samples: []Sample{{controller, unixSeconds(reloads.LastSwap)}},
+        },
+        {
+            Name:    "remy_rat_tree_last_rollback_timestamp_seconds",
+            Help:    "Unix time of the last whisker tree rollback, or 0 if there was none.",
+            Type:    Gauge,
+            Samples: []Sample{{controller, unixSeconds(reloads.LastRollback)}},
+        },
+    }
+
+    window := Family{Name: "remy_rat_flow_congestion_window_packets", Help: "Congestion window of each active flow.", Type: Gauge}
+    intersend := Family{Name: "remy_rat_flow_intersend_seconds", Help: "Intersend time of each active flow.", Type: Gauge}
+    sent := Family{Name: "remy_rat_flow_packets_sent_total", Help: "Number of packets sent in each active flow.", Type: Counter}
+    received := Family{Name: "remy_rat_flow_packets_received_total", Help: "Number of packets acknowledged in each active flow.", Type: Counter}
+    lost := Family{Name: "remy_rat_flow_packets_lost_total", Help: "Number of packets reported lost in each active flow.", Type: Counter}
+    for _, id := range ids {
+        flow := flows[id]
+        labels := Labels{"controller": c.name, "flow": strconv.FormatUint(uint64(id), 10)}
+        window.Samples = append(window.Samples, Sample{labels, float64(flow.CongestionWindow())})
+        intersend.Samples = append(intersend.Samples, Sample{labels, flow.Intersend()})
+        sent.Samples = append(sent.Samples, Sample{labels, float64(flow.PacketsSent())})
+        received.Samples = append(received.Samples, Sample{labels, float64(flow.PacketsReceived())})
+        lost.Samples = append(lost.Samples, Sample{labels, float64(flow.PacketsLost())})
+    }
+    families = append(families, window, intersend, sent, received, lost)
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    hits := Family{Name: "remy_rat_whisker_hits_total", Help: "Number of window updates made by each whisker, by index in the tree's order.", Type: Counter}
+    indices := make([]int, 0, len(c.hits))
+    for i := range c.hits {
+        indices = append(indices, i)
+    }
+    sort.Ints(indices)
+    for _, i := range indices {
+        name := strconv.Itoa(i)
+        if i < 0 {
+            name = "root"
+        }
+        hits.Samples = append(hits.Samples, Sample{Labels{"controller": c.name, "whisker": name}, float64(c.hits[i])})
+    }
+    return append(families, hits,
+        Family{
+            Name:       "remy_rat_congestion_window_packets",
+            Help:       "Congestion windows set by whisker lookups.",
+            Type:       Histogram,
+            Histograms: []HistogramSample{c.windows.sample(controller)},
+        },
+        Family{
+            Name:       "remy_rat_intersend_seconds",
+            Help:       "Intersend times set by whisker lookups.",
+            Type:       Histogram,
+            Histograms: []HistogramSample{c.intersend.sample(controller)},
+        },
+        Family{
+            Name:       "remy_rat_rtt_seconds",
+            Help:       "Round-trip times of acknowledged packets.",
+            Type:       Histogram,
+            Histograms: []HistogramSample{c.rtts.sample(controller)},
+        },
+    )
+}
+
+// unixSeconds returns a time in seconds since the Unix epoch, with the zero time as 0
+func unixSeconds(t time.Time) float64 {
+    if t.IsZero() {
+        return 0
+    }
+    return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+    "strconv"
+
+    "github.com/Aanthord/remy-go/pkg/sender"
+)
+
+// SenderCollector exposes the byte counts, windows, send rates and timeouts of the senders of a gang
+// The gang's mutex is held while the senders are read. Every sample carries the labels gang and sender; the RAT the
+// senders share can be exposed as well with a RATCollector.
+type SenderCollector struct {
+    name string
+    gang *sender.SenderGang
+}
+
+// NewSenderCollector creates a collector for the senders of a gang under the given name
+func NewSenderCollector(name string, gang *sender.SenderGang) *SenderCollector {
+    return &SenderCollector{name: name, gang: gang}
+}
+
+// Collect returns the senders' metrics
+func (c *SenderCollector) Collect() []Family {
+    sent := Family{Name: "remy_sender_bytes_sent_total", Help: "Number of bytes sent by each sender.", Type: Counter}
+    acked := Family{Name: "remy_sender_bytes_acked_total", Help: "Number of bytes acknowledged to each sender.", Type: Counter}
+    window := Family{Name: "remy_sender_congestion_window_packets", Help: "Congestion window of each sender.", Type: Gauge}
+    rate := Family{Name: "remy_sender_send_rate_packets_per_second", Help: "Send rate of each sender, or 0 if it is not paced.", Type: Gauge}
+    outstanding := Family{Name: "remy_sender_outstanding_packets", Help: "Number of packets each sender has in flight.", Type: Gauge}
+    timeouts := Family{Name: "remy_sender_timeouts_total", Help: "Number of timeouts of each sender.", Type: Counter}
+
+    c.gang.Mu.Lock()
+    defer c.gang.Mu.Unlock()
+    for _, s := range c.gang.Senders {
+        // Senders are created when the gang starts
+        if s == nil {
+            continue
+        }
+        labels := Labels{"gang": c.name, "sender": strconv.Itoa(s.ID)}
+        sent.Samples = append(sent.Samples, Sample{labels, float64(s.BytesSent)})
+        acked.Samples = append(acked.Samples, Sample{labels, float64(s.BytesAcked)})
+        window.Samples = append(window.Samples, Sample{labels, float64(s.CongestionWnd)})
+        rate.Samples = append(rate.Samples, Sample{labels, s.SendRate})
+        outstanding.Samples = append(outstanding.Samples, Sample{labels, float64(s.Outstanding())})
+        timeouts.Samples = append(timeouts.Samples, Sample{labels, float64(s.Timeouts)})
+    }
+    return []Family{sent, acked, window, rate, outstanding, timeouts}
+}
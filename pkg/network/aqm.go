@@ -0,0 +1,229 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// AQM is an active queue management scheme for the link's queue
+// Admit is asked about every arriving packet and Drop about every packet about to be served
+type AQM interface {
+    Admit(queueLength int, now time.Time) bool
+    Drop(packet *Packet, queueLength int, now time.Time) bool
+}
+
+// AQMKind names an active queue management scheme
+type AQMKind string
+
+// Known active queue management schemes
+const (
+    DropTail AQMKind = ""      // No active queue management: only a full buffer drops packets
+    CoDel    AQMKind = "codel" // Controlled delay, RFC 8289
+    RED      AQMKind = "red"   // Random early detection
+)
+
+// AQMConfig describes the active queue management of a link
+type AQMConfig struct {
+    Kind           AQMKind
+    Target         time.Duration // CoDel: acceptable standing queue delay
+    Interval       time.Duration // CoDel: time the queue delay must stay above the target before dropping
+    MinThreshold   float64       // RED: average queue length in packets below which nothing is dropped
+    MaxThreshold   float64       // RED: average queue length in packets above which everything is dropped
+    MaxProbability float64       // RED: drop probability reached at the maximum threshold
+    Weight         float64       // RED: gain of the moving average of the queue length
+}
+
+// DefaultAQMConfig returns the usual parameters of a scheme
+func DefaultAQMConfig(kind AQMKind) AQMConfig {
+    return AQMConfig{
+        Kind:           kind,
+        Target:         5 * time.Millisecond,
+        Interval:       100 * time.Millisecond,
+        MinThreshold:   5,
+        MaxThreshold:   15,
+        MaxProbability: 0.1,
+        Weight:         0.002,
+    }
+}
+
+// ParseAQM parses a scheme such as "codel", "codel:target=5ms,interval=100ms" or "red:min=5,max=15,p=0.1"
+// The empty string and "droptail" mean no active queue management
+func ParseAQM(spec string) (AQMConfig, error) {
+    name, params, _ := strings.Cut(spec, ":")
+    var config AQMConfig
+    switch AQMKind(name) {
+    case DropTail, "droptail":
+        if params != "" {
+            return AQMConfig{}, fmt.Errorf("drop-tail queue takes no parameters: %q", spec)
+        }
+        return AQMConfig{Kind: DropTail}, nil
+    case CoDel, RED:
+        config = DefaultAQMConfig(AQMKind(name))
+    default:
+        return AQMConfig{}, fmt.Errorf("unknown AQM %q (want droptail, codel or red)", name)
+    }
+    if params == "" {
+        return config, nil
+    }
+
+    for _, param := range strings.Split(params, ",") {
+        key, value, ok := strings.Cut(param, "=")
+        if !ok {
+            return AQMConfig{}, fmt.Errorf("AQM parameter %q is not key=value", param)
+        }
+        var err error
+        switch {
+        case config.Kind == CoDel && key == "target":
+            config.Target, err = time.ParseDuration(value)
+        case config.Kind == CoDel && key == "interval":
+            config.Interval, err = time.ParseDuration(value)
+        case config.Kind == RED && key == "min":
+            config.MinThreshold, err = strconv.ParseFloat(value, 64)
+        case config.Kind == RED && key == "max":
+            config.MaxThreshold, err = strconv.ParseFloat(value, 64)
+        case config.Kind == RED && key == "p":
+            config.MaxProbability, err = strconv.ParseFloat(value, 64)
+        case config.Kind == RED && key == "weight":
+            config.Weight, err = strconv.ParseFloat(value, 64)
+        default:
+            return AQMConfig{}, fmt.Errorf("unknown %s parameter %q", config.Kind, key)
+        }
+        if err != nil {
+            return AQMConfig{}, fmt.Errorf("AQM parameter %q: %v", param, err)
+        }
+    }
+    return config, nil
+}
+
+// String returns the scheme in the form ParseAQM reads
+func (c AQMConfig) String() string {
+    switch c.Kind {
+    case CoDel:
+        return fmt.Sprintf("codel:target=%v,interval=%v", c.Target, c.Interval)
+    case RED:
+        return fmt.Sprintf("red:min=%g,max=%g,p=%g,weight=%g", c.MinThreshold, c.MaxThreshold, c.MaxProbability, c.Weight)
+    default:
+        return "droptail"
+    }
+}
+
+// NewAQM creates the state of a scheme, or returns nil for a drop-tail queue
+// RED draws its drops from rng
+func NewAQM(config AQMConfig, rng *rand.Rand) AQM {
+    switch config.Kind {
+    case CoDel:
+        return &codel{target: config.Target, interval: config.Interval}
+    case RED:
+        return &red{config: config, rng: rng, count: -1}
+    default:
+        return nil
+    }
+}
+
+// codel is the CoDel controller of RFC 8289, deciding drops as packets reach the head of the queue
+type codel struct {
+    target     time.Duration
+    interval   time.Duration
+    firstAbove time.Time // When the queue delay will have been above the target for an interval, or zero
+    dropNext   time.Time // Time of the next drop while dropping
+    count      int       // Drops since entering the dropping state
+    lastCount  int       // Value of count when the dropping state was last entered
+    dropping   bool
+}
+
+// Admit accepts every packet; CoDel only drops at the head of the queue
+func (c *codel) Admit(queueLength int, now time.Time) bool {
+    return true
+}
+
+// Drop decides whether to drop a packet about to be served, given the packets in the queue including it
+func (c *codel) Drop(packet *Packet, queueLength int, now time.Time) bool {
+    okToDrop := c.okToDrop(packet, queueLength, now)
+    if c.dropping {
+        if !okToDrop {
+            c.dropping = false
+            return false
+        }
+        if !now.Before(c.dropNext) {
+            c.count++
+            c.dropNext = c.controlLaw(c.dropNext)
+            return true
+        }
+        return false
+    }
+    if !okToDrop {
+        return false
+    }
+
+    // Start dropping, at the rate reached last time if the queue was dropping recently
+    c.dropping = true
+    delta := c.count - c.lastCount
+    c.count = 1
+    if delta > 1 && now.Sub(c.dropNext) < 16*c.interval {
+        c.count = delta
+    }
+    c.lastCount = c.count
+    c.dropNext = c.controlLaw(now)
+    return true
+}
+
+// okToDrop checks if the packet's sojourn time has been above the target for at least an interval
+func (c *codel) okToDrop(packet *Packet, queueLength int, now time.Time) bool {
+    if now.Sub(packet.Enqueued) < c.target || queueLength <= 1 {
+        c.firstAbove = time.Time{}
+        return false
+    }
+    if c.firstAbove.IsZero() {
+        c.firstAbove = now.Add(c.interval)
+        return false
+    }
+    return !now.Before(c.firstAbove)
+}
+
+// controlLaw returns the time of the next drop, which comes sooner the more drops there have been
+func (c *codel) controlLaw(t time.Time) time.Time {
+    return t.Add(time.Duration(float64(c.interval) / math.Sqrt(float64(c.count))))
+}
+
+// red is random early detection, dropping arriving packets with a probability that grows with the average queue
+type red struct {
+    config  AQMConfig
+    rng     *rand.Rand
+    average float64 // Moving average of the queue length in packets
+    count   int     // Packets admitted since the last drop, or -1 while the average is below the minimum threshold
+}
+
+// Admit decides whether an arriving packet joins the queue, given the packets already in it
+func (r *red) Admit(queueLength int, now time.Time) bool {
+    r.average += r.config.Weight * (float64(queueLength) - r.average)
+    switch {
+    case r.average < r.config.MinThreshold:
+        r.count = -1
+        return true
+    case r.average >= r.config.MaxThreshold:
+        r.count = 0
+        return false
+    }
+
+    // Spread drops out evenly by raising the probability with every packet admitted since the last one
+    r.count++
+    pb := r.config.MaxProbability * (r.average - r.config.MinThreshold) / (r.config.MaxThreshold - r.config.MinThreshold)
+    pa := 1.0
+    if d := 1 - float64(r.count)*pb; d > 0 {
+        pa = pb / d
+    }
+    if r.rng.Float64() < pa {
+        r.count = 0
+        return false
+    }
+    return true
+}
+
+// Drop never drops; RED only drops arriving packets
+func (r *red) Drop(packet *Packet, queueLength int, now time.Time) bool {
+    return false
+}
@@ -1,12 +1,19 @@
 package network
 
 import (
-    "time" // Import the time package for time-related operations
+    "math/rand" // Import the rand package for jitter
+    "time"      // Import the time package for time-related operations
 )
 
 // Delay represents the propagation delay in the network
+// Packets leave it in the order they entered, each after the fixed delay plus a random jitter; a packet never
+// overtakes the one before it, so jitter that would reorder packets holds the later one back instead
 type Delay struct {
-    Delay time.Duration // Duration of the delay
+    Delay    time.Duration // Duration of the delay
+    Jitter   time.Duration // Largest random delay added to each packet
+    rng      *rand.Rand    // Source of the jitter
+    packets  []*Packet     // Packets in flight, oldest first
+    releases []time.Time   // Times at which the packets in flight arrive
 }
 
 // NewDelay is a constructor that creates a new instance of the Delay struct
@@ -15,3 +22,43 @@ func NewDelay(delay time.Duration) *Delay {
         Delay: delay,
     }
 }
+
+// NewConfiguredDelay creates the delay a network configuration describes, with rng as the source of its jitter
+func NewConfiguredDelay(config Config, rng *rand.Rand) *Delay {
+    delay := NewDelay(time.Duration(config.RTT * float64(time.Millisecond)))
+    delay.Jitter = time.Duration(config.Jitter * float64(time.Millisecond))
+    delay.rng = rng
+    return delay
+}
+
+// Accept puts a packet entering the delay at the given time in flight
+func (d *Delay) Accept(packet *Packet, now time.Time) {
+    release := now.Add(d.Delay)
+    if d.Jitter > 0 && d.rng != nil {
+        release = release.Add(time.Duration(d.rng.Int63n(int64(d.Jitter) + 1)))
+    }
+    if n := len(d.releases); n > 0 && release.Before(d.releases[n-1]) {
+        release = d.releases[n-1]
+    }
+    d.packets = append(d.packets, packet)
+    d.releases = append(d.releases, release)
+}
+
+// NextRelease returns the time at which the next packet arrives, or false if no packet is in flight
+func (d *Delay) NextRelease() (time.Time, bool) {
+    if len(d.packets) == 0 {
+        return time.Time{}, false
+    }
+    return d.releases[0], true
+}
+
+// Release removes and returns the packets that have arrived by the given time
+func (d *Delay) Release(now time.Time) []*Packet {
+    var packets []*Packet
+    for len(d.packets) > 0 && !d.releases[0].After(now) {
+        packets = append(packets, d.packets[0])
+        d.packets = d.packets[1:]
+        d.releases = d.releases[1:]
+    }
+    return packets
+}
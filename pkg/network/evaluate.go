@@ -0,0 +1,82 @@
+package network
+
+import (
+    "errors"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/dna"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Evaluator scores whisker trees by simulating them on a set of networks, as Evaluator does in Remy
+type Evaluator struct {
+    Configs  []Config      // Networks each tree is run on
+    Duration time.Duration // Simulated time of each run
+    Runs     int           // Number of runs per network, each with its own seed
+    Seed     int64         // Seed of the first run; later runs use the following seeds
+    Delta    float64       // Weight of delay against throughput in the utility
+}
+
+// NewEvaluator is a constructor that creates an Evaluator with Remy's defaults for the given networks
+func NewEvaluator(configs []Config) *Evaluator {
+    return &Evaluator{
+        Configs:  configs,
+        Duration: 100 * time.Second,
+        Runs:     1,
+        Seed:     1,
+        Delta:    1,
+    }
+}
+
+// Evaluate runs the tree on every network with tracking enabled and returns its average utility
+// The use count of every whisker is increased by the number of times it was chosen
+func (e *Evaluator) Evaluate(tree *whisker.WhiskerTree) (float64, error) {
+    if len(e.Configs) == 0 {
+        return 0, errors.New("no networks to evaluate on")
+    }
+
+    runs := e.Runs
+    if runs < 1 {
+        runs = 1
+    }
+
+    total := 0.0
+    for _, config := range e.Configs {
+        for run := 0; run < runs; run++ {
+            network := NewNetwork(tree, config, e.Seed+int64(run), true)
+            network.Run(e.Duration)
+            total += network.Utility(e.Delta)
+        }
+    }
+    return total / float64(len(e.Configs)*runs), nil
+}
+
+// ConfigsFromRange returns the networks at the corners of a training configuration's ranges
+func ConfigsFromRange(config *dna.ConfigRange) []Config {
+    var configs []Config
+    for _, linkPPT := range rangeEnds(config.LinkPpt) {
+        for _, rtt := range rangeEnds(config.Rtt) {
+            for _, numSenders := range rangeEnds(config.NumSenders) {
+                configs = append(configs, Config{
+                    LinkPPT:    linkPPT,
+                    RTT:        rtt,
+                    NumSenders: int(numSenders),
+                    MeanOn:     float64(config.MeanOnDuration),
+                    MeanOff:    float64(config.MeanOffDuration),
+                })
+            }
+        }
+    }
+    return configs
+}
+
+// rangeEnds returns the distinct ends of a range
+func rangeEnds(r *dna.Range) []float64 {
+    if r == nil {
+        return []float64{0}
+    }
+    if r.Low == r.High {
+        return []float64{float64(r.Low)}
+    }
+    return []float64{float64(r.Low), float64(r.High)}
+}
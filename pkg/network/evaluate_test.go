@@ -0,0 +1,70 @@
+package network
+
+import (
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// split divides a domain in two at the given value of a signal
+func split(domain *memory.MemoryRange, id memory.SignalID, cut memory.DataType) (*memory.MemoryRange, *memory.MemoryRange) {
+    upper, lower := domain.Upper.Clone(), domain.Lower.Clone()
+    upper.Set(id, cut)
+    lower.Set(id, cut)
+    return memory.NewMemoryRange(domain.Lower, upper), memory.NewMemoryRange(lower, domain.Upper)
+}
+
+func TestPruneWithEvaluator(t *testing.T) {
+    // Senders sending slowly use the left subtree, whose leaves act alike, and those sending fast use the right
+    // one, whose leaves do not: one keeps a window of 50 and the other grows it one packet at a time
+    tree := whisker.NewWhiskerTree()
+    slow, fast := split(tree.Root.Whisker.Domain, memory.SendRate, 10)
+    slow1, slow2 := split(slow, memory.RecvRate, 10)
+    fast1, fast2 := split(fast, memory.RecvRate, 12)
+    tree.Root.Children = []*whisker.WhiskerNode{
+        {Whisker: whisker.NewWhisker(1, 1, 1, 0.001, slow), Children: []*whisker.WhiskerNode{
+            {Whisker: whisker.NewWhisker(2, 1, 1, 0.001, slow1)},
+            {Whisker: whisker.NewWhisker(2, 1, 1, 0.001, slow2)},
+        }},
+        {Whisker: whisker.NewWhisker(1, 1, 1, 0.001, fast), Children: []*whisker.WhiskerNode{
+            {Whisker: whisker.NewWhisker(2, 50, 0, 0.001, fast1)},
+            {Whisker: whisker.NewWhisker(2, 1, 1, 0.001, fast2)},
+        }},
+    }
+    tree.Invalidate()
+
+    evaluator := NewEvaluator([]Config{{LinkPPT: 1, RTT: 150, NumSenders: 2, MeanOn: 1000, MeanOff: 1000, Buffer: 100}})
+    evaluator.Duration = 10 * time.Second
+    // Every leaf counts as unused, so both subtrees are candidates whatever their actions
+    opts := whisker.DefaultPruneOptions()
+    opts.MinCount = 1 << 62
+
+    pruned, result, err := whisker.Prune(tree, evaluator.Evaluate, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Collapsed != 1 || result.Rejected != 1 {
+        t.Fatalf("got %v, want one collapse kept and one rejected", result)
+    }
+    if n := len(pruned.Root.Children[0].Children); n != 0 {
+        t.Errorf("the left subtree still has %d children", n)
+    }
+    if n := len(pruned.Root.Children[1].Children); n != 2 {
+        t.Errorf("the right subtree has %d children, want its 2 leaves back", n)
+    }
+    if result.WhiskersBefore != 7 || result.WhiskersAfter != 5 || pruned.Len() != 5 {
+        t.Errorf("got %v and a tree of %d nodes, want 7 -> 5", result, pruned.Len())
+    }
+    if result.Score < result.BaselineScore {
+        t.Errorf("kept a collapse that lowered the score: %v", result)
+    }
+    // The score reported is the pruned tree's, and pruning left the tree passed in alone
+    if s, _ := evaluator.Evaluate(pruned); s != result.Score {
+        t.Errorf("pruned tree scores %g, result says %g", s, result.Score)
+    }
+    if tree.Len() != 7 {
+        t.Errorf("the tree passed in now has %d nodes", tree.Len())
+    }
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+    "fmt"
+    "time"
+)
+
+// EventKind is what happened to a packet in the simulated network
+type EventKind int
+
+const (
+    EventEnqueue EventKind = iota // The packet entered the link's queue
+    EventDrop                     // The link dropped the packet, on arrival or at the head of the queue
+    EventDequeue                  // The packet left the link for the delay
+    EventAck                      // The packet arrived and was acknowledged
+)
+
+// String returns the name of the event kind
+func (k EventKind) String() string {
+    switch k {
+    case EventEnqueue:
+        return "enqueue"
+    case EventDrop:
+        return "drop"
+    case EventDequeue:
+        return "dequeue"
+    case EventAck:
+        return "ack"
+    }
+    return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// DropReason is why the link dropped a packet
+type DropReason int
+
+const (
+    DropNone   DropReason = iota // The event is not a drop
+    DropBuffer                   // The buffer was full
+    DropAQM                      // The AQM chose to drop the packet
+    DropLoss                     // The link lost the packet at random
+)
+
+// String returns the name of the drop reason
+func (r DropReason) String() string {
+    switch r {
+    case DropNone:
+        return "none"
+    case DropBuffer:
+        return "buffer"
+    case DropAQM:
+        return "aqm"
+    case DropLoss:
+        return "loss"
+    }
+    return fmt.Sprintf("DropReason(%d)", int(r))
+}
+
+// Event records something that happened to a packet in the simulated network, at simulated time
+type Event struct {
+    Kind        EventKind
+    Time        time.Time
+    Packet      *Packet
+    Reason      DropReason // Why the packet was dropped, for drops
+    QueueLength int        // Length of the link's queue after the event, for the link's events
+}
+
+// String returns a one-line description of the event
+func (e Event) String() string {
+    s := fmt.Sprintf("%v %s sender %d flow %d seq %d", e.Time.Sub(time.Unix(0, 0)), e.Kind, e.Packet.Sender, e.Packet.FlowID, e.Packet.SeqNo)
+    if e.Kind == EventDrop {
+        s += " (" + e.Reason.String() + ")"
+    }
+    return s
+}
+
+// EventLog receives every event of a simulated network
+type EventLog interface {
+    Record(event Event) error
+    Flush() error
+}
+
+// multiEventLog records every event in several logs
+type multiEventLog []EventLog
+
+// MultiEventLog creates an EventLog that records every event in all of the given logs, as io.MultiWriter does
+func MultiEventLog(logs ...EventLog) EventLog {
+    return multiEventLog(logs)
+}
+
+// Record records the event in every log, returning the first error
+func (m multiEventLog) Record(event Event) error {
+    var first error
+    for _, log := range m {
+        if err := log.Record(event); err != nil && first == nil {
+            first = err
+        }
+    }
+    return first
+}
+
+// Flush flushes every log, returning the first error
+func (m multiEventLog) Flush() error {
+    var first error
+    for _, log := range m {
+        if err := log.Flush(); err != nil && first == nil {
+            first = err
+        }
+    }
+    return first
+}
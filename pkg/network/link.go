@@ -1,32 +1,167 @@
 package network
 
 import (
-    "time" // Import the time package for time-related operations
+    "math/rand" // Import the rand package for random losses
+    "time"      // Import the time package for time-related operations
 )
 
-// Link represents a network link
+// Link represents the bottleneck link of the simulated network
+// It serves the packets in its queue in order at a fixed rate, or at the delivery opportunities of a trace, and
+// drops packets that arrive to a full buffer, that its AQM chooses to drop, or at random
 type Link struct {
-    Bandwidth    int           // Bandwidth of the link in bytes per second
-    Latency      time.Duration // Latency of the link
-    PacketQueue  chan *Packet  // Channel acting as a packet queue
+    Rate          float64     // Rate of the link in packets per millisecond
+    Buffer        int         // Capacity of the queue in packets, or 0 for an unlimited queue
+    AQM           AQM         // Active queue management of the queue, or nil for a drop-tail queue
+    Loss          float64     // Probability that an arriving packet is lost regardless of the queue
+    Trace         *Trace      // Delivery opportunities that replace the fixed rate, or nil
+    OnEvent       func(Event) // Called for every packet the link enqueues, drops or dequeues, or nil
+    OnDrop        func(Event) // Called for every packet the link drops, so that its sender can learn of the loss, or nil
+    rng           *rand.Rand  // Source of random losses
+    queue         []*Packet   // Packets waiting to leave the link, head first
+    nextDeparture time.Time   // Time at which the packet at the head of the queue leaves the link
+    free          time.Time   // Time at which the link finished serving its last packet
+    traceOrigin   time.Time   // Start of the trace's first period
+    traceNext     int         // Index of the first delivery opportunity not yet used or passed
+    dropped       uint        // Number of packets dropped because the buffer was full
+    aqmDropped    uint        // Number of packets dropped by the AQM
+    lost          uint        // Number of packets lost at random
 }
 
 // NewLink is a constructor that creates a new instance of the Link struct
-func NewLink(bandwidth int, latency time.Duration) *Link {
+func NewLink(rate float64, buffer int) *Link {
     return &Link{
-        Bandwidth:    bandwidth,
-        Latency:      latency,
-        PacketQueue:  make(chan *Packet, 1000), // Create a buffered channel with capacity 1000
+        Rate:   rate,
+        Buffer: buffer,
     }
 }
 
-// Enqueue attempts to enqueue a packet into the link's packet queue
-// It returns true if the packet was successfully enqueued, false otherwise
-func (l *Link) Enqueue(packet *Packet) bool {
-    select {
-    case l.PacketQueue <- packet:
-        return true
-    default:
+// NewConfiguredLink creates the link a network configuration describes, with rng as its source of randomness
+func NewConfiguredLink(config Config, rng *rand.Rand) *Link {
+    link := NewLink(config.LinkPPT, config.Buffer)
+    link.AQM = NewAQM(config.AQM, rng)
+    link.Loss = config.Loss
+    link.Trace = config.Trace
+    link.rng = rng
+    return link
+}
+
+// serviceTime returns the time the link takes to serve one packet
+func (l *Link) serviceTime() time.Duration {
+    return time.Duration(float64(time.Millisecond) / l.Rate)
+}
+
+// departure returns the time a packet whose service starts at the given time leaves the link
+func (l *Link) departure(start time.Time) time.Time {
+    if l.Trace == nil {
+        return start.Add(l.serviceTime())
+    }
+    if l.traceOrigin.IsZero() {
+        l.traceOrigin = start
+    }
+    at, k := l.Trace.next(l.traceOrigin, l.traceNext, start)
+    l.traceNext = k + 1
+    return at
+}
+
+// Enqueue adds a packet arriving at the given time to the link's queue
+// It returns true if the packet was successfully enqueued, false if it was dropped
+func (l *Link) Enqueue(packet *Packet, now time.Time) bool {
+    if l.Loss > 0 && l.rng != nil && l.rng.Float64() < l.Loss {
+        l.lost++
+        l.emit(EventDrop, DropLoss, packet, now)
+        return false
+    }
+    if l.Buffer > 0 && len(l.queue) >= l.Buffer {
+        l.dropped++
+        l.emit(EventDrop, DropBuffer, packet, now)
+        return false
+    }
+    if l.AQM != nil && !l.AQM.Admit(len(l.queue), now) {
+        l.aqmDropped++
+        l.emit(EventDrop, DropAQM, packet, now)
         return false
     }
+    packet.Enqueued = now
+    l.queue = append(l.queue, packet)
+    l.emit(EventEnqueue, DropNone, packet, now)
+    if len(l.queue) == 1 {
+        start := now
+        if l.free.After(start) {
+            start = l.free
+        }
+        l.startService(start)
+    }
+    return true
+}
+
+// startService picks the packet the link serves next, letting the AQM drop packets at the head of the queue first
+func (l *Link) startService(start time.Time) {
+    for len(l.queue) > 0 {
+        if l.AQM != nil && l.AQM.Drop(l.queue[0], len(l.queue), start) {
+            dropped := l.queue[0]
+            l.queue = l.queue[1:]
+            l.aqmDropped++
+            l.emit(EventDrop, DropAQM, dropped, start)
+            continue
+        }
+        l.nextDeparture = l.departure(start)
+        return
+    }
+}
+
+// emit reports an event to OnEvent, and a drop to OnDrop, if they are set, once the queue reflects it
+func (l *Link) emit(kind EventKind, reason DropReason, packet *Packet, at time.Time) {
+    if l.OnEvent == nil && (kind != EventDrop || l.OnDrop == nil) {
+        return
+    }
+    event := Event{Kind: kind, Time: at, Packet: packet, Reason: reason, QueueLength: len(l.queue)}
+    if l.OnEvent != nil {
+        l.OnEvent(event)
+    }
+    if kind == EventDrop && l.OnDrop != nil {
+        l.OnDrop(event)
+    }
+}
+
+// NextDeparture returns the time at which the next packet leaves the link, or false if the queue is empty
+func (l *Link) NextDeparture() (time.Time, bool) {
+    if len(l.queue) == 0 {
+        return time.Time{}, false
+    }
+    return l.nextDeparture, true
+}
+
+// Dequeue removes and returns the packets that have left the link by the given time, along with their departure times
+func (l *Link) Dequeue(now time.Time) ([]*Packet, []time.Time) {
+    var packets []*Packet
+    var departures []time.Time
+    for len(l.queue) > 0 && !l.nextDeparture.After(now) {
+        packets = append(packets, l.queue[0])
+        departures = append(departures, l.nextDeparture)
+        l.queue = l.queue[1:]
+        l.emit(EventDequeue, DropNone, packets[len(packets)-1], l.nextDeparture)
+        l.free = l.nextDeparture
+        l.startService(l.free)
+    }
+    return packets, departures
+}
+
+// QueueLength returns the number of packets waiting in the link's queue
+func (l *Link) QueueLength() int {
+    return len(l.queue)
+}
+
+// Dropped returns the number of packets the link has dropped because its buffer was full
+func (l *Link) Dropped() uint {
+    return l.dropped
+}
+
+// AQMDropped returns the number of packets the link's AQM has dropped
+func (l *Link) AQMDropped() uint {
+    return l.aqmDropped
+}
+
+// Lost returns the number of packets the link has lost at random
+func (l *Link) Lost() uint {
+    return l.lost
 }
@@ -1,106 +1,383 @@
 package network
 
 import (
+    "fmt"       // Import the fmt package for reporting event log errors
+    "math"      // Import the math package for the utility calculation
     "math/rand" // Import the rand package for random number generation
     "time"      // Import the time package for time-related operations
 
-    "github.com/Aanthord/remy-go/pkg/memory" // Import the memory package from the remy project
-    "github.com/Aanthord/remy-go/pkg/rat"    // Import the rat package from the remy project
-    "github.com/Aanthord/remy-go/pkg/sender" // Import the sender package from the remy project
+    "github.com/Aanthord/remy-go/pkg/clock"   // Import the clock package from the remy project
+    "github.com/Aanthord/remy-go/pkg/rat"     // Import the rat package from the remy project
+    "github.com/Aanthord/remy-go/pkg/whisker" // Import the whisker package from the remy project
 )
 
+// Config describes a simulated network, as NetConfig does in Remy
+type Config struct {
+    LinkPPT    float64   // Rate of the bottleneck link in packets per millisecond
+    RTT        float64   // Round-trip time in milliseconds
+    NumSenders int       // Number of senders sharing the link
+    MeanOn     float64   // Mean duration of an on period in milliseconds
+    MeanOff    float64   // Mean duration of an off period in milliseconds
+    Buffer     int       // Capacity of the link's queue in packets, or 0 for an unlimited queue
+    AQM        AQMConfig // Active queue management of the link's queue
+    Loss       float64   // Probability that the link loses a packet at random
+    Jitter     float64   // Largest random delay in milliseconds added to the round-trip time of each packet
+    Trace      *Trace    // Mahimahi delivery trace the link follows instead of LinkPPT, which should be its average rate
+}
+
 // Network represents the simulated network environment
+// Senders that switch on and off share a bottleneck link followed by a fixed delay, and are acknowledged
+// as soon as their packets arrive. Time only moves from one event to the next, so a run depends on
+// nothing but the configuration, the whisker tree and the seed
 type Network struct {
-    Senders   []*rat.RAT     // Array of RAT (Remy Augmented TCP) senders
-    Receivers []*Receiver    // Array of Receiver objects that receive packets
-    Links     []*Link        // Array of Link objects representing network links
-    Delay     time.Duration  // Propagation delay in the network
+    config  Config
+    rat     *rat.RAT      // Congestion controller of all the senders, with one flow per on period
+    clock   *clock.Manual // Simulated time
+    link    *Link
+    delay   *Delay
+    senders []*sender
+    rng     *rand.Rand
+    events  EventLog      // Log of every enqueue, drop, dequeue and ACK, or nil
+    err     error         // First error of the event log
+    losses  []pendingLoss // Dropped packets not yet reported to the RAT
+}
+
+// pendingLoss is a dropped packet and the time its sender learns of the drop
+type pendingLoss struct {
+    at     time.Time
+    packet *rat.Packet
+}
+
+// sender is a simulated sender that alternates between on and off periods
+type sender struct {
+    id         int
+    on         bool
+    flowID     uint      // Flow of the current on period
+    seq        int       // Sequence number of the next packet
+    switchTime time.Time // Time at which the sender next switches on or off
+    onSince    time.Time // Start of the current on period
+    stats      SenderStats
+}
+
+// SenderStats holds what a sender achieved over a run
+type SenderStats struct {
+    PacketsSent     uint          // Number of packets sent
+    PacketsReceived uint          // Number of packets that arrived during the on period they were sent in
+    PacketsDropped  uint          // Number of packets dropped by the link
+    TotalDelay      time.Duration // Sum of the delays of the packets received
+    OnDuration      time.Duration // Total duration of the sender's on periods
+}
+
+// Throughput returns the average throughput of the sender while it was on, in packets per millisecond
+func (s SenderStats) Throughput() float64 {
+    if s.OnDuration == 0 {
+        return 0
+    }
+    return float64(s.PacketsReceived) / (float64(s.OnDuration) / float64(time.Millisecond))
+}
+
+// AverageDelay returns the average delay of the packets the sender received, in milliseconds
+func (s SenderStats) AverageDelay() float64 {
+    if s.PacketsReceived == 0 {
+        return 0
+    }
+    return float64(s.TotalDelay) / float64(time.Millisecond) / float64(s.PacketsReceived)
+}
+
+// Utility returns Remy's utility of the sender: the log of its throughput as a share of the link,
+// less delta times the log of its average delay as a multiple of the round-trip time
+// A sender that was never on has a utility of zero and one that was on but received nothing has -Inf
+func (s SenderStats) Utility(config Config, delta float64) float64 {
+    if s.OnDuration == 0 {
+        return 0
+    }
+    if s.PacketsReceived == 0 {
+        return math.Inf(-1)
+    }
+    fairShare := config.LinkPPT / float64(config.NumSenders)
+    return math.Log2(s.Throughput()/fairShare) - delta*math.Log2(s.AverageDelay()/config.RTT)
 }
 
 // NewNetwork is a constructor that creates a new instance of the Network struct
-func NewNetwork(numSenders int, numLinks int, delay time.Duration) *Network {
+// All the senders start off. If track is set, the use count of every whisker chosen during the run is updated
+func NewNetwork(whiskers *whisker.WhiskerTree, config Config, seed int64, track bool) *Network {
+    start := time.Unix(0, 0)
     network := &Network{
-        Senders:   make([]*rat.RAT, numSenders),
-        Receivers: make([]*Receiver, numSenders),
-        Links:     make([]*Link, numLinks),
-        Delay:     delay,
+        config:  config,
+        rat:     rat.NewRAT(whiskers, track),
+        clock:   clock.NewManual(start),
+        senders: make([]*sender, config.NumSenders),
+        rng:     rand.New(rand.NewSource(seed)),
     }
+    network.link = NewConfiguredLink(config, network.rng)
+    network.link.OnDrop = network.drop
+    network.delay = NewConfiguredDelay(config, network.rng)
+    network.rat.SetClock(network.clock)
 
-    // Initialize senders, receivers, and links
-    for i := 0; i < numSenders; i++ {
-        network.Senders[i] = rat.NewRAT(nil) // Initialize with nil whiskers
-        network.Receivers[i] = NewReceiver()
-    }
-    for i := 0; i < numLinks; i++ {
-        network.Links[i] = NewLink(1000, 10*time.Millisecond)
+    // Initialize senders
+    for i := range network.senders {
+        network.senders[i] = &sender{
+            id:         i,
+            switchTime: start.Add(network.exponential(config.MeanOff)),
+        }
     }
 
     return network
 }
 
-// Run simulates the network for a given duration
+// RAT returns the congestion controller of the senders
+func (n *Network) RAT() *rat.RAT {
+    return n.rat
+}
+
+// Link returns the bottleneck link
+func (n *Network) Link() *Link {
+    return n.link
+}
+
+// SetEventLog sets the log that receives every enqueue, drop, dequeue and ACK of the run; nil disables logging
+func (n *Network) SetEventLog(log EventLog) {
+    n.events = log
+    n.link.OnEvent = nil
+    if log != nil {
+        n.link.OnEvent = n.record
+    }
+}
+
+// record writes an event to the event log, keeping the first error for Err
+func (n *Network) record(event Event) {
+    if err := n.events.Record(event); err != nil && n.err == nil {
+        n.err = fmt.Errorf("failed to record %v event: %w", event.Kind, err)
+    }
+}
+
+// Err returns the first error of the event log during the run, or nil if there was none
+// The run carries on past such errors, so that a failing log never changes the results
+func (n *Network) Err() error {
+    return n.err
+}
+
+// drop counts a packet the link dropped against its sender and schedules the loss to be reported to the RAT
+// The sender learns of the drop one round-trip time later, when the packet's ACK would have arrived; until then the
+// packet holds its place in the window, as it would in Remy's senders
+func (n *Network) drop(event Event) {
+    packet := event.Packet
+    n.senders[packet.Sender].stats.PacketsDropped++
+    n.losses = append(n.losses, pendingLoss{
+        at: event.Time.Add(time.Duration(n.config.RTT * float64(time.Millisecond))),
+        packet: &rat.Packet{
+            SeqNo:  packet.SeqNo,
+            ID:     packet.Sender,
+            FlowID: packet.FlowID,
+            Sent:   packet.Sent,
+        },
+    })
+}
+
+// Now returns the current simulated time
+func (n *Network) Now() time.Time {
+    return n.clock.Now()
+}
+
+// exponential draws a duration from an exponential distribution with the given mean in milliseconds
+func (n *Network) exponential(mean float64) time.Duration {
+    return time.Duration(n.rng.ExpFloat64() * mean * float64(time.Millisecond))
+}
+
+// Run simulates the network for a given duration of simulated time
 func (n *Network) Run(duration time.Duration) {
-    rand.Seed(time.Now().UnixNano()) // Seed the random number generator
-    endTime := time.Now().Add(duration)
-
-    for time.Now().Before(endTime) {
-        for i, sender := range n.Senders {
-            if sender.TimeToSend() {
-                seq := rand.Intn(1000) // Generate a random sequence number
-                packet := &Packet{
-                    SeqNo: seq,
-                    Sent:  time.Now(),
-                }
-                n.SendPacket(packet, sender, n.Receivers[i])
+    end := n.clock.Now().Add(duration)
+
+    for {
+        next := n.nextEventTime()
+        if next.After(end) {
+            break
+        }
+        n.clock.Set(next)
+        n.tick(next)
+    }
+
+    n.clock.Set(end)
+    for _, s := range n.senders {
+        if s.on {
+            s.stats.OnDuration += end.Sub(s.onSince)
+            s.onSince = end
+        }
+    }
+}
+
+// nextEventTime returns the time of the earliest pending event
+func (n *Network) nextEventTime() time.Time {
+    now := n.clock.Now()
+    next := time.Time{}
+    consider := func(t time.Time) {
+        if next.IsZero() || t.Before(next) {
+            next = t
+        }
+    }
+
+    for _, s := range n.senders {
+        consider(s.switchTime)
+        if s.on && n.windowOpen(s) {
+            t := n.rat.NextEventTime(s.flowID)
+            if !t.After(now) {
+                t = now.Add(time.Nanosecond)
             }
+            consider(t)
         }
-        time.Sleep(n.Delay) // Sleep for the propagation delay
     }
+    if t, ok := n.link.NextDeparture(); ok {
+        consider(t)
+    }
+    if t, ok := n.delay.NextRelease(); ok {
+        consider(t)
+    }
+    for _, loss := range n.losses {
+        consider(loss.at)
+    }
+    if next.IsZero() {
+        return now
+    }
+    return next
 }
 
-// SendPacket simulates the sending of a packet from a sender to a receiver
-func (n *Network) SendPacket(packet *Packet, sender *rat.RAT, receiver *Receiver) {
-    sender.OnPacketSent(packet.SeqNo)
+// windowOpen checks if the window of a sender's flow allows it to send another packet
+func (n *Network) windowOpen(s *sender) bool {
+    flow, ok := n.rat.Flow(s.flowID)
+    if !ok {
+        return false
+    }
+    return flow.PacketsSent() < flow.PacketsReceived()+flow.PacketsLost()+flow.CongestionWindow()
+}
 
-    // Send the packet through all links
-    for _, link := range n.Links {
-        if !link.Enqueue(packet) {
-            sender.OnPacketLost()
-            return
+// tick processes every event due at the given time
+func (n *Network) tick(now time.Time) {
+    // Switch senders on and off
+    for _, s := range n.senders {
+        if s.switchTime.After(now) {
+            continue
         }
+        if s.on {
+            s.on = false
+            s.stats.OnDuration += now.Sub(s.onSince)
+            n.rat.EndFlow(s.flowID)
+            s.switchTime = now.Add(n.exponential(n.config.MeanOff))
+        } else {
+            s.on = true
+            s.onSince = now
+            s.flowID = n.rat.NewFlow()
+            s.switchTime = now.Add(n.exponential(n.config.MeanOn))
+        }
+    }
+
+    // Move packets that have left the link into the delay
+    packets, departures := n.link.Dequeue(now)
+    for i, packet := range packets {
+        n.delay.Accept(packet, departures[i])
     }
 
-    // Schedule packet delivery after the propagation delay
-    go func() {
-        time.Sleep(n.Delay)
-        if rand.Float64() < 0.1 { // Simulate packet loss with 10% probability
-            sender.OnPacketLost()
-            return
+    // Deliver packets that have arrived, acknowledging them at once
+    var received []*rat.Packet
+    for _, packet := range n.delay.Release(now) {
+        s := n.senders[packet.Sender]
+        if s.on && packet.FlowID == s.flowID {
+            s.stats.PacketsReceived++
+            s.stats.TotalDelay += now.Sub(packet.Sent)
         }
-        receiver.ReceivePacket(packet)
-        sender.OnPacketAcked(packet.SeqNo, time.Since(packet.Sent))
-    }()
+        if n.events != nil {
+            n.record(Event{Kind: EventAck, Time: now, Packet: packet})
+        }
+        received = append(received, &rat.Packet{
+            SeqNo:    packet.SeqNo,
+            ID:       packet.Sender,
+            FlowID:   packet.FlowID,
+            Sent:     packet.Sent,
+            Received: now,
+        })
+    }
+    if len(received) > 0 {
+        n.rat.ReceivePackets(received)
+    }
+
+    // Report the drops whose senders have noticed them, freeing their places in the windows
+    var lost []*rat.Packet
+    pending := n.losses[:0]
+    for _, loss := range n.losses {
+        if loss.at.After(now) {
+            pending = append(pending, loss)
+        } else {
+            lost = append(lost, loss.packet)
+        }
+    }
+    n.losses = pending
+    if len(lost) > 0 {
+        n.rat.LosePackets(lost)
+    }
+
+    // Let every sender that is on send as much as its window and intersend time allow
+    hop := linkHop{network: n}
+    for _, s := range n.senders {
+        if !s.on {
+            continue
+        }
+        for {
+            before := n.rat.PacketsSent()
+            hop.sender = s
+            if err := n.rat.Send(s.flowID, s.id, &hop, s.seq, math.MaxUint32); err != nil {
+                break
+            }
+            if n.rat.PacketsSent() == before {
+                break
+            }
+            s.seq++
+            s.stats.PacketsSent++
+        }
+    }
 }
 
-// Packet represents a network packet
-type Packet struct {
-    SeqNo int        // Sequence number
-    Sent  time.Time  // Timestamp when the packet was sent
+// Results returns the statistics of every sender
+func (n *Network) Results() []SenderStats {
+    stats := make([]SenderStats, len(n.senders))
+    for i, s := range n.senders {
+        stats[i] = s.stats
+    }
+    return stats
 }
 
-// Receiver represents a receiver that receives packets
-type Receiver struct {
-    ReceivedPackets []*Packet // Slice of received packets
+// Utility returns the sum of the utilities of the senders, which is the score Remy maximizes
+func (n *Network) Utility(delta float64) float64 {
+    total := 0.0
+    for _, s := range n.senders {
+        total += s.stats.Utility(n.config, delta)
+    }
+    return total
 }
 
-// NewReceiver is a constructor that creates a new instance of the Receiver struct
-func NewReceiver() *Receiver {
-    return &Receiver{
-        ReceivedPackets: make([]*Packet, 0),
+// linkHop is the next hop of the senders, which puts their packets on the link
+type linkHop struct {
+    network *Network
+    sender  *sender
+}
+
+// Accept enqueues a packet on the link
+func (h *linkHop) Accept(packet *rat.Packet) error {
+    queued := &Packet{
+        SeqNo:  packet.SeqNo,
+        Sender: h.sender.id,
+        FlowID: packet.FlowID,
+        Sent:   packet.Sent,
     }
+    // A dropped packet is counted and scheduled for reporting by the link's OnDrop
+    h.network.link.Enqueue(queued, h.network.clock.Now())
+    return nil
 }
 
-// ReceivePacket adds a received packet to the receiver's ReceivedPackets slice
-func (r *Receiver) ReceivePacket(packet *Packet) {
-    r.ReceivedPackets = append(r.ReceivedPackets, packet)
+// Packet represents a network packet
+type Packet struct {
+    SeqNo    int       // Sequence number
+    Sender   int       // Index of the sender
+    FlowID   uint      // Flow the packet belongs to
+    Sent     time.Time // Timestamp when the packet was sent
+    Enqueued time.Time // Timestamp when the packet entered the link's queue
+    Data     []byte    // Contents of an emulated packet, or nil in the simulator
 }
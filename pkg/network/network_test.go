@@ -0,0 +1,148 @@
+package network
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+    "math"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// failingLog is an event log that fails from the given event on
+type failingLog struct {
+    events int
+    failAt int
+}
+
+func (l *failingLog) Record(Event) error {
+    l.events++
+    if l.events >= l.failAt {
+        return errors.New("disk full")
+    }
+    return nil
+}
+
+func (l *failingLog) Flush() error {
+    return nil
+}
+
+func TestEventLogErrorsAreKept(t *testing.T) {
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 1, 1, 0.001, tree.Root.Whisker.Domain)
+    config := Config{LinkPPT: 1, RTT: 150, NumSenders: 2, MeanOn: 1000, MeanOff: 1000, Buffer: 10}
+
+    quiet := NewNetwork(tree, config, 1, false)
+    quiet.Run(10 * time.Second)
+    if err := quiet.Err(); err != nil {
+        t.Fatalf("Err() = %v without an event log", err)
+    }
+
+    logged := NewNetwork(tree, config, 1, false)
+    log := &failingLog{failAt: 10}
+    logged.SetEventLog(log)
+    logged.Run(10 * time.Second)
+    if log.events <= log.failAt {
+        t.Fatalf("the run recorded only %d events", log.events)
+    }
+    if err := logged.Err(); err == nil {
+        t.Error("Err() = nil after the event log failed")
+    }
+    // The run carries on past the failure, with the same results
+    if !reflect.DeepEqual(logged.Results(), quiet.Results()) {
+        t.Errorf("results with a failing log %v differ from %v", logged.Results(), quiet.Results())
+    }
+}
+
+// fixedWindowTree returns a tree with a single whisker that keeps the window at the given size and sends without pacing
+func fixedWindowTree(window int) *whisker.WhiskerTree {
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, window, 0, 0, tree.Root.Whisker.Domain)
+    tree.Invalidate()
+    return tree
+}
+
+// alwaysOn describes a network whose single sender switches on at once and stays on
+func alwaysOn(buffer int) Config {
+    return Config{LinkPPT: 1, RTT: 100, NumSenders: 1, MeanOn: 1e12, MeanOff: 0, Buffer: buffer}
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 1, 1, 0.001, tree.Root.Whisker.Domain)
+    config := Config{LinkPPT: 1, RTT: 150, NumSenders: 4, MeanOn: 1000, MeanOff: 1000, Buffer: 20, Loss: 0.01, Jitter: 5}
+
+    run := func(seed int64) *Network {
+        network := NewNetwork(tree, config, seed, false)
+        network.Run(20 * time.Second)
+        return network
+    }
+    first, second := run(1), run(1)
+    if u1, u2 := first.Utility(1), second.Utility(1); u1 != u2 {
+        t.Errorf("two runs with the same seed scored %g and %g", u1, u2)
+    }
+    if !reflect.DeepEqual(first.Results(), second.Results()) {
+        t.Errorf("two runs with the same seed gave %v and %v", first.Results(), second.Results())
+    }
+    if other := run(2); reflect.DeepEqual(other.Results(), first.Results()) {
+        t.Errorf("runs with different seeds both gave %v", first.Results())
+    }
+}
+
+func TestLinkRate(t *testing.T) {
+    start := time.Unix(0, 0)
+    link := NewLink(2, 0)
+    for i := 0; i < 10; i++ {
+        link.Enqueue(&Packet{SeqNo: i}, start)
+    }
+    packets, departures := link.Dequeue(start.Add(time.Second))
+    if len(packets) != 10 {
+        t.Fatalf("dequeued %d packets, want 10", len(packets))
+    }
+    // At 2 packets per millisecond, the packets leave every 500 microseconds
+    for i, departure := range departures {
+        if want := start.Add(time.Duration(i+1) * 500 * time.Microsecond); !departure.Equal(want) {
+            t.Errorf("packet %d left at %v, want %v", i, departure.Sub(start), want.Sub(start))
+        }
+    }
+
+    // A sender whose window covers the path keeps the link busy, so it gets the whole rate
+    network := NewNetwork(fixedWindowTree(200), alwaysOn(0), 1, false)
+    network.Run(10 * time.Second)
+    if throughput := network.Results()[0].Throughput(); math.Abs(throughput-1) > 0.02 {
+        t.Errorf("throughput is %g packets per millisecond, want the link's 1", throughput)
+    }
+}
+
+func TestDrops(t *testing.T) {
+    start := time.Unix(0, 0)
+    link := NewLink(1, 3)
+    var drops []Event
+    link.OnDrop = func(event Event) { drops = append(drops, event) }
+    for i := 0; i < 5; i++ {
+        if queued := link.Enqueue(&Packet{SeqNo: i}, start); queued != (i < 3) {
+            t.Errorf("Enqueue of packet %d returned %v with %d packets queued", i, queued, i)
+        }
+    }
+    if link.Dropped() != 2 || len(drops) != 2 || drops[0].Packet.SeqNo != 3 || drops[1].Reason != DropBuffer {
+        t.Errorf("got %d drops reported as %v, want packets 3 and 4 dropped for a full buffer", link.Dropped(), drops)
+    }
+
+    // A window far larger than the buffer overflows it, and every packet sent is received, dropped or still on its way
+    network := NewNetwork(fixedWindowTree(100), alwaysOn(10), 1, false)
+    network.Run(10 * time.Second)
+    stats := network.Results()[0]
+    if stats.PacketsDropped == 0 || stats.PacketsDropped != network.Link().Dropped() {
+        t.Fatalf("sender counted %d drops, link %d", stats.PacketsDropped, network.Link().Dropped())
+    }
+    inFlight := uint(network.Link().QueueLength() + len(network.delay.packets))
+    if stats.PacketsSent != stats.PacketsReceived+stats.PacketsDropped+inFlight {
+        t.Errorf("sent %d packets, but %d were received, %d dropped and %d are in flight",
+            stats.PacketsSent, stats.PacketsReceived, stats.PacketsDropped, inFlight)
+    }
+    // Drops are reported to the RAT, so they free their places in the window rather than stall the sender
+    if lost := network.RAT().PacketsLost(); lost == 0 || lost > stats.PacketsDropped {
+        t.Errorf("RAT learned of %d losses out of %d drops", lost, stats.PacketsDropped)
+    }
+}
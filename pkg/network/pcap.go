@@ -0,0 +1,255 @@
+package network
+
+import (
+    "bufio"
+    "encoding/binary"
+    "io"
+    "strings"
+)
+
+// PcapFormat is the file format of a pcap event log
+type PcapFormat int
+
+const (
+    // FormatPcap is classic pcap with nanosecond timestamps, which every tool reads
+    // It has no room for annotations, so the TTL of a data frame tells where it was seen: 64 as it entered the link,
+    // 63 as it left it, and 0 when the link dropped it.
+    FormatPcap PcapFormat = iota
+    // FormatPcapNG is pcapng, with an interface for each point of the network (link ingress, link egress and the
+    // receiver) and a comment on every frame naming its event and, for drops, the reason
+    FormatPcapNG
+)
+
+// PcapFormatFor returns the format a file name's extension implies: pcapng for .pcapng and pcap otherwise
+func PcapFormatFor(filename string) PcapFormat {
+    if strings.HasSuffix(strings.ToLower(filename), ".pcapng") {
+        return FormatPcapNG
+    }
+    return FormatPcap
+}
+
+// PcapPacketSize is the size in bytes given to every simulated data packet, whose headers alone are captured
+const PcapPacketSize = 1500
+
+// Frames are raw IPv4 carrying TCP, so that tcptrace-style tools see every on period of a sender as a connection:
+// sender i is 10.1.0.0+i+1, the receiver is 10.2.0.1, and the sender's port is taken from the flow ID. Data
+// segments carry PcapPacketSize bytes, of which only the headers are captured, with sequence numbers counted from
+// the packets' own; ACKs come back from the receiver acknowledging the segment they are for.
+const (
+    linktypeRaw     = 101 // LINKTYPE_RAW: frames start with the IP header
+    pcapHeaderBytes = 40  // Bytes of IPv4 and TCP header captured per frame
+    pcapReceiver    = 0x0a020001
+    pcapSenderBase  = 0x0a010000
+    pcapServerPort  = 5001
+    pcapClientPort  = 10000
+    pcapPayload     = PcapPacketSize - pcapHeaderBytes
+)
+
+// pcapInterfaces are the capture points of a pcapng log, in interface ID order
+var pcapInterfaces = []struct{ name, description string }{
+    {"link-ingress", "Packets arriving at the bottleneck link, enqueued or dropped"},
+    {"link-egress", "Packets leaving the bottleneck link"},
+    {"receiver", "ACKs sent by the receiver as packets arrive"},
+}
+
+// pcapLog writes events as captured frames
+type pcapLog struct {
+    w      *bufio.Writer
+    format PcapFormat
+    frame  [pcapHeaderBytes]byte
+}
+
+// NewPcapEventLog creates an EventLog that writes every event to w as a frame in a pcap or pcapng capture
+// Timestamps are simulated time, which starts at the Unix epoch.
+func NewPcapEventLog(w io.Writer, format PcapFormat) EventLog {
+    l := &pcapLog{w: bufio.NewWriter(w), format: format}
+    if format == FormatPcapNG {
+        l.writeSectionHeader()
+    } else {
+        l.writeFileHeader()
+    }
+    return l
+}
+
+// Record writes a single event as a frame
+func (l *pcapLog) Record(event Event) error {
+    l.build(event)
+    if l.format == FormatPcapNG {
+        return l.writeEnhancedPacket(event)
+    }
+    ns := event.Time.UnixNano()
+    var header [16]byte
+    binary.LittleEndian.PutUint32(header[0:], uint32(ns/1e9))
+    binary.LittleEndian.PutUint32(header[4:], uint32(ns%1e9))
+    binary.LittleEndian.PutUint32(header[8:], pcapHeaderBytes)
+    binary.LittleEndian.PutUint32(header[12:], uint32(l.length(event)))
+    l.w.Write(header[:])
+    _, err := l.w.Write(l.frame[:])
+    return err
+}
+
+// Flush flushes any buffered frames to the underlying writer
+func (l *pcapLog) Flush() error {
+    return l.w.Flush()
+}
+
+// writeFileHeader writes the header of a classic pcap file with nanosecond timestamps
+func (l *pcapLog) writeFileHeader() {
+    var header [24]byte
+    binary.LittleEndian.PutUint32(header[0:], 0xa1b23c4d)
+    binary.LittleEndian.PutUint16(header[4:], 2)
+    binary.LittleEndian.PutUint16(header[6:], 4)
+    binary.LittleEndian.PutUint32(header[16:], 65535)
+    binary.LittleEndian.PutUint32(header[20:], linktypeRaw)
+    l.w.Write(header[:])
+}
+
+// writeSectionHeader writes the section header block of a pcapng file and an interface description block for
+// every capture point
+func (l *pcapLog) writeSectionHeader() {
+    body := make([]byte, 16)
+    binary.LittleEndian.PutUint32(body[0:], 0x1a2b3c4d)
+    binary.LittleEndian.PutUint16(body[4:], 1)
+    binary.LittleEndian.PutUint16(body[6:], 0)
+    binary.LittleEndian.PutUint64(body[8:], ^uint64(0)) // Section length not given
+    body = appendOption(body, 4, []byte("remy-go simulator")) // shb_userappl
+    body = appendOption(body, 0, nil)
+    l.writeBlock(0x0a0d0d0a, body)
+
+    for _, iface := range pcapInterfaces {
+        body := make([]byte, 8)
+        binary.LittleEndian.PutUint16(body[0:], linktypeRaw)
+        binary.LittleEndian.PutUint32(body[4:], 65535)
+        body = appendOption(body, 2, []byte(iface.name))        // if_name
+        body = appendOption(body, 3, []byte(iface.description)) // if_description
+        body = appendOption(body, 9, []byte{9})                 // if_tsresol: nanoseconds
+        body = appendOption(body, 0, nil)
+        l.writeBlock(1, body)
+    }
+}
+
+// writeEnhancedPacket writes the frame of an event as a pcapng enhanced packet block
+func (l *pcapLog) writeEnhancedPacket(event Event) error {
+    iface := 0
+    direction := uint32(2) // Outbound, from the sender
+    switch event.Kind {
+    case EventDequeue:
+        iface = 1
+    case EventAck:
+        iface = 2
+        direction = 1 // Inbound, back to the sender
+    }
+    comment := event.Kind.String()
+    if event.Kind == EventDrop {
+        comment += " (" + event.Reason.String() + ")"
+    }
+
+    ns := uint64(event.Time.UnixNano())
+    body := make([]byte, 20, 20+pcapHeaderBytes+64)
+    binary.LittleEndian.PutUint32(body[0:], uint32(iface))
+    binary.LittleEndian.PutUint32(body[4:], uint32(ns>>32))
+    binary.LittleEndian.PutUint32(body[8:], uint32(ns))
+    binary.LittleEndian.PutUint32(body[12:], pcapHeaderBytes)
+    binary.LittleEndian.PutUint32(body[16:], uint32(l.length(event)))
+    body = append(body, l.frame[:]...)
+    var flags [4]byte
+    binary.LittleEndian.PutUint32(flags[:], direction)
+    body = appendOption(body, 1, []byte(comment)) // opt_comment
+    body = appendOption(body, 2, flags[:])        // epb_flags
+    body = appendOption(body, 0, nil)
+    return l.writeBlock(6, body)
+}
+
+// writeBlock writes a pcapng block, whose body is already padded to 32 bits
+func (l *pcapLog) writeBlock(blockType uint32, body []byte) error {
+    var header [8]byte
+    total := uint32(len(body) + 12)
+    binary.LittleEndian.PutUint32(header[0:], blockType)
+    binary.LittleEndian.PutUint32(header[4:], total)
+    l.w.Write(header[:])
+    l.w.Write(body)
+    _, err := l.w.Write(header[4:])
+    return err
+}
+
+// appendOption appends a pcapng option, padded to 32 bits; code 0 ends the options
+func appendOption(b []byte, code uint16, value []byte) []byte {
+    b = binary.LittleEndian.AppendUint16(b, code)
+    b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+    b = append(b, value...)
+    for len(b)%4 != 0 {
+        b = append(b, 0)
+    }
+    return b
+}
+
+// length returns the length on the wire of an event's frame
+func (l *pcapLog) length(event Event) int {
+    if event.Kind == EventAck {
+        return pcapHeaderBytes
+    }
+    return PcapPacketSize
+}
+
+// build fills in the IPv4 and TCP headers of an event's frame
+func (l *pcapLog) build(event Event) {
+    packet := event.Packet
+    f := l.frame[:]
+    for i := range f {
+        f[i] = 0
+    }
+
+    sender := uint32(pcapSenderBase + packet.Sender + 1)
+    port := uint16(pcapClientPort + packet.FlowID%50000)
+    seq := uint32(packet.SeqNo)*pcapPayload + 1
+    src, dst, srcPort, dstPort := sender, uint32(pcapReceiver), port, uint16(pcapServerPort)
+    ttl := byte(64)
+    tcpFlags := byte(0x18) // PSH and ACK
+    switch event.Kind {
+    case EventDequeue:
+        ttl = 63
+    case EventDrop:
+        ttl = 0
+    case EventAck:
+        src, dst, srcPort, dstPort = dst, src, dstPort, srcPort
+        tcpFlags = 0x10 // ACK
+    }
+
+    // IPv4
+    f[0] = 0x45
+    binary.BigEndian.PutUint16(f[2:], uint16(l.length(event)))
+    binary.BigEndian.PutUint16(f[4:], uint16(packet.SeqNo))
+    f[6] = 0x40 // Don't fragment
+    f[8] = ttl
+    f[9] = 6 // TCP
+    binary.BigEndian.PutUint32(f[12:], src)
+    binary.BigEndian.PutUint32(f[16:], dst)
+    binary.BigEndian.PutUint16(f[10:], ipChecksum(f[:20]))
+
+    // TCP, whose checksum is left out since the payload is not captured
+    t := f[20:]
+    binary.BigEndian.PutUint16(t[0:], srcPort)
+    binary.BigEndian.PutUint16(t[2:], dstPort)
+    if event.Kind == EventAck {
+        binary.BigEndian.PutUint32(t[4:], 1)
+        binary.BigEndian.PutUint32(t[8:], seq+pcapPayload)
+    } else {
+        binary.BigEndian.PutUint32(t[4:], seq)
+        binary.BigEndian.PutUint32(t[8:], 1)
+    }
+    t[12] = 5 << 4
+    t[13] = tcpFlags
+    binary.BigEndian.PutUint16(t[14:], 65535)
+}
+
+// ipChecksum returns the Internet checksum of an IPv4 header whose checksum field is zero
+func ipChecksum(header []byte) uint16 {
+    var sum uint32
+    for i := 0; i < len(header); i += 2 {
+        sum += uint32(binary.BigEndian.Uint16(header[i:]))
+    }
+    for sum > 0xffff {
+        sum = sum&0xffff + sum>>16
+    }
+    return ^uint16(sum)
+}
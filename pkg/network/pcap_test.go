@@ -0,0 +1,170 @@
+package network
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+    "time"
+)
+
+// pcapEvents are one event of each kind for packet 2 of the second sender's flow 7, 1.5 seconds in
+func pcapEvents() []Event {
+    at := time.Unix(1, 500000000)
+    packet := &Packet{SeqNo: 2, Sender: 1, FlowID: 7, Sent: at}
+    return []Event{
+        {Kind: EventEnqueue, Time: at, Packet: packet},
+        {Kind: EventDrop, Time: at, Packet: packet, Reason: DropBuffer},
+        {Kind: EventDequeue, Time: at, Packet: packet},
+        {Kind: EventAck, Time: at, Packet: packet},
+    }
+}
+
+// writePcap writes events to a capture in the given format and returns its bytes
+func writePcap(t *testing.T, format PcapFormat, events []Event) []byte {
+    var buf bytes.Buffer
+    log := NewPcapEventLog(&buf, format)
+    for _, event := range events {
+        if err := log.Record(event); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := log.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestPcapFileHeaderAndRecord(t *testing.T) {
+    b := writePcap(t, FormatPcap, pcapEvents()[:1])
+    if len(b) != 24+16+pcapHeaderBytes {
+        t.Fatalf("capture is %d bytes, want a file header and one record", len(b))
+    }
+    le := binary.LittleEndian
+    if magic := le.Uint32(b[0:]); magic != 0xa1b23c4d {
+        t.Errorf("magic is %#x, want the nanosecond pcap magic", magic)
+    }
+    if major, minor := le.Uint16(b[4:]), le.Uint16(b[6:]); major != 2 || minor != 4 {
+        t.Errorf("version is %d.%d, want 2.4", major, minor)
+    }
+    if snaplen, linktype := le.Uint32(b[16:]), le.Uint32(b[20:]); snaplen != 65535 || linktype != linktypeRaw {
+        t.Errorf("snaplen %d and link type %d, want 65535 and %d", snaplen, linktype, linktypeRaw)
+    }
+
+    record := b[24:]
+    if sec, nsec := le.Uint32(record[0:]), le.Uint32(record[4:]); sec != 1 || nsec != 500000000 {
+        t.Errorf("timestamp is %d.%09d, want 1.500000000", sec, nsec)
+    }
+    if captured, length := le.Uint32(record[8:]), le.Uint32(record[12:]); captured != pcapHeaderBytes || length != PcapPacketSize {
+        t.Errorf("captured %d of %d bytes, want %d of %d", captured, length, pcapHeaderBytes, PcapPacketSize)
+    }
+
+    be := binary.BigEndian
+    frame := record[16:]
+    if frame[0] != 0x45 || be.Uint16(frame[2:]) != PcapPacketSize || frame[8] != 64 || frame[9] != 6 {
+        t.Errorf("IPv4 header % x does not describe a 1500 byte TCP packet with a TTL of 64", frame[:20])
+    }
+    if src, dst := be.Uint32(frame[12:]), be.Uint32(frame[16:]); src != 0x0a010002 || dst != pcapReceiver {
+        t.Errorf("packet goes from %#x to %#x, want 10.1.0.2 to 10.2.0.1", src, dst)
+    }
+    tcp := frame[20:]
+    if srcPort, dstPort := be.Uint16(tcp[0:]), be.Uint16(tcp[2:]); srcPort != pcapClientPort+7 || dstPort != pcapServerPort {
+        t.Errorf("ports are %d and %d, want %d and %d", srcPort, dstPort, pcapClientPort+7, pcapServerPort)
+    }
+    if seq := be.Uint32(tcp[4:]); seq != 2*pcapPayload+1 {
+        t.Errorf("sequence number is %d, want %d", seq, 2*pcapPayload+1)
+    }
+}
+
+func TestPcapNGBlocks(t *testing.T) {
+    b := writePcap(t, FormatPcapNG, pcapEvents())
+    le := binary.LittleEndian
+
+    var types []uint32
+    var packets [][]byte
+    for len(b) > 0 {
+        if len(b) < 12 {
+            t.Fatalf("%d bytes left over after the last block", len(b))
+        }
+        blockType, length := le.Uint32(b[0:]), le.Uint32(b[4:])
+        if length%4 != 0 || length < 12 || int(length) > len(b) {
+            t.Fatalf("block %#x has a length of %d with %d bytes left", blockType, length, len(b))
+        }
+        if trailer := le.Uint32(b[length-4:]); trailer != length {
+            t.Fatalf("block %#x has a length of %d but a trailer of %d", blockType, length, trailer)
+        }
+        body := b[8 : length-4]
+        switch blockType {
+        case 0x0a0d0d0a:
+            if magic := le.Uint32(body[0:]); magic != 0x1a2b3c4d {
+                t.Errorf("byte-order magic is %#x", magic)
+            }
+        case 1:
+            if linktype := le.Uint16(body[0:]); linktype != linktypeRaw {
+                t.Errorf("interface has link type %d, want %d", linktype, linktypeRaw)
+            }
+        case 6:
+            packets = append(packets, body)
+        }
+        types = append(types, blockType)
+        b = b[length:]
+    }
+    want := []uint32{0x0a0d0d0a, 1, 1, 1, 6, 6, 6, 6}
+    if len(types) != len(want) {
+        t.Fatalf("got blocks %x, want %x", types, want)
+    }
+    for i := range want {
+        if types[i] != want[i] {
+            t.Fatalf("got blocks %x, want %x", types, want)
+        }
+    }
+
+    // Enqueues and drops are seen at the link's ingress, dequeues at its egress and ACKs at the receiver
+    for i, want := range []struct {
+        iface   uint32
+        length  uint32
+        ttl     byte
+        comment string
+    }{
+        {0, PcapPacketSize, 64, "enqueue"},
+        {0, PcapPacketSize, 0, "drop (buffer)"},
+        {1, PcapPacketSize, 63, "dequeue"},
+        {2, pcapHeaderBytes, 64, "ack"},
+    } {
+        body := packets[i]
+        if iface := le.Uint32(body[0:]); iface != want.iface {
+            t.Errorf("packet %d is on interface %d, want %d", i, iface, want.iface)
+        }
+        if ns := uint64(le.Uint32(body[4:]))<<32 | uint64(le.Uint32(body[8:])); ns != 1500000000 {
+            t.Errorf("packet %d has a timestamp of %d ns", i, ns)
+        }
+        if captured, length := le.Uint32(body[12:]), le.Uint32(body[16:]); captured != pcapHeaderBytes || length != want.length {
+            t.Errorf("packet %d captured %d of %d bytes, want %d of %d", i, captured, length, pcapHeaderBytes, want.length)
+        }
+        if ttl := body[20+8]; ttl != want.ttl {
+            t.Errorf("packet %d has a TTL of %d, want %d", i, ttl, want.ttl)
+        }
+        options := body[20+pcapHeaderBytes:]
+        code, n := le.Uint16(options[0:]), le.Uint16(options[2:])
+        if code != 1 || string(options[4:4+n]) != want.comment {
+            t.Errorf("packet %d has option %d %q, want the comment %q", i, code, options[4:4+n], want.comment)
+        }
+    }
+}
+
+func TestIPv4Checksum(t *testing.T) {
+    // A UDP packet's header whose checksum is known to be 0xb861
+    header := []byte{0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7}
+    if sum := ipChecksum(header); sum != 0xb861 {
+        t.Errorf("checksum is %#04x, want 0xb861", sum)
+    }
+
+    // Summing a header together with its checksum gives zero, for every frame written
+    b := writePcap(t, FormatPcap, pcapEvents())[24:]
+    for i := 0; len(b) > 0; i++ {
+        frame := b[16 : 16+pcapHeaderBytes]
+        if sum := ipChecksum(frame[:20]); sum != 0 {
+            t.Errorf("header of frame %d, % x, does not check out", i, frame[:20])
+        }
+        b = b[16+pcapHeaderBytes:]
+    }
+}
@@ -0,0 +1,58 @@
+package network
+
+import (
+    "fmt"
+    "math"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// QuantizationReport compares the score of a tree with the score of its fixed-point quantization
+// Only the tree is quantized: both scores come from signals computed in floating point, as in training.
+type QuantizationReport struct {
+    Quantize       *whisker.QuantizeResult // How much the tree changed when it was quantized
+    FloatScore     float64                 // Score of the tree as trained
+    QuantizedScore float64                 // Score of the quantized tree
+}
+
+// ScoreLoss returns how much the score dropped through quantization
+func (r *QuantizationReport) ScoreLoss() float64 {
+    return r.FloatScore - r.QuantizedScore
+}
+
+// RelativeLoss returns the score loss as a fraction of the magnitude of the float score
+func (r *QuantizationReport) RelativeLoss() float64 {
+    if r.FloatScore == 0 {
+        if r.ScoreLoss() == 0 {
+            return 0
+        }
+        return math.Inf(1)
+    }
+    return r.ScoreLoss() / math.Abs(r.FloatScore)
+}
+
+// String returns a string representation of the quantization report
+func (r *QuantizationReport) String() string {
+    return fmt.Sprintf("score %g -> %g, loss %g (%.4g%%), tree quantized, signals in floating point\n%v",
+        r.FloatScore, r.QuantizedScore, r.ScoreLoss(), 100*r.RelativeLoss(), r.Quantize)
+}
+
+// EvaluateQuantized quantizes the tree and scores both it and the quantized tree on the evaluator's networks
+// Both runs use the same seeds, so the score loss is due to quantization alone. Only the tree's bounds and actions
+// are quantized: the memory signals are still updated in floating point, so the loss does not include the error of
+// a controller that also computes its moving averages in fixed point, which drifts further from the float values.
+func (e *Evaluator) EvaluateQuantized(tree *whisker.WhiskerTree, opts whisker.QuantizeOptions) (*whisker.WhiskerTree, *QuantizationReport, error) {
+    quantized, result, err := whisker.Quantize(tree, opts)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    report := &QuantizationReport{Quantize: result}
+    if report.FloatScore, err = e.Evaluate(tree); err != nil {
+        return nil, nil, err
+    }
+    if report.QuantizedScore, err = e.Evaluate(quantized); err != nil {
+        return nil, nil, err
+    }
+    return quantized, report, nil
+}
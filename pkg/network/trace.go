@@ -0,0 +1,90 @@
+package network
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Trace is a Mahimahi packet delivery trace
+// Every line of a trace file is a time in milliseconds at which the link may deliver one packet; the trace repeats
+// with a period of its last time, so a link that follows it has the varying rate of the recorded network
+type Trace struct {
+    Opportunities []time.Duration // Delivery opportunities within a period, in order
+    Period        time.Duration   // Length of the trace, after which it starts over
+}
+
+// ParseTrace reads a trace in Mahimahi's format
+func ParseTrace(r io.Reader) (*Trace, error) {
+    trace := &Trace{}
+    scanner := bufio.NewScanner(r)
+    line := 0
+    for scanner.Scan() {
+        line++
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" {
+            continue
+        }
+        ms, err := strconv.ParseUint(text, 10, 63)
+        if err != nil {
+            return nil, fmt.Errorf("trace line %d: %v", line, err)
+        }
+        opportunity := time.Duration(ms) * time.Millisecond
+        if n := len(trace.Opportunities); n > 0 && opportunity < trace.Opportunities[n-1] {
+            return nil, fmt.Errorf("trace line %d: %d ms comes before the previous line", line, ms)
+        }
+        trace.Opportunities = append(trace.Opportunities, opportunity)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(trace.Opportunities) == 0 {
+        return nil, errors.New("trace has no delivery opportunities")
+    }
+    trace.Period = trace.Opportunities[len(trace.Opportunities)-1]
+    if trace.Period <= 0 {
+        return nil, errors.New("trace must end after 0 ms")
+    }
+    return trace, nil
+}
+
+// LoadTrace reads a trace file in Mahimahi's format
+func LoadTrace(filename string) (*Trace, error) {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return ParseTrace(f)
+}
+
+// Rate returns the average rate of the trace in packets per millisecond
+func (t *Trace) Rate() float64 {
+    return float64(len(t.Opportunities)) / (float64(t.Period) / float64(time.Millisecond))
+}
+
+// opportunity returns the time of the k-th delivery opportunity after the trace started at origin
+func (t *Trace) opportunity(origin time.Time, k int) time.Time {
+    n := len(t.Opportunities)
+    return origin.Add(time.Duration(k/n)*t.Period + t.Opportunities[k%n])
+}
+
+// next returns the first opportunity from the k-th on that is not before the given time, and its index
+func (t *Trace) next(origin time.Time, k int, after time.Time) (time.Time, int) {
+    // Skip whole periods at once when the link has been idle for a long time
+    if behind := after.Sub(t.opportunity(origin, k)); behind > t.Period {
+        k += int(behind/t.Period-1) * len(t.Opportunities)
+    }
+    for {
+        at := t.opportunity(origin, k)
+        if !at.Before(after) {
+            return at, k
+        }
+        k++
+    }
+}
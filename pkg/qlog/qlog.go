@@ -0,0 +1,293 @@
+package qlog
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/dna"
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/network"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Version is the qlog schema version the logs declare
+const Version = "0.3"
+
+// recordSeparator starts every record of a JSON-SEQ file (RFC 7464)
+const recordSeparator = 0x1e
+
+// Options describe the trace a Logger writes
+type Options struct {
+    Title         string               // Title of the trace
+    VantagePoint  string               // Where the events were seen from, such as client, server or network
+    ReferenceTime time.Time            // Time that event times are relative to
+    PacketSize    int                  // Bytes per packet, which converts windows in packets to the bytes qlog expects
+    Whiskers      *whisker.WhiskerTree // Tree whose whisker indices window updates carry, or nil
+}
+
+// Logger writes events in a qlog-like schema, as a JSON-SEQ stream that qlog tools read
+// Every flow is a group of its own: the group_id of an event names the sender or connection it belongs to, so that
+// visualizers can render each flow's window and round-trip time over time.
+type Logger struct {
+    mu      sync.Mutex
+    w       *bufio.Writer
+    options Options
+    index   map[*whisker.Whisker]int
+    groups  map[uint]string          // Group of every flow ID seen
+    minRTT  map[string]time.Duration // Smallest round-trip time of every group
+    err     error                    // First event that could not be encoded, returned by Flush
+}
+
+// event is a qlog event record
+type event struct {
+    Time    float64        `json:"time"` // Milliseconds since the reference time
+    Name    string         `json:"name"`
+    GroupID string         `json:"group_id,omitempty"`
+    Data    map[string]any `json:"data"`
+}
+
+// NewLogger creates a Logger writing to w and writes the header of its trace
+func NewLogger(w io.Writer, options Options) *Logger {
+    if options.VantagePoint == "" {
+        options.VantagePoint = "unknown"
+    }
+    l := &Logger{
+        w:       bufio.NewWriter(w),
+        options: options,
+        groups:  make(map[uint]string),
+        minRTT:  make(map[string]time.Duration),
+    }
+    if options.Whiskers != nil {
+        l.index = make(map[*whisker.Whisker]int)
+        for i, w := range options.Whiskers.Whiskers() {
+            l.index[w] = i
+        }
+    }
+    l.write(map[string]any{
+        "qlog_version": Version,
+        "qlog_format":  "JSON-SEQ",
+        "title":        options.Title,
+        "trace": map[string]any{
+            "vantage_point": map[string]any{"type": options.VantagePoint},
+            "common_fields": map[string]any{
+                "time_format":    "relative",
+                "reference_time": float64(options.ReferenceTime.UnixNano()) / 1e6,
+            },
+        },
+    })
+    return l
+}
+
+// SetGroup names the group of a flow's window updates, which otherwise go to "flow-<ID>"
+func (l *Logger) SetGroup(flowID uint, group string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.groups[flowID] = group
+}
+
+// PacketSent logs a packet, or a retransmission of one, sent by a flow
+func (l *Logger) PacketSent(t time.Time, group string, packetNumber int64, length int, retransmission bool) {
+    data := map[string]any{
+        "header": map[string]any{"packet_number": packetNumber},
+        "raw":    map[string]any{"length": length},
+    }
+    if retransmission {
+        data["trigger"] = "retransmission"
+    }
+    l.event(t, "transport:packet_sent", group, data)
+}
+
+// PacketAcked logs an acknowledged packet and the round-trip time it measured
+func (l *Logger) PacketAcked(t time.Time, group string, packetNumber int64, rtt time.Duration) {
+    l.event(t, "recovery:packet_acked", group, map[string]any{
+        "header":     map[string]any{"packet_number": packetNumber},
+        "latest_rtt": milliseconds(rtt),
+    })
+
+    l.mu.Lock()
+    minRTT, ok := l.minRTT[group]
+    if !ok || rtt < minRTT {
+        minRTT = rtt
+        l.minRTT[group] = rtt
+    }
+    l.mu.Unlock()
+    l.event(t, "recovery:metrics_updated", group, map[string]any{
+        "latest_rtt": milliseconds(rtt),
+        "min_rtt":    milliseconds(minRTT),
+    })
+}
+
+// PacketLost logs a packet declared lost, with what declared it
+func (l *Logger) PacketLost(t time.Time, group string, packetNumber int64, trigger string) {
+    l.event(t, "recovery:packet_lost", group, map[string]any{
+        "header":  map[string]any{"packet_number": packetNumber},
+        "trigger": trigger,
+    })
+}
+
+// CongestionWindowUpdated logs a whisker lookup that set a flow's window, in packets, and intersend time
+// The whisker is its index in the tree's order, or -1 if it is unknown.
+func (l *Logger) CongestionWindowUpdated(t time.Time, group string, oldWindow, newWindow uint, whiskerIndex int, intersend float64) {
+    l.event(t, "recovery:congestion_window_updated", group, map[string]any{
+        "old":          oldWindow,
+        "new":          newWindow,
+        "whisker":      whiskerIndex,
+        "intersend_ms": intersend * 1000,
+    })
+    metrics := map[string]any{"congestion_window": newWindow}
+    if l.options.PacketSize > 0 {
+        metrics["congestion_window"] = newWindow * uint(l.options.PacketSize)
+    }
+    if intersend > 0 && l.options.PacketSize > 0 {
+        metrics["pacing_rate"] = float64(l.options.PacketSize*8) / intersend
+    }
+    l.event(t, "recovery:metrics_updated", group, metrics)
+}
+
+// QueueLengthUpdated logs the length of a queue in packets
+func (l *Logger) QueueLengthUpdated(t time.Time, group string, length int) {
+    l.event(t, "network:queue_length_updated", group, map[string]any{"length": length})
+}
+
+// Flush flushes any buffered events to the underlying writer
+// Once the writer succeeds, it returns the error of the first event that could not be encoded and was skipped, if any
+func (l *Logger) Flush() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if err := l.w.Flush(); err != nil {
+        return err
+    }
+    return l.err
+}
+
+// group returns the group of a flow
+func (l *Logger) group(flowID uint) string {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if group, ok := l.groups[flowID]; ok {
+        return group
+    }
+    return fmt.Sprintf("flow-%d", flowID)
+}
+
+// event writes an event record
+func (l *Logger) event(t time.Time, name, group string, data map[string]any) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.write(event{
+        Time:    milliseconds(t.Sub(l.options.ReferenceTime)),
+        Name:    name,
+        GroupID: group,
+        Data:    data,
+    })
+}
+
+// write writes a record of the JSON-SEQ stream
+func (l *Logger) write(record any) {
+    data, err := json.Marshal(record)
+    if err != nil {
+        if l.err == nil {
+            l.err = fmt.Errorf("failed to encode qlog event: %w", err)
+        }
+        return
+    }
+    l.w.WriteByte(recordSeparator)
+    l.w.Write(data)
+    l.w.WriteByte('\n')
+}
+
+// milliseconds converts a duration to qlog's unit of time
+func milliseconds(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
+// Decisions returns a log of a RAT's whisker lookups, for RAT.SetDecisionLog, that logs every one that set a window
+// under the group of its flow
+func (l *Logger) Decisions() DecisionLog {
+    return DecisionLog{l: l}
+}
+
+// DecisionsFor returns a log of the whisker lookups of a RAT with a single flow, logged under the given group
+// It tells apart RATs of their own, such as those of separate connections, whose flows share the same IDs.
+func (l *Logger) DecisionsFor(group string) DecisionLog {
+    return DecisionLog{l: l, group: group}
+}
+
+// DecisionLog adapts a Logger to the decision log of a RAT
+type DecisionLog struct {
+    l     *Logger
+    group string // Group of every lookup, or empty for the group of its flow
+}
+
+// Record logs a decision as a window update; lookups that found no whisker changed nothing and are skipped
+func (d DecisionLog) Record(decision *dna.Decision) error {
+    if decision.Error != "" {
+        return nil
+    }
+    whiskerIndex := -1
+    if d.l.options.Whiskers != nil && decision.Memory != nil {
+        if w, err := d.l.options.Whiskers.FindWhisker(memory.FromDNAMemory(decision.Memory)); err == nil {
+            if i, ok := d.l.index[w]; ok {
+                whiskerIndex = i
+            }
+        }
+    }
+    group := d.group
+    if group == "" {
+        group = d.l.group(uint(decision.FlowId))
+    }
+    d.l.CongestionWindowUpdated(time.Unix(0, decision.TimeNs), group,
+        uint(decision.OldWindow), uint(decision.NewWindow), whiskerIndex, decision.Intersend)
+    return nil
+}
+
+// Flush flushes the logger
+func (d DecisionLog) Flush() error {
+    return d.l.Flush()
+}
+
+// Events returns a log of a simulated network's events, for Network.SetEventLog
+// Each sender is a group, named sender-<index>. Packets the link takes in are sent, drops are losses and ACKs are
+// acknowledgments, and the link's queue length is logged under the group link.
+func (l *Logger) Events() EventLog {
+    return EventLog{l}
+}
+
+// EventLog adapts a Logger to the event log of a simulated network
+type EventLog struct {
+    l *Logger
+}
+
+// Record logs a network event
+func (e EventLog) Record(ev network.Event) error {
+    packet := ev.Packet
+    group := fmt.Sprintf("sender-%d", packet.Sender)
+    e.l.SetGroup(packet.FlowID, group)
+
+    switch ev.Kind {
+    case network.EventEnqueue:
+        e.l.PacketSent(ev.Time, group, int64(packet.SeqNo), e.l.options.PacketSize, false)
+        e.l.QueueLengthUpdated(ev.Time, "link", ev.QueueLength)
+    case network.EventDrop:
+        // A packet dropped on arrival never made it into the queue, so it was not logged as sent yet
+        if packet.Enqueued.IsZero() {
+            e.l.PacketSent(ev.Time, group, int64(packet.SeqNo), e.l.options.PacketSize, false)
+        }
+        e.l.PacketLost(ev.Time, group, int64(packet.SeqNo), ev.Reason.String())
+        e.l.QueueLengthUpdated(ev.Time, "link", ev.QueueLength)
+    case network.EventDequeue:
+        e.l.QueueLengthUpdated(ev.Time, "link", ev.QueueLength)
+    case network.EventAck:
+        e.l.PacketAcked(ev.Time, group, int64(packet.SeqNo), ev.Time.Sub(packet.Sent))
+    }
+    return nil
+}
+
+// Flush flushes the logger
+func (e EventLog) Flush() error {
+    return e.l.Flush()
+}
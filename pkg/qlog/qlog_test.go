@@ -0,0 +1,143 @@
+package qlog
+
+import (
+    "bytes"
+    "encoding/json"
+    "math"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/network"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+func TestFlushReportsUnencodableEvents(t *testing.T) {
+    var buf bytes.Buffer
+    start := time.Unix(1000, 0)
+    l := NewLogger(&buf, Options{ReferenceTime: start})
+    l.QueueLengthUpdated(start, "link", 3)
+    if err := l.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    // JSON has no NaN, so the window update is skipped and the error kept for Flush; the metrics update that
+    // follows it has no intersend time and is still written
+    l.CongestionWindowUpdated(start, "flow-1", 1, 2, 0, math.NaN())
+    l.QueueLengthUpdated(start.Add(time.Millisecond), "link", 4)
+    if err := l.Flush(); err == nil {
+        t.Error("Flush() = nil after an event failed to encode")
+    }
+    if records := strings.Count(buf.String(), "\x1e"); records != 4 {
+        t.Errorf("wrote %d records, want the header, two queue lengths and the metrics: %q", records, buf.String())
+    }
+}
+
+// decode splits a JSON-SEQ stream into its records
+func decode(t *testing.T, b []byte) []map[string]any {
+    t.Helper()
+    if len(b) == 0 || b[0] != recordSeparator {
+        t.Fatalf("stream does not start with a record separator: %q", b)
+    }
+    var records []map[string]any
+    for _, data := range bytes.Split(b[1:], []byte{recordSeparator}) {
+        var record map[string]any
+        if err := json.Unmarshal(data, &record); err != nil {
+            t.Fatalf("failed to decode record %q: %v", data, err)
+        }
+        records = append(records, record)
+    }
+    return records
+}
+
+func TestSimulationTrace(t *testing.T) {
+    // Senders sending slowly and fast look up whiskers 0 and 1, and the small buffer drops some of their packets
+    tree := whisker.NewWhiskerTree()
+    domain := tree.Root.Whisker.Domain
+    upper, lower := domain.Upper.Clone(), domain.Lower.Clone()
+    upper.Set(memory.SendRate, 10)
+    lower.Set(memory.SendRate, 10)
+    tree.Root.Children = []*whisker.WhiskerNode{
+        {Whisker: whisker.NewWhisker(1, 1, 1, 0.001, memory.NewMemoryRange(domain.Lower, upper))},
+        {Whisker: whisker.NewWhisker(1, 2, 1, 0.001, memory.NewMemoryRange(lower, domain.Upper))},
+    }
+    tree.Invalidate()
+    config := network.Config{LinkPPT: 1, RTT: 150, NumSenders: 2, MeanOn: 1000, MeanOff: 1000, Buffer: 5}
+    sim := network.NewNetwork(tree, config, 1, false)
+
+    var buf bytes.Buffer
+    logger := NewLogger(&buf, Options{
+        Title:         "test",
+        VantagePoint:  "network",
+        ReferenceTime: sim.Now(),
+        PacketSize:    network.PcapPacketSize,
+        Whiskers:      tree,
+    })
+    sim.SetEventLog(logger.Events())
+    sim.RAT().SetDecisionLog(logger.Decisions())
+    sim.Run(10 * time.Second)
+    if err := sim.Err(); err != nil {
+        t.Fatal(err)
+    }
+    if err := logger.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    records := decode(t, buf.Bytes())
+    header := records[0]
+    trace, _ := header["trace"].(map[string]any)
+    vantage, _ := trace["vantage_point"].(map[string]any)
+    if header["qlog_version"] != Version || header["qlog_format"] != "JSON-SEQ" || header["title"] != "test" || vantage["type"] != "network" {
+        t.Errorf("header is %v", header)
+    }
+
+    counts := make(map[string]int)
+    whiskers := make(map[float64]bool)
+    last := -1.0
+    for _, record := range records[1:] {
+        name, _ := record["name"].(string)
+        group, _ := record["group_id"].(string)
+        data, _ := record["data"].(map[string]any)
+        counts[name]++
+        if at, _ := record["time"].(float64); at < last || at > 10000 {
+            t.Fatalf("%s at %g ms follows an event at %g ms in a 10 s run", name, at, last)
+        } else {
+            last = at
+        }
+        switch name {
+        case "transport:packet_sent", "recovery:packet_acked", "recovery:packet_lost", "recovery:congestion_window_updated":
+            if group != "sender-0" && group != "sender-1" {
+                t.Errorf("%s is in group %q, want a sender's", name, group)
+            }
+        case "network:queue_length_updated":
+            if length, _ := data["length"].(float64); group != "link" || length < 0 || length > 5 {
+                t.Errorf("queue length %v in group %q, want 0 to 5 in the link's", data["length"], group)
+            }
+        }
+        switch name {
+        case "recovery:packet_lost":
+            if data["trigger"] != "buffer" {
+                t.Errorf("packet lost for %v, want the full buffer", data["trigger"])
+            }
+        case "recovery:congestion_window_updated":
+            index, _ := data["whisker"].(float64)
+            whiskers[index] = true
+            if increment := data["new"].(float64) - data["old"].(float64); increment != 1+index {
+                t.Errorf("whisker %g grew the window from %v to %v", index, data["old"], data["new"])
+            }
+        }
+    }
+    for _, name := range []string{"transport:packet_sent", "recovery:packet_acked", "recovery:packet_lost",
+        "recovery:congestion_window_updated", "recovery:metrics_updated", "network:queue_length_updated"} {
+        if counts[name] == 0 {
+            t.Errorf("no %s events in %v", name, counts)
+        }
+    }
+    if counts["recovery:packet_acked"] > counts["transport:packet_sent"] {
+        t.Errorf("more packets acknowledged than sent: %v", counts)
+    }
+    if len(whiskers) != 2 || !whiskers[0] || !whiskers[1] {
+        t.Errorf("window updates name whiskers %v, want 0 and 1", whiskers)
+    }
+}
@@ -0,0 +1,83 @@
+package rat
+
+import (
+    "bufio"
+    "io"
+
+    "google.golang.org/protobuf/encoding/protodelim"
+    "google.golang.org/protobuf/encoding/protojson"
+
+    "github.com/Aanthord/remy-go/pkg/dna"
+)
+
+// DecisionLog receives a record of every whisker lookup made by a RAT. A lookup follows a batch of
+// acknowledgments of a flow, so there is one record per flow per batch rather than one per ACK
+type DecisionLog interface {
+    Record(decision *dna.Decision) error
+    Flush() error
+}
+
+// jsonDecisionLog writes decisions as JSON lines
+type jsonDecisionLog struct {
+    w *bufio.Writer
+}
+
+// NewJSONDecisionLog creates a DecisionLog that writes one JSON object per line to w
+func NewJSONDecisionLog(w io.Writer) DecisionLog {
+    return &jsonDecisionLog{w: bufio.NewWriter(w)}
+}
+
+// Record writes a single decision as a JSON line
+func (l *jsonDecisionLog) Record(decision *dna.Decision) error {
+    data, err := protojson.Marshal(decision)
+    if err != nil {
+        return err
+    }
+    if _, err := l.w.Write(data); err != nil {
+        return err
+    }
+    return l.w.WriteByte('\n')
+}
+
+// Flush flushes any buffered records to the underlying writer
+func (l *jsonDecisionLog) Flush() error {
+    return l.w.Flush()
+}
+
+// protoDecisionLog writes decisions as length-delimited protobuf records
+type protoDecisionLog struct {
+    w *bufio.Writer
+}
+
+// NewProtoDecisionLog creates a DecisionLog that writes varint length-prefixed dna.Decision records to w
+func NewProtoDecisionLog(w io.Writer) DecisionLog {
+    return &protoDecisionLog{w: bufio.NewWriter(w)}
+}
+
+// Record writes a single decision as a length-delimited protobuf record
+func (l *protoDecisionLog) Record(decision *dna.Decision) error {
+    _, err := protodelim.MarshalTo(l.w, decision)
+    return err
+}
+
+// Flush flushes any buffered records to the underlying writer
+func (l *protoDecisionLog) Flush() error {
+    return l.w.Flush()
+}
+
+// ReadDecisions reads all length-delimited dna.Decision records from r
+func ReadDecisions(r io.Reader) ([]*dna.Decision, error) {
+    var decisions []*dna.Decision
+    reader := bufio.NewReader(r)
+    for {
+        decision := &dna.Decision{}
+        err := protodelim.UnmarshalFrom(reader, decision)
+        if err == io.EOF {
+            return decisions, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        decisions = append(decisions, decision)
+    }
+}
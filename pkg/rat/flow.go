@@ -0,0 +1,150 @@
+package rat
+
+import (
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Flow holds the congestion control state of a single flow managed by a RAT
+type Flow struct {
+    memory           *memory.Memory   // Network state observed by this flow
+    packetsSent      uint             // Number of packets sent in this flow
+    packetsReceived  uint             // Number of packets received in this flow
+    packetsLost      uint             // Number of packets of this flow reported lost
+    lastSendTime     time.Time        // Timestamp of the last packet sent
+    congestionWindow uint             // Current congestion window size
+    intersendTime    float64          // Intersend time for the current whisker
+    currentWhisker   *whisker.Whisker // Pointer to the currently selected Whisker
+}
+
+// newFlow creates the state of a flow with empty memory and no window
+func newFlow() *Flow {
+    return &Flow{memory: memory.NewMemory()}
+}
+
+// resetWindow sets the window and intersend time of the flow from the given whisker, as at the start of an on period
+func (f *Flow) resetWindow(initial *whisker.Whisker) {
+    f.currentWhisker = initial
+    f.congestionWindow = initial.Window(0)
+    f.intersendTime = initial.Intersend
+}
+
+// updateState updates the flow state with the whisker chosen for its latest memory
+func (f *Flow) updateState(currentWhisker *whisker.Whisker) {
+    f.currentWhisker = currentWhisker
+    f.congestionWindow = f.currentWhisker.Window(f.congestionWindow)
+    f.intersendTime = f.currentWhisker.Intersend
+}
+
+// CongestionWindow returns the current congestion window of the flow
+func (f *Flow) CongestionWindow() uint {
+    return f.congestionWindow
+}
+
+// Intersend returns the current intersend time of the flow in seconds
+func (f *Flow) Intersend() float64 {
+    return f.intersendTime
+}
+
+// Whisker returns the whisker that set the flow's current window and intersend time
+func (f *Flow) Whisker() *whisker.Whisker {
+    return f.currentWhisker
+}
+
+// PacketsSent returns the number of packets sent in the flow
+func (f *Flow) PacketsSent() uint {
+    return f.packetsSent
+}
+
+// PacketsReceived returns the number of packets received in the flow
+func (f *Flow) PacketsReceived() uint {
+    return f.packetsReceived
+}
+
+// PacketsLost returns the number of packets of the flow reported lost
+func (f *Flow) PacketsLost() uint {
+    return f.packetsLost
+}
+
+// StartFlow starts the flow with the given ID, or restarts it if it is already active
+// As Remy does at the start of every on period, the flow's memory is reset and its window is taken from
+// the whisker for that empty memory
+func (rat *RAT) StartFlow(flowID uint) {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    rat.flows[flowID] = rat.startFlow()
+    if flowID >= rat.nextFlowID {
+        rat.nextFlowID = flowID + 1
+    }
+}
+
+// NewFlow starts a flow with a previously unused ID and returns that ID
+func (rat *RAT) NewFlow() uint {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    flowID := rat.nextFlowID
+    rat.nextFlowID++
+    rat.flows[flowID] = rat.startFlow()
+    return flowID
+}
+
+// startFlow creates the state of a new flow and sets its initial window
+func (rat *RAT) startFlow() *Flow {
+    flow := newFlow()
+    flow.resetWindow(rat.initialWhisker(flow))
+    return flow
+}
+
+// initialWhisker looks up the whisker that sets the window of a flow whose window is zero, as Rat::send does in Remy
+// The root whisker is used if no whisker covers the flow's memory
+func (rat *RAT) initialWhisker(flow *Flow) *whisker.Whisker {
+    tree := rat.whiskers.Load()
+    w, err := tree.FindWhisker(flow.memory)
+    if err != nil {
+        return tree.Root.Whisker
+    }
+    if rat.track {
+        w.Use()
+    }
+    return w
+}
+
+// EndFlow ends the flow with the given ID and discards its state
+// Packets of the flow that arrive afterwards are ignored
+func (rat *RAT) EndFlow(flowID uint) {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    delete(rat.flows, flowID)
+}
+
+// snapshot returns a copy of the flow's state, with a memory of its own so that later updates do not show through
+func (f *Flow) snapshot() Flow {
+    copied := *f
+    copied.memory = f.memory.Clone()
+    return copied
+}
+
+// Flow returns a snapshot of the state of the flow with the given ID
+func (rat *RAT) Flow(flowID uint) (Flow, bool) {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    flow, ok := rat.flows[flowID]
+    if !ok {
+        return Flow{}, false
+    }
+    return flow.snapshot(), true
+}
+
+// ActiveFlows returns the number of flows that are currently active
+func (rat *RAT) ActiveFlows() int {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    return len(rat.flows)
+}
@@ -0,0 +1,33 @@
+package rat
+
+import (
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Observer is told about every acknowledgment a RAT receives and every window it sets, for metrics
+// The RAT's counters of packets sent, received and lost are read with its accessors instead. The methods are called
+// with the RAT's lock held, so they must be quick and must not call back into the RAT.
+type Observer interface {
+    PacketAcked(flowID uint, rtt time.Duration)
+    WindowUpdated(flowID uint, window uint, intersend float64, chosen *whisker.Whisker)
+}
+
+// SetObserver sets the observer of the RAT; nil disables observation
+func (rat *RAT) SetObserver(o Observer) {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    rat.observer = o
+}
+
+// Flows returns a snapshot of the state of every active flow, keyed by flow ID
+func (rat *RAT) Flows() map[uint]Flow {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    flows := make(map[uint]Flow, len(rat.flows))
+    for id, flow := range rat.flows {
+        flows[id] = flow.snapshot()
+    }
+    return flows
+}
@@ -1,92 +1,102 @@
 package rat
 
 import (
-    "bytes"
-    "encoding/binary"
     "fmt"
+    "io"
     "net"
     "sync"
+    "sync/atomic"
     "time"
 
+    "github.com/Aanthord/remy-go/pkg/clock"
+    "github.com/Aanthord/remy-go/pkg/dna"
     "github.com/Aanthord/remy-go/pkg/memory"
     "github.com/Aanthord/remy-go/pkg/whisker"
-    "golang.org/x/sys/unix"
 )
 
 // RAT represents the Remy Augmented TCP (RAT) congestion control algorithm
 type RAT struct {
-    whiskers        *whisker.WhiskerTree // Pointer to the WhiskerTree that holds the whiskers
-    memory          *memory.Memory       // Pointer to the Memory object that stores the network state
-    packetsSent     uint                 // Number of packets sent
-    packetsReceived uint                 // Number of packets received
-    track           bool                 // Flag to enable tracking (used in the C++ implementation)
-    lastSendTime    time.Time            // Timestamp of the last packet sent
-    congestionWindow uint                // Current congestion window size
-    intersendTime   float64              // Intersend time for the current whisker
-    flowID          uint                 // Flow ID
-    currentWhisker  *whisker.Whisker     // Pointer to the currently selected Whisker
-    mu              sync.Mutex           // Mutex for synchronization
+    whiskers        atomic.Pointer[whisker.WhiskerTree] // Current tree, swapped without taking mu so lookups never wait for a reload
+    flows           map[uint]*Flow                      // State of every active flow, keyed by flow ID
+    nextFlowID      uint                                // Next flow ID handed out by NewFlow
+    packetsSent     uint                                // Number of packets sent across all flows
+    packetsReceived uint                                // Number of packets received across all flows
+    packetsLost     uint                                // Number of packets reported lost across all flows
+    lookupErrors    uint                                // Number of whisker lookups that found no whisker
+    err             error                               // Last error of a whisker lookup or of the decision log
+    track           bool                                // Flag to count the uses of every whisker, as in the C++ implementation
+    decisions       DecisionLog                         // Optional log of every whisker lookup
+    observer        Observer                            // Optional observer of every acknowledgment and window update
+    clock           atomic.Pointer[clock.Clock]         // Source of the timestamps of sent packets, read without taking mu
+    reloads         reloadCounters                      // Record of tree swaps and rollbacks
+    mu              sync.Mutex                          // Mutex for synchronization
 }
 
 // NewRAT is a constructor that creates a new instance of the RAT struct
 func NewRAT(whiskers *whisker.WhiskerTree, track bool) *RAT {
-    return &RAT{
-        whiskers:       whiskers,
-        memory:         memory.NewMemory(),
-        track:          track,
-        flowID:         0,
-        currentWhisker: whiskers.Root.Whisker,
+    rat := &RAT{
+        flows:      make(map[uint]*Flow),
+        nextFlowID: 1,
+        track:      track,
     }
+    rat.whiskers.Store(whiskers)
+    rat.SetClock(clock.Real{})
+    return rat
 }
 
-// Start initializes the RAT algorithm
-// It creates a TCP listener and sets the RAT algorithm as the system-wide congestion control algorithm
-func (rat *RAT) Start() error {
-    // Create a TCP listener
-    listener, err := net.Listen("tcp", ":0")
-    if err != nil {
-        return err
-    }
-    defer listener.Close()
+// SetClock sets the clock used to timestamp sent packets and pace sends
+func (rat *RAT) SetClock(c clock.Clock) {
+    rat.clock.Store(&c)
+}
 
-    // Get the listener's socket file descriptor
-    listenerFD, err := listener.(*net.TCPListener).File()
-    if err != nil {
-        return err
-    }
-    defer listenerFD.Close()
+// SetDecisionLog sets the log that records every whisker lookup; nil disables logging
+func (rat *RAT) SetDecisionLog(log DecisionLog) {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    rat.decisions = log
+}
 
-    // Set the RAT algorithm as the system-wide congestion control algorithm
-    err = SetRATAlgorithmSystemWide(int(listenerFD.Fd()))
-    if err != nil {
-        return err
-    }
+// NextHop accepts the packets a RAT sends, as the next hop does in Remy
+type NextHop interface {
+    Accept(packet *Packet) error
+}
 
-    return nil
+// ConnNextHop is a NextHop that writes packets to a TCP connection
+type ConnNextHop struct {
+    Conn *net.TCPConn
 }
 
-// Send sends a packet from the sender to the receiver
-func (rat *RAT) Send(id int, next *net.TCPConn, seq int, packetsSentCap uint) error {
+// Accept writes the packet to the connection
+func (h ConnNextHop) Accept(packet *Packet) error {
+    return SendPacket(h.Conn, packet)
+}
+
+// Send sends a packet of the given flow to the next hop if the flow's window and intersend time allow it
+func (rat *RAT) Send(flowID uint, id int, next NextHop, seq int, packetsSentCap uint) error {
     rat.mu.Lock()
     defer rat.mu.Unlock()
 
+    flow, ok := rat.flows[flowID]
+    if !ok {
+        return fmt.Errorf("flow %d has not been started", flowID)
+    }
+
     // Assertion to ensure that the number of packets sent is greater than or equal to the number of packets received
-    assertCondition(rat.packetsSent >= rat.packetsReceived, "Number of packets sent should be greater than or equal to the number of packets received")
+    assertCondition(flow.packetsSent >= flow.packetsReceived, "Number of packets sent should be greater than or equal to the number of packets received")
 
-    if rat.congestionWindow == 0 {
+    if flow.congestionWindow == 0 {
         // If the congestion window is zero, initialize the current whisker, congestion window, and intersend time
-        rat.currentWhisker = rat.whiskers.Root.Whisker
-        rat.congestionWindow = rat.currentWhisker.Window(0)
-        rat.intersendTime = rat.currentWhisker.Intersend
+        flow.resetWindow(rat.initialWhisker(flow))
     }
 
-    if rat.packetsSent < rat.packetsReceived+rat.congestionWindow &&
-        time.Since(rat.lastSendTime) >= time.Duration(rat.intersendTime*float64(time.Second)) {
+    now := rat.now()
+    if flow.packetsSent < flow.packetsReceived+flow.packetsLost+flow.congestionWindow &&
+        now.Sub(flow.lastSendTime) >= time.Duration(flow.intersendTime*float64(time.Second)) {
 
         // Check if it's time to send a packet based on the congestion window and intersend time
 
         // Have we reached the end of the flow for now?
-        if rat.packetsSent >= packetsSentCap {
+        if flow.packetsSent >= packetsSentCap {
             return nil
         }
 
@@ -94,82 +104,189 @@ func (rat *RAT) Send(id int, next *net.TCPConn, seq int, packetsSentCap uint) er
         packet := &Packet{
             SeqNo:  seq,
             ID:     id,
-            FlowID: rat.flowID,
-            Sent:   time.Now(),
+            FlowID: flowID,
+            Sent:   now,
         }
+        flow.packetsSent++
         rat.packetsSent++
-        rat.memory.UpdateSentPacket(&memory.Packet{
+        err := next.Accept(packet)
+        if err != nil {
+            return err
+        }
+        flow.lastSendTime = now
+    }
+
+    return nil
+}
+
+// ReceivePackets receives a slice of packets and updates the state of the flows they belong to
+// As in Remy, each flow's memory is updated with all of its packets before a single whisker is
+// looked up for the flow. Packets of flows that are not active (for example, stragglers from a
+// flow that has ended) are ignored
+func (rat *RAT) ReceivePackets(packets []*Packet) {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    var flowIDs []uint
+    memoryPackets := make(map[uint][]*memory.Packet)
+    lastPackets := make(map[uint]*Packet)
+    for _, packet := range packets {
+        flow, ok := rat.flows[packet.FlowID]
+        if !ok {
+            continue
+        }
+        flow.packetsReceived++
+        rat.packetsReceived++
+        if rat.observer != nil {
+            rat.observer.PacketAcked(packet.FlowID, packet.Received.Sub(packet.Sent))
+        }
+
+        if _, seen := memoryPackets[packet.FlowID]; !seen {
+            flowIDs = append(flowIDs, packet.FlowID)
+        }
+        memoryPackets[packet.FlowID] = append(memoryPackets[packet.FlowID], &memory.Packet{
             SeqNo:    packet.SeqNo,
             ID:       packet.ID,
             FlowID:   packet.FlowID,
             Sent:     packet.Sent,
             Received: packet.Received,
         })
-        err := SendPacket(next, packet)
+        lastPackets[packet.FlowID] = packet
+    }
+
+    for _, flowID := range flowIDs {
+        flow := rat.flows[flowID]
+        flow.memory.UpdateReceivedPackets(memoryPackets[flowID], flowID)
+
+        var lookupMemory *dna.Memory
+        if rat.decisions != nil {
+            lookupMemory = flow.memory.ToDNAMemory()
+        }
+        whisker, err := rat.whiskers.Load().FindWhisker(flow.memory)
         if err != nil {
-            return err
+            rat.lookupErrors++
+            rat.err = fmt.Errorf("failed to find whisker for flow %d: %w", flowID, err)
+            rat.recordDecision(flow, lastPackets[flowID], lookupMemory, nil, flow.congestionWindow, err)
+            continue
         }
-        rat.lastSendTime = time.Now()
+        if rat.track {
+            whisker.Use()
+        }
+        oldWindow := flow.congestionWindow
+        flow.updateState(whisker)
+        rat.recordDecision(flow, lastPackets[flowID], lookupMemory, whisker, oldWindow, nil)
+        rat.observeWindow(flowID, flow)
     }
-
-    return nil
 }
 
-// ReceivePackets receives a slice of packets and updates the RAT state
-func (rat *RAT) ReceivePackets(packets []*Packet) {
+// LosePackets reports packets that a transport gave up on, so that they no longer hold the window of their flows
+// Each lost packet is observed by the flow's memory, as Memory::packet_lost does, but no whisker is looked up
+// Packets of flows that are not active are ignored
+func (rat *RAT) LosePackets(packets []*Packet) {
     rat.mu.Lock()
     defer rat.mu.Unlock()
 
-    rat.packetsReceived += uint(len(packets))
-
-    var memoryPackets []*memory.Packet
     for _, packet := range packets {
-        if packet.FlowID == rat.flowID {
-            rtt := time.Since(packet.Sent)
-            memoryPackets = append(memoryPackets, &memory.Packet{
-                SeqNo:    packet.SeqNo,
-                ID:       packet.ID,
-                FlowID:   packet.FlowID,
-                Sent:     packet.Sent,
-                Received: packet.Received,
-            })
-            whisker, err := rat.whiskers.FindWhisker(rat.memory)
-            if err != nil {
-                fmt.Println("Error finding whisker:", err)
-                continue
-            }
-            rat.updateState(rtt.Seconds(), packet.SeqNo, whisker)
+        flow, ok := rat.flows[packet.FlowID]
+        if !ok {
+            continue
         }
+        flow.packetsLost++
+        rat.packetsLost++
+        flow.memory.UpdateLostPacket(&memory.Packet{
+            SeqNo:  packet.SeqNo,
+            ID:     packet.ID,
+            FlowID: packet.FlowID,
+            Sent:   packet.Sent,
+        })
+    }
+}
+
+// observeWindow tells the observer, if one is set, about the window and intersend time of a flow
+func (rat *RAT) observeWindow(flowID uint, flow *Flow) {
+    if rat.observer != nil {
+        rat.observer.WindowUpdated(flowID, flow.congestionWindow, flow.intersendTime, flow.currentWhisker)
     }
-    rat.memory.UpdateReceivedPackets(memoryPackets, rat.flowID)
 }
 
-// updateState updates the RAT state based on the received packet
-func (rat *RAT) updateState(rtt float64, seq int, currentWhisker *whisker.Whisker) {
-    rat.memory.UpdateRTT(memory.DataType(rtt))
-    rat.currentWhisker = currentWhisker
-    rat.congestionWindow = rat.currentWhisker.Window(rat.congestionWindow)
-    rat.intersendTime = rat.currentWhisker.Intersend
-    rat.flowID++
-    if rat.flowID == 0 {
-        rat.flowID = 1
+// recordDecision writes the outcome of a whisker lookup to the decision log, if one is set
+func (rat *RAT) recordDecision(flow *Flow, packet *Packet, lookupMemory *dna.Memory, chosen *whisker.Whisker, oldWindow uint, lookupErr error) {
+    if rat.decisions == nil {
+        return
+    }
+
+    decision := &dna.Decision{
+        TimeNs:    rat.now().UnixNano(),
+        FlowId:    uint32(packet.FlowID),
+        LastSeqNo: int32(packet.SeqNo),
+        Memory:    lookupMemory,
+        OldWindow: uint32(oldWindow),
+        NewWindow: uint32(flow.congestionWindow),
+        Intersend: flow.intersendTime,
+    }
+    if chosen != nil {
+        decision.Whisker = chosen.ToDNAWhisker()
+    }
+    if lookupErr != nil {
+        decision.Error = lookupErr.Error()
+    }
+
+    if err := rat.decisions.Record(decision); err != nil {
+        rat.err = fmt.Errorf("failed to record decision: %w", err)
     }
 }
 
-// NextEventTime returns the time of the next event
-func (rat *RAT) NextEventTime() time.Time {
-    nextSendTime := rat.lastSendTime.Add(time.Duration(rat.intersendTime * float64(time.Second)))
-    return nextSendTime
+// NextEventTime returns the time at which the given flow may next send
+func (rat *RAT) NextEventTime(flowID uint) time.Time {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+
+    flow, ok := rat.flows[flowID]
+    if !ok {
+        return time.Time{}
+    }
+    return flow.lastSendTime.Add(time.Duration(flow.intersendTime * float64(time.Second)))
 }
 
-// PacketsSent returns the number of packets sent
+// PacketsSent returns the number of packets sent across all flows
 func (rat *RAT) PacketsSent() uint {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
     return rat.packetsSent
 }
 
-// Whiskers returns the WhiskerTree
+// PacketsReceived returns the number of packets received across all flows
+func (rat *RAT) PacketsReceived() uint {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    return rat.packetsReceived
+}
+
+// PacketsLost returns the number of packets reported lost across all flows
+func (rat *RAT) PacketsLost() uint {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    return rat.packetsLost
+}
+
+// LookupErrors returns the number of whisker lookups that found no whisker
+// A flow whose lookup fails keeps its window and intersend time; the failure is also in the decision log, if one is set
+func (rat *RAT) LookupErrors() uint {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    return rat.lookupErrors
+}
+
+// Err returns the last error of a whisker lookup or of the decision log, or nil if there was none
+func (rat *RAT) Err() error {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    return rat.err
+}
+
+// Whiskers returns the WhiskerTree currently in use
 func (rat *RAT) Whiskers() *whisker.WhiskerTree {
-    return rat.whiskers
+    return rat.whiskers.Load()
 }
 
 // Packet represents a network packet
@@ -181,91 +298,33 @@ type Packet struct {
     Received time.Time  // Timestamp when the packet was received
 }
 
-// SendPacket sends a packet over the network
+// SendPacket sends a packet over the network as a frame of the wire format
 func SendPacket(conn *net.TCPConn, packet *Packet) error {
-    data, err := encodePacket(packet)
-    if err != nil {
-        return err
-    }
-    _, err = conn.Write(data)
-    return err
+    return SendFrame(conn, &Frame{Packet: *packet})
 }
 
 // ReceivePacket receives a packet from the network
+// Exactly one frame is read, so that nothing after it is lost; a loop reading a stream should use a Decoder instead
 func ReceivePacket(conn *net.TCPConn) (*Packet, error) {
-    buffer := make([]byte, 1024)
-    n, err := conn.Read(buffer)
-    if err != nil {
+    buffer := make([]byte, FrameHeaderSize)
+    if _, err := io.ReadFull(conn, buffer); err != nil {
         return nil, err
     }
-    packet, err := decodePacket(buffer[:n])
+    n, err := frameLength(buffer)
     if err != nil {
         return nil, err
     }
-    packet.Received = time.Now()
-    return packet, nil
-}
-
-// encodePacket encodes a packet into a byte slice
-func encodePacket(packet *Packet) ([]byte, error) {
-    buf := new(bytes.Buffer)
-
-    err := binary.Write(buf, binary.BigEndian, int32(packet.SeqNo))
-    if err != nil {
+    buffer = append(buffer, make([]byte, n-FrameHeaderSize)...)
+    if _, err := io.ReadFull(conn, buffer[FrameHeaderSize:]); err != nil {
         return nil, err
     }
-
-    err = binary.Write(buf, binary.BigEndian, int32(packet.ID))
+    frame, err := parseFrame(buffer)
     if err != nil {
         return nil, err
     }
-
-    err = binary.Write(buf, binary.BigEndian, packet.FlowID)
-    if err != nil {
-        return nil, err
-    }
-
-    err = binary.Write(buf, binary.BigEndian, packet.Sent.UnixNano())
-    if err != nil {
-        return nil, err
-    }
-
-    return buf.Bytes(), nil
-}
-
-// decodePacket decodes a packet from a byte slice
-func decodePacket(buffer []byte) (*Packet, error) {
-    buf := bytes.NewReader(buffer)
-
-    packet := &Packet{}
-
-    var seqNo int32
-    err := binary.Read(buf, binary.BigEndian, &seqNo)
-    if err != nil {
-        return nil, err
-    }
-    packet.SeqNo = int(seqNo)
-
-    var id int32
-    err = binary.Read(buf, binary.BigEndian, &id)
-    if err != nil {
-        return nil, err
-    }
-    packet.ID = int(id)
-
-    err = binary.Read(buf, binary.BigEndian, &packet.FlowID)
-    if err != nil {
-        return nil, err
-    }
-
-    var sent int64
-    err = binary.Read(buf, binary.BigEndian, &sent)
-    if err != nil {
-        return nil, err
-    }
-    packet.Sent = time.Unix(0, sent)
-
-    return packet, nil
+    packet := frame.Packet
+    packet.Received = time.Now()
+    return &packet, nil
 }
 
 // assertCondition is a function for assertions
@@ -274,12 +333,3 @@ func assertCondition(condition bool, message string) {
         panic(message)
     }
 }
-
-// SetRATAlgorithmSystemWide sets the RAT congestion control algorithm as the system-wide default
-func SetRATAlgorithmSystemWide(sockFD int) error {
-    err := unix.SetsockoptString(sockFD, unix.SOL_TCP, unix.TCP_CONGESTION, "remy")
-    if err != nil {
-        return fmt.Errorf("failed to set RAT congestion control algorithm: %v", err)
-    }
-    return nil
-}
@@ -0,0 +1,144 @@
+package rat
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/clock"
+    "github.com/Aanthord/remy-go/pkg/dna"
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// failingLog is a decision log that keeps every decision and fails to record them
+type failingLog struct {
+    decisions []*dna.Decision
+}
+
+func (l *failingLog) Record(d *dna.Decision) error {
+    l.decisions = append(l.decisions, d)
+    return errors.New("disk full")
+}
+
+func (l *failingLog) Flush() error {
+    return nil
+}
+
+// collect is a next hop that keeps the packets it accepts
+type collect []*Packet
+
+func (c *collect) Accept(packet *Packet) error {
+    *c = append(*c, packet)
+    return nil
+}
+
+func TestLookupFailuresAreStored(t *testing.T) {
+    // A root whose domain is empty contains no memory, so every lookup fails
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 1, 1, 0, memory.NewMemoryRange(memory.NewMemory(), memory.NewMemory()))
+    tree.Invalidate()
+
+    start := time.Unix(1000, 0)
+    c := clock.NewManual(start)
+    rat := NewRAT(tree, false)
+    rat.SetClock(c)
+    log := &failingLog{}
+    rat.SetDecisionLog(log)
+    flowID := rat.NewFlow()
+
+    var sent collect
+    if err := rat.Send(flowID, 0, &sent, 0, 10); err != nil {
+        t.Fatal(err)
+    }
+    if len(sent) != 1 {
+        t.Fatalf("sent %d packets, want 1", len(sent))
+    }
+    c.Advance(100 * time.Millisecond)
+    sent[0].Received = c.Now()
+    rat.ReceivePackets(sent)
+
+    if n := rat.LookupErrors(); n != 1 {
+        t.Errorf("LookupErrors() = %d, want 1", n)
+    }
+    if len(log.decisions) != 1 || log.decisions[0].Error == "" {
+        t.Errorf("the failed lookup was not logged as an error decision: %v", log.decisions)
+    }
+    // The decision log failed after the lookup, so its error is the last one
+    if err := rat.Err(); err == nil || err.Error() != "failed to record decision: disk full" {
+        t.Errorf("Err() = %v, want the decision log's error", err)
+    }
+}
+
+func TestFlowSnapshotsKeepTheirMemory(t *testing.T) {
+    c := clock.NewManual(time.Unix(1000, 0))
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 1, 1, 0, tree.Root.Whisker.Domain)
+    rat := NewRAT(tree, false)
+    rat.SetClock(c)
+    flowID := rat.NewFlow()
+
+    single, _ := rat.Flow(flowID)
+    all := rat.Flows()
+    var sent collect
+    if err := rat.Send(flowID, 0, &sent, 0, 10); err != nil {
+        t.Fatal(err)
+    }
+    c.Advance(100 * time.Millisecond)
+    sent[0].Received = c.Now()
+    rat.ReceivePackets(sent)
+
+    // The first packet sets the smallest round-trip time but none of the signals
+    live, _ := rat.Flow(flowID)
+    if live.memory.MinRTT() != 100 {
+        t.Fatalf("flow's smallest round-trip time is %v after a packet of 100 ms", live.memory.MinRTT())
+    }
+    for _, snapshot := range []Flow{single, all[flowID]} {
+        if rtt := snapshot.memory.MinRTT(); rtt != 0 {
+            t.Errorf("snapshot taken before the packet arrived has a smallest round-trip time of %v", rtt)
+        }
+    }
+}
+
+// memoryLog is a decision log that keeps every decision
+type memoryLog []*dna.Decision
+
+func (l *memoryLog) Record(d *dna.Decision) error {
+    *l = append(*l, d)
+    return nil
+}
+
+func (l *memoryLog) Flush() error {
+    return nil
+}
+
+func TestOneDecisionPerBatch(t *testing.T) {
+    c := clock.NewManual(time.Unix(1000, 0))
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 4, 0, 0, tree.Root.Whisker.Domain)
+    tree.Invalidate()
+    rat := NewRAT(tree, false)
+    rat.SetClock(c)
+    log := &memoryLog{}
+    rat.SetDecisionLog(log)
+    flowID := rat.NewFlow()
+
+    var sent collect
+    for seq := 0; seq < 3; seq++ {
+        if err := rat.Send(flowID, seq, &sent, seq, 10); err != nil {
+            t.Fatal(err)
+        }
+    }
+    c.Advance(50 * time.Millisecond)
+    for _, packet := range sent {
+        packet.Received = c.Now()
+    }
+    rat.ReceivePackets(sent)
+
+    if len(*log) != 1 {
+        t.Fatalf("got %d decisions for one batch, want 1", len(*log))
+    }
+    if seq := (*log)[0].LastSeqNo; seq != 2 {
+        t.Errorf("LastSeqNo = %d, want 2", seq)
+    }
+}
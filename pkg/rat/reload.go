@@ -0,0 +1,264 @@
+package rat
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// reloadCounters records the tree swaps of a RAT; it is updated atomically so that reading it never blocks a swap
+type reloadCounters struct {
+    swaps        atomic.Uint64
+    rollbacks    atomic.Uint64
+    lastSwap     atomic.Int64 // Time of the last swap in nanoseconds since the epoch, or 0
+    lastRollback atomic.Int64 // Time of the last rollback in nanoseconds since the epoch, or 0
+}
+
+// ReloadStats is a snapshot of the tree swaps of a RAT
+type ReloadStats struct {
+    Swaps        uint64    // Number of times a new tree was swapped in
+    Rollbacks    uint64    // Number of times a swap was undone
+    LastSwap     time.Time // Time of the last swap, or zero if there was none
+    LastRollback time.Time // Time of the last rollback, or zero if there was none
+}
+
+// String returns a string representation of the reload stats
+func (s ReloadStats) String() string {
+    return fmt.Sprintf("%d swaps, %d rollbacks", s.Swaps, s.Rollbacks)
+}
+
+// SwapWhiskers makes the RAT use a new tree from its next lookup on and returns the tree it used before
+// Flows keep their memory, window and intersend time, so the new tree takes over where the old one left off.
+// The swap is a single atomic store: it does not wait for the RAT's lock and lookups never wait for it.
+func (rat *RAT) SwapWhiskers(tree *whisker.WhiskerTree) *whisker.WhiskerTree {
+    old := rat.whiskers.Swap(tree)
+    rat.reloads.swaps.Add(1)
+    rat.reloads.lastSwap.Store(rat.now().UnixNano())
+    return old
+}
+
+// rollbackWhiskers puts back a tree replaced by SwapWhiskers, recording it as a rollback rather than a swap
+// Nothing is done if the current tree is no longer the one expected, for example because another swap happened since
+func (rat *RAT) rollbackWhiskers(current, previous *whisker.WhiskerTree) bool {
+    if !rat.whiskers.CompareAndSwap(current, previous) {
+        return false
+    }
+    rat.reloads.rollbacks.Add(1)
+    rat.reloads.lastRollback.Store(rat.now().UnixNano())
+    return true
+}
+
+// ReloadStats returns a snapshot of the tree swaps of the RAT
+func (rat *RAT) ReloadStats() ReloadStats {
+    stats := ReloadStats{
+        Swaps:     rat.reloads.swaps.Load(),
+        Rollbacks: rat.reloads.rollbacks.Load(),
+    }
+    if ns := rat.reloads.lastSwap.Load(); ns != 0 {
+        stats.LastSwap = time.Unix(0, ns)
+    }
+    if ns := rat.reloads.lastRollback.Load(); ns != 0 {
+        stats.LastRollback = time.Unix(0, ns)
+    }
+    return stats
+}
+
+// now returns the time of the RAT's clock
+// The clock is loaded atomically, so a swap can read it without waiting for a lookup that holds the RAT's lock.
+func (rat *RAT) now() time.Time {
+    return (*rat.clock.Load()).Now()
+}
+
+// Health is a snapshot of the packet counters of a RAT, or the difference between two snapshots
+type Health struct {
+    Time            time.Time     // Time the snapshot was taken
+    Elapsed         time.Duration // Length of the interval, for a difference between snapshots
+    PacketsSent     uint          // Number of packets sent
+    PacketsReceived uint          // Number of packets received
+    PacketsLost     uint          // Number of packets reported lost
+}
+
+// Health returns a snapshot of the packet counters of the RAT
+func (rat *RAT) Health() Health {
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    return Health{Time: rat.now(), PacketsSent: rat.packetsSent, PacketsReceived: rat.packetsReceived, PacketsLost: rat.packetsLost}
+}
+
+// Since returns the health over the interval between an earlier snapshot and this one
+func (h Health) Since(earlier Health) Health {
+    return Health{
+        Time:            h.Time,
+        Elapsed:         h.Time.Sub(earlier.Time),
+        PacketsSent:     h.PacketsSent - earlier.PacketsSent,
+        PacketsReceived: h.PacketsReceived - earlier.PacketsReceived,
+        PacketsLost:     h.PacketsLost - earlier.PacketsLost,
+    }
+}
+
+// LossRate returns the fraction of the packets whose fate is known that were reported lost
+// Packets still in flight at the end of the interval are neither received nor lost yet, so they are left out
+func (h Health) LossRate() float64 {
+    if h.PacketsLost == 0 {
+        return 0
+    }
+    return float64(h.PacketsLost) / float64(h.PacketsReceived+h.PacketsLost)
+}
+
+// Throughput returns the number of packets received per second
+func (h Health) Throughput() float64 {
+    if h.Elapsed <= 0 {
+        return 0
+    }
+    return float64(h.PacketsReceived) / h.Elapsed.Seconds()
+}
+
+// HealthCheck decides whether a new tree is healthy, given the health over the interval before it was swapped in
+// and the health over the interval since
+type HealthCheck func(before, after Health) bool
+
+// LossRateCheck returns a health check that fails when the loss rate rises by more than tolerance
+func LossRateCheck(tolerance float64) HealthCheck {
+    return func(before, after Health) bool {
+        return after.LossRate() <= before.LossRate()+tolerance
+    }
+}
+
+// ThroughputCheck returns a health check that fails when the throughput drops by more than the given fraction
+func ThroughputCheck(fraction float64) HealthCheck {
+    return func(before, after Health) bool {
+        return after.Throughput() >= before.Throughput()*(1-fraction)
+    }
+}
+
+// Reloader swaps new trees into a RAT and rolls a swap back if the tree fails a health check
+// After a swap, the new tree runs for a probation period; the first call to Check after that compares the health
+// over the probation period with the health over the interval before the swap
+type Reloader struct {
+    rat       *RAT
+    check     HealthCheck
+    probation time.Duration
+    load      whisker.LoadOptions
+
+    mu       sync.Mutex
+    mark     Health               // Snapshot taken at the last swap or settled check
+    baseline Health               // Health over the interval before the pending swap
+    current  *whisker.WhiskerTree // Tree swapped in by the pending swap, or nil if there is none
+    previous *whisker.WhiskerTree // Tree the pending swap replaced
+}
+
+// NewReloader is a constructor that creates a Reloader for the given RAT
+// A nil check accepts every tree, so swaps are never rolled back. Files are loaded with the given options, so a
+// strict LoadFile refuses trees that fail validation before they are swapped in.
+func NewReloader(rat *RAT, check HealthCheck, probation time.Duration, load whisker.LoadOptions) *Reloader {
+    return &Reloader{
+        rat:       rat,
+        check:     check,
+        probation: probation,
+        load:      load,
+        mark:      rat.Health(),
+    }
+}
+
+// Reload swaps a new tree into the RAT and puts it on probation
+// A swap still on probation is accepted first, since the new tree replaces it anyway
+func (r *Reloader) Reload(tree *whisker.WhiskerTree) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    now := r.rat.Health()
+    r.baseline = now.Since(r.mark)
+    r.mark = now
+    r.previous = r.rat.SwapWhiskers(tree)
+    r.current = tree
+}
+
+// LoadFile loads a tree from a whisker file with the reloader's load options and swaps it in with Reload
+// A tree the options refuse leaves the current tree in place
+func (r *Reloader) LoadFile(filename string) error {
+    tree, _, err := whisker.LoadWhiskersWithOptions(filename, r.load)
+    if err != nil {
+        return err
+    }
+    r.Reload(tree)
+    return nil
+}
+
+// Check ends the probation of the pending swap once its probation period has passed, rolling it back if the new
+// tree fails the health check
+// It returns whether the swap was rolled back
+func (r *Reloader) Check() bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.current == nil {
+        return false
+    }
+    now := r.rat.Health()
+    after := now.Since(r.mark)
+    if after.Elapsed < r.probation {
+        return false
+    }
+
+    rolledBack := false
+    if r.check != nil && !r.check(r.baseline, after) {
+        rolledBack = r.rat.rollbackWhiskers(r.current, r.previous)
+    }
+    r.mark = now
+    r.current = nil
+    r.previous = nil
+    return rolledBack
+}
+
+// Pending checks if a swap is on probation
+func (r *Reloader) Pending() bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.current != nil
+}
+
+// Watch polls a whisker file every interval, reloading it whenever its modification time changes and checking
+// the pending swap, until the context is done
+// Files that fail to load are reported to onError, if it is not nil, and leave the current tree in place
+func (r *Reloader) Watch(ctx context.Context, filename string, interval time.Duration, onError func(error)) error {
+    info, err := os.Stat(filename)
+    if err != nil {
+        return err
+    }
+    modified := info.ModTime()
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            if errors.Is(ctx.Err(), context.Canceled) {
+                return nil
+            }
+            return ctx.Err()
+        case <-ticker.C:
+        }
+
+        r.Check()
+        info, err := os.Stat(filename)
+        if err != nil {
+            if onError != nil {
+                onError(err)
+            }
+            continue
+        }
+        if info.ModTime().Equal(modified) {
+            continue
+        }
+        modified = info.ModTime()
+        if err := r.LoadFile(filename); err != nil && onError != nil {
+            onError(fmt.Errorf("failed to reload %s: %w", filename, err))
+        }
+    }
+}
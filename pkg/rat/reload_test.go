@@ -0,0 +1,140 @@
+package rat
+
+import (
+    "errors"
+    "math"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/clock"
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+func TestSwapDoesNotWaitForLock(t *testing.T) {
+    start := time.Unix(1000, 0)
+    rat := NewRAT(whisker.NewWhiskerTree(), false)
+    rat.SetClock(clock.NewManual(start))
+    old := rat.Whiskers()
+    tree := whisker.NewWhiskerTree()
+
+    // A lookup holding the lock must not hold up a swap or its rollback
+    rat.mu.Lock()
+    defer rat.mu.Unlock()
+    done := make(chan bool)
+    go func() {
+        rat.SwapWhiskers(tree)
+        done <- rat.rollbackWhiskers(tree, old)
+    }()
+    select {
+    case rolledBack := <-done:
+        if !rolledBack {
+            t.Fatal("rollback did not find the swapped tree")
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("swap waited for the RAT's lock")
+    }
+
+    stats := rat.ReloadStats()
+    if stats.Swaps != 1 || stats.Rollbacks != 1 || !stats.LastSwap.Equal(start) || !stats.LastRollback.Equal(start) {
+        t.Errorf("ReloadStats() = %+v, want one swap and one rollback at %v", stats, start)
+    }
+}
+
+// exchange sends n packets of a flow one at a time, a millisecond apart, and reports every lost-th one lost and the
+// others received, or none lost if lost is 0
+func exchange(t *testing.T, rat *RAT, c *clock.Manual, flowID uint, n, lost int) {
+    t.Helper()
+    for i := 0; i < n; i++ {
+        var sent collect
+        if err := rat.Send(flowID, 0, &sent, i, math.MaxUint32); err != nil {
+            t.Fatal(err)
+        }
+        if len(sent) != 1 {
+            t.Fatalf("packet %d was not sent", i)
+        }
+        c.Advance(time.Millisecond)
+        if lost > 0 && i%lost == 0 {
+            rat.LosePackets(sent)
+            continue
+        }
+        sent[0].Received = c.Now()
+        rat.ReceivePackets(sent)
+    }
+}
+
+func TestReloaderRollsBackLossIncrease(t *testing.T) {
+    c := clock.NewManual(time.Unix(1000, 0))
+    old := whisker.NewWhiskerTree()
+    old.Root.Whisker = whisker.NewWhisker(0, 1, 1, 0, old.Root.Whisker.Domain)
+    rat := NewRAT(old, false)
+    rat.SetClock(c)
+    flowID := rat.NewFlow()
+    reloader := NewReloader(rat, LossRateCheck(0.05), 10*time.Second, whisker.LoadOptions{Validation: whisker.DefaultValidationOptions()})
+
+    // One packet in a hundred is lost before the swap, and one in five after it
+    exchange(t, rat, c, flowID, 1000, 100)
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 2, 1, 0, tree.Root.Whisker.Domain)
+    reloader.Reload(tree)
+    if rat.Whiskers() != tree || !reloader.Pending() {
+        t.Fatal("the new tree was not swapped in on probation")
+    }
+    exchange(t, rat, c, flowID, 1000, 5)
+
+    // The check waits for the probation period to pass
+    c.Advance(8 * time.Second)
+    if reloader.Check() || rat.Whiskers() != tree {
+        t.Fatal("the swap was rolled back before its probation ended")
+    }
+    c.Advance(2 * time.Second)
+    if !reloader.Check() {
+        t.Fatal("the swap was not rolled back")
+    }
+    if rat.Whiskers() != old || reloader.Pending() {
+        t.Error("the old tree was not put back")
+    }
+    stats := rat.ReloadStats()
+    if stats.Swaps != 1 || stats.Rollbacks != 1 || !stats.LastRollback.Equal(c.Now()) {
+        t.Errorf("ReloadStats() = %+v, want one swap and one rollback at %v", stats, c.Now())
+    }
+
+    // The same loss rate after another swap is accepted
+    reloader.Reload(tree)
+    exchange(t, rat, c, flowID, 1000, 100)
+    c.Advance(10 * time.Second)
+    if reloader.Check() || rat.Whiskers() != tree {
+        t.Error("a swap that kept the loss rate was rolled back")
+    }
+}
+
+func TestLossRateIgnoresPacketsInFlight(t *testing.T) {
+    h := Health{PacketsSent: 100, PacketsReceived: 40, PacketsLost: 10}
+    if got := h.LossRate(); got != 0.2 {
+        t.Errorf("LossRate() = %v with 40 packets received, 10 lost and 50 in flight, want 0.2", got)
+    }
+    if got := (Health{PacketsSent: 100, PacketsReceived: 10}).LossRate(); got != 0 {
+        t.Errorf("LossRate() = %v with nothing lost, want 0", got)
+    }
+}
+
+func TestReloaderRefusesInvalidTree(t *testing.T) {
+    old := whisker.NewWhiskerTree()
+    rat := NewRAT(old, false)
+    reloader := NewReloader(rat, nil, time.Second, whisker.LoadOptions{Strict: true, Validation: whisker.DefaultValidationOptions()})
+
+    // A window multiple of 5 is outside the default bounds
+    filename := filepath.Join(t.TempDir(), "invalid.pb")
+    bad := whisker.NewWhisker(0, 1, 5, 0, memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory()))
+    if err := whisker.SaveWhiskers([]*whisker.Whisker{bad}, filename); err != nil {
+        t.Fatal(err)
+    }
+    var invalid *whisker.ValidationError
+    if err := reloader.LoadFile(filename); !errors.As(err, &invalid) {
+        t.Fatalf("LoadFile returned %v, want a validation error", err)
+    }
+    if rat.Whiskers() != old || reloader.Pending() || rat.ReloadStats().Swaps != 0 {
+        t.Error("the invalid tree was swapped in")
+    }
+}
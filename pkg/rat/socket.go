@@ -0,0 +1,224 @@
+package rat
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "net"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/dna"
+    "github.com/Aanthord/remy-go/pkg/shadow"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// SocketAction is what a SocketController last applied to its connection
+type SocketAction struct {
+    Whisker     *whisker.Whisker // Whisker chosen for the connection's memory, or nil if none covered it
+    Window      uint             // Window of the flow in segments
+    Intersend   float64          // Intersend time of the flow in seconds
+    MSS         uint32           // Segment size the window and pacing rate were converted with
+    PacingRate  uint64           // Bytes per second set with SO_MAX_PACING_RATE, or UnlimitedPacing
+    WindowBytes int              // Bytes set with TCP_WINDOW_CLAMP and TCP_NOTSENT_LOWAT, which do not cap the data in flight
+}
+
+// UnlimitedPacing is the SO_MAX_PACING_RATE that leaves the kernel's pacing alone
+const UnlimitedPacing = math.MaxUint64
+
+// String returns a string representation of the action
+func (a SocketAction) String() string {
+    pacing := "unlimited"
+    if a.PacingRate != UnlimitedPacing {
+        pacing = fmt.Sprintf("%d bytes/s", a.PacingRate)
+    }
+    return fmt.Sprintf("window %d (%d bytes), intersend %g s, pacing %s", a.Window, a.WindowBytes, a.Intersend, pacing)
+}
+
+// socketOptions are the options a SocketController changes, so that they can be put back
+type socketOptions struct {
+    pacingRate  uint64
+    windowClamp int
+    notSentLow  int
+}
+
+// SocketController applies the whiskers of a RAT to an ordinary TCP connection run by the kernel
+// The connection is a flow of the RAT. On every step the controller reads TCP_INFO, updates the flow's memory with
+// the segments delivered and retransmitted since the last step, looks up the whisker and applies its action: the
+// intersend time becomes the socket's SO_MAX_PACING_RATE, and the window becomes the unsent data the socket queues
+// (TCP_NOTSENT_LOWAT) and the window it advertises (TCP_WINDOW_CLAMP). The kernel's congestion control still
+// runs underneath, so the whisker can only slow the connection down, never push it past the kernel's window.
+//
+// Only the pacing rate is enforced. Neither option caps the data in flight: TCP_NOTSENT_LOWAT bounds the data
+// waiting to be sent, and TCP_WINDOW_CLAMP the window the peer may use towards this end, so the data in flight is
+// whatever the kernel's own window allows. To hold it to the whisker's window, write through a Writer from
+// NewTCPWriter, which admits each segment only once the flow's window has room for it.
+type SocketController struct {
+    rat       *RAT
+    conn      *net.TCPConn
+    flowID    uint
+    estimator *shadow.Estimator
+    original  socketOptions
+
+    mu     sync.Mutex
+    last   shadow.Sample
+    action SocketAction
+}
+
+// NewSocketController starts a flow in the RAT for a connection and applies the flow's initial window and pacing
+func NewSocketController(rat *RAT, conn *net.TCPConn) (*SocketController, error) {
+    original, err := getSocketOptions(conn)
+    if err != nil {
+        return nil, err
+    }
+    sample, err := shadow.ReadTCPInfo(conn)
+    if err != nil {
+        return nil, err
+    }
+
+    c := &SocketController{rat: rat, conn: conn, original: original, last: sample}
+    c.flowID = rat.NewFlow()
+    rat.mu.Lock()
+    flow := rat.flows[c.flowID]
+    c.estimator = shadow.NewEstimatorFor(flow.memory)
+    c.estimator.Update(sample)
+    c.action = actionFor(flow, sample.MSS)
+    rat.mu.Unlock()
+
+    if err := c.apply(c.action); err != nil {
+        rat.EndFlow(c.flowID)
+        return nil, err
+    }
+    return c, nil
+}
+
+// FlowID returns the ID of the RAT flow the connection is
+func (c *SocketController) FlowID() uint {
+    return c.flowID
+}
+
+// Action returns what the controller last applied to the connection
+func (c *SocketController) Action() SocketAction {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.action
+}
+
+// Step samples the connection, updates the flow and applies the action of the whisker for its memory
+// If no whisker covers the memory the previous action stays in place, as it does for a RAT's own flows.
+func (c *SocketController) Step() (SocketAction, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    sample, err := shadow.ReadTCPInfo(c.conn)
+    if err != nil {
+        return c.action, err
+    }
+    last := c.last
+    c.last = sample
+
+    rat := c.rat
+    rat.mu.Lock()
+    flow, ok := rat.flows[c.flowID]
+    if !ok {
+        rat.mu.Unlock()
+        return c.action, fmt.Errorf("flow %d has ended", c.flowID)
+    }
+
+    // Count the segments as the packets of the flow, so that the RAT's health reflects the connection
+    sent := uint(sample.DataSegsOut - last.DataSegsOut)
+    delivered := uint(sample.Delivered - last.Delivered)
+    lost := uint(sample.TotalRetrans - last.TotalRetrans)
+    flow.packetsSent += sent
+    flow.packetsReceived += delivered
+    flow.packetsLost += lost
+    rat.packetsSent += sent
+    rat.packetsReceived += delivered
+    rat.packetsLost += lost
+    if sent > 0 {
+        flow.lastSendTime = sample.Time
+    }
+    c.estimator.Update(sample)
+
+    var lookupMemory *dna.Memory
+    if rat.decisions != nil {
+        lookupMemory = flow.memory.ToDNAMemory()
+    }
+    packet := &Packet{SeqNo: int(sample.DataSegsOut), FlowID: c.flowID, Sent: sample.Time, Received: sample.Time}
+    chosen, err := rat.whiskers.Load().FindWhisker(flow.memory)
+    if err != nil {
+        rat.recordDecision(flow, packet, lookupMemory, nil, flow.congestionWindow, err)
+        rat.mu.Unlock()
+        return c.action, nil
+    }
+    if rat.track {
+        chosen.Use()
+    }
+    oldWindow := flow.congestionWindow
+    flow.updateState(chosen)
+    rat.recordDecision(flow, packet, lookupMemory, chosen, oldWindow, nil)
+    rat.observeWindow(c.flowID, flow)
+    action := actionFor(flow, sample.MSS)
+    rat.mu.Unlock()
+
+    if err := c.apply(action); err != nil {
+        return c.action, err
+    }
+    c.action = action
+    return action, nil
+}
+
+// Run steps the controller at the given interval until the context is done or the connection can no longer be read
+func (c *SocketController) Run(ctx context.Context, interval time.Duration) error {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+            if _, err := c.Step(); err != nil {
+                return err
+            }
+        }
+    }
+}
+
+// Close ends the connection's flow and puts back the socket options the controller changed
+// The connection itself is left open.
+func (c *SocketController) Close() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.rat.EndFlow(c.flowID)
+    return setSocketOptions(c.conn, c.original)
+}
+
+// apply sets the socket options for an action
+func (c *SocketController) apply(action SocketAction) error {
+    return setSocketOptions(c.conn, socketOptions{
+        pacingRate:  action.PacingRate,
+        windowClamp: action.WindowBytes,
+        notSentLow:  action.WindowBytes,
+    })
+}
+
+// actionFor converts the window and intersend time of a flow into socket options for the given segment size
+// A window of zero still lets one segment through, as the RAT's own flows send one packet before looking up a whisker
+func actionFor(flow *Flow, mss uint32) SocketAction {
+    action := SocketAction{
+        Whisker:    flow.currentWhisker,
+        Window:     flow.congestionWindow,
+        Intersend:  flow.intersendTime,
+        MSS:        mss,
+        PacingRate: UnlimitedPacing,
+    }
+    if flow.intersendTime > 0 {
+        action.PacingRate = uint64(float64(mss) / flow.intersendTime)
+    }
+    segments := uint64(flow.congestionWindow)
+    if segments == 0 {
+        segments = 1
+    }
+    action.WindowBytes = int(min(segments*uint64(mss), math.MaxInt32))
+    return action
+}
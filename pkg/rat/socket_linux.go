@@ -0,0 +1,72 @@
+package rat
+
+import (
+    "errors"
+    "math"
+    "net"
+
+    "golang.org/x/sys/unix"
+)
+
+// getSocketOptions reads the options a SocketController changes
+func getSocketOptions(conn *net.TCPConn) (socketOptions, error) {
+    raw, err := conn.SyscallConn()
+    if err != nil {
+        return socketOptions{}, err
+    }
+    var options socketOptions
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        var rate int
+        if rate, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE); sockErr != nil {
+            return
+        }
+        // The rate reads back as 32 bits, where all ones means unlimited
+        options.pacingRate = uint64(uint32(rate))
+        if uint32(rate) == math.MaxUint32 {
+            options.pacingRate = UnlimitedPacing
+        }
+        if options.windowClamp, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_WINDOW_CLAMP); sockErr != nil {
+            return
+        }
+        options.notSentLow, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT)
+    })
+    if err != nil {
+        return socketOptions{}, err
+    }
+    return options, sockErr
+}
+
+// setSocketOptions sets the pacing rate, window clamp and unsent low-water mark of a connection
+func setSocketOptions(conn *net.TCPConn, options socketOptions) error {
+    raw, err := conn.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        if sockErr = setPacingRate(int(fd), options.pacingRate); sockErr != nil {
+            return
+        }
+        // The kernel refuses to clear the clamp of a connected socket, but a clamp of 0 is only read back before one is set
+        if options.windowClamp > 0 {
+            if sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_WINDOW_CLAMP, options.windowClamp); sockErr != nil {
+                return
+            }
+        }
+        sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, options.notSentLow)
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
+
+// setPacingRate sets SO_MAX_PACING_RATE, falling back to the 32-bit form older kernels take
+func setPacingRate(fd int, rate uint64) error {
+    err := unix.SetsockoptUint64(fd, unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE, rate)
+    if !errors.Is(err, unix.EINVAL) {
+        return err
+    }
+    return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MAX_PACING_RATE, int(uint32(min(rate, math.MaxUint32))))
+}
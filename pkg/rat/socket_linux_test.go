@@ -0,0 +1,101 @@
+package rat
+
+import (
+    "io"
+    "net"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// tcpPair returns both ends of a TCP connection over loopback
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+    t.Helper()
+    l, err := net.Listen("tcp4", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    defer l.Close()
+    client, err := net.Dial("tcp4", l.Addr().String())
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    server, err := l.Accept()
+    if err != nil {
+        t.Fatalf("failed to accept: %v", err)
+    }
+    t.Cleanup(func() {
+        client.Close()
+        server.Close()
+    })
+    return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+func TestSocketControllerPaces(t *testing.T) {
+    client, server := tcpPair(t)
+    original, err := getSocketOptions(client)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // One segment every 50 ms
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 4, 1, 0.05, tree.Root.Whisker.Domain)
+    tree.Invalidate()
+    c, err := NewSocketController(NewRAT(tree, false), client)
+    if err != nil {
+        t.Fatal(err)
+    }
+    action, err := c.Step()
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := uint64(float64(action.MSS) / 0.05)
+    if action.Whisker != tree.Root.Whisker || action.PacingRate != want {
+        t.Fatalf("Step applied %v, want the root whisker paced at %d bytes/s", action, want)
+    }
+    options, err := getSocketOptions(client)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if options.pacingRate != want || options.notSentLow != action.WindowBytes {
+        t.Errorf("socket has pacing rate %d and low-water mark %d, want %d and %d", options.pacingRate, options.notSentLow, want, action.WindowBytes)
+    }
+
+    // Loopback delivers gigabytes a second unpaced; paced, a second carries little more than the rate allows
+    received := make(chan int64)
+    go func() {
+        n, _ := io.Copy(io.Discard, server)
+        received <- n
+    }()
+    const duration = time.Second
+    client.SetWriteDeadline(time.Now().Add(duration))
+    buf := make([]byte, 64*1024)
+    for {
+        if _, err := client.Write(buf); err != nil {
+            break
+        }
+    }
+    client.CloseWrite()
+    server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+    n := <-received
+    if limit := int64(3 * float64(want) * duration.Seconds()); n > limit {
+        t.Errorf("received %d bytes in %v at a pacing rate of %d bytes/s", n, duration, want)
+    }
+    if n == 0 {
+        t.Error("nothing got through")
+    }
+
+    // Closing puts the pacing rate back
+    if err := c.Close(); err != nil {
+        t.Fatal(err)
+    }
+    options, err = getSocketOptions(client)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if options.pacingRate != original.pacingRate {
+        t.Errorf("pacing rate after Close is %d, want %d", options.pacingRate, original.pacingRate)
+    }
+}
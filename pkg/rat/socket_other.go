@@ -0,0 +1,21 @@
+//go:build !linux
+
+package rat
+
+import (
+    "errors"
+    "net"
+)
+
+// errSocketOptions is returned on platforms without the socket options a SocketController needs
+var errSocketOptions = errors.New("SO_MAX_PACING_RATE, TCP_WINDOW_CLAMP and TCP_NOTSENT_LOWAT are only available on Linux")
+
+// getSocketOptions reads the options a SocketController changes, which only Linux provides
+func getSocketOptions(conn *net.TCPConn) (socketOptions, error) {
+    return socketOptions{}, errSocketOptions
+}
+
+// setSocketOptions sets the options a SocketController changes, which only Linux provides
+func setSocketOptions(conn *net.TCPConn, options socketOptions) error {
+    return errSocketOptions
+}
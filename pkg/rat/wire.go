@@ -0,0 +1,280 @@
+package rat
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "time"
+)
+
+// Wire format of a frame, all fields big-endian:
+//
+//     offset  size  field
+//          0     2  magic, "RA"
+//          2     1  version
+//          3     1  flags
+//          4     2  payload length
+//          6     4  sequence number (signed)
+//         10     4  sender ID (signed)
+//         14     4  flow ID
+//         18     8  send time, nanoseconds since the Unix epoch
+//         26     8  echoed send time, or 0 when the frame echoes nothing
+//         34     4  CRC-32C of the header up to here followed by the payload
+//         38        payload
+//
+// A receiver that does not know a flag ignores it; a frame of another version is refused.
+
+// Constants of the wire format
+const (
+    FrameMagic      = 0x5241 // "RA"
+    FrameVersion    = 1
+    FrameHeaderSize = 38
+    MaxFramePayload = 1<<16 - 1
+)
+
+// Frame flags
+const (
+    FlagEcho = 1 << 0 // The frame answers the packet whose send time it echoes
+    FlagLost = 1 << 1 // With FlagEcho, the packet the frame answers was dropped rather than delivered
+)
+
+// Errors returned for frames that cannot be decoded
+var (
+    ErrFrameMagic    = errors.New("frame does not start with the magic number")
+    ErrFrameChecksum = errors.New("frame checksum mismatch")
+    ErrFrameTooLarge = errors.New("frame payload too large")
+)
+
+// FrameVersionError is returned for frames of a version this decoder does not speak
+type FrameVersionError struct {
+    Version uint8
+}
+
+// Error describes the unsupported version
+func (e FrameVersionError) Error() string {
+    return fmt.Sprintf("unsupported frame version %d (want %d)", e.Version, FrameVersion)
+}
+
+// castagnoli is the CRC-32C table used for frame checksums
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Frame is a packet as it travels between a sender and a receiver
+type Frame struct {
+    Flags   uint8
+    Packet  Packet    // Sequence number, sender, flow and send time; Received is set on decoding, not sent
+    Echo    time.Time // Send time of the packet this frame answers, or zero
+    Payload []byte
+}
+
+// EchoedPacket returns the packet a frame answers, with the echoed send time and the frame's receive time,
+// which is what a RAT expects back
+func (f *Frame) EchoedPacket() *Packet {
+    packet := f.Packet
+    packet.Sent = f.Echo
+    return &packet
+}
+
+// AppendFrame appends the encoding of a frame to b
+func AppendFrame(b []byte, f *Frame) ([]byte, error) {
+    if len(f.Payload) > MaxFramePayload {
+        return b, ErrFrameTooLarge
+    }
+    start := len(b)
+    b = binary.BigEndian.AppendUint16(b, FrameMagic)
+    b = append(b, FrameVersion, f.Flags)
+    b = binary.BigEndian.AppendUint16(b, uint16(len(f.Payload)))
+    b = binary.BigEndian.AppendUint32(b, uint32(int32(f.Packet.SeqNo)))
+    b = binary.BigEndian.AppendUint32(b, uint32(int32(f.Packet.ID)))
+    // uint has no fixed size, so the flow ID is written as 32 bits
+    b = binary.BigEndian.AppendUint32(b, uint32(f.Packet.FlowID))
+    b = binary.BigEndian.AppendUint64(b, uint64(unixNano(f.Packet.Sent)))
+    b = binary.BigEndian.AppendUint64(b, uint64(unixNano(f.Echo)))
+    crc := crc32.Update(crc32.Checksum(b[start:], castagnoli), castagnoli, f.Payload)
+    b = binary.BigEndian.AppendUint32(b, crc)
+    return append(b, f.Payload...), nil
+}
+
+// unixNano returns a time in nanoseconds since the Unix epoch, with the zero time as 0
+func unixNano(t time.Time) int64 {
+    if t.IsZero() {
+        return 0
+    }
+    return t.UnixNano()
+}
+
+// fromUnixNano is the inverse of unixNano
+func fromUnixNano(ns int64) time.Time {
+    if ns == 0 {
+        return time.Time{}
+    }
+    return time.Unix(0, ns)
+}
+
+// frameLength checks the header at the start of b and returns the length of the whole frame
+// b must hold at least FrameHeaderSize bytes
+func frameLength(b []byte) (int, error) {
+    if binary.BigEndian.Uint16(b) != FrameMagic {
+        return 0, ErrFrameMagic
+    }
+    if b[2] != FrameVersion {
+        return 0, FrameVersionError{Version: b[2]}
+    }
+    return FrameHeaderSize + int(binary.BigEndian.Uint16(b[4:])), nil
+}
+
+// parseFrame decodes a complete frame whose header frameLength accepted
+// The payload of the frame aliases b
+func parseFrame(b []byte) (*Frame, error) {
+    crc := crc32.Update(crc32.Checksum(b[:FrameHeaderSize-4], castagnoli), castagnoli, b[FrameHeaderSize:])
+    if binary.BigEndian.Uint32(b[FrameHeaderSize-4:]) != crc {
+        return nil, ErrFrameChecksum
+    }
+    f := &Frame{
+        Flags: b[3],
+        Packet: Packet{
+            SeqNo:  int(int32(binary.BigEndian.Uint32(b[6:]))),
+            ID:     int(int32(binary.BigEndian.Uint32(b[10:]))),
+            FlowID: uint(binary.BigEndian.Uint32(b[14:])),
+            Sent:   fromUnixNano(int64(binary.BigEndian.Uint64(b[18:]))),
+        },
+        Echo: fromUnixNano(int64(binary.BigEndian.Uint64(b[26:]))),
+    }
+    if len(b) > FrameHeaderSize {
+        f.Payload = b[FrameHeaderSize:]
+    }
+    return f, nil
+}
+
+// DecodeFrame decodes the frame at the start of b and returns it with the number of bytes it took
+// io.ErrUnexpectedEOF means b holds only part of a frame. The payload of the frame aliases b.
+func DecodeFrame(b []byte) (*Frame, int, error) {
+    if len(b) < FrameHeaderSize {
+        return nil, 0, io.ErrUnexpectedEOF
+    }
+    n, err := frameLength(b)
+    if err != nil {
+        return nil, 0, err
+    }
+    if len(b) < n {
+        return nil, 0, io.ErrUnexpectedEOF
+    }
+    f, err := parseFrame(b[:n])
+    if err != nil {
+        return nil, 0, err
+    }
+    return f, n, nil
+}
+
+// Decoder reads frames from a byte stream, however the reads split or coalesce them
+// A frame that fails to decode is reported once; the next Decode resynchronizes on the following magic number,
+// so a caller may choose to carry on past corrupt data.
+type Decoder struct {
+    r          io.Reader
+    buf        []byte // Bytes read but not yet decoded are buf[start:]
+    start      int
+    maxPayload int   // Largest payload length accepted before the rest of a frame is read
+    err        error // Error of the underlying reader, returned once the buffer is used up
+}
+
+// NewDecoder creates a decoder that reads from r and accepts payloads of up to MaxFramePayload bytes
+func NewDecoder(r io.Reader) *Decoder {
+    return &Decoder{r: r, buf: make([]byte, 0, 4096), maxPayload: MaxFramePayload}
+}
+
+// SetMaxPayload sets the largest payload the decoder accepts
+// A header whose length exceeds it is refused with ErrFrameTooLarge as soon as it is read, rather than after
+// waiting for the whole frame, so that a corrupt length field costs at most one frame of the largest size
+// the stream carries before the decoder resynchronizes.
+func (d *Decoder) SetMaxPayload(n int) {
+    d.maxPayload = min(max(n, 0), MaxFramePayload)
+}
+
+// Decode returns the next frame, with its packet's Received time set to when its last byte was read
+// It returns io.EOF at the end of the stream and io.ErrUnexpectedEOF if the stream ends inside a frame.
+// The payload of the frame stays valid only until the next call.
+func (d *Decoder) Decode() (*Frame, error) {
+    if err := d.fill(FrameHeaderSize); err != nil {
+        return nil, err
+    }
+    pending := d.buf[d.start:]
+    n, err := frameLength(pending)
+    if err != nil {
+        d.skip()
+        return nil, err
+    }
+    if n-FrameHeaderSize > d.maxPayload {
+        d.skip()
+        return nil, ErrFrameTooLarge
+    }
+    if err := d.fill(n); err != nil {
+        return nil, err
+    }
+    f, err := parseFrame(d.buf[d.start : d.start+n])
+    if err != nil {
+        d.skip()
+        return nil, err
+    }
+    f.Packet.Received = time.Now()
+    d.start += n
+    return f, nil
+}
+
+// skip discards bytes up to the next possible magic number after a frame that failed to decode
+func (d *Decoder) skip() {
+    pending := d.buf[d.start+1:]
+    magic := []byte{FrameMagic >> 8, FrameMagic & 0xff}
+    if i := bytes.Index(pending, magic); i >= 0 {
+        d.start += 1 + i
+        return
+    }
+    // Keep a trailing first byte of the magic, whose second byte may not have arrived yet
+    d.start = len(d.buf)
+    if len(pending) > 0 && pending[len(pending)-1] == magic[0] {
+        d.start--
+    }
+}
+
+// fill reads until at least n undecoded bytes are buffered
+func (d *Decoder) fill(n int) error {
+    for len(d.buf)-d.start < n {
+        if d.err != nil {
+            if d.err == io.EOF && len(d.buf) > d.start {
+                return io.ErrUnexpectedEOF
+            }
+            return d.err
+        }
+
+        // Move the undecoded bytes to the front, growing the buffer if a frame does not fit
+        pending := len(d.buf) - d.start
+        if d.start > 0 {
+            copy(d.buf, d.buf[d.start:])
+            d.buf = d.buf[:pending]
+            d.start = 0
+        }
+        if cap(d.buf) < n {
+            grown := make([]byte, pending, n)
+            copy(grown, d.buf)
+            d.buf = grown
+        }
+
+        m, err := d.r.Read(d.buf[pending:cap(d.buf)])
+        d.buf = d.buf[:pending+m]
+        if err != nil {
+            d.err = err
+        }
+    }
+    return nil
+}
+
+// SendFrame writes a frame to w
+func SendFrame(w io.Writer, f *Frame) error {
+    data, err := AppendFrame(nil, f)
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(data)
+    return err
+}
@@ -0,0 +1,121 @@
+package rat
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "testing"
+    "testing/iotest"
+    "time"
+)
+
+// seedFrames are valid frames the fuzz tests start from
+var seedFrames = []*Frame{
+    {Packet: Packet{SeqNo: 1, ID: 2, FlowID: 3, Sent: time.Unix(0, 1700000000123456789)}},
+    {Flags: FlagEcho, Packet: Packet{SeqNo: -7, ID: 0, FlowID: 1 << 31, Sent: time.Unix(5, 0)}, Echo: time.Unix(4, 999)},
+    {Flags: FlagEcho | FlagLost, Packet: Packet{SeqNo: 1 << 30}, Echo: time.Unix(0, 1)},
+    {Flags: 0xff, Packet: Packet{SeqNo: 42, ID: -1, FlowID: 9}, Payload: []byte("payload")},
+}
+
+// encodeSeed encodes a seed frame
+func encodeSeed(t testing.TB, f *Frame) []byte {
+    b, err := AppendFrame(nil, f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+// checkReencodes fails if a decoded frame does not encode back to the bytes it was decoded from
+func checkReencodes(t *testing.T, f *Frame, encoded []byte) {
+    b, err := AppendFrame(nil, f)
+    if err != nil {
+        t.Fatalf("re-encoding a decoded frame: %v", err)
+    }
+    if !bytes.Equal(b, encoded) {
+        t.Fatalf("frame re-encodes to\n%x\nwant\n%x", b, encoded)
+    }
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+    for _, frame := range seedFrames {
+        b := encodeSeed(f, frame)
+        f.Add(b)
+        f.Add(b[:len(b)-1])
+        f.Add(append(b, b...))
+    }
+    f.Fuzz(func(t *testing.T, b []byte) {
+        frame, n, err := DecodeFrame(b)
+        if err != nil {
+            if frame != nil || n != 0 {
+                t.Fatalf("DecodeFrame returned a frame of %d bytes with error %v", n, err)
+            }
+            return
+        }
+        if n < FrameHeaderSize || n > len(b) {
+            t.Fatalf("DecodeFrame took %d bytes of %d", n, len(b))
+        }
+        checkReencodes(t, frame, b[:n])
+    })
+}
+
+func FuzzDecoder(f *testing.F) {
+    var stream []byte
+    for _, frame := range seedFrames {
+        b := encodeSeed(f, frame)
+        f.Add(b, false, MaxFramePayload)
+        stream = append(stream, b...)
+    }
+    f.Add(stream, true, 0)
+    f.Add(append([]byte{0x52, 0x41, FrameVersion, 0, 0xff, 0xff}, stream...), false, 16)
+    f.Fuzz(func(t *testing.T, data []byte, oneByte bool, maxPayload int) {
+        var r io.Reader = bytes.NewReader(data)
+        if oneByte {
+            r = iotest.OneByteReader(r)
+        }
+        d := NewDecoder(r)
+        d.SetMaxPayload(maxPayload)
+        limit := min(max(maxPayload, 0), MaxFramePayload)
+
+        // Every failed decode discards at least one byte, so the stream must end within this many calls
+        for calls := 0; ; calls++ {
+            if calls > len(data)+1 {
+                t.Fatalf("decoder still going after %d calls on %d bytes", calls, len(data))
+            }
+            frame, err := d.Decode()
+            if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+                return
+            }
+            if err != nil {
+                continue
+            }
+            encoded, err := AppendFrame(nil, frame)
+            if err != nil {
+                t.Fatalf("re-encoding a decoded frame: %v", err)
+            }
+            if !bytes.Contains(data, encoded) {
+                t.Fatalf("decoded frame re-encodes to %x, which is not in the stream", encoded)
+            }
+            if len(frame.Payload) > limit {
+                t.Fatalf("decoded a payload of %d bytes with a limit of %d", len(frame.Payload), limit)
+            }
+        }
+    })
+}
+
+func TestDecoderRefusesLongPayloadEarly(t *testing.T) {
+    good := encodeSeed(t, seedFrames[0])
+    // A corrupt header claiming the largest payload, followed by far fewer bytes than it claims
+    corrupt := append([]byte{0x52, 0x41, FrameVersion, 0, 0xff, 0xff}, make([]byte, FrameHeaderSize-6)...)
+    d := NewDecoder(bytes.NewReader(append(corrupt, good...)))
+    d.SetMaxPayload(0)
+
+    if _, err := d.Decode(); !errors.Is(err, ErrFrameTooLarge) {
+        t.Fatalf("Decode of a corrupt length returned %v, want %v", err, ErrFrameTooLarge)
+    }
+    frame, err := d.Decode()
+    if err != nil {
+        t.Fatalf("Decode after resynchronizing: %v", err)
+    }
+    checkReencodes(t, frame, good)
+}
@@ -0,0 +1,283 @@
+package rat
+
+import (
+    "context"
+    "errors"
+    "io"
+    "math"
+    "net"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/shadow"
+)
+
+// ErrWriterClosed is returned by writes to a Writer that has been closed
+var ErrWriterClosed = errors.New("rat: writer closed")
+
+// ErrWindowClosed is returned by writes to a Writer whose flow has a window of zero and nothing in flight, which no
+// acknowledgment or loss can open again
+var ErrWindowClosed = errors.New("rat: window closed with nothing in flight")
+
+// DefaultLossTimeout is how long a Writer waits for a chunk to be acknowledged before reporting it lost
+const DefaultLossTimeout = time.Second
+
+// Writer paces the writes to an io.Writer with a flow of a RAT
+// Writes are cut into chunks, and every chunk is a packet of the flow: it is only written once the flow's window and
+// intersend time let the RAT send it, and it holds the window until the receiver acknowledges it. Acknowledgments are
+// cumulative byte counts, from the application's own protocol through Acknowledge or from the kernel through
+// NewTCPWriter, so a trained tree can govern an existing stream without changing what goes over it. A chunk that is
+// not acknowledged within the loss timeout is reported lost, so that it no longer holds the window.
+type Writer struct {
+    rat         *RAT
+    w           io.Writer
+    flowID      uint
+    chunkSize   int
+    lossTimeout time.Duration
+    wake      chan struct{} // Signals blocked writes that an acknowledgment arrived or the writer closed
+    writeMu   sync.Mutex    // Serializes writes, so that chunks reach w in order
+
+    mu       sync.Mutex
+    inFlight []writerChunk // Chunks written but not acknowledged, in order
+    admitted *Packet       // Packet the RAT just sent, set by the writer's next hop
+    written  int64         // Bytes written to w so far
+    acked    int64         // Bytes acknowledged so far
+    seq      int           // Sequence number of the next chunk
+    closed   bool
+    stop     func()        // Stops the feedback of a TCP writer
+}
+
+// writerChunk is a chunk in flight
+type writerChunk struct {
+    packet *Packet
+    end    int64 // Stream offset one past the chunk's last byte
+}
+
+// NewWriter starts a flow in the RAT for writes to w, cut into chunks of at most chunkSize bytes
+// The caller reports how much of the stream the receiver has processed with Acknowledge; without acknowledgments,
+// writes stop once the flow's window is full.
+func NewWriter(rat *RAT, w io.Writer, chunkSize int) *Writer {
+    if chunkSize <= 0 {
+        chunkSize = 1
+    }
+    return &Writer{
+        rat:         rat,
+        w:           w,
+        flowID:      rat.NewFlow(),
+        chunkSize:   chunkSize,
+        lossTimeout: DefaultLossTimeout,
+        wake:        make(chan struct{}, 1),
+    }
+}
+
+// NewTCPWriter creates a Writer for a TCP connection whose acknowledgments come from the kernel
+// Chunks are the connection's segments, and TCP_INFO is read at the given interval for the bytes the peer has
+// acknowledged. The stream is counted from the bytes acknowledged when the writer is created, so nothing written
+// before should still be unacknowledged.
+func NewTCPWriter(rat *RAT, conn *net.TCPConn, interval time.Duration) (*Writer, error) {
+    sample, err := shadow.ReadTCPInfo(conn)
+    if err != nil {
+        return nil, err
+    }
+    wr := NewWriter(rat, conn, int(sample.MSS))
+    base := sample.BytesAcked
+
+    ctx, cancel := context.WithCancel(context.Background())
+    wr.stop = cancel
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+            sample, err := shadow.ReadTCPInfo(conn)
+            if err != nil {
+                return
+            }
+            wr.Acknowledge(int64(sample.BytesAcked - base))
+        }
+    }()
+    return wr, nil
+}
+
+// FlowID returns the ID of the RAT flow the writer sends on
+func (wr *Writer) FlowID() uint {
+    return wr.flowID
+}
+
+// SetLossTimeout sets how long a chunk may go unacknowledged before it is reported lost; 0 never reports chunks lost
+func (wr *Writer) SetLossTimeout(timeout time.Duration) {
+    wr.mu.Lock()
+    defer wr.mu.Unlock()
+    wr.lossTimeout = timeout
+}
+
+// Write writes p to the underlying writer chunk by chunk, blocking whenever the flow's window or pacing holds it back
+// It fails with ErrWindowClosed if the window is zero with nothing in flight, since the write would never finish.
+func (wr *Writer) Write(p []byte) (int, error) {
+    wr.writeMu.Lock()
+    defer wr.writeMu.Unlock()
+
+    n := 0
+    timer := time.NewTimer(time.Hour)
+    defer timer.Stop()
+    for len(p) > 0 {
+        size := min(len(p), wr.chunkSize)
+        wait, err := wr.admit(size)
+        if err != nil {
+            return n, err
+        }
+        if wait > 0 {
+            // Without an acknowledgment, only the pacing of the flow can let it send again
+            timer.Reset(wait)
+            select {
+            case <-wr.wake:
+            case <-timer.C:
+            }
+            if !timer.Stop() {
+                select {
+                case <-timer.C:
+                default:
+                }
+            }
+            continue
+        }
+
+        written, err := wr.w.Write(p[:size])
+        n += written
+        p = p[size:]
+        if err != nil {
+            return n, err
+        }
+    }
+    return n, nil
+}
+
+// admit asks the RAT to send a chunk of the given size, and returns how long to wait before asking again if it does not
+// Chunks in flight for longer than the loss timeout are reported lost first.
+func (wr *Writer) admit(size int) (time.Duration, error) {
+    wr.mu.Lock()
+    defer wr.mu.Unlock()
+
+    if wr.closed {
+        return 0, ErrWriterClosed
+    }
+    wr.expire()
+    wr.admitted = nil
+    if err := wr.rat.Send(wr.flowID, 0, writerHop{wr}, wr.seq, math.MaxUint32); err != nil {
+        return 0, err
+    }
+    if wr.admitted == nil {
+        if len(wr.inFlight) == 0 {
+            if flow, ok := wr.rat.Flow(wr.flowID); ok && flow.CongestionWindow() == 0 {
+                return 0, ErrWindowClosed
+            }
+        }
+        wait := time.Hour
+        if until := time.Until(wr.rat.NextEventTime(wr.flowID)); until > 0 {
+            wait = until
+        }
+        // Without an acknowledgment, the oldest chunk in flight frees the window once it is declared lost
+        if len(wr.inFlight) > 0 && wr.lossTimeout > 0 {
+            if until := wr.inFlight[0].packet.Sent.Add(wr.lossTimeout).Sub(wr.rat.now()); until < wait {
+                wait = max(until, time.Millisecond)
+            }
+        }
+        return wait, nil
+    }
+    wr.seq++
+    wr.written += int64(size)
+    wr.inFlight = append(wr.inFlight, writerChunk{packet: wr.admitted, end: wr.written})
+    wr.admitted = nil
+    return 0, nil
+}
+
+// expire reports the chunks in flight for longer than the loss timeout lost, oldest first
+func (wr *Writer) expire() {
+    if wr.lossTimeout <= 0 {
+        return
+    }
+    now := wr.rat.now()
+    var lost []*Packet
+    for len(wr.inFlight) > 0 && now.Sub(wr.inFlight[0].packet.Sent) >= wr.lossTimeout {
+        lost = append(lost, wr.inFlight[0].packet)
+        wr.inFlight = wr.inFlight[1:]
+    }
+    if len(lost) > 0 {
+        wr.rat.LosePackets(lost)
+    }
+}
+
+// writerHop is the next hop of a writer's RAT, which only records the packet; the chunk is written once the RAT's
+// lock is released, so that a slow writer never holds up the RAT's other flows
+type writerHop struct {
+    wr *Writer
+}
+
+// Accept records the packet the RAT sent for the writer's next chunk
+func (h writerHop) Accept(packet *Packet) error {
+    h.wr.admitted = packet
+    return nil
+}
+
+// Acknowledge reports that the receiver has processed the first total bytes of the stream
+// Every chunk that ends within them is a packet received by the flow, timestamped now. Counts that do not move the
+// acknowledged offset forward are ignored.
+func (wr *Writer) Acknowledge(total int64) {
+    wr.mu.Lock()
+    if total <= wr.acked || wr.closed {
+        wr.mu.Unlock()
+        return
+    }
+    wr.acked = total
+    now := wr.rat.now()
+    var packets []*Packet
+    for len(wr.inFlight) > 0 && wr.inFlight[0].end <= total {
+        packet := wr.inFlight[0].packet
+        packet.Received = now
+        packets = append(packets, packet)
+        wr.inFlight = wr.inFlight[1:]
+    }
+    wr.mu.Unlock()
+
+    if len(packets) > 0 {
+        wr.rat.ReceivePackets(packets)
+        wr.signal()
+    }
+}
+
+// Acknowledged returns the number of bytes acknowledged so far
+func (wr *Writer) Acknowledged() int64 {
+    wr.mu.Lock()
+    defer wr.mu.Unlock()
+    return wr.acked
+}
+
+// Close ends the writer's flow and fails blocked and later writes; the underlying writer is left open
+func (wr *Writer) Close() error {
+    wr.mu.Lock()
+    if wr.closed {
+        wr.mu.Unlock()
+        return nil
+    }
+    wr.closed = true
+    if wr.stop != nil {
+        wr.stop()
+    }
+    wr.mu.Unlock()
+
+    wr.rat.EndFlow(wr.flowID)
+    wr.signal()
+    return nil
+}
+
+// signal wakes a blocked write
+func (wr *Writer) signal() {
+    select {
+    case wr.wake <- struct{}{}:
+    default:
+    }
+}
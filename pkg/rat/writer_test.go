@@ -0,0 +1,172 @@
+package rat
+
+import (
+    "bytes"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// syncBuffer is a buffer that writes and reads can share
+type syncBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Len()
+}
+
+// fixedRAT returns a RAT whose only whisker keeps the window at the given size and sets the intersend time
+func fixedRAT(window int, intersend float64) *RAT {
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, window, 0, intersend, tree.Root.Whisker.Domain)
+    tree.Invalidate()
+    return NewRAT(tree, false)
+}
+
+// writeAsync starts a write and returns a channel that receives its result
+func writeAsync(wr *Writer, p []byte) chan error {
+    done := make(chan error, 1)
+    go func() {
+        n, err := wr.Write(p)
+        if err == nil && n != len(p) {
+            err = errors.New("short write")
+        }
+        done <- err
+    }()
+    return done
+}
+
+// waitFor polls until the condition holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestWriterPaces(t *testing.T) {
+    var out syncBuffer
+    wr := NewWriter(fixedRAT(100, 0.02), &out, 10)
+    defer wr.Close()
+
+    // Five chunks, 20 ms apart
+    start := time.Now()
+    if _, err := wr.Write(make([]byte, 50)); err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+        t.Errorf("five chunks paced 20 ms apart took %v", elapsed)
+    }
+    if out.Len() != 50 {
+        t.Errorf("wrote %d bytes, want 50", out.Len())
+    }
+}
+
+func TestWriterAcknowledgeUnblocksWrite(t *testing.T) {
+    var out syncBuffer
+    wr := NewWriter(fixedRAT(2, 0), &out, 10)
+    wr.SetLossTimeout(0)
+    defer wr.Close()
+
+    // Two chunks fill the window, and the third waits for an acknowledgment
+    done := writeAsync(wr, make([]byte, 30))
+    waitFor(t, "the window to fill", func() bool { return out.Len() == 20 })
+    select {
+    case err := <-done:
+        t.Fatalf("write finished with a full window: %v", err)
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    wr.Acknowledge(10)
+    if err := <-done; err != nil {
+        t.Fatal(err)
+    }
+    if out.Len() != 30 || wr.Acknowledged() != 10 {
+        t.Errorf("wrote %d bytes with %d acknowledged, want 30 and 10", out.Len(), wr.Acknowledged())
+    }
+    flow, _ := wr.rat.Flow(wr.FlowID())
+    if flow.PacketsSent() != 3 || flow.PacketsReceived() != 1 {
+        t.Errorf("flow sent %d and received %d packets, want 3 and 1", flow.PacketsSent(), flow.PacketsReceived())
+    }
+}
+
+func TestWriterCloseUnblocksWrite(t *testing.T) {
+    var out syncBuffer
+    wr := NewWriter(fixedRAT(1, 0), &out, 10)
+    wr.SetLossTimeout(0)
+
+    done := writeAsync(wr, make([]byte, 20))
+    waitFor(t, "the window to fill", func() bool { return out.Len() == 10 })
+    wr.Close()
+    select {
+    case err := <-done:
+        if !errors.Is(err, ErrWriterClosed) {
+            t.Errorf("pending write returned %v, want %v", err, ErrWriterClosed)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("Close did not unblock the pending write")
+    }
+    if _, err := wr.Write([]byte("more")); !errors.Is(err, ErrWriterClosed) {
+        t.Errorf("write after Close returned %v, want %v", err, ErrWriterClosed)
+    }
+}
+
+func TestWriterLosesUnacknowledgedChunks(t *testing.T) {
+    var out syncBuffer
+    wr := NewWriter(fixedRAT(2, 0), &out, 10)
+    wr.SetLossTimeout(50 * time.Millisecond)
+    defer wr.Close()
+
+    // Nothing is ever acknowledged, so the window only opens as chunks are declared lost
+    start := time.Now()
+    select {
+    case err := <-writeAsync(wr, make([]byte, 40)):
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("write hung without acknowledgments")
+    }
+    if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+        t.Errorf("write of two windows took %v, less than the loss timeout", elapsed)
+    }
+    flow, _ := wr.rat.Flow(wr.FlowID())
+    if flow.PacketsLost() != 2 {
+        t.Errorf("flow lost %d packets, want 2", flow.PacketsLost())
+    }
+}
+
+func TestWriterFailsOnClosedWindow(t *testing.T) {
+    var out syncBuffer
+    wr := NewWriter(fixedRAT(0, 0), &out, 10)
+    defer wr.Close()
+
+    select {
+    case err := <-writeAsync(wr, make([]byte, 10)):
+        if !errors.Is(err, ErrWindowClosed) {
+            t.Errorf("write with a window of zero returned %v, want %v", err, ErrWindowClosed)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("write with a window of zero hung")
+    }
+    if out.Len() != 0 {
+        t.Errorf("wrote %d bytes with a window of zero", out.Len())
+    }
+}
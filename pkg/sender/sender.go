@@ -2,30 +2,34 @@ package sender
 
 import (
     "fmt"   // Import the fmt package for formatted I/O
+    "math"  // Import the math package for the unlimited packet cap
     "time"  // Import the time package for time-related operations
 
-    "github.com/Aanthord/remy-go/pkg/memory" // Import the memory package from the remy project
-    "github.com/Aanthord/remy-go/pkg/rat"    // Import the rat package from the remy project
+    "github.com/Aanthord/remy-go/pkg/rat" // Import the rat package from the remy project
 )
 
 // Sender represents a sender in the network
 type Sender struct {
     ID            int              // Unique identifier of the sender
     Rat           *rat.RAT         // Pointer to the RAT (Remy Augmented TCP) congestion control algorithm instance
-    SendRate      float64          // Current sending rate of the sender
-    CongestionWnd int              // Current congestion window size
+    FlowID        uint             // Flow of the RAT the sender's packets belong to
+    SendRate      float64          // Current sending rate of the sender in packets per second, or 0 if unpaced
+    CongestionWnd int              // Current congestion window size in packets
     LastSendTime  time.Time        // Timestamp of the last packet sent
     LastAckTime   time.Time        // Timestamp of the last acknowledgment received
     BytesSent     int              // Total number of bytes sent by the sender
     BytesAcked    int              // Total number of bytes acknowledged by the receiver
     SeqNo         int              // Sequence number of the last sent packet
+    Timeouts      int              // Number of timeouts that occurred
+    outstanding   []*rat.Packet    // Packets sent but not yet acknowledged or lost
 }
 
-// NewSender is a constructor that creates a new instance of the Sender struct
+// NewSender is a constructor that creates a new instance of the Sender struct, as a new flow of the RAT
 func NewSender(id int, rat *rat.RAT) *Sender {
-    return &Sender{
+    s := &Sender{
         ID:            id,
         Rat:           rat,
+        FlowID:        rat.NewFlow(),
         SendRate:      0,
         CongestionWnd: 1,
         LastSendTime:  time.Now(),
@@ -34,25 +38,30 @@ func NewSender(id int, rat *rat.RAT) *Sender {
         BytesAcked:    0,
         SeqNo:         0,
     }
+    s.UpdateSendRate()
+    s.UpdateCongestionWnd()
+    return s
 }
 
-// Send sends data from the sender
+// Send sends data from the sender if the RAT's window and intersend time allow it
 func (s *Sender) Send(data []byte) error {
-    // Check if it's too early to send based on the current send rate
-    if time.Since(s.LastSendTime) < time.Duration(1.0/s.SendRate)*time.Second {
-        return fmt.Errorf("Sender %d: Too early to send, rate limiting", s.ID)
+    hop := &senderHop{}
+    if err := s.Rat.Send(s.FlowID, s.ID, hop, s.SeqNo+1, math.MaxUint32); err != nil {
+        return fmt.Errorf("Sender %d: %v", s.ID, err)
     }
-
-    // Check if the congestion window limit is reached
-    if s.BytesSent-s.BytesAcked >= s.CongestionWnd {
-        return fmt.Errorf("Sender %d: Congestion window limit reached", s.ID)
+    if hop.packet == nil {
+        // Check if the congestion window limit is reached, otherwise it is too early to send
+        if len(s.outstanding) >= s.CongestionWnd {
+            return fmt.Errorf("Sender %d: Congestion window limit reached", s.ID)
+        }
+        return fmt.Errorf("Sender %d: Too early to send, rate limiting", s.ID)
     }
 
     // Send the data
     s.SeqNo++
     s.BytesSent += len(data)
-    s.LastSendTime = time.Now()
-    s.Rat.OnPacketSent(s.SeqNo)
+    s.LastSendTime = hop.packet.Sent
+    s.outstanding = append(s.outstanding, hop.packet)
 
     return nil
 }
@@ -61,27 +70,65 @@ func (s *Sender) Send(data []byte) error {
 func (s *Sender) OnAck(ack *Ack) {
     s.BytesAcked += ack.BytesAcked
     s.LastAckTime = time.Now()
-    rtt := time.Since(ack.SentTime)
-    s.Rat.OnPacketAcked(ack.SeqNo, rtt)
+
+    packet := &rat.Packet{SeqNo: ack.SeqNo, ID: s.ID, FlowID: s.FlowID, Sent: ack.SentTime}
+    for i, p := range s.outstanding {
+        if p.SeqNo == ack.SeqNo {
+            packet = p
+            s.outstanding = append(s.outstanding[:i], s.outstanding[i+1:]...)
+            break
+        }
+    }
+    packet.Received = s.LastAckTime
+    s.Rat.ReceivePackets([]*rat.Packet{packet})
     s.UpdateSendRate()
     s.UpdateCongestionWnd()
 }
 
-// OnTimeout is called when a timeout occurs, indicating a packet loss
+// OnTimeout is called when a timeout occurs, indicating that every outstanding packet was lost
 func (s *Sender) OnTimeout() {
-    s.Rat.OnPacketLost()
+    s.Timeouts++
+    s.Rat.LosePackets(s.outstanding)
+    s.outstanding = nil
     s.UpdateSendRate()
     s.UpdateCongestionWnd()
 }
 
-// UpdateSendRate updates the send rate of the sender
+// Outstanding returns the number of packets sent but not yet acknowledged or lost
+func (s *Sender) Outstanding() int {
+    return len(s.outstanding)
+}
+
+// UpdateSendRate updates the send rate of the sender from the intersend time of its flow
 func (s *Sender) UpdateSendRate() {
-    s.SendRate = s.Rat.GetSendRate()
+    flow, ok := s.Rat.Flow(s.FlowID)
+    if !ok {
+        return
+    }
+    s.SendRate = 0
+    if flow.Intersend() > 0 {
+        s.SendRate = 1 / flow.Intersend()
+    }
 }
 
-// UpdateCongestionWnd updates the congestion window size of the sender
+// UpdateCongestionWnd updates the congestion window size of the sender from the window of its flow
 func (s *Sender) UpdateCongestionWnd() {
-    s.CongestionWnd = s.Rat.GetCongestionWindow()
+    flow, ok := s.Rat.Flow(s.FlowID)
+    if !ok {
+        return
+    }
+    s.CongestionWnd = int(flow.CongestionWindow())
+}
+
+// senderHop is the next hop of a sender's RAT, which only records the packet the RAT let through
+type senderHop struct {
+    packet *rat.Packet
+}
+
+// Accept records the packet
+func (h *senderHop) Accept(packet *rat.Packet) error {
+    h.packet = packet
+    return nil
 }
 
 // Ack represents an acknowledgment received by the sender
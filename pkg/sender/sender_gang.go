@@ -5,7 +5,8 @@ import (
     "sync"  // Import the sync package for synchronization primitives
     "time"  // Import the time package for time-related operations
 
-    "github.com/Aanthord/remy-go/pkg/rat" // Import the rat package from the remy project
+    "github.com/Aanthord/remy-go/pkg/rat"     // Import the rat package from the remy project
+    "github.com/Aanthord/remy-go/pkg/whisker" // Import the whisker package from the remy project
 )
 
 // SenderGang represents a group of senders
@@ -80,8 +81,10 @@ func (sg *SenderGang) OnTimeout(senderId int) {
     }
 }
 
-// SenderFactory is an example implementation of the sender factory
-func SenderFactory(id int) *Sender {
-    rat := rat.NewRAT(nil) // Initialize with nil whiskers
-    return NewSender(id, rat)
+// NewSenderFactory returns a sender factory whose senders are flows of a single RAT running the given whiskers
+func NewSenderFactory(whiskers *whisker.WhiskerTree) func(id int) *Sender {
+    r := rat.NewRAT(whiskers, false)
+    return func(id int) *Sender {
+        return NewSender(id, r)
+    }
 }
@@ -0,0 +1,98 @@
+package sender
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/rat"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// newTestSender creates a sender on a RAT whose only whisker adds increment to the window and sets the intersend time
+func newTestSender(increment int, intersend float64) *Sender {
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, increment, 1, intersend, tree.Root.Whisker.Domain)
+    tree.Invalidate()
+    return NewSender(7, rat.NewRAT(tree, false))
+}
+
+func TestSendRespectsWindow(t *testing.T) {
+    s := newTestSender(2, 0)
+    for i := 1; i <= 2; i++ {
+        if err := s.Send([]byte("data")); err != nil {
+            t.Fatalf("send %d: %v", i, err)
+        }
+        if s.SeqNo != i || s.Outstanding() != i || s.BytesSent != 4*i {
+            t.Fatalf("after send %d: sequence number %d, %d outstanding, %d bytes sent", i, s.SeqNo, s.Outstanding(), s.BytesSent)
+        }
+    }
+    err := s.Send([]byte("data"))
+    if err == nil || !strings.Contains(err.Error(), "Congestion window limit reached") {
+        t.Fatalf("send beyond the window returned %v", err)
+    }
+    if s.SeqNo != 2 || s.Outstanding() != 2 {
+        t.Errorf("a refused send changed the sender: sequence number %d, %d outstanding", s.SeqNo, s.Outstanding())
+    }
+    flow, _ := s.Rat.Flow(s.FlowID)
+    if flow.PacketsSent() != 2 {
+        t.Errorf("flow sent %d packets, want 2", flow.PacketsSent())
+    }
+}
+
+func TestSendRespectsIntersend(t *testing.T) {
+    s := newTestSender(4, 10)
+    if err := s.Send([]byte("data")); err != nil {
+        t.Fatal(err)
+    }
+    err := s.Send([]byte("data"))
+    if err == nil || !strings.Contains(err.Error(), "Too early to send") {
+        t.Fatalf("send within the intersend time returned %v", err)
+    }
+}
+
+func TestOnAckReleasesWindow(t *testing.T) {
+    s := newTestSender(2, 0)
+    s.Send([]byte("one"))
+    sent := s.outstanding[0].Sent
+    s.Send([]byte("two"))
+
+    s.OnAck(&Ack{SeqNo: 1, BytesAcked: 3})
+    if s.Outstanding() != 1 || s.outstanding[0].SeqNo != 2 {
+        t.Fatalf("outstanding after the first ACK: %v", s.outstanding)
+    }
+    if s.BytesAcked != 3 || s.LastAckTime.Before(sent) {
+        t.Errorf("BytesAcked = %d, LastAckTime = %v", s.BytesAcked, s.LastAckTime)
+    }
+    // The lookup after the ACK adds the increment to the window of 2
+    if s.CongestionWnd != 4 {
+        t.Errorf("CongestionWnd = %d after an ACK, want 4", s.CongestionWnd)
+    }
+    flow, _ := s.Rat.Flow(s.FlowID)
+    if flow.PacketsReceived() != 1 {
+        t.Errorf("flow received %d packets, want 1", flow.PacketsReceived())
+    }
+    for i := 0; i < 3; i++ {
+        if err := s.Send([]byte("more")); err != nil {
+            t.Fatalf("send %d after the window grew: %v", i, err)
+        }
+    }
+}
+
+func TestOnTimeoutLosesOutstanding(t *testing.T) {
+    s := newTestSender(2, 0)
+    s.Send([]byte("one"))
+    s.Send([]byte("two"))
+
+    s.OnTimeout()
+    if s.Outstanding() != 0 || s.Timeouts != 1 {
+        t.Fatalf("after a timeout: %d outstanding, %d timeouts", s.Outstanding(), s.Timeouts)
+    }
+    flow, _ := s.Rat.Flow(s.FlowID)
+    if flow.PacketsLost() != 2 {
+        t.Errorf("flow lost %d packets, want 2", flow.PacketsLost())
+    }
+    // Lost packets no longer hold the window
+    if err := s.Send([]byte("three")); err != nil {
+        t.Errorf("send after a timeout: %v", err)
+    }
+}
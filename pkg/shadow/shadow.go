@@ -0,0 +1,296 @@
+package shadow
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Sample is what TCP_INFO reports about a connection at one moment
+type Sample struct {
+    Time         time.Time
+    MSS          uint32        // Sender maximum segment size in bytes
+    RTT          time.Duration // Smoothed round-trip time
+    MinRTT       time.Duration // Minimum round-trip time
+    DeliveryRate uint64        // Latest delivery rate estimate in bytes per second
+    PacingRate   uint64        // Current pacing rate in bytes per second
+    Cwnd         uint32        // Congestion window in segments
+    Delivered    uint32        // Segments delivered so far
+    DataSegsOut  uint32        // Data segments sent so far, retransmissions included
+    TotalRetrans uint32        // Segments retransmitted so far
+    Lost         uint32        // Segments currently believed lost
+    BytesAcked   uint64        // Bytes acknowledged so far
+    CAState      uint8         // State of the kernel's congestion control, such as open, recovery or loss
+}
+
+// Estimator turns successive samples of a connection into the Memory a RAT controlling it would have
+// Every segment delivered between two samples is an acknowledgment, spaced by the kernel's delivery rate and
+// sent as far apart as the segments that went out, and every retransmission is a lost packet.
+type Estimator struct {
+    memory  *memory.Memory
+    last    Sample
+    started bool
+}
+
+// NewEstimator creates an estimator whose memory starts empty, as a new flow's does
+func NewEstimator() *Estimator {
+    return NewEstimatorFor(memory.NewMemory())
+}
+
+// NewEstimatorFor creates an estimator that updates an existing memory, such as that of a RAT's flow
+func NewEstimatorFor(m *memory.Memory) *Estimator {
+    return &Estimator{memory: m}
+}
+
+// Memory returns the memory built so far
+func (e *Estimator) Memory() *memory.Memory {
+    return e.memory
+}
+
+// Update applies a new sample to the memory
+// The first sample only sets the reference counters.
+func (e *Estimator) Update(s Sample) *memory.Memory {
+    if !e.started {
+        e.last, e.started = s, true
+        return e.memory
+    }
+    last := e.last
+    e.last = s
+
+    elapsed := s.Time.Sub(last.Time)
+    delivered := s.Delivered - last.Delivered
+    sent := s.DataSegsOut - last.DataSegsOut
+    retransmitted := s.TotalRetrans - last.TotalRetrans
+    if elapsed <= 0 {
+        return e.memory
+    }
+
+    rtt := milliseconds(s.RTT)
+    minRTT := milliseconds(s.MinRTT)
+    if minRTT == 0 || minRTT > rtt {
+        minRTT = rtt
+    }
+    sentAt := s.Time.Add(-s.RTT)
+
+    if delivered > 0 {
+        recvInterval := elapsed / time.Duration(delivered)
+        if s.DeliveryRate > 0 && s.MSS > 0 {
+            recvInterval = time.Duration(float64(s.MSS) / float64(s.DeliveryRate) * float64(time.Second))
+        }
+        sendInterval := recvInterval
+        if sent > 0 {
+            sendInterval = elapsed / time.Duration(sent)
+        }
+        e.memory.Observe(&memory.Observation{
+            Sent:         sentAt,
+            Received:     s.Time,
+            LastSent:     sentAt.Add(-sendInterval),
+            LastReceived: s.Time.Add(-recvInterval),
+            RTT:          rtt,
+            MinRTT:       minRTT,
+        }, int(delivered))
+    }
+    if retransmitted > 0 {
+        e.memory.Observe(&memory.Observation{
+            Sent:   sentAt,
+            MinRTT: minRTT,
+            Lost:   true,
+        }, int(retransmitted))
+    }
+    return e.memory
+}
+
+// milliseconds converts a duration to Remy's tick unit
+func milliseconds(d time.Duration) memory.DataType {
+    return memory.DataType(d) / memory.DataType(time.Millisecond)
+}
+
+// Record compares what the tree would do on a connection with what the kernel does
+type Record struct {
+    Time             time.Time          `json:"time"`
+    Conn             string             `json:"conn"`
+    Memory           map[string]float64 `json:"memory"`
+    Whisker          int                `json:"whisker"`            // Index of the chosen whisker in the tree's order, or -1 for none or the placeholder root
+    Domain           string             `json:"domain,omitempty"`   // Domain of the chosen whisker
+    KernelWindow     uint32             `json:"kernel_window"`      // Congestion window of the kernel in segments
+    TreeWindow       uint               `json:"tree_window"`        // Window the whisker sets from the kernel's
+    KernelPacingRate uint64             `json:"kernel_pacing_rate"` // Bytes per second
+    TreePacingRate   float64            `json:"tree_pacing_rate"`   // Bytes per second, or 0 when the whisker does not pace
+    RTT              float64            `json:"rtt_ms"`             // Smoothed round-trip time in milliseconds
+    MinRTT           float64            `json:"min_rtt_ms"`         // Minimum round-trip time in milliseconds
+    DeliveryRate     uint64             `json:"delivery_rate"`      // Bytes per second
+    Lost             uint32             `json:"lost"`               // Segments the kernel believes lost
+    CAState          uint8              `json:"ca_state"`           // State of the kernel's congestion control
+    Error            string             `json:"error,omitempty"`    // Why no whisker was chosen
+}
+
+// WindowRatio returns the tree's window as a multiple of the kernel's, or 0 if the kernel's is 0
+func (r Record) WindowRatio() float64 {
+    if r.KernelWindow == 0 {
+        return 0
+    }
+    return float64(r.TreeWindow) / float64(r.KernelWindow)
+}
+
+// String returns a one-line summary of the record
+func (r Record) String() string {
+    if r.Error != "" {
+        return fmt.Sprintf("%s: %s: %v", r.Conn, r.Error, r.Memory)
+    }
+    return fmt.Sprintf("%s: whisker %d, window kernel %d tree %d, pacing kernel %d tree %.0f bytes/s, rtt %.3g/%.3g ms",
+        r.Conn, r.Whisker, r.KernelWindow, r.TreeWindow, r.KernelPacingRate, r.TreePacingRate, r.RTT, r.MinRTT)
+}
+
+// Compare looks up the whisker for a memory and compares its action on the kernel's state with the kernel's own
+// index maps every whisker of the tree to its position, as Index builds it
+func Compare(tree *whisker.WhiskerTree, index map[*whisker.Whisker]int, conn string, m *memory.Memory, s Sample) Record {
+    record := Record{
+        Time:             s.Time,
+        Conn:             conn,
+        Memory:           make(map[string]float64),
+        Whisker:          -1,
+        KernelWindow:     s.Cwnd,
+        KernelPacingRate: s.PacingRate,
+        RTT:              float64(milliseconds(s.RTT)),
+        MinRTT:           float64(milliseconds(s.MinRTT)),
+        DeliveryRate:     s.DeliveryRate,
+        Lost:             s.Lost,
+        CAState:          s.CAState,
+    }
+    for i, name := range memory.SignalNames() {
+        record.Memory[name] = float64(m.Get(memory.SignalID(i)))
+    }
+
+    chosen, err := tree.FindWhisker(m)
+    if err != nil {
+        record.Error = err.Error()
+        return record
+    }
+    if i, ok := index[chosen]; ok {
+        record.Whisker = i
+    }
+    record.Domain = fmt.Sprint(chosen.Domain)
+    record.TreeWindow = chosen.Window(uint(s.Cwnd))
+    if chosen.Intersend > 0 {
+        record.TreePacingRate = float64(s.MSS) / chosen.Intersend
+    }
+    return record
+}
+
+// Index maps every whisker of a tree to its position in the tree's order
+func Index(tree *whisker.WhiskerTree) map[*whisker.Whisker]int {
+    index := make(map[*whisker.Whisker]int)
+    for i, w := range tree.Whiskers() {
+        index[w] = i
+    }
+    return index
+}
+
+// NewJSONReporter returns a report function that writes every record as a JSON line to w
+// Records that fail to be written are reported to onError, if it is not nil
+func NewJSONReporter(w io.Writer, onError func(error)) func(Record) {
+    var mu sync.Mutex
+    encoder := json.NewEncoder(w)
+    return func(r Record) {
+        mu.Lock()
+        defer mu.Unlock()
+        if err := encoder.Encode(r); err != nil && onError != nil {
+            onError(fmt.Errorf("failed to write shadow record: %w", err))
+        }
+    }
+}
+
+// Monitor polls the TCP_INFO of live connections and reports what the tree would do on each of them
+// The connections are left alone: the kernel's congestion control keeps running them, which is what makes this
+// a shadow evaluation.
+type Monitor struct {
+    tree     *whisker.WhiskerTree
+    index    map[*whisker.Whisker]int
+    interval time.Duration
+    report   func(Record)
+
+    mu    sync.Mutex
+    conns map[string]*watched
+}
+
+// watched is a connection under the monitor
+type watched struct {
+    conn      syscall.Conn
+    estimator *Estimator
+}
+
+// NewMonitor creates a monitor that polls at the given interval and hands every record to report
+func NewMonitor(tree *whisker.WhiskerTree, interval time.Duration, report func(Record)) *Monitor {
+    return &Monitor{
+        tree:     tree,
+        index:    Index(tree),
+        interval: interval,
+        report:   report,
+        conns:    make(map[string]*watched),
+    }
+}
+
+// Add starts monitoring a TCP connection under the given name
+// Its memory starts empty, as a new flow's does, however long the connection has been open
+func (m *Monitor) Add(name string, conn syscall.Conn) error {
+    s, err := ReadTCPInfo(conn)
+    if err != nil {
+        return err
+    }
+    w := &watched{conn: conn, estimator: NewEstimator()}
+    w.estimator.Update(s)
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.conns[name] = w
+    return nil
+}
+
+// Remove stops monitoring a connection
+func (m *Monitor) Remove(name string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.conns, name)
+}
+
+// Len returns the number of connections being monitored
+func (m *Monitor) Len() int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.conns)
+}
+
+// Poll samples every connection once and reports on it; connections that can no longer be read are dropped
+func (m *Monitor) Poll() {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for name, w := range m.conns {
+        s, err := ReadTCPInfo(w.conn)
+        if err != nil {
+            delete(m.conns, name)
+            continue
+        }
+        m.report(Compare(m.tree, m.index, name, w.estimator.Update(s), s))
+    }
+}
+
+// Run polls at the monitor's interval until the context is done
+func (m *Monitor) Run(ctx context.Context) {
+    ticker := time.NewTicker(m.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            m.Poll()
+        }
+    }
+}
@@ -0,0 +1,40 @@
+package shadow
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "testing"
+)
+
+// failingWriter is a writer that always fails
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+    return 0, errors.New("disk full")
+}
+
+func TestJSONReporter(t *testing.T) {
+    var buf bytes.Buffer
+    report := NewJSONReporter(&buf, func(err error) {
+        t.Errorf("unexpected error %v", err)
+    })
+    report(Record{Conn: "a", Whisker: 3})
+    var r Record
+    if err := json.Unmarshal(buf.Bytes(), &r); err != nil || r.Conn != "a" || r.Whisker != 3 {
+        t.Errorf("wrote %q, decoded as %+v, %v", buf.String(), r, err)
+    }
+
+    var errs []error
+    report = NewJSONReporter(failingWriter{}, func(err error) {
+        errs = append(errs, err)
+    })
+    report(Record{})
+    report(Record{})
+    if len(errs) != 2 {
+        t.Errorf("reported %d errors for 2 failed writes", len(errs))
+    }
+
+    // Without a handler, failed writes are dropped quietly
+    NewJSONReporter(failingWriter{}, nil)(Record{})
+}
@@ -0,0 +1,58 @@
+package shadow
+
+import (
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// ReadTCPInfo samples the TCP_INFO of a TCP connection
+// Fields that an older kernel does not report are zero.
+func ReadTCPInfo(conn syscall.Conn) (Sample, error) {
+    raw, err := conn.SyscallConn()
+    if err != nil {
+        return Sample{}, err
+    }
+    var info *unix.TCPInfo
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+    })
+    if err != nil {
+        return Sample{}, err
+    }
+    if sockErr != nil {
+        return Sample{}, sockErr
+    }
+    return Sample{
+        Time:         time.Now(),
+        MSS:          info.Snd_mss,
+        RTT:          time.Duration(info.Rtt) * time.Microsecond,
+        MinRTT:       time.Duration(info.Min_rtt) * time.Microsecond,
+        DeliveryRate: info.Delivery_rate,
+        PacingRate:   info.Pacing_rate,
+        Cwnd:         info.Snd_cwnd,
+        Delivered:    info.Delivered,
+        DataSegsOut:  info.Data_segs_out,
+        TotalRetrans: info.Total_retrans,
+        Lost:         info.Lost,
+        BytesAcked:   info.Bytes_acked,
+        CAState:      info.Ca_state,
+    }, nil
+}
+
+// SetCongestion returns a dialer or listener Control function that sets the kernel congestion control of new
+// sockets, such as cubic or bbr, so that a tree can be shadowed against a given algorithm
+func SetCongestion(name string) func(network, address string, raw syscall.RawConn) error {
+    return func(network, address string, raw syscall.RawConn) error {
+        var sockErr error
+        err := raw.Control(func(fd uintptr) {
+            sockErr = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, name)
+        })
+        if err != nil {
+            return err
+        }
+        return sockErr
+    }
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package shadow
+
+import (
+    "errors"
+    "syscall"
+)
+
+// ReadTCPInfo samples the TCP_INFO of a TCP connection, which only Linux provides
+func ReadTCPInfo(conn syscall.Conn) (Sample, error) {
+    return Sample{}, errors.New("TCP_INFO is only available on Linux")
+}
+
+// SetCongestion returns a Control function that fails, as only Linux lets sockets choose their congestion control
+func SetCongestion(name string) func(network, address string, raw syscall.RawConn) error {
+    return func(network, address string, raw syscall.RawConn) error {
+        return errors.New("TCP_CONGESTION is only available on Linux")
+    }
+}
@@ -0,0 +1,721 @@
+package transport
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/qlog"
+    "github.com/Aanthord/remy-go/pkg/rat"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// Config controls the connections made by Dial and Listen
+// Zero fields take the defaults of DefaultConfig
+type Config struct {
+    Whiskers         *whisker.WhiskerTree // Tree controlling every connection, each in a RAT of its own
+    RAT              *rat.RAT             // RAT shared by the connections, each a flow of its own; takes precedence over Whiskers
+    MSS              int                  // Largest payload of a data segment in bytes
+    SendBuffer       int                  // Largest number of unacknowledged segments before Write blocks
+    InitialRTO       time.Duration        // Retransmission timeout before the round-trip time is measured
+    MinRTO           time.Duration        // Smallest retransmission timeout
+    MaxRTO           time.Duration        // Largest retransmission timeout, reached by backing off
+    HandshakeTimeout time.Duration        // How long Dial waits for the listener to accept
+    Linger           time.Duration        // How long Close waits for sent data to be acknowledged
+    QLog             *qlog.Logger         // Log of every connection's packets and window updates, or nil
+}
+
+// DefaultConfig returns a configuration in which every connection is controlled by the given tree
+func DefaultConfig(tree *whisker.WhiskerTree) Config {
+    return Config{
+        Whiskers:         tree,
+        MSS:              1200,
+        SendBuffer:       1024,
+        InitialRTO:       time.Second,
+        MinRTO:           200 * time.Millisecond,
+        MaxRTO:           60 * time.Second,
+        HandshakeTimeout: 5 * time.Second,
+        Linger:           5 * time.Second,
+    }
+}
+
+// withDefaults returns the configuration with its zero fields set to the defaults
+func (c Config) withDefaults() Config {
+    d := DefaultConfig(c.Whiskers)
+    d.RAT = c.RAT
+    d.QLog = c.QLog
+    if c.MSS > 0 {
+        d.MSS = c.MSS
+    }
+    if c.SendBuffer > 0 {
+        d.SendBuffer = c.SendBuffer
+    }
+    if c.InitialRTO > 0 {
+        d.InitialRTO = c.InitialRTO
+    }
+    if c.MinRTO > 0 {
+        d.MinRTO = c.MinRTO
+    }
+    if c.MaxRTO > 0 {
+        d.MaxRTO = c.MaxRTO
+    }
+    if c.HandshakeTimeout > 0 {
+        d.HandshakeTimeout = c.HandshakeTimeout
+    }
+    if c.Linger > 0 {
+        d.Linger = c.Linger
+    }
+    return d
+}
+
+// controller returns the RAT a new connection uses
+func (c Config) controller() (*rat.RAT, error) {
+    if c.RAT != nil {
+        return c.RAT, nil
+    }
+    if c.Whiskers == nil {
+        return nil, errors.New("transport config needs a whisker tree or a RAT")
+    }
+    return rat.NewRAT(c.Whiskers, false), nil
+}
+
+// ErrReset is returned when the peer no longer knows the connection
+var ErrReset = errors.New("connection reset by peer")
+
+// dupThresh is the number of segments acknowledged above an unacknowledged one that declares it lost, as in TCP
+const dupThresh = 3
+
+// maxOutOfOrder is the largest number of segments held while waiting for a missing one
+const maxOutOfOrder = 4096
+
+// outSegment is a segment of the byte stream that has not been cumulatively acknowledged
+type outSegment struct {
+    seq      uint32
+    payload  []byte
+    fin      bool
+    sent     time.Time // Time of the latest transmission
+    inFlight bool      // The latest transmission is neither acknowledged nor declared lost
+    sacked   bool      // Acknowledged by a SACK range
+    queued   bool      // Waiting to be sent or retransmitted
+}
+
+// inSegment is a segment received ahead of the next expected one
+type inSegment struct {
+    payload []byte
+    fin     bool
+}
+
+// Conn is a reliable, ordered byte stream over UDP whose window and pacing are controlled by a RAT
+// Every data segment sent is a packet of the connection's flow: an acknowledgment is a packet received, with the
+// round-trip time measured from the echoed send time, and a segment declared lost is reported to the RAT so that it
+// no longer holds the window. Lost segments are retransmitted under the same window and pacing.
+type Conn struct {
+    config Config
+    rat    *rat.RAT
+    flowID uint
+    local  net.Addr
+    remote net.Addr
+    output  func([]byte) error // Writes a datagram to the peer
+    release func()             // Frees the socket or listener entry once the connection is finished
+
+    mu       sync.Mutex
+    cond     *sync.Cond    // Signals blocked reads, writes and closes
+    wake     chan struct{} // Signals the send loop
+    stop     chan struct{} // Closed when the connection is finished
+    closed   bool          // Close was called
+    finished bool          // The send loop has stopped
+    err      error         // Error that ended the connection
+
+    // Sending
+    nextSeq     uint32                 // Sequence number of the next new segment
+    sndUna      uint32                 // Lowest unacknowledged sequence number
+    unacked     map[uint32]*outSegment // Segments not yet cumulatively acknowledged
+    queue       []*outSegment          // Segments waiting to be sent, retransmissions first
+    highestAck  uint32                 // One past the highest sequence number acknowledged
+    latestAcked time.Time              // Latest transmission time of an acknowledged segment
+    sending     *outSegment            // Segment being handed to the RAT
+    sent        bool                   // Whether the RAT sent it
+    srtt        time.Duration
+    rttvar      time.Duration
+    rto         time.Duration
+
+    // Receiving
+    rcvNext    uint32
+    outOfOrder map[uint32]inSegment
+    readBuf    []byte
+    eof        bool
+
+    readDeadline  time.Time
+    writeDeadline time.Time
+}
+
+// newConn creates a connection and starts its send loop
+func newConn(config Config, controller *rat.RAT, local, remote net.Addr, output func([]byte) error, release func()) *Conn {
+    c := &Conn{
+        config:     config,
+        rat:        controller,
+        flowID:     controller.NewFlow(),
+        local:      local,
+        remote:     remote,
+        output:     output,
+        release:    release,
+        wake:       make(chan struct{}, 1),
+        stop:       make(chan struct{}),
+        unacked:    make(map[uint32]*outSegment),
+        outOfOrder: make(map[uint32]inSegment),
+        rto:        config.InitialRTO,
+    }
+    c.cond = sync.NewCond(&c.mu)
+
+    // Window updates go to the connection's group: through the flow's group in a shared RAT, whose decision log the
+    // caller sets, or directly in a RAT of the connection's own
+    if config.QLog != nil {
+        if config.RAT == nil {
+            controller.SetDecisionLog(config.QLog.DecisionsFor(c.group()))
+        } else {
+            config.QLog.SetGroup(c.flowID, c.group())
+        }
+    }
+    go c.run()
+    return c
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Conn) LocalAddr() net.Addr {
+    return c.local
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+    return c.remote
+}
+
+// FlowID returns the flow of the connection in its RAT
+func (c *Conn) FlowID() uint {
+    return c.flowID
+}
+
+// group returns the qlog group of the connection, named after its addresses
+func (c *Conn) group() string {
+    return fmt.Sprintf("%v-%v", c.local, c.remote)
+}
+
+// RAT returns the RAT that controls the connection
+func (c *Conn) RAT() *rat.RAT {
+    return c.rat
+}
+
+// Read reads data received from the peer, returning io.EOF once the peer has closed the connection and all of its
+// data has been read
+func (c *Conn) Read(b []byte) (int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for len(c.readBuf) == 0 && !c.eof && c.err == nil && !c.closed && !expired(c.readDeadline) {
+        c.cond.Wait()
+    }
+    switch {
+    case len(c.readBuf) > 0:
+        n := copy(b, c.readBuf)
+        c.readBuf = c.readBuf[n:]
+        return n, nil
+    case c.eof:
+        return 0, io.EOF
+    case c.closed:
+        return 0, net.ErrClosed
+    case c.err != nil:
+        return 0, c.err
+    default:
+        return 0, os.ErrDeadlineExceeded
+    }
+}
+
+// Write queues data to be sent to the peer, blocking while the send buffer is full
+func (c *Conn) Write(b []byte) (int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    n := 0
+    for len(b) > 0 {
+        for len(c.unacked) >= c.config.SendBuffer && c.err == nil && !c.closed && !expired(c.writeDeadline) {
+            c.cond.Wait()
+        }
+        switch {
+        case c.closed:
+            return n, net.ErrClosed
+        case c.err != nil:
+            return n, c.err
+        case len(c.unacked) >= c.config.SendBuffer:
+            return n, os.ErrDeadlineExceeded
+        }
+
+        size := len(b)
+        if size > c.config.MSS {
+            size = c.config.MSS
+        }
+        c.enqueue(&outSegment{seq: c.nextSeq, payload: append([]byte(nil), b[:size]...)})
+        c.nextSeq++
+        b = b[size:]
+        n += size
+        c.signal()
+    }
+    return n, nil
+}
+
+// Close sends the end of the stream and waits, up to the linger time, for everything sent to be acknowledged
+// If the peer has not closed its side yet, the connection keeps acknowledging its data in the background until it
+// does or the rest of the linger time passes, so that the peer's Close does not wait in vain.
+func (c *Conn) Close() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.closed {
+        return nil
+    }
+    c.closed = true
+    if c.err != nil {
+        c.finish(nil)
+        return nil
+    }
+    c.enqueue(&outSegment{seq: c.nextSeq, fin: true})
+    c.nextSeq++
+    c.signal()
+
+    deadline := time.Now().Add(c.config.Linger)
+    linger := time.AfterFunc(c.config.Linger, c.broadcast)
+    for len(c.unacked) > 0 && c.err == nil && time.Now().Before(deadline) {
+        c.cond.Wait()
+    }
+    linger.Stop()
+    if remaining := time.Until(deadline); c.eof || c.err != nil || len(c.unacked) > 0 || remaining <= 0 {
+        c.finish(nil)
+    } else {
+        time.AfterFunc(remaining, func() { c.fail(nil) })
+    }
+    return nil
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+    c.SetReadDeadline(t)
+    return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the time after which blocked and future reads fail; a zero time means no deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.readDeadline = t
+    c.wakeAt(t)
+    return nil
+}
+
+// SetWriteDeadline sets the time after which blocked and future writes fail; a zero time means no deadline
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.writeDeadline = t
+    c.wakeAt(t)
+    return nil
+}
+
+// wakeAt wakes blocked reads and writes now and at the given time, so that they see a new deadline
+func (c *Conn) wakeAt(t time.Time) {
+    c.cond.Broadcast()
+    if !t.IsZero() {
+        time.AfterFunc(time.Until(t), c.broadcast)
+    }
+}
+
+// broadcast wakes everything blocked on the connection
+func (c *Conn) broadcast() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.cond.Broadcast()
+}
+
+// expired checks if a deadline has passed
+func expired(deadline time.Time) bool {
+    return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// signal wakes the send loop
+func (c *Conn) signal() {
+    select {
+    case c.wake <- struct{}{}:
+    default:
+    }
+}
+
+// finish stops the connection, ends its flow and frees its socket; err, if not nil, is reported by later calls
+func (c *Conn) finish(err error) {
+    if c.finished {
+        return
+    }
+    c.finished = true
+    if c.err == nil {
+        c.err = err
+    }
+    close(c.stop)
+    c.rat.EndFlow(c.flowID)
+    c.release()
+    c.cond.Broadcast()
+}
+
+// fail ends the connection with an error
+func (c *Conn) fail(err error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.finish(err)
+}
+
+// enqueue adds a new segment to the send buffer and the end of the send queue
+func (c *Conn) enqueue(seg *outSegment) {
+    c.unacked[seg.seq] = seg
+    seg.queued = true
+    c.queue = append(c.queue, seg)
+}
+
+// run is the send loop, which hands queued segments to the RAT whenever its window and pacing allow and
+// declares segments lost when their retransmission timeout passes
+func (c *Conn) run() {
+    timer := time.NewTimer(time.Hour)
+    defer timer.Stop()
+    for {
+        c.mu.Lock()
+        if c.finished {
+            c.mu.Unlock()
+            return
+        }
+        now := time.Now()
+        c.checkTimeouts(now)
+        c.transmit()
+        wait := c.nextWake(now)
+        c.mu.Unlock()
+
+        timer.Reset(wait)
+        select {
+        case <-c.stop:
+            return
+        case <-c.wake:
+        case <-timer.C:
+        }
+        if !timer.Stop() {
+            select {
+            case <-timer.C:
+            default:
+            }
+        }
+    }
+}
+
+// transmit sends queued segments until the RAT holds the flow back
+func (c *Conn) transmit() {
+    for len(c.queue) > 0 {
+        seg := c.queue[0]
+        if _, ok := c.unacked[seg.seq]; !ok || seg.sacked {
+            seg.queued = false
+            c.queue = c.queue[1:]
+            continue
+        }
+
+        c.sending, c.sent = seg, false
+        err := c.rat.Send(c.flowID, 0, hop{c}, int(seg.seq), math.MaxUint32)
+        c.sending = nil
+        if err != nil {
+            c.finish(err)
+            return
+        }
+        if !c.sent {
+            return
+        }
+        c.queue = c.queue[1:]
+    }
+}
+
+// hop is the next hop of a connection's RAT, which writes the segment being sent to the socket
+type hop struct {
+    c *Conn
+}
+
+// Accept transmits the connection's current segment, timestamped as the RAT sent it
+func (h hop) Accept(packet *rat.Packet) error {
+    seg := h.c.sending
+    retransmission := !seg.sent.IsZero()
+    seg.sent = packet.Sent
+    seg.inFlight = true
+    seg.queued = false
+    h.c.sent = true
+
+    var flags uint8
+    if seg.fin {
+        flags = flagFin
+    }
+    datagram := (&segment{Type: typeData, Flags: flags, Seq: seg.seq, Time: packet.Sent, Payload: seg.payload}).marshal()
+    if h.c.config.QLog != nil {
+        h.c.config.QLog.PacketSent(packet.Sent, h.c.group(), int64(seg.seq), len(datagram), retransmission)
+    }
+    if err := h.c.output(datagram); err != nil && !isTransient(err) {
+        return err
+    }
+    return nil
+}
+
+// isTransient checks if a socket error only loses the datagram, which retransmission recovers from, rather than
+// meaning the socket is gone
+func isTransient(err error) bool {
+    return !errors.Is(err, net.ErrClosed)
+}
+
+// nextWake returns how long the send loop may sleep before it has something to do
+func (c *Conn) nextWake(now time.Time) time.Duration {
+    wait := time.Hour
+    if len(c.queue) > 0 {
+        // Without an ACK or a timeout, only the pacing of the flow can let it send again
+        if until := c.rat.NextEventTime(c.flowID).Sub(now); until > 0 {
+            wait = until
+        }
+    }
+    for _, seg := range c.unacked {
+        if seg.inFlight {
+            if until := seg.sent.Add(c.rto).Sub(now); until < wait {
+                wait = until
+            }
+        }
+    }
+    if wait < 0 {
+        wait = 0
+    }
+    return wait
+}
+
+// checkTimeouts declares lost every segment in flight for longer than the retransmission timeout, and backs the
+// timeout off if there was any
+func (c *Conn) checkTimeouts(now time.Time) {
+    var lost []*outSegment
+    for _, seg := range c.unacked {
+        if seg.inFlight && now.Sub(seg.sent) >= c.rto {
+            lost = append(lost, seg)
+        }
+    }
+    if len(lost) == 0 {
+        return
+    }
+    c.rto *= 2
+    if c.rto > c.config.MaxRTO {
+        c.rto = c.config.MaxRTO
+    }
+    c.lose(lost, now, "retransmission_timeout")
+}
+
+// lose reports segments lost to the RAT and queues them for retransmission ahead of new data
+// The trigger names what declared them lost in the qlog.
+func (c *Conn) lose(lost []*outSegment, now time.Time, trigger string) {
+    sort.Slice(lost, func(i, j int) bool { return lost[i].seq < lost[j].seq })
+    packets := make([]*rat.Packet, 0, len(lost))
+    var retransmit []*outSegment
+    for _, seg := range lost {
+        seg.inFlight = false
+        packets = append(packets, &rat.Packet{SeqNo: int(seg.seq), FlowID: c.flowID, Sent: seg.sent})
+        if c.config.QLog != nil {
+            c.config.QLog.PacketLost(now, c.group(), int64(seg.seq), trigger)
+        }
+        if !seg.queued {
+            seg.queued = true
+            retransmit = append(retransmit, seg)
+        }
+    }
+    c.rat.LosePackets(packets)
+    c.queue = append(retransmit, c.queue...)
+}
+
+// handle processes a datagram from the peer
+func (c *Conn) handle(s *segment) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.finished {
+        return
+    }
+    switch s.Type {
+    case typeData:
+        c.handleData(s)
+    case typeAck:
+        c.handleAck(s, time.Now())
+    case typeReset:
+        c.finish(ErrReset)
+    }
+}
+
+// handleData stores a data segment, delivers whatever is now in order and acknowledges it
+func (c *Conn) handleData(s *segment) {
+    switch {
+    case s.Seq == c.rcvNext:
+        c.deliver(inSegment{payload: s.Payload, fin: s.Flags&flagFin != 0})
+        for {
+            next, ok := c.outOfOrder[c.rcvNext]
+            if !ok {
+                break
+            }
+            delete(c.outOfOrder, c.rcvNext)
+            c.deliver(next)
+        }
+        c.cond.Broadcast()
+    case seqLess(c.rcvNext, s.Seq) && len(c.outOfOrder) < maxOutOfOrder:
+        if _, ok := c.outOfOrder[s.Seq]; !ok {
+            c.outOfOrder[s.Seq] = inSegment{payload: append([]byte(nil), s.Payload...), fin: s.Flags&flagFin != 0}
+        }
+    }
+
+    ack := &segment{Type: typeAck, Ack: c.rcvNext, EchoSeq: s.Seq, EchoTime: s.Time, Sacks: c.sackRanges()}
+    c.output(ack.marshal())
+
+    // Both sides have closed and everything is acknowledged
+    if c.eof && c.closed && len(c.unacked) == 0 {
+        c.finish(nil)
+    }
+}
+
+// deliver appends an in-order segment to the data waiting to be read
+func (c *Conn) deliver(in inSegment) {
+    c.rcvNext++
+    if in.fin {
+        c.eof = true
+        return
+    }
+    c.readBuf = append(c.readBuf, in.payload...)
+}
+
+// sackRanges returns the ranges of segments held out of order, lowest first
+func (c *Conn) sackRanges() []sackRange {
+    if len(c.outOfOrder) == 0 {
+        return nil
+    }
+    seqs := make([]uint32, 0, len(c.outOfOrder))
+    for seq := range c.outOfOrder {
+        seqs = append(seqs, seq)
+    }
+    sort.Slice(seqs, func(i, j int) bool { return seqLess(seqs[i], seqs[j]) })
+
+    var ranges []sackRange
+    for _, seq := range seqs {
+        if n := len(ranges); n > 0 && ranges[n-1].End == seq {
+            ranges[n-1].End++
+            continue
+        }
+        if len(ranges) == maxSackRanges {
+            break
+        }
+        ranges = append(ranges, sackRange{Start: seq, End: seq + 1})
+    }
+    return ranges
+}
+
+// handleAck processes an acknowledgment: acknowledged segments are received packets of the flow, the echoed send
+// time gives a round-trip time sample, and segments that enough later segments overtook are declared lost, as in
+// TCP's RACK
+func (c *Conn) handleAck(s *segment, now time.Time) {
+    var received []*rat.Packet
+    ack := func(seg *outSegment) {
+        if !seg.inFlight {
+            return
+        }
+        seg.inFlight = false
+        if seg.sent.After(c.latestAcked) {
+            c.latestAcked = seg.sent
+        }
+        sent := seg.sent
+        if s.EchoSeq == seg.seq {
+            sent = s.EchoTime
+        }
+        received = append(received, &rat.Packet{SeqNo: int(seg.seq), FlowID: c.flowID, Sent: sent, Received: now})
+        if c.config.QLog != nil {
+            c.config.QLog.PacketAcked(now, c.group(), int64(seg.seq), now.Sub(sent))
+        }
+    }
+
+    for seqLess(c.sndUna, s.Ack) && seqLess(s.Ack, c.nextSeq+1) {
+        if seg, ok := c.unacked[c.sndUna]; ok {
+            ack(seg)
+            delete(c.unacked, c.sndUna)
+        }
+        c.sndUna++
+    }
+    if seqLess(c.highestAck, c.sndUna) {
+        c.highestAck = c.sndUna
+    }
+    for _, r := range s.Sacks {
+        for seq := r.Start; seqLess(seq, r.End) && seqLess(seq, c.nextSeq); seq++ {
+            if seg, ok := c.unacked[seq]; ok && !seg.sacked {
+                ack(seg)
+                seg.sacked = true
+            }
+        }
+        if seqLess(c.highestAck, r.End) && !seqLess(c.nextSeq, r.End) {
+            c.highestAck = r.End
+        }
+    }
+
+    if !s.EchoTime.IsZero() {
+        c.sampleRTT(now.Sub(s.EchoTime))
+    }
+
+    var lost []*outSegment
+    for _, seg := range c.unacked {
+        // Only segments sent before one that got through count, so that a retransmission gets a chance of its own
+        if seg.inFlight && seqLess(seg.seq+dupThresh, c.highestAck) && seg.sent.Before(c.latestAcked) {
+            lost = append(lost, seg)
+        }
+    }
+    if len(lost) > 0 {
+        c.lose(lost, now, "reordering_threshold")
+    }
+    if len(received) > 0 {
+        c.rat.ReceivePackets(received)
+    }
+    c.cond.Broadcast()
+    c.signal()
+}
+
+// sampleRTT updates the smoothed round-trip time and the retransmission timeout, as RFC 6298 does
+func (c *Conn) sampleRTT(rtt time.Duration) {
+    if rtt <= 0 {
+        return
+    }
+    if c.srtt == 0 {
+        c.srtt = rtt
+        c.rttvar = rtt / 2
+    } else {
+        diff := c.srtt - rtt
+        if diff < 0 {
+            diff = -diff
+        }
+        c.rttvar = (3*c.rttvar + diff) / 4
+        c.srtt = (7*c.srtt + rtt) / 8
+    }
+    c.rto = c.srtt + 4*c.rttvar
+    if c.rto < c.config.MinRTO {
+        c.rto = c.config.MinRTO
+    }
+    if c.rto > c.config.MaxRTO {
+        c.rto = c.config.MaxRTO
+    }
+}
+
+// SmoothedRTT returns the smoothed round-trip time, or zero before the first sample
+func (c *Conn) SmoothedRTT() time.Duration {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.srtt
+}
+
+// seqLess compares sequence numbers, allowing them to wrap around
+func seqLess(a, b uint32) bool {
+    return int32(a-b) < 0
+}
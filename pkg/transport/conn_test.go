@@ -0,0 +1,445 @@
+package transport
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "math/rand"
+    "net"
+    "os"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+    "github.com/Aanthord/remy-go/pkg/qlog"
+    "github.com/Aanthord/remy-go/pkg/whisker"
+)
+
+// testConfig returns a configuration with a fixed window of 16 segments and short timeouts
+func testConfig() Config {
+    tree := whisker.NewWhiskerTree()
+    tree.Root.Whisker = whisker.NewWhisker(0, 16, 0, 0, memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory()))
+    tree.Invalidate()
+    config := DefaultConfig(tree)
+    config.InitialRTO = 100 * time.Millisecond
+    config.MinRTO = 20 * time.Millisecond
+    config.MaxRTO = time.Second
+    config.HandshakeTimeout = 2 * time.Second
+    config.Linger = 2 * time.Second
+    return config
+}
+
+// proxy relays datagrams between one client and a server, dropping and reordering data segments
+type proxy struct {
+    pc     *net.UDPConn
+    server *net.UDPAddr
+    client *net.UDPAddr
+
+    lossRate    float64     // Fraction of data segments dropped
+    reorderRate float64     // Fraction of data segments held back until the next one has been relayed
+    blackhole   atomic.Bool // Drop every data segment and ACK
+
+    dropped   atomic.Int64
+    reordered atomic.Int64
+}
+
+// newProxy starts relaying to the server
+func newProxy(t *testing.T, server net.Addr, lossRate, reorderRate float64) *proxy {
+    t.Helper()
+    pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    p := &proxy{pc: pc, server: server.(*net.UDPAddr), lossRate: lossRate, reorderRate: reorderRate}
+    go p.relay(rand.New(rand.NewSource(1)))
+    t.Cleanup(func() { pc.Close() })
+    return p
+}
+
+// Addr returns the address clients dial
+func (p *proxy) Addr() string {
+    return p.pc.LocalAddr().String()
+}
+
+// relay forwards datagrams until the socket is closed
+// A held datagram is released after the next one in the same direction, or when nothing else arrives for a while.
+func (p *proxy) relay(rng *rand.Rand) {
+    type held struct {
+        b  []byte
+        to *net.UDPAddr
+    }
+    var pending []held
+    buf := make([]byte, maxDatagram)
+    for {
+        p.pc.SetReadDeadline(time.Now().Add(5 * time.Millisecond))
+        n, from, err := p.pc.ReadFromUDP(buf)
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+            for _, h := range pending {
+                p.pc.WriteToUDP(h.b, h.to)
+            }
+            pending = nil
+            continue
+        }
+        to := p.server
+        if from.String() == p.server.String() {
+            to = p.client
+        } else {
+            p.client = from
+        }
+        if to == nil {
+            continue
+        }
+        b := append([]byte(nil), buf[:n]...)
+
+        if s, err := unmarshalSegment(b); err == nil && (s.Type == typeData || s.Type == typeAck) {
+            if p.blackhole.Load() {
+                p.dropped.Add(1)
+                continue
+            }
+            if s.Type == typeData {
+                if rng.Float64() < p.lossRate {
+                    p.dropped.Add(1)
+                    continue
+                }
+                if rng.Float64() < p.reorderRate {
+                    p.reordered.Add(1)
+                    pending = append(pending, held{b, to})
+                    continue
+                }
+            }
+        }
+        p.pc.WriteToUDP(b, to)
+        for _, h := range pending {
+            p.pc.WriteToUDP(h.b, h.to)
+        }
+        pending = nil
+    }
+}
+
+// pair dials a listener through a proxy and returns both ends of the connection
+func pair(t *testing.T, lossRate, reorderRate float64) (*Conn, *Conn, *proxy) {
+    t.Helper()
+    l, err := Listen("udp4", "127.0.0.1:0", testConfig())
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    t.Cleanup(func() { l.Close() })
+    p := newProxy(t, l.Addr(), lossRate, reorderRate)
+
+    client, err := Dial("udp4", p.Addr(), testConfig())
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    t.Cleanup(func() { client.Close() })
+    server, err := l.Accept()
+    if err != nil {
+        t.Fatalf("failed to accept: %v", err)
+    }
+    return client, server.(*Conn), p
+}
+
+func TestTransfer(t *testing.T) {
+    for _, test := range []struct {
+        name        string
+        lossRate    float64
+        reorderRate float64
+    }{
+        {"clean", 0, 0},
+        {"loss", 0.05, 0},
+        {"reordering", 0, 0.1},
+        {"loss and reordering", 0.05, 0.1},
+    } {
+        t.Run(test.name, func(t *testing.T) {
+            client, server, p := pair(t, test.lossRate, test.reorderRate)
+
+            data := make([]byte, 300*1000)
+            rand.New(rand.NewSource(2)).Read(data)
+            errs := make(chan error, 1)
+            go func() {
+                if _, err := client.Write(data); err != nil {
+                    errs <- err
+                    return
+                }
+                errs <- client.Close()
+            }()
+
+            server.SetReadDeadline(time.Now().Add(20 * time.Second))
+            got, err := io.ReadAll(server)
+            if err != nil {
+                t.Fatalf("failed to read: %v", err)
+            }
+            if err := <-errs; err != nil {
+                t.Fatalf("failed to write: %v", err)
+            }
+            if !bytes.Equal(got, data) {
+                t.Fatalf("received %d bytes that differ from the %d sent", len(got), len(data))
+            }
+            if test.lossRate > 0 && p.dropped.Load() == 0 {
+                t.Errorf("the proxy dropped nothing")
+            }
+            if test.reorderRate > 0 && p.reordered.Load() == 0 {
+                t.Errorf("the proxy reordered nothing")
+            }
+            if client.SmoothedRTT() == 0 {
+                t.Errorf("the sender measured no round-trip time")
+            }
+            server.Close()
+        })
+    }
+}
+
+func TestCloseWaitsForAcknowledgment(t *testing.T) {
+    client, server, _ := pair(t, 0, 0)
+
+    if _, err := client.Write([]byte("bye")); err != nil {
+        t.Fatalf("failed to write: %v", err)
+    }
+    start := time.Now()
+    if err := client.Close(); err != nil {
+        t.Fatalf("failed to close: %v", err)
+    }
+    if elapsed := time.Since(start); elapsed >= testConfig().Linger {
+        t.Errorf("Close took %v although the peer acknowledged everything", elapsed)
+    }
+
+    server.SetReadDeadline(time.Now().Add(5 * time.Second))
+    got, err := io.ReadAll(server)
+    if err != nil || string(got) != "bye" {
+        t.Fatalf("read %q, %v; want \"bye\"", got, err)
+    }
+    if _, err := client.Write([]byte("more")); !errors.Is(err, net.ErrClosed) {
+        t.Errorf("Write after Close returned %v, want %v", err, net.ErrClosed)
+    }
+    if _, err := client.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+        t.Errorf("Read after Close returned %v, want %v", err, net.ErrClosed)
+    }
+    server.Close()
+}
+
+func TestCloseLingers(t *testing.T) {
+    client, _, p := pair(t, 0, 0)
+
+    // Nothing gets through any more, so Close gives up once the linger time has passed
+    p.blackhole.Store(true)
+    if _, err := client.Write([]byte("lost")); err != nil {
+        t.Fatalf("failed to write: %v", err)
+    }
+    start := time.Now()
+    client.Close()
+    elapsed := time.Since(start)
+    if linger := testConfig().Linger; elapsed < linger || elapsed > linger+time.Second {
+        t.Errorf("Close took %v, want the linger time of %v", elapsed, linger)
+    }
+}
+
+func TestReset(t *testing.T) {
+    config := testConfig()
+    config.Linger = 100 * time.Millisecond
+    l, err := Listen("udp4", "127.0.0.1:0", config)
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    defer l.Close()
+    client, err := Dial("udp4", l.Addr().String(), testConfig())
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    defer client.Close()
+    server, err := l.Accept()
+    if err != nil {
+        t.Fatalf("failed to accept: %v", err)
+    }
+
+    // The server's side is forgotten once its linger time has passed, so that later data from the client is
+    // answered with a reset
+    server.Close()
+    time.Sleep(2 * config.Linger)
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        _, err := client.Write([]byte("anyone there?"))
+        if errors.Is(err, ErrReset) {
+            break
+        }
+        if err != nil {
+            t.Fatalf("Write returned %v, want %v", err, ErrReset)
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("the connection was not reset")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestDialRefused(t *testing.T) {
+    l, err := Listen("udp4", "127.0.0.1:0", testConfig())
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    defer l.Close()
+
+    // Data from an address the listener does not know is answered with a reset
+    udp, err := net.DialUDP("udp4", nil, l.Addr().(*net.UDPAddr))
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    defer udp.Close()
+    udp.Write((&segment{Type: typeData, Time: time.Now()}).marshal())
+    udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+    buf := make([]byte, maxDatagram)
+    n, err := udp.Read(buf)
+    if err != nil {
+        t.Fatalf("no answer: %v", err)
+    }
+    if s, err := unmarshalSegment(buf[:n]); err != nil || s.Type != typeReset {
+        t.Fatalf("got %+v, %v; want a reset", s, err)
+    }
+}
+
+func TestReadDeadline(t *testing.T) {
+    client, _, _ := pair(t, 0, 0)
+
+    client.SetReadDeadline(time.Now().Add(-time.Second))
+    if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Fatalf("Read past its deadline returned %v, want %v", err, os.ErrDeadlineExceeded)
+    }
+
+    // A blocked read wakes up when its deadline passes
+    start := time.Now()
+    client.SetReadDeadline(start.Add(50 * time.Millisecond))
+    _, err := client.Read(make([]byte, 1))
+    var netErr net.Error
+    if !errors.As(err, &netErr) || !netErr.Timeout() {
+        t.Fatalf("Read returned %v, want a timeout", err)
+    }
+    if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+        t.Errorf("Read timed out after %v, want 50ms", elapsed)
+    }
+
+    // Clearing the deadline lets reads block again
+    client.SetReadDeadline(time.Time{})
+    done := make(chan error, 1)
+    go func() {
+        _, err := client.Read(make([]byte, 1))
+        done <- err
+    }()
+    select {
+    case err := <-done:
+        t.Fatalf("Read without a deadline returned %v", err)
+    case <-time.After(100 * time.Millisecond):
+    }
+    client.SetReadDeadline(time.Now())
+    if err := <-done; !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Errorf("Read returned %v after its deadline was moved, want %v", err, os.ErrDeadlineExceeded)
+    }
+}
+
+func TestWriteDeadline(t *testing.T) {
+    l, err := Listen("udp4", "127.0.0.1:0", testConfig())
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    defer l.Close()
+    p := newProxy(t, l.Addr(), 0, 0)
+    config := testConfig()
+    config.SendBuffer = 4
+    config.Linger = 10 * time.Millisecond
+    client, err := Dial("udp4", p.Addr(), config)
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    defer client.Close()
+
+    // Without ACKs the send buffer fills up and Write blocks until its deadline
+    p.blackhole.Store(true)
+    client.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+    n, err := client.Write(make([]byte, 10*config.MSS))
+    if !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Fatalf("Write returned %v, want %v", err, os.ErrDeadlineExceeded)
+    }
+    if want := config.SendBuffer * config.MSS; n != want {
+        t.Errorf("Write queued %d bytes before its deadline, want %d", n, want)
+    }
+}
+
+func TestQLog(t *testing.T) {
+    l, err := Listen("udp4", "127.0.0.1:0", testConfig())
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    t.Cleanup(func() { l.Close() })
+    p := newProxy(t, l.Addr(), 0.1, 0)
+
+    // The client's tree has a single whisker of its own, which window updates name as whisker 0
+    tree := whisker.NewWhiskerTree()
+    if err := tree.Insert(whisker.NewWhisker(1, 16, 0, 0, memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory()))); err != nil {
+        t.Fatal(err)
+    }
+    var buf bytes.Buffer
+    logger := qlog.NewLogger(&buf, qlog.Options{VantagePoint: "client", ReferenceTime: time.Now(), Whiskers: tree})
+    config := testConfig()
+    config.Whiskers = tree
+    config.QLog = logger
+    client, err := Dial("udp4", p.Addr(), config)
+    if err != nil {
+        t.Fatalf("failed to dial: %v", err)
+    }
+    server, err := l.Accept()
+    if err != nil {
+        t.Fatalf("failed to accept: %v", err)
+    }
+    defer server.Close()
+
+    go func() {
+        client.Write(make([]byte, 100*1000))
+        client.Close()
+    }()
+    server.SetReadDeadline(time.Now().Add(20 * time.Second))
+    if _, err := io.ReadAll(server); err != nil {
+        t.Fatalf("failed to read: %v", err)
+    }
+    client.Close()
+    if err := logger.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    records := bytes.Split(bytes.TrimPrefix(buf.Bytes(), []byte{0x1e}), []byte{0x1e})
+    var header map[string]any
+    if err := json.Unmarshal(records[0], &header); err != nil || header["qlog_format"] != "JSON-SEQ" {
+        t.Fatalf("header %q is not a JSON-SEQ qlog header: %v", records[0], err)
+    }
+    group := client.LocalAddr().String() + "-" + client.RemoteAddr().String()
+    counts := make(map[string]int)
+    for _, data := range records[1:] {
+        var record struct {
+            Name    string         `json:"name"`
+            GroupID string         `json:"group_id"`
+            Data    map[string]any `json:"data"`
+        }
+        if err := json.Unmarshal(data, &record); err != nil {
+            t.Fatalf("failed to decode record %q: %v", data, err)
+        }
+        if record.GroupID != group {
+            t.Errorf("%s is in group %q, want the connection's %q", record.Name, record.GroupID, group)
+        }
+        counts[record.Name]++
+        if record.Data["trigger"] == "retransmission" {
+            counts["retransmission"]++
+        }
+        if record.Name == "recovery:congestion_window_updated" && (record.Data["whisker"] != 0.0 || record.Data["new"] != 16.0) {
+            t.Errorf("window update %v, want whisker 0 setting a window of 16", record.Data)
+        }
+    }
+    // With a tenth of the datagrams dropped, some segments are lost and sent again
+    for _, name := range []string{"transport:packet_sent", "recovery:packet_acked", "recovery:packet_lost",
+        "recovery:congestion_window_updated", "recovery:metrics_updated", "retransmission"} {
+        if counts[name] == 0 {
+            t.Errorf("no %s events in %v", name, counts)
+        }
+    }
+}
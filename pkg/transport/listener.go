@@ -0,0 +1,242 @@
+package transport
+
+import (
+    "errors"
+    "net"
+    "sync"
+    "time"
+)
+
+// maxDatagram is the size of the buffers datagrams are read into
+const maxDatagram = 65536
+
+// acceptBacklog is the largest number of connections waiting for Accept
+const acceptBacklog = 16
+
+// synInterval is the time between SYN retransmissions while dialing
+const synInterval = 200 * time.Millisecond
+
+// Dial opens a connection to a listener at the given UDP address
+// The network must be "udp", "udp4" or "udp6".
+func Dial(network, address string, config Config) (*Conn, error) {
+    config = config.withDefaults()
+    controller, err := config.controller()
+    if err != nil {
+        return nil, err
+    }
+    raddr, err := net.ResolveUDPAddr(network, address)
+    if err != nil {
+        return nil, err
+    }
+    udp, err := net.DialUDP(network, nil, raddr)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := handshake(udp, config.HandshakeTimeout); err != nil {
+        udp.Close()
+        return nil, err
+    }
+
+    c := newConn(config, controller, udp.LocalAddr(), raddr, func(b []byte) error {
+        _, err := udp.Write(b)
+        return err
+    }, func() {
+        udp.Close()
+    })
+    go func() {
+        buf := make([]byte, maxDatagram)
+        for {
+            n, err := udp.Read(buf)
+            if err != nil {
+                if errors.Is(err, net.ErrClosed) {
+                    c.fail(net.ErrClosed)
+                    return
+                }
+                // Errors such as ICMP port unreachable only lose a datagram
+                continue
+            }
+            if s, err := unmarshalSegment(buf[:n]); err == nil {
+                c.handle(s)
+            }
+        }
+    }()
+    return c, nil
+}
+
+// handshake sends a SYN every synInterval until the listener answers with a SYNACK
+func handshake(udp *net.UDPConn, timeout time.Duration) error {
+    syn := (&segment{Type: typeSyn}).marshal()
+    deadline := time.Now().Add(timeout)
+    buf := make([]byte, maxDatagram)
+    for time.Now().Before(deadline) {
+        if _, err := udp.Write(syn); err != nil && errors.Is(err, net.ErrClosed) {
+            return err
+        }
+        wait := time.Now().Add(synInterval)
+        if wait.After(deadline) {
+            wait = deadline
+        }
+        udp.SetReadDeadline(wait)
+        for {
+            n, err := udp.Read(buf)
+            if err != nil {
+                break
+            }
+            if s, err := unmarshalSegment(buf[:n]); err == nil {
+                switch s.Type {
+                case typeSynAck:
+                    udp.SetReadDeadline(time.Time{})
+                    return nil
+                case typeReset:
+                    return ErrReset
+                }
+            }
+        }
+    }
+    return errors.New("handshake timed out")
+}
+
+// Listener accepts connections on a UDP socket, which all its connections share
+type Listener struct {
+    config   Config
+    pc       *net.UDPConn
+    accepted chan *Conn
+    done     chan struct{}
+
+    mu     sync.Mutex
+    conns  map[string]*Conn // Open connections by remote address
+    closed bool
+}
+
+// Listen listens for connections on the given UDP address
+// The network must be "udp", "udp4" or "udp6".
+func Listen(network, address string, config Config) (*Listener, error) {
+    config = config.withDefaults()
+    if _, err := config.controller(); err != nil {
+        return nil, err
+    }
+    laddr, err := net.ResolveUDPAddr(network, address)
+    if err != nil {
+        return nil, err
+    }
+    pc, err := net.ListenUDP(network, laddr)
+    if err != nil {
+        return nil, err
+    }
+    l := &Listener{
+        config:   config,
+        pc:       pc,
+        accepted: make(chan *Conn, acceptBacklog),
+        done:     make(chan struct{}),
+        conns:    make(map[string]*Conn),
+    }
+    go l.serve()
+    return l, nil
+}
+
+// Accept waits for and returns the next connection
+// The connection is a *Conn.
+func (l *Listener) Accept() (net.Conn, error) {
+    select {
+    case c := <-l.accepted:
+        return c, nil
+    case <-l.done:
+        return nil, net.ErrClosed
+    }
+}
+
+// Close stops the listener; the connections it accepted share its socket and end with it
+func (l *Listener) Close() error {
+    l.mu.Lock()
+    if l.closed {
+        l.mu.Unlock()
+        return nil
+    }
+    l.closed = true
+    close(l.done)
+    l.mu.Unlock()
+    return l.pc.Close()
+}
+
+// Addr returns the address the listener is listening on
+func (l *Listener) Addr() net.Addr {
+    return l.pc.LocalAddr()
+}
+
+// serve reads datagrams and hands them to the connection of their sender
+func (l *Listener) serve() {
+    buf := make([]byte, maxDatagram)
+    for {
+        n, raddr, err := l.pc.ReadFromUDP(buf)
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                l.closeConns()
+                return
+            }
+            continue
+        }
+        s, err := unmarshalSegment(buf[:n])
+        if err != nil {
+            continue
+        }
+
+        l.mu.Lock()
+        c := l.conns[raddr.String()]
+        if c == nil && s.Type == typeSyn {
+            c = l.open(raddr)
+        }
+        l.mu.Unlock()
+
+        switch {
+        case s.Type == typeSyn:
+            // A repeated SYN means the SYNACK was lost
+            if c != nil {
+                l.pc.WriteToUDP((&segment{Type: typeSynAck}).marshal(), raddr)
+            }
+        case c != nil:
+            c.handle(s)
+        case s.Type != typeReset:
+            l.pc.WriteToUDP((&segment{Type: typeReset}).marshal(), raddr)
+        }
+    }
+}
+
+// open creates the connection for a SYN and queues it for Accept, or returns nil if the backlog is full
+func (l *Listener) open(raddr *net.UDPAddr) *Conn {
+    if l.closed || len(l.accepted) == cap(l.accepted) {
+        return nil
+    }
+    controller, err := l.config.controller()
+    if err != nil {
+        return nil
+    }
+    key := raddr.String()
+    var c *Conn
+    c = newConn(l.config, controller, l.pc.LocalAddr(), raddr, func(b []byte) error {
+        _, err := l.pc.WriteToUDP(b, raddr)
+        return err
+    }, func() {
+        l.mu.Lock()
+        defer l.mu.Unlock()
+        if l.conns[key] == c {
+            delete(l.conns, key)
+        }
+    })
+    l.conns[key] = c
+    l.accepted <- c
+    return c
+}
+
+// closeConns ends every connection once the shared socket is gone
+func (l *Listener) closeConns() {
+    l.mu.Lock()
+    conns := make([]*Conn, 0, len(l.conns))
+    for _, c := range l.conns {
+        conns = append(conns, c)
+    }
+    l.mu.Unlock()
+    for _, c := range conns {
+        c.fail(net.ErrClosed)
+    }
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+    "encoding/binary"
+    "errors"
+    "time"
+)
+
+// segmentType identifies the kind of a datagram
+type segmentType uint8
+
+const (
+    typeData   segmentType = iota + 1 // Carries a numbered segment of the byte stream
+    typeAck                           // Acknowledges data segments
+    typeSyn                           // Opens a connection
+    typeSynAck                        // Accepts a connection
+    typeReset                         // Tells the peer the connection does not exist
+)
+
+// flagFin marks the data segment that ends the stream; it carries no payload
+const flagFin = 1
+
+// maxSackRanges is the largest number of SACK ranges an ACK carries
+const maxSackRanges = 8
+
+// errMalformed is returned for datagrams that cannot be decoded
+var errMalformed = errors.New("malformed datagram")
+
+// sackRange is a range [Start, End) of segments received above the cumulative ACK
+type sackRange struct {
+    Start, End uint32
+}
+
+// segment is a decoded datagram
+// Data segments use Seq, Flags, Time and Payload; ACKs use Ack, EchoSeq, EchoTime and Sacks
+type segment struct {
+    Type     segmentType
+    Flags    uint8
+    Seq      uint32    // Sequence number of a data segment
+    Time     time.Time // Time a data segment was sent, echoed back by the ACK it causes
+    Payload  []byte
+    Ack      uint32    // Cumulative ACK: the next sequence number the receiver expects
+    EchoSeq  uint32    // Sequence number of the data segment that caused the ACK
+    EchoTime time.Time // Send time of that data segment
+    Sacks    []sackRange
+}
+
+// Sizes of the encoded headers
+const (
+    commonHeaderSize = 2
+    dataHeaderSize   = commonHeaderSize + 4 + 8
+    ackHeaderSize    = commonHeaderSize + 4 + 4 + 8 + 1
+)
+
+// marshal encodes the segment as a datagram
+func (s *segment) marshal() []byte {
+    var b []byte
+    switch s.Type {
+    case typeData:
+        b = make([]byte, dataHeaderSize, dataHeaderSize+len(s.Payload))
+        binary.BigEndian.PutUint32(b[2:], s.Seq)
+        binary.BigEndian.PutUint64(b[6:], uint64(s.Time.UnixNano()))
+        b = append(b, s.Payload...)
+    case typeAck:
+        b = make([]byte, ackHeaderSize, ackHeaderSize+8*len(s.Sacks))
+        binary.BigEndian.PutUint32(b[2:], s.Ack)
+        binary.BigEndian.PutUint32(b[6:], s.EchoSeq)
+        binary.BigEndian.PutUint64(b[10:], uint64(s.EchoTime.UnixNano()))
+        b[18] = uint8(len(s.Sacks))
+        for _, r := range s.Sacks {
+            b = binary.BigEndian.AppendUint32(b, r.Start)
+            b = binary.BigEndian.AppendUint32(b, r.End)
+        }
+    default:
+        b = make([]byte, commonHeaderSize)
+    }
+    b[0] = uint8(s.Type)
+    b[1] = s.Flags
+    return b
+}
+
+// unmarshalSegment decodes a datagram
+// The payload of a data segment aliases b
+func unmarshalSegment(b []byte) (*segment, error) {
+    if len(b) < commonHeaderSize {
+        return nil, errMalformed
+    }
+    s := &segment{Type: segmentType(b[0]), Flags: b[1]}
+    switch s.Type {
+    case typeData:
+        if len(b) < dataHeaderSize {
+            return nil, errMalformed
+        }
+        s.Seq = binary.BigEndian.Uint32(b[2:])
+        s.Time = time.Unix(0, int64(binary.BigEndian.Uint64(b[6:])))
+        s.Payload = b[dataHeaderSize:]
+    case typeAck:
+        if len(b) < ackHeaderSize {
+            return nil, errMalformed
+        }
+        s.Ack = binary.BigEndian.Uint32(b[2:])
+        s.EchoSeq = binary.BigEndian.Uint32(b[6:])
+        s.EchoTime = time.Unix(0, int64(binary.BigEndian.Uint64(b[10:])))
+        n := int(b[18])
+        if n > maxSackRanges || len(b) < ackHeaderSize+8*n {
+            return nil, errMalformed
+        }
+        for i := 0; i < n; i++ {
+            off := ackHeaderSize + 8*i
+            s.Sacks = append(s.Sacks, sackRange{
+                Start: binary.BigEndian.Uint32(b[off:]),
+                End:   binary.BigEndian.Uint32(b[off+4:]),
+            })
+        }
+    case typeSyn, typeSynAck, typeReset:
+    default:
+        return nil, errMalformed
+    }
+    return s, nil
+}
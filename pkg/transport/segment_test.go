@@ -0,0 +1,74 @@
+package transport
+
+import (
+    "bytes"
+    "testing"
+    "time"
+)
+
+func TestSegmentRoundTrip(t *testing.T) {
+    sent := time.Unix(1700000000, 123456789)
+    segments := []*segment{
+        {Type: typeData, Seq: 42, Time: sent, Payload: []byte("hello")},
+        {Type: typeData, Flags: flagFin, Seq: 0xffffffff, Time: sent},
+        {Type: typeAck, Ack: 7, EchoSeq: 9, EchoTime: sent},
+        {Type: typeAck, Ack: 7, EchoSeq: 12, EchoTime: sent, Sacks: []sackRange{{9, 10}, {12, 15}}},
+        {Type: typeSyn},
+        {Type: typeSynAck},
+        {Type: typeReset},
+    }
+    for _, want := range segments {
+        b := want.marshal()
+        got, err := unmarshalSegment(b)
+        if err != nil {
+            t.Fatalf("failed to unmarshal %+v: %v", want, err)
+        }
+        if got.Type != want.Type || got.Flags != want.Flags || got.Seq != want.Seq || got.Ack != want.Ack ||
+            got.EchoSeq != want.EchoSeq || !bytes.Equal(got.Payload, want.Payload) || len(got.Sacks) != len(want.Sacks) {
+            t.Fatalf("round trip of %+v gave %+v", want, got)
+        }
+        for i := range want.Sacks {
+            if got.Sacks[i] != want.Sacks[i] {
+                t.Fatalf("SACK range %d: got %v, want %v", i, got.Sacks[i], want.Sacks[i])
+            }
+        }
+        if want.Type == typeData && !got.Time.Equal(want.Time) {
+            t.Fatalf("send time: got %v, want %v", got.Time, want.Time)
+        }
+        if want.Type == typeAck && !got.EchoTime.Equal(want.EchoTime) {
+            t.Fatalf("echoed time: got %v, want %v", got.EchoTime, want.EchoTime)
+        }
+    }
+}
+
+func TestSegmentSizes(t *testing.T) {
+    data := (&segment{Type: typeData, Time: time.Unix(1, 0), Payload: make([]byte, 100)}).marshal()
+    if len(data) != dataHeaderSize+100 {
+        t.Fatalf("data segment is %d bytes, want %d", len(data), dataHeaderSize+100)
+    }
+    ack := (&segment{Type: typeAck, EchoTime: time.Unix(1, 0), Sacks: make([]sackRange, 3)}).marshal()
+    if len(ack) != ackHeaderSize+24 {
+        t.Fatalf("ACK is %d bytes, want %d", len(ack), ackHeaderSize+24)
+    }
+}
+
+func TestMalformedSegments(t *testing.T) {
+    ack := (&segment{Type: typeAck, EchoTime: time.Unix(1, 0), Sacks: []sackRange{{1, 2}}}).marshal()
+    tooManySacks := (&segment{Type: typeAck, EchoTime: time.Unix(1, 0)}).marshal()
+    tooManySacks[18] = maxSackRanges + 1
+    tooManySacks = append(tooManySacks, make([]byte, 8*(maxSackRanges+1))...)
+
+    for name, b := range map[string][]byte{
+        "empty":          nil,
+        "short header":   {uint8(typeData)},
+        "unknown type":   {99, 0},
+        "short data":     {uint8(typeData), 0, 1, 2, 3},
+        "short ACK":      {uint8(typeAck), 0, 1, 2, 3, 4, 5},
+        "truncated SACK": ack[:len(ack)-1],
+        "too many SACKs": tooManySacks,
+    } {
+        if _, err := unmarshalSegment(b); err != errMalformed {
+            t.Errorf("%s: got %v, want %v", name, err, errMalformed)
+        }
+    }
+}
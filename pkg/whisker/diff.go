@@ -0,0 +1,324 @@
+package whisker
+
+import (
+    "fmt"
+    "math"
+    "strings"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// ChangeKind describes how a region of memory space changed between two whisker trees
+type ChangeKind int
+
+const (
+    Modified ChangeKind = iota // Same domain, different action
+    Split                      // One old domain is now covered by several smaller ones
+    Merged                     // Several old domains are now covered by one larger one
+    Reshaped                   // Old and new domains overlap without one refining the other
+    Added                      // A new domain covers space no old domain covered
+    Removed                    // An old domain covers space no new domain covers
+)
+
+// String returns the name of the change kind
+func (k ChangeKind) String() string {
+    switch k {
+    case Modified:
+        return "modified"
+    case Split:
+        return "split"
+    case Merged:
+        return "merged"
+    case Reshaped:
+        return "reshaped"
+    case Added:
+        return "added"
+    case Removed:
+        return "removed"
+    default:
+        return fmt.Sprintf("change(%d)", int(k))
+    }
+}
+
+// ActionDelta is the difference between the actions of a new and an old whisker over the region they share
+type ActionDelta struct {
+    Old             *Whisker
+    New             *Whisker
+    WindowIncrement int
+    WindowMultiple  float64
+    Intersend       float64
+    tolerance       float64 // Relative difference below which the actions are considered the same
+}
+
+// IsZero checks if the actions are the same, within the action tolerance of the diff that computed the delta
+func (d ActionDelta) IsZero() bool {
+    if d.Old == nil || d.New == nil {
+        return d.WindowIncrement == 0 && d.WindowMultiple == 0 && d.Intersend == 0
+    }
+    return sameAction(d.Old, d.New, d.tolerance)
+}
+
+// String returns a string representation of the action delta
+func (d ActionDelta) String() string {
+    return fmt.Sprintf("increment %+d, multiple %+g, intersend %+g", d.WindowIncrement, d.WindowMultiple, d.Intersend)
+}
+
+// newActionDelta computes the action delta between two whiskers
+func newActionDelta(old, new *Whisker, tolerance float64) ActionDelta {
+    return ActionDelta{
+        tolerance:       tolerance,
+        Old:             old,
+        New:             new,
+        WindowIncrement: new.WindowIncrement - old.WindowIncrement,
+        WindowMultiple:  new.WindowMultiple - old.WindowMultiple,
+        Intersend:       new.Intersend - old.Intersend,
+    }
+}
+
+// RegionChange describes one region of memory space whose whiskers differ between two trees
+type RegionChange struct {
+    Kind   ChangeKind
+    Old    []*Whisker    // Whiskers of the old tree covering the region
+    New    []*Whisker    // Whiskers of the new tree covering the region
+    Deltas []ActionDelta // Action deltas between every overlapping old and new whisker
+}
+
+// String returns a string representation of the region change
+func (c RegionChange) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s:", c.Kind)
+    for _, w := range c.Old {
+        fmt.Fprintf(&b, "\n  - %v", w)
+    }
+    for _, w := range c.New {
+        fmt.Fprintf(&b, "\n  + %v", w)
+    }
+    for _, d := range c.Deltas {
+        if !d.IsZero() {
+            fmt.Fprintf(&b, "\n    %v over %v", d, intersection(d.Old.Domain, d.New.Domain))
+        }
+    }
+    return b.String()
+}
+
+// TreeDiff is the structural difference between two whisker trees
+type TreeDiff struct {
+    Changes       []RegionChange
+    Unchanged     int                 // Number of domains present in both trees with the same action
+    Bounds        *memory.MemoryRange // Finite box the volumes are measured in
+    ChangedVolume float64             // Volume of the part of Bounds whose action changed
+    TotalVolume   float64             // Volume of Bounds
+}
+
+// ChangedFraction returns the fraction of the measured memory space whose action changed
+func (d *TreeDiff) ChangedFraction() float64 {
+    if d.TotalVolume == 0 {
+        return 0
+    }
+    return d.ChangedVolume / d.TotalVolume
+}
+
+// Count returns the number of region changes of the given kind
+func (d *TreeDiff) Count(kind ChangeKind) int {
+    count := 0
+    for _, c := range d.Changes {
+        if c.Kind == kind {
+            count++
+        }
+    }
+    return count
+}
+
+// Summary returns the number of changes of each kind and the share of memory space whose action changed
+func (d *TreeDiff) Summary() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "%d unchanged, %d modified, %d split, %d merged, %d reshaped, %d added, %d removed\n",
+        d.Unchanged, d.Count(Modified), d.Count(Split), d.Count(Merged), d.Count(Reshaped), d.Count(Added), d.Count(Removed))
+    fmt.Fprintf(&b, "action changed over %.4g of %.4g (%.2f%%) of memory space within %v\n",
+        d.ChangedVolume, d.TotalVolume, 100*d.ChangedFraction(), d.Bounds)
+    return b.String()
+}
+
+// String returns a summary of the diff followed by every region change
+func (d *TreeDiff) String() string {
+    var b strings.Builder
+    b.WriteString(d.Summary())
+    for _, c := range d.Changes {
+        fmt.Fprintf(&b, "%v\n", c)
+    }
+    return b.String()
+}
+
+// DiffOptions control what Diff counts as a change of action
+type DiffOptions struct {
+    ActionTolerance float64 // Relative difference below which two actions are considered the same
+}
+
+// DefaultDiffOptions returns options under which any difference of action is a change
+func DefaultDiffOptions() DiffOptions {
+    return DiffOptions{ActionTolerance: 0}
+}
+
+// Diff aligns the leaves of two whisker trees by domain and reports how the new tree differs from the old one
+// Any difference of action is a change; use DiffWithOptions to ignore small ones
+func Diff(old, new *WhiskerTree) *TreeDiff {
+    return DiffWithOptions(old, new, DefaultDiffOptions())
+}
+
+// DiffWithOptions aligns the leaves of two whisker trees by domain and reports how the new tree differs from the old one
+// Only leaves are compared. The action of an internal node applies only to the parts of its domain that its children
+// leave uncovered, which Validate warns about as partial splits; trees whose children partition their parents, as Remy's do, never
+// use it. Actions within ActionTolerance of each other, as Prune measures it, count as unchanged.
+// Volumes are measured within the smallest box that holds every finite domain bound of either tree,
+// so unbounded domains are counted only up to the largest bound either tree uses
+func DiffWithOptions(old, new *WhiskerTree, opts DiffOptions) *TreeDiff {
+    tolerance := opts.ActionTolerance
+    oldLeaves := old.Leaves()
+    newLeaves := new.Leaves()
+
+    diff := &TreeDiff{Bounds: finiteBounds(oldLeaves, newLeaves)}
+    diff.TotalVolume = volume(diff.Bounds)
+
+    newInOld := make(map[*WhiskerNode]int)
+    overlaps := make(map[*WhiskerNode][]*WhiskerNode)
+    for _, a := range oldLeaves {
+        for _, b := range new.Overlapping(a.Whisker.Domain) {
+            if len(b.Children) == 0 {
+                overlaps[a] = append(overlaps[a], b)
+                newInOld[b]++
+            }
+        }
+    }
+
+    reported := make(map[*WhiskerNode]bool)
+    for _, a := range oldLeaves {
+        matches := overlaps[a]
+        if len(matches) == 0 {
+            diff.Changes = append(diff.Changes, RegionChange{Kind: Removed, Old: []*Whisker{a.Whisker}})
+            continue
+        }
+
+        for _, b := range matches {
+            delta := newActionDelta(a.Whisker, b.Whisker, tolerance)
+            if !delta.IsZero() {
+                diff.ChangedVolume += volume(intersection(intersection(a.Whisker.Domain, b.Whisker.Domain), diff.Bounds))
+            }
+        }
+
+        switch {
+        case len(matches) == 1 && matches[0].Whisker.Domain.Equal(a.Whisker.Domain):
+            delta := newActionDelta(a.Whisker, matches[0].Whisker, tolerance)
+            if delta.IsZero() {
+                diff.Unchanged++
+            } else {
+                diff.Changes = append(diff.Changes, RegionChange{Kind: Modified, Old: []*Whisker{a.Whisker}, New: []*Whisker{matches[0].Whisker}, Deltas: []ActionDelta{delta}})
+            }
+            reported[matches[0]] = true
+        case len(matches) > 1 && allWithin(matches, a.Whisker.Domain):
+            change := RegionChange{Kind: Split, Old: []*Whisker{a.Whisker}}
+            for _, b := range matches {
+                change.New = append(change.New, b.Whisker)
+                change.Deltas = append(change.Deltas, newActionDelta(a.Whisker, b.Whisker, tolerance))
+                reported[b] = true
+            }
+            diff.Changes = append(diff.Changes, change)
+        case len(matches) == 1 && matches[0].Whisker.Domain.ContainsRange(a.Whisker.Domain) && newInOld[matches[0]] > 1:
+            b := matches[0]
+            if reported[b] {
+                continue
+            }
+            change := RegionChange{Kind: Merged, New: []*Whisker{b.Whisker}}
+            for _, other := range old.Overlapping(b.Whisker.Domain) {
+                if len(other.Children) == 0 && b.Whisker.Domain.ContainsRange(other.Whisker.Domain) {
+                    change.Old = append(change.Old, other.Whisker)
+                    change.Deltas = append(change.Deltas, newActionDelta(other.Whisker, b.Whisker, tolerance))
+                }
+            }
+            diff.Changes = append(diff.Changes, change)
+            reported[b] = true
+        default:
+            change := RegionChange{Kind: Reshaped, Old: []*Whisker{a.Whisker}}
+            for _, b := range matches {
+                change.New = append(change.New, b.Whisker)
+                change.Deltas = append(change.Deltas, newActionDelta(a.Whisker, b.Whisker, tolerance))
+                reported[b] = true
+            }
+            diff.Changes = append(diff.Changes, change)
+        }
+    }
+
+    for _, b := range newLeaves {
+        if newInOld[b] == 0 {
+            diff.Changes = append(diff.Changes, RegionChange{Kind: Added, New: []*Whisker{b.Whisker}})
+        }
+    }
+
+    return diff
+}
+
+// allWithin checks if the domains of all the nodes lie within the given range
+func allWithin(nodes []*WhiskerNode, mr *memory.MemoryRange) bool {
+    for _, node := range nodes {
+        if !mr.ContainsRange(node.Whisker.Domain) {
+            return false
+        }
+    }
+    return true
+}
+
+// intersection returns the range common to two ranges; it is empty along any signal where they do not overlap
+func intersection(a, b *memory.MemoryRange) *memory.MemoryRange {
+    lower := memory.NewMemory()
+    upper := memory.NewMemory()
+    for i := range lower.Values {
+        id := memory.SignalID(i)
+        lower.Values[i] = memory.DataType(math.Max(float64(a.Lower.Get(id)), float64(b.Lower.Get(id))))
+        upper.Values[i] = memory.DataType(math.Min(float64(a.Upper.Get(id)), float64(b.Upper.Get(id))))
+    }
+    return memory.NewMemoryRange(lower, upper)
+}
+
+// volume returns the volume of a range, or zero if it is empty
+func volume(mr *memory.MemoryRange) float64 {
+    v := 1.0
+    for i := range mr.Lower.Values {
+        extent := float64(mr.Upper.Get(memory.SignalID(i)) - mr.Lower.Values[i])
+        if extent <= 0 {
+            return 0
+        }
+        v *= extent
+    }
+    return v
+}
+
+// finiteBounds returns the smallest box holding every finite domain bound of the given nodes
+// Signals with no finite upper bound get a unit extent
+func finiteBounds(nodeSets ...[]*WhiskerNode) *memory.MemoryRange {
+    lower := memory.MaxMemory()
+    upper := memory.MinMemory()
+    for i := range upper.Values {
+        upper.Values[i] = memory.DataType(math.Inf(-1))
+    }
+    for _, nodes := range nodeSets {
+        for _, node := range nodes {
+            for i := range lower.Values {
+                for _, v := range []memory.DataType{node.Whisker.Domain.Lower.Get(memory.SignalID(i)), node.Whisker.Domain.Upper.Get(memory.SignalID(i))} {
+                    if math.IsInf(float64(v), 0) {
+                        continue
+                    }
+                    lower.Values[i] = memory.DataType(math.Min(float64(lower.Values[i]), float64(v)))
+                    upper.Values[i] = memory.DataType(math.Max(float64(upper.Values[i]), float64(v)))
+                }
+            }
+        }
+    }
+    for i := range lower.Values {
+        if math.IsInf(float64(lower.Values[i]), 0) {
+            lower.Values[i] = 0
+        }
+        if math.IsInf(float64(upper.Values[i]), 0) || upper.Values[i] <= lower.Values[i] {
+            upper.Values[i] = lower.Values[i] + 1
+        }
+    }
+    return memory.NewMemoryRange(lower, upper)
+}
@@ -0,0 +1,47 @@
+package whisker
+
+import (
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// splitTree returns a tree split in two on the receive rate, whose lower half has the given window multiple
+func splitTree(t *testing.T, multiple float64) *WhiskerTree {
+    tree := NewWhiskerTree()
+    domain := tree.Root.Whisker.Domain
+    cutLower, cutUpper := copyMemory(domain.Lower), copyMemory(domain.Upper)
+    cutLower.Set(memory.RecvRate, 10)
+    cutUpper.Set(memory.RecvRate, 10)
+    for _, w := range []*Whisker{
+        NewWhisker(1, 1, multiple, 0.01, memory.NewMemoryRange(domain.Lower, cutUpper)),
+        NewWhisker(1, 1, 1, 0.01, memory.NewMemoryRange(cutLower, domain.Upper)),
+    } {
+        if err := tree.Insert(w); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return tree
+}
+
+func TestDiffActionTolerance(t *testing.T) {
+    old := splitTree(t, 0.5)
+    new := splitTree(t, 0.5*(1+1e-12))
+
+    exact := Diff(old, new)
+    if exact.Count(Modified) != 1 || exact.Unchanged != 1 || exact.ChangedVolume == 0 {
+        t.Errorf("exact diff: %v", exact.Summary())
+    }
+
+    opts := DefaultDiffOptions()
+    opts.ActionTolerance = 1e-9
+    tolerant := DiffWithOptions(old, new, opts)
+    if len(tolerant.Changes) != 0 || tolerant.Unchanged != 2 || tolerant.ChangedVolume != 0 {
+        t.Errorf("diff within %g: %v", opts.ActionTolerance, tolerant)
+    }
+
+    // A difference larger than the tolerance is still a change
+    if d := DiffWithOptions(old, splitTree(t, 0.6), opts); d.Count(Modified) != 1 {
+        t.Errorf("diff of a changed multiple within %g: %v", opts.ActionTolerance, d.Summary())
+    }
+}
@@ -0,0 +1,69 @@
+package whisker
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// WriteDOT writes the structure of the tree in Graphviz DOT format
+// Each node is labelled with its action and the bounds of its domain that differ from its parent's;
+// nodes whose whiskers were used while tracking also show their use count
+func (wt *WhiskerTree) WriteDOT(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+
+    fmt.Fprintln(bw, "digraph whiskers {")
+    fmt.Fprintln(bw, "    node [shape=box, fontname=\"Helvetica\", fontsize=10];")
+
+    ids := make(map[*WhiskerNode]int)
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        id := len(ids)
+        ids[node] = id
+
+        var parentDomain *memory.MemoryRange
+        if parent != nil {
+            parentDomain = parent.Whisker.Domain
+        }
+        style := ""
+        if len(node.Children) == 0 {
+            style = ", style=filled, fillcolor=\"#e8f0fe\""
+        }
+        fmt.Fprintf(bw, "    n%d [label=\"%s\"%s];\n", id, dotLabel(node.Whisker, parentDomain), style)
+        if parent != nil {
+            fmt.Fprintf(bw, "    n%d -> n%d;\n", ids[parent], id)
+        }
+    })
+
+    fmt.Fprintln(bw, "}")
+    return bw.Flush()
+}
+
+// dotLabel returns the label of a whisker's node, with its domain given relative to its parent's
+func dotLabel(w *Whisker, parentDomain *memory.MemoryRange) string {
+    lines := []string{
+        fmt.Sprintf("gen %d", w.Generation),
+        fmt.Sprintf("w = %.6g*w + %d, intersend %.6g", w.WindowMultiple, w.WindowIncrement, w.Intersend),
+    }
+
+    names := memory.SignalNames()
+    for i := range w.Domain.Lower.Values {
+        id := memory.SignalID(i)
+        lower, upper := w.Domain.Lower.Get(id), w.Domain.Upper.Get(id)
+        if parentDomain != nil && lower == parentDomain.Lower.Get(id) && upper == parentDomain.Upper.Get(id) {
+            continue
+        }
+        name := fmt.Sprintf("signal %d", i)
+        if i < len(names) {
+            name = names[i]
+        }
+        lines = append(lines, fmt.Sprintf("%s in [%.6g, %.6g)", name, lower, upper))
+    }
+
+    if count := w.Count(); count > 0 {
+        lines = append(lines, fmt.Sprintf("used %d times", count))
+    }
+    return strings.Join(lines, "\\n")
+}
@@ -0,0 +1,48 @@
+package whisker
+
+import (
+    "bytes"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// smallTree returns a root covering all of memory with one child below a recv_rate of 100
+func smallTree(t *testing.T) *WhiskerTree {
+    t.Helper()
+    tree := NewWhiskerTree()
+    half := memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())
+    half.Upper.Set(memory.RecvRate, 100)
+    for _, w := range []*Whisker{
+        NewWhisker(0, 1, 1, 0.01, memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())),
+        NewWhisker(1, 2, 0.5, 0.02, half),
+    } {
+        if err := tree.Insert(w); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return tree
+}
+
+func TestWriteDOT(t *testing.T) {
+    tree := smallTree(t)
+    for i := 0; i < 3; i++ {
+        tree.Root.Children[0].Whisker.Use()
+    }
+
+    var b bytes.Buffer
+    if err := tree.WriteDOT(&b); err != nil {
+        t.Fatal(err)
+    }
+    // The root lists its whole domain, the child only the bound it narrows, and only the leaf is filled
+    want := `digraph whiskers {
+    node [shape=box, fontname="Helvetica", fontsize=10];
+    n0 [label="gen 0\nw = 1*w + 1, intersend 0.01\nrecv_rate in [0, +Inf)\nsend_rate in [0, +Inf)\nlatest_delay in [0, +Inf)\ninter_packet_delay in [0, +Inf)"];
+    n1 [label="gen 1\nw = 0.5*w + 2, intersend 0.02\nrecv_rate in [0, 100)\nused 3 times", style=filled, fillcolor="#e8f0fe"];
+    n0 -> n1;
+}
+`
+    if b.String() != want {
+        t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+    }
+}
@@ -0,0 +1,146 @@
+package whisker
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "unicode/utf8"
+
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/encoding/prototext"
+    "google.golang.org/protobuf/proto"
+)
+
+// Format is an encoding of whisker files and configurations
+type Format int
+
+const (
+    Binary Format = iota // Binary protobuf
+    JSON                 // Protobuf JSON mapping
+    Text                 // Protobuf text format
+)
+
+// String returns the name of the format
+func (f Format) String() string {
+    switch f {
+    case Binary:
+        return "binary"
+    case JSON:
+        return "json"
+    case Text:
+        return "text"
+    default:
+        return fmt.Sprintf("format(%d)", int(f))
+    }
+}
+
+// ParseFormat returns the format with the given name or one of its usual file extensions
+func ParseFormat(name string) (Format, error) {
+    switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+    case "binary", "pb", "proto", "bin":
+        return Binary, nil
+    case "json":
+        return JSON, nil
+    case "text", "textproto", "txtpb", "pbtxt", "prototxt":
+        return Text, nil
+    default:
+        return 0, fmt.Errorf("unknown format %q", name)
+    }
+}
+
+// FormatForFile returns the format implied by a file's extension, or false if the extension implies none
+func FormatForFile(filename string) (Format, bool) {
+    ext := filepath.Ext(filename)
+    if ext == "" {
+        return 0, false
+    }
+    f, err := ParseFormat(ext)
+    if err != nil {
+        return 0, false
+    }
+    return f, true
+}
+
+// DetectFormat guesses the format of encoded data from its content
+// Data starting with '{' is JSON, other printable text is text format and anything else is binary
+func DetectFormat(data []byte) Format {
+    trimmed := bytes.TrimSpace(data)
+    if len(trimmed) == 0 {
+        return Binary
+    }
+    if trimmed[0] == '{' {
+        return JSON
+    }
+    if !utf8.Valid(trimmed) {
+        return Binary
+    }
+    for _, r := range string(trimmed) {
+        if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+            return Binary
+        }
+    }
+    return Text
+}
+
+// Marshal encodes a message in the given format; JSON and text are indented for reading and editing
+func Marshal(m proto.Message, format Format) ([]byte, error) {
+    switch format {
+    case Binary:
+        return proto.Marshal(m)
+    case JSON:
+        return protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+    case Text:
+        return prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+    default:
+        return nil, fmt.Errorf("unknown format %v", format)
+    }
+}
+
+// Unmarshal decodes a message from data in the given format
+func Unmarshal(data []byte, format Format, m proto.Message) error {
+    switch format {
+    case Binary:
+        return proto.Unmarshal(data, m)
+    case JSON:
+        return protojson.Unmarshal(data, m)
+    case Text:
+        return prototext.Unmarshal(data, m)
+    default:
+        return fmt.Errorf("unknown format %v", format)
+    }
+}
+
+// ReadMessage reads a message from a file, in the format implied by its extension or else detected from its content
+func ReadMessage(filename string, m proto.Message) error {
+    data, err := os.ReadFile(filename)
+    if err != nil {
+        return err
+    }
+    format, ok := FormatForFile(filename)
+    if !ok {
+        format = DetectFormat(data)
+    }
+    if err := Unmarshal(data, format, m); err != nil {
+        return fmt.Errorf("failed to decode %s as %v: %w", filename, format, err)
+    }
+    return nil
+}
+
+// WriteMessage writes a message to a file in the given format
+func WriteMessage(filename string, m proto.Message, format Format) error {
+    data, err := Marshal(m, format)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filename, data, 0644)
+}
+
+// formatOrBinary returns the format implied by a file's extension, or binary if it implies none
+func formatOrBinary(filename string) Format {
+    if format, ok := FormatForFile(filename); ok {
+        return format
+    }
+    return Binary
+}
@@ -0,0 +1,89 @@
+package whisker
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "google.golang.org/protobuf/proto"
+
+    "github.com/Aanthord/remy-go/pkg/dna"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+    tree := smallTree(t)
+    dir := t.TempDir()
+    for _, test := range []struct {
+        format Format
+        ext    string
+    }{
+        {Binary, ".pb"},
+        {JSON, ".json"},
+        {Text, ".textproto"},
+    } {
+        // Saved with an extension, the file is in its format and loads back to the same whiskers
+        filename := filepath.Join(dir, "tree"+test.ext)
+        if err := SaveTree(tree, filename); err != nil {
+            t.Fatal(err)
+        }
+        data, err := os.ReadFile(filename)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := DetectFormat(data); got != test.format {
+            t.Errorf("%s file detected as %v, want %v", test.ext, got, test.format)
+        }
+        loaded, err := LoadWhiskers(filename)
+        if err != nil {
+            t.Fatalf("failed to load %s: %v", test.ext, err)
+        }
+        checkSameWhiskers(t, test.format, loaded, tree)
+
+        // Without an extension, the format is detected from the content
+        bare := filepath.Join(dir, "tree-"+test.format.String())
+        if err := os.WriteFile(bare, data, 0644); err != nil {
+            t.Fatal(err)
+        }
+        decoded := &dna.Whiskers{}
+        if err := ReadMessage(bare, decoded); err != nil {
+            t.Fatalf("failed to read %v without an extension: %v", test.format, err)
+        }
+        original := &dna.Whiskers{}
+        if err := Unmarshal(data, test.format, original); err != nil {
+            t.Fatal(err)
+        }
+        if !proto.Equal(decoded, original) {
+            t.Errorf("%v without an extension read as %v, want %v", test.format, decoded, original)
+        }
+    }
+}
+
+// checkSameWhiskers fails if two trees do not hold the same whiskers in the same order
+func checkSameWhiskers(t *testing.T, format Format, got, want *WhiskerTree) {
+    t.Helper()
+    g, w := got.Whiskers(), want.Whiskers()
+    if len(g) != len(w) {
+        t.Fatalf("%v: loaded %d whiskers, want %d", format, len(g), len(w))
+    }
+    for i := range w {
+        if !proto.Equal(g[i].ToDNAWhisker(), w[i].ToDNAWhisker()) {
+            t.Errorf("%v: whisker %d is %v, want %v", format, i, g[i], w[i])
+        }
+    }
+}
+
+func TestParseFormat(t *testing.T) {
+    for name, want := range map[string]Format{
+        "binary": Binary, ".pb": Binary, "json": JSON, "JSON": JSON, "text": Text, ".pbtxt": Text,
+    } {
+        if got, err := ParseFormat(name); err != nil || got != want {
+            t.Errorf("ParseFormat(%q) = %v, %v; want %v", name, got, err, want)
+        }
+    }
+    if _, err := ParseFormat("yaml"); err == nil {
+        t.Error("ParseFormat accepted yaml")
+    }
+    if _, ok := FormatForFile("whiskers"); ok {
+        t.Error("a file without an extension implied a format")
+    }
+}
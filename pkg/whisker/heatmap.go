@@ -0,0 +1,200 @@
+package whisker
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// ActionField selects one part of a whisker's action
+type ActionField int
+
+const (
+    WindowMultipleField  ActionField = iota // Multiple applied to the window
+    WindowIncrementField                    // Increment added to the window
+    IntersendField                          // Time between sends
+)
+
+// ActionFields lists every action field
+var ActionFields = []ActionField{WindowMultipleField, WindowIncrementField, IntersendField}
+
+// String returns the name of the action field
+func (f ActionField) String() string {
+    switch f {
+    case WindowMultipleField:
+        return "window_multiple"
+    case WindowIncrementField:
+        return "window_increment"
+    case IntersendField:
+        return "intersend"
+    default:
+        return fmt.Sprintf("field(%d)", int(f))
+    }
+}
+
+// ParseActionField returns the action field with the given name
+func ParseActionField(name string) (ActionField, error) {
+    for _, f := range ActionFields {
+        if f.String() == name {
+            return f, nil
+        }
+    }
+    return 0, fmt.Errorf("unknown action field %q", name)
+}
+
+// Value returns the value of the field in a whisker's action
+func (f ActionField) Value(w *Whisker) float64 {
+    switch f {
+    case WindowMultipleField:
+        return w.WindowMultiple
+    case WindowIncrementField:
+        return float64(w.WindowIncrement)
+    default:
+        return w.Intersend
+    }
+}
+
+// HeatmapOptions describe the slice of memory space a heatmap shows
+type HeatmapOptions struct {
+    Field    ActionField
+    X        memory.SignalID // Signal along the horizontal axis
+    Y        memory.SignalID // Signal along the vertical axis
+    XMin     memory.DataType
+    XMax     memory.DataType
+    YMin     memory.DataType
+    YMax     memory.DataType
+    Fixed    *memory.Memory // Values of the other signals; zero if nil
+    Columns  int            // Number of cells along the horizontal axis
+    Rows     int            // Number of cells along the vertical axis
+    CellSize int            // Size of a cell in pixels
+}
+
+// DefaultHeatmapOptions returns options for a heatmap of the given field over two signals,
+// spanning the finite bounds of the tree's leaves
+func (wt *WhiskerTree) DefaultHeatmapOptions(field ActionField, x, y memory.SignalID) HeatmapOptions {
+    bounds := finiteBounds(wt.Leaves())
+    return HeatmapOptions{
+        Field:    field,
+        X:        x,
+        Y:        y,
+        XMin:     bounds.Lower.Get(x),
+        XMax:     bounds.Upper.Get(x),
+        YMin:     bounds.Lower.Get(y),
+        YMax:     bounds.Upper.Get(y),
+        Columns:  100,
+        Rows:     100,
+        CellSize: 5,
+    }
+}
+
+// WriteHeatmapSVG writes an SVG heatmap of one action field over two signals, with the other signals fixed
+// Each cell is coloured by the action of the whisker chosen at its centre; cells no whisker covers are grey
+func (wt *WhiskerTree) WriteHeatmapSVG(w io.Writer, opts HeatmapOptions) error {
+    if opts.Columns <= 0 || opts.Rows <= 0 || opts.CellSize <= 0 {
+        return errors.New("heatmap needs a positive number of cells and cell size")
+    }
+    if !(opts.XMax > opts.XMin) || !(opts.YMax > opts.YMin) || math.IsInf(float64(opts.XMax-opts.XMin), 0) || math.IsInf(float64(opts.YMax-opts.YMin), 0) {
+        return fmt.Errorf("heatmap needs finite, non-empty ranges, got [%g, %g) by [%g, %g)", opts.XMin, opts.XMax, opts.YMin, opts.YMax)
+    }
+
+    // Sample the action at the centre of every cell
+    values := make([][]float64, opts.Rows)
+    low, high := math.Inf(1), math.Inf(-1)
+    m := memory.NewMemory()
+    if opts.Fixed != nil {
+        m.Update(opts.Fixed.Values...)
+    }
+    for row := 0; row < opts.Rows; row++ {
+        values[row] = make([]float64, opts.Columns)
+        y := opts.YMax - (opts.YMax-opts.YMin)*(memory.DataType(row)+0.5)/memory.DataType(opts.Rows)
+        for col := 0; col < opts.Columns; col++ {
+            x := opts.XMin + (opts.XMax-opts.XMin)*(memory.DataType(col)+0.5)/memory.DataType(opts.Columns)
+            m.Set(opts.X, x)
+            m.Set(opts.Y, y)
+            found, err := wt.FindWhisker(m)
+            if err != nil {
+                values[row][col] = math.NaN()
+                continue
+            }
+            v := opts.Field.Value(found)
+            values[row][col] = v
+            low = math.Min(low, v)
+            high = math.Max(high, v)
+        }
+    }
+
+    names := memory.SignalNames()
+    signalName := func(id memory.SignalID) string {
+        if int(id) < len(names) {
+            return names[id]
+        }
+        return fmt.Sprintf("signal %d", id)
+    }
+
+    const margin, legendWidth = 60, 80
+    plotWidth := opts.Columns * opts.CellSize
+    plotHeight := opts.Rows * opts.CellSize
+    width := margin + plotWidth + legendWidth
+    height := plotHeight + 2*margin
+
+    bw := bufio.NewWriter(w)
+    fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"Helvetica\" font-size=\"11\">\n", width, height)
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" font-size=\"14\">%s</text>\n", margin, margin/2, opts.Field)
+
+    for row := range values {
+        for col, v := range values[row] {
+            fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n",
+                margin+col*opts.CellSize, margin+row*opts.CellSize, opts.CellSize, opts.CellSize, heatColor(v, low, high))
+        }
+    }
+
+    // Axes
+    fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"black\"/>\n", margin, margin, plotWidth, plotHeight)
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\">%g</text>\n", margin, margin+plotHeight+15, opts.XMin)
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%g</text>\n", margin+plotWidth, margin+plotHeight+15, opts.XMax)
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">%s</text>\n", margin+plotWidth/2, margin+plotHeight+30, signalName(opts.X))
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%g</text>\n", margin-5, margin+plotHeight, opts.YMin)
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%g</text>\n", margin-5, margin+10, opts.YMax)
+    fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" transform=\"rotate(-90 %d %d)\">%s</text>\n",
+        margin-30, margin+plotHeight/2, margin-30, margin+plotHeight/2, signalName(opts.Y))
+
+    // Legend, from the highest value at the top to the lowest at the bottom
+    const steps = 20
+    legendX := margin + plotWidth + 20
+    step := plotHeight / steps
+    if low <= high {
+        for i := 0; i < steps; i++ {
+            v := high - (high-low)*float64(i)/float64(steps-1)
+            fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"15\" height=\"%d\" fill=\"%s\"/>\n", legendX, margin+i*step, step, heatColor(v, low, high))
+        }
+        fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\">%g</text>\n", legendX+20, margin+10, high)
+        fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\">%g</text>\n", legendX+20, margin+steps*step, low)
+    }
+
+    fmt.Fprintln(bw, "</svg>")
+    return bw.Flush()
+}
+
+// heatColor maps a value to a colour running from dark blue at the low end through green to yellow at the high end
+func heatColor(v, low, high float64) string {
+    if math.IsNaN(v) {
+        return "#bbbbbb"
+    }
+    t := 0.5
+    if high > low {
+        t = (v - low) / (high - low)
+    }
+    stops := [][3]float64{{68, 1, 84}, {59, 82, 139}, {33, 145, 140}, {94, 201, 98}, {253, 231, 37}}
+    pos := t * float64(len(stops)-1)
+    i := int(math.Min(math.Floor(pos), float64(len(stops)-2)))
+    f := pos - float64(i)
+    var rgb [3]int
+    for c := range rgb {
+        rgb[c] = int(math.Round(stops[i][c] + (stops[i+1][c]-stops[i][c])*f))
+    }
+    return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
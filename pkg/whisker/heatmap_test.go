@@ -0,0 +1,60 @@
+package whisker
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+func TestWriteHeatmapSVG(t *testing.T) {
+    tree := smallTree(t)
+    opts := HeatmapOptions{
+        Field:    WindowIncrementField,
+        X:        memory.RecvRate,
+        Y:        memory.SendRate,
+        XMin:     0,
+        XMax:     200,
+        YMin:     0,
+        YMax:     10,
+        Columns:  4,
+        Rows:     2,
+        CellSize: 10,
+    }
+    var b bytes.Buffer
+    if err := tree.WriteHeatmapSVG(&b, opts); err != nil {
+        t.Fatal(err)
+    }
+    svg := b.String()
+    if !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>\n") {
+        t.Fatalf("output is not an SVG document:\n%s", svg)
+    }
+
+    // The left half of the plot is the child's, with the highest increment, and the right half the root's
+    const low, high = "#440154", "#fde725"
+    for row := 0; row < 2; row++ {
+        for col := 0; col < 4; col++ {
+            fill := low
+            if col < 2 {
+                fill = high
+            }
+            want := fmt.Sprintf(`<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, 60+col*10, 60+row*10, fill)
+            if !strings.Contains(svg, want) {
+                t.Errorf("no cell %s", want)
+            }
+        }
+    }
+    for _, label := range []string{">window_increment<", ">recv_rate<", ">send_rate<", ">200<", ">10<"} {
+        if !strings.Contains(svg, label) {
+            t.Errorf("no %s label", label)
+        }
+    }
+
+    opts.XMax = opts.XMin
+    if err := tree.WriteHeatmapSVG(&b, opts); err == nil {
+        t.Error("WriteHeatmapSVG accepted an empty range")
+    }
+}
+
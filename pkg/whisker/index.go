@@ -0,0 +1,179 @@
+package whisker
+
+import (
+    "sort"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+const (
+    bucketSize    = 8  // Number of domains below which a k-d node is not split further
+    maxIndexDepth = 64 // Maximum depth of the k-d tree
+)
+
+// indexEntry is a node of the whisker tree along with its depth in that tree
+type indexEntry struct {
+    node  *WhiskerNode
+    depth int
+}
+
+// kdNode is a node of the k-d tree over whisker domains
+// Internal nodes split memory space on one signal at a cut value; leaves hold the domains that
+// overlap their cell, deepest first
+type kdNode struct {
+    dim     memory.SignalID
+    cut     memory.DataType
+    left    *kdNode      // Cell below the cut
+    right   *kdNode      // Cell at or above the cut
+    entries []indexEntry // Domains overlapping a leaf cell
+}
+
+// index is a k-d tree used by FindWhisker to reach the deepest node containing a memory value
+// without scanning the children of every node on the way down
+type index struct {
+    root *kdNode
+}
+
+// newIndex builds the lookup index of a whisker tree
+func newIndex(wt *WhiskerTree) *index {
+    var entries []indexEntry
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        entries = append(entries, indexEntry{node: node, depth: depth})
+    })
+    return &index{root: buildKD(entries, 0)}
+}
+
+// find returns the deepest node whose domain contains the memory value, or nil if there is none
+func (idx *index) find(m *memory.Memory) *WhiskerNode {
+    cell := idx.root
+    for cell.left != nil {
+        if m.Get(cell.dim) < cell.cut {
+            cell = cell.left
+        } else {
+            cell = cell.right
+        }
+    }
+    for _, entry := range cell.entries {
+        if entry.node.Whisker.Domain.Contains(m) {
+            return entry.node
+        }
+    }
+    return nil
+}
+
+// buildKD is a recursive function that builds the k-d tree over the given entries
+func buildKD(entries []indexEntry, depth int) *kdNode {
+    if len(entries) <= bucketSize || depth >= maxIndexDepth {
+        return newKDLeaf(entries)
+    }
+
+    // Pick the signal whose median lower bound splits the domains most evenly
+    dims := len(entries[0].node.Whisker.Domain.Lower.Values)
+    bestCost := len(entries)
+    var bestDim memory.SignalID
+    var bestCut memory.DataType
+    for d := 0; d < dims; d++ {
+        dim := memory.SignalID(d)
+        cut, ok := medianLower(entries, dim)
+        if !ok {
+            continue
+        }
+        left, right := countSplit(entries, dim, cut)
+        cost := left
+        if right > cost {
+            cost = right
+        }
+        if cost < bestCost {
+            bestCost, bestDim, bestCut = cost, dim, cut
+        }
+    }
+    if bestCost >= len(entries) {
+        return newKDLeaf(entries)
+    }
+
+    var left, right []indexEntry
+    for _, entry := range entries {
+        domain := entry.node.Whisker.Domain
+        if domain.Lower.Get(bestDim) < bestCut {
+            left = append(left, entry)
+        }
+        if domain.Upper.Get(bestDim) > bestCut {
+            right = append(right, entry)
+        }
+    }
+    return &kdNode{
+        dim:   bestDim,
+        cut:   bestCut,
+        left:  buildKD(left, depth+1),
+        right: buildKD(right, depth+1),
+    }
+}
+
+// medianLower returns the median of the distinct lower bounds of the domains along a signal,
+// excluding the smallest one since cutting there separates nothing
+func medianLower(entries []indexEntry, dim memory.SignalID) (memory.DataType, bool) {
+    seen := make(map[memory.DataType]bool)
+    var bounds []memory.DataType
+    for _, entry := range entries {
+        v := entry.node.Whisker.Domain.Lower.Get(dim)
+        if !seen[v] {
+            seen[v] = true
+            bounds = append(bounds, v)
+        }
+    }
+    if len(bounds) < 2 {
+        return 0, false
+    }
+    sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+    return bounds[1+(len(bounds)-1)/2], true
+}
+
+// countSplit returns how many domains overlap each side of a cut
+func countSplit(entries []indexEntry, dim memory.SignalID, cut memory.DataType) (int, int) {
+    left, right := 0, 0
+    for _, entry := range entries {
+        domain := entry.node.Whisker.Domain
+        if domain.Lower.Get(dim) < cut {
+            left++
+        }
+        if domain.Upper.Get(dim) > cut {
+            right++
+        }
+    }
+    return left, right
+}
+
+// newKDLeaf creates a leaf cell holding the given entries, deepest first
+func newKDLeaf(entries []indexEntry) *kdNode {
+    sorted := make([]indexEntry, len(entries))
+    copy(sorted, entries)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].depth > sorted[j].depth })
+    return &kdNode{entries: sorted}
+}
+
+// overlapping returns the nodes whose domains intersect the given range
+func (idx *index) overlapping(mr *memory.MemoryRange) []*WhiskerNode {
+    seen := make(map[*WhiskerNode]bool)
+    var nodes []*WhiskerNode
+    idx.root.collect(mr, seen, &nodes)
+    return nodes
+}
+
+// collect is a recursive function that gathers the nodes of the cells a range overlaps
+func (cell *kdNode) collect(mr *memory.MemoryRange, seen map[*WhiskerNode]bool, nodes *[]*WhiskerNode) {
+    if cell.left != nil {
+        if mr.Lower.Get(cell.dim) < cell.cut {
+            cell.left.collect(mr, seen, nodes)
+        }
+        if mr.Upper.Get(cell.dim) > cell.cut {
+            cell.right.collect(mr, seen, nodes)
+        }
+        return
+    }
+    for _, entry := range cell.entries {
+        if !seen[entry.node] && entry.node.Whisker.Domain.Intersects(mr) {
+            seen[entry.node] = true
+            *nodes = append(*nodes, entry.node)
+        }
+    }
+}
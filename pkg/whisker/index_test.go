@@ -0,0 +1,128 @@
+package whisker
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// randomTree returns a tree of about n whiskers grown by splitting random leaves in two along a random signal
+// Some splits keep only one half, so that the parent's whisker still handles the other, as in trained trees.
+func randomTree(rng *rand.Rand, n int) *WhiskerTree {
+    tree := NewWhiskerTree()
+    leaves := []*WhiskerNode{tree.Root}
+    count := 1
+    for count < n {
+        i := rng.Intn(len(leaves))
+        leaf := leaves[i]
+        domain := leaf.Whisker.Domain
+        dim := memory.SignalID(rng.Intn(memory.NumSignals()))
+        lower, upper := domain.Lower.Get(dim), domain.Upper.Get(dim)
+        cut := (lower + upper) / 2
+        if math.IsInf(float64(upper), 1) {
+            cut = lower*2 + 100
+        }
+
+        var children []*WhiskerNode
+        for half, bounds := range [][2]memory.DataType{{lower, cut}, {cut, upper}} {
+            if len(children) == 0 && half == 1 || rng.Float64() < 0.8 {
+                childLower := copyMemory(domain.Lower)
+                childUpper := copyMemory(domain.Upper)
+                childLower.Set(dim, bounds[0])
+                childUpper.Set(dim, bounds[1])
+                w := NewWhisker(1, rng.Intn(10)-5, rng.Float64()*2, rng.Float64()/100, memory.NewMemoryRange(childLower, childUpper))
+                children = append(children, &WhiskerNode{Whisker: w})
+            }
+        }
+        leaf.Children = children
+        leaves = append(leaves[:i], leaves[i+1:]...)
+        leaves = append(leaves, children...)
+        count += len(children)
+    }
+    tree.Invalidate()
+    return tree
+}
+
+// copyMemory returns a copy of a memory's values
+func copyMemory(m *memory.Memory) *memory.Memory {
+    c := memory.NewMemory()
+    c.Update(m.Values...)
+    return c
+}
+
+// linearFind returns the whisker of the deepest node containing the memory by scanning every node of the tree
+func linearFind(tree *WhiskerTree, m *memory.Memory) *Whisker {
+    var found *Whisker
+    deepest := -1
+    tree.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        if depth > deepest && node.Whisker.Domain.Contains(m) {
+            found, deepest = node.Whisker, depth
+        }
+    })
+    return found
+}
+
+// randomMemories returns memories spread over the tree, half of them on the lower corner of a random whisker's
+// domain so that the half-open boundaries are exercised
+func randomMemories(rng *rand.Rand, tree *WhiskerTree, n int) []*memory.Memory {
+    var nodes []*WhiskerNode
+    tree.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        nodes = append(nodes, node)
+    })
+    memories := make([]*memory.Memory, n)
+    for i := range memories {
+        m := memory.NewMemory()
+        if i%2 == 0 {
+            m.Update(nodes[rng.Intn(len(nodes))].Whisker.Domain.Lower.Values...)
+        } else {
+            for id := range m.Values {
+                m.Values[id] = memory.DataType(rng.Float64() * 1500)
+            }
+        }
+        memories[i] = m
+    }
+    return memories
+}
+
+func TestIndexAgreesWithLinearScan(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    for _, size := range []int{1, 10, 100, 1000, 10000} {
+        tree := randomTree(rng, size)
+        for _, m := range randomMemories(rng, tree, 2000) {
+            want := linearFind(tree, m)
+            got, err := tree.FindWhisker(m)
+            if want == nil {
+                if err == nil {
+                    t.Fatalf("tree of %d: FindWhisker(%v) = %v, the linear scan found nothing", size, m, got)
+                }
+                continue
+            }
+            if err != nil || got != want {
+                t.Fatalf("tree of %d: FindWhisker(%v) = %v, %v; the linear scan found %v", size, m, got, err, want)
+            }
+        }
+    }
+}
+
+func BenchmarkFindWhisker(b *testing.B) {
+    for _, size := range []int{10000, 100000} {
+        rng := rand.New(rand.NewSource(1))
+        tree := randomTree(rng, size)
+        memories := randomMemories(rng, tree, 1024)
+        tree.lookupIndex()
+
+        b.Run(fmt.Sprintf("indexed/%d", size), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                tree.FindWhisker(memories[i%len(memories)])
+            }
+        })
+        b.Run(fmt.Sprintf("linear/%d", size), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                linearFind(tree, memories[i%len(memories)])
+            }
+        })
+    }
+}
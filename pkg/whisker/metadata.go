@@ -0,0 +1,124 @@
+package whisker
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "runtime/debug"
+    "sort"
+    "strings"
+    "time"
+
+    "google.golang.org/protobuf/encoding/prototext"
+    "google.golang.org/protobuf/proto"
+    "github.com/Aanthord/remy-go/pkg/dna"
+)
+
+// FormatVersion is the version of the whisker file format written by this package
+// Files without metadata predate versioning and are read as version 0
+const FormatVersion = 1
+
+// NewMetadata returns metadata for a whisker file written now by this build
+func NewMetadata() *dna.Metadata {
+    return &dna.Metadata{
+        FormatVersion: FormatVersion,
+        GitRevision:   gitRevision(),
+        CreatedTimeNs: time.Now().UnixNano(),
+    }
+}
+
+// gitRevision returns the revision of the source this binary was built from, if the build recorded it
+func gitRevision() string {
+    info, ok := debug.ReadBuildInfo()
+    if !ok {
+        return ""
+    }
+    revision, modified := "", false
+    for _, setting := range info.Settings {
+        switch setting.Key {
+        case "vcs.revision":
+            revision = setting.Value
+        case "vcs.modified":
+            modified = setting.Value == "true"
+        }
+    }
+    if revision != "" && modified {
+        revision += "-dirty"
+    }
+    return revision
+}
+
+// ContentHash returns a hash of the whiskers and signal layout of a whisker file, ignoring its metadata
+func ContentHash(dnaWhiskers *dna.Whiskers) (string, error) {
+    content := &dna.Whiskers{Whiskers: dnaWhiskers.Whiskers, Signals: dnaWhiskers.Signals}
+    data, err := proto.MarshalOptions{Deterministic: true}.Marshal(content)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(data)
+    return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// checkMetadata refuses whisker files of a format version this package does not understand and
+// reports files whose content does not match the hash recorded in their metadata
+func checkMetadata(dnaWhiskers *dna.Whiskers) ([]Issue, error) {
+    metadata := dnaWhiskers.Metadata
+    if metadata == nil {
+        return nil, nil
+    }
+    if metadata.FormatVersion > FormatVersion {
+        return nil, fmt.Errorf("whisker file format version %d is newer than the supported version %d", metadata.FormatVersion, FormatVersion)
+    }
+    if metadata.ContentHash == "" {
+        return nil, nil
+    }
+    hash, err := ContentHash(dnaWhiskers)
+    if err != nil {
+        return nil, err
+    }
+    if hash != metadata.ContentHash {
+        return []Issue{{
+            Kind:    HashMismatch,
+            Message: fmt.Sprintf("content hash %s does not match %s recorded in the metadata", hash, metadata.ContentHash),
+        }}, nil
+    }
+    return nil, nil
+}
+
+// MetadataString returns a human-readable summary of whisker file metadata
+func MetadataString(metadata *dna.Metadata) string {
+    if metadata == nil {
+        return "no metadata (format version 0)"
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "format version: %d\n", metadata.FormatVersion)
+    if metadata.CreatedTimeNs != 0 {
+        fmt.Fprintf(&b, "created: %s\n", time.Unix(0, metadata.CreatedTimeNs).UTC().Format(time.RFC3339))
+    }
+    if metadata.GitRevision != "" {
+        fmt.Fprintf(&b, "git revision: %s\n", metadata.GitRevision)
+    }
+    if metadata.Objective != "" {
+        var params []string
+        for name, value := range metadata.ObjectiveParameters {
+            params = append(params, fmt.Sprintf("%s=%g", name, value))
+        }
+        sort.Strings(params)
+        fmt.Fprintf(&b, "objective: %s %s\n", metadata.Objective, strings.Join(params, " "))
+        fmt.Fprintf(&b, "score: %g\n", metadata.Score)
+    }
+    if metadata.Generations != 0 {
+        fmt.Fprintf(&b, "generations: %d\n", metadata.Generations)
+    }
+    if metadata.Seed != 0 {
+        fmt.Fprintf(&b, "seed: %d\n", metadata.Seed)
+    }
+    if metadata.Config != nil {
+        fmt.Fprintf(&b, "config: %s\n", prototext.MarshalOptions{}.Format(metadata.Config))
+    }
+    if metadata.ContentHash != "" {
+        fmt.Fprintf(&b, "content hash: %s\n", metadata.ContentHash)
+    }
+    return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,100 @@
+package whisker
+
+import (
+    "errors"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/dna"
+)
+
+// savedTree saves a small tree to a file in the given directory and returns the file's name and contents
+func savedTree(t *testing.T, dir string) (string, *dna.Whiskers) {
+    t.Helper()
+    filename := filepath.Join(dir, "tree.json")
+    if err := SaveTree(smallTree(t), filename); err != nil {
+        t.Fatal(err)
+    }
+    saved := &dna.Whiskers{}
+    if err := ReadMessage(filename, saved); err != nil {
+        t.Fatal(err)
+    }
+    return filename, saved
+}
+
+// errorsIn returns the issues that are not warnings; the small tree's partial split is one
+func errorsIn(issues []Issue) []Issue {
+    var found []Issue
+    for _, issue := range issues {
+        if !issue.Kind.Warning() {
+            found = append(found, issue)
+        }
+    }
+    return found
+}
+
+func TestSavedMetadata(t *testing.T) {
+    filename, saved := savedTree(t, t.TempDir())
+    if saved.Metadata.GetFormatVersion() != FormatVersion || !strings.HasPrefix(saved.Metadata.GetContentHash(), "sha256:") {
+        t.Fatalf("saved metadata is %v, want format version %d and a content hash", saved.Metadata, FormatVersion)
+    }
+    tree, issues, err := LoadWhiskersWithOptions(filename, LoadOptions{Strict: true, Validation: DefaultValidationOptions()})
+    if err != nil || len(errorsIn(issues)) != 0 {
+        t.Fatalf("loading an untouched file gave %v, %v", issues, err)
+    }
+    if tree.Metadata.GetContentHash() != saved.Metadata.ContentHash {
+        t.Errorf("loaded tree has metadata %v", tree.Metadata)
+    }
+
+    // The hash covers the whiskers and signals only, so changing the metadata leaves it valid
+    saved.Metadata.Objective = "edited"
+    if hash, err := ContentHash(saved); err != nil || hash != saved.Metadata.ContentHash {
+        t.Errorf("hash with edited metadata is %s, %v; want %s", hash, err, saved.Metadata.ContentHash)
+    }
+}
+
+func TestNewerFormatVersionIsRefused(t *testing.T) {
+    filename, saved := savedTree(t, t.TempDir())
+    saved.Metadata.FormatVersion = FormatVersion + 1
+    if err := WriteMessage(filename, saved, JSON); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadWhiskers(filename); err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+        t.Errorf("loading format version %d gave %v, want it refused", FormatVersion+1, err)
+    }
+
+    // Files without metadata predate versioning and still load
+    saved.Metadata = nil
+    if err := WriteMessage(filename, saved, JSON); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadWhiskers(filename); err != nil {
+        t.Errorf("failed to load a file without metadata: %v", err)
+    }
+}
+
+func TestTamperedFileReportsHashMismatch(t *testing.T) {
+    filename, saved := savedTree(t, t.TempDir())
+    saved.Whiskers[1].WindowIncrement = 50
+    if err := WriteMessage(filename, saved, JSON); err != nil {
+        t.Fatal(err)
+    }
+
+    tree, issues, err := LoadWhiskersWithOptions(filename, LoadOptions{Validation: DefaultValidationOptions()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if issues = errorsIn(issues); len(issues) != 1 || issues[0].Kind != HashMismatch {
+        t.Fatalf("loading a tampered file found %v, want a hash mismatch", issues)
+    }
+    if w := tree.Root.Children[0].Whisker; w.WindowIncrement != 50 {
+        t.Errorf("loaded whisker %v, want the tampered increment of 50", w)
+    }
+
+    // A mismatch is not a warning, so strict loading refuses the file
+    var validation *ValidationError
+    if _, _, err := LoadWhiskersWithOptions(filename, LoadOptions{Strict: true, Validation: DefaultValidationOptions()}); !errors.As(err, &validation) {
+        t.Errorf("strict loading of a tampered file gave %v, want a validation error", err)
+    }
+}
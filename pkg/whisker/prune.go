@@ -0,0 +1,248 @@
+package whisker
+
+import (
+    "fmt"
+    "math"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// ScoreFunc evaluates a whisker tree and returns its score, higher being better
+// Prune expects it to run the tree with tracking enabled, so that the use count of every whisker is updated
+type ScoreFunc func(*WhiskerTree) (float64, error)
+
+// PruneOptions control which whiskers Prune tries to collapse and which results it keeps
+type PruneOptions struct {
+    MinCount        uint64  // Leaves used fewer times than this are considered unused
+    ActionTolerance float64 // Relative difference below which two actions are considered the same
+    ScoreTolerance  float64 // Largest drop in score accepted for a collapse
+    MaxEvaluations  int     // Limit on the number of times the tree is evaluated, or 0 for no limit
+}
+
+// DefaultPruneOptions returns options that collapse unused leaves and siblings with identical actions
+// whenever the score does not drop at all
+func DefaultPruneOptions() PruneOptions {
+    return PruneOptions{
+        MinCount:        1,
+        ActionTolerance: 0,
+        ScoreTolerance:  0,
+        MaxEvaluations:  0,
+    }
+}
+
+// PruneResult summarizes a run of Prune
+type PruneResult struct {
+    WhiskersBefore int     // Number of whiskers in the tree that was pruned
+    WhiskersAfter  int     // Number of whiskers in the pruned tree
+    BaselineScore  float64 // Score of the tree that was pruned
+    Score          float64 // Score of the pruned tree
+    Collapsed      int     // Number of nodes whose children were collapsed into them
+    Rejected       int     // Number of collapses undone because the score dropped too much
+    Evaluations    int     // Number of times the score function was called
+}
+
+// String returns a string representation of the prune result
+func (r *PruneResult) String() string {
+    return fmt.Sprintf("%d -> %d whiskers, score %g -> %g, %d collapsed, %d rejected, %d evaluations",
+        r.WhiskersBefore, r.WhiskersAfter, r.BaselineScore, r.Score, r.Collapsed, r.Rejected, r.Evaluations)
+}
+
+// Prune shrinks a whisker tree by collapsing the children of a node back into it
+// A node whose children are all leaves is a candidate when its used children (those used at least
+// MinCount times) have actions within ActionTolerance of each other; the node then takes the action
+// of its most used child. Candidates are tried in batches, halving a batch whose score drops by more
+// than ScoreTolerance, and this repeats until no collapse is kept. The tree passed in is not modified.
+// The tree must score a finite number, since no drop from an infinite score can be measured; a collapse whose
+// score is not finite is rejected.
+func Prune(wt *WhiskerTree, score ScoreFunc, opts PruneOptions) (*WhiskerTree, *PruneResult, error) {
+    p := &pruner{
+        score:    score,
+        opts:     opts,
+        rejected: make(map[*memory.MemoryRange]bool),
+        result:   &PruneResult{WhiskersBefore: wt.Len()},
+    }
+
+    current, _ := wt.Clone()
+    baseline, err := p.evaluate(current)
+    if err != nil {
+        return nil, nil, err
+    }
+    if !finite(baseline) {
+        return nil, nil, fmt.Errorf("whisker tree scores %g, cannot prune against a score that is not finite", baseline)
+    }
+    p.result.BaselineScore = baseline
+    p.result.Score = baseline
+    p.baseline = baseline
+
+    for {
+        candidates := p.candidates(current)
+        if len(candidates) == 0 {
+            break
+        }
+        next, _, collapsed, err := p.tryBatch(current, candidates)
+        if err != nil {
+            return nil, nil, err
+        }
+        if collapsed == 0 {
+            break
+        }
+        current = next
+    }
+
+    p.result.WhiskersAfter = current.Len()
+    return current, p.result, nil
+}
+
+// pruner holds the state of a run of Prune
+type pruner struct {
+    score    ScoreFunc
+    opts     PruneOptions
+    baseline float64
+    rejected map[*memory.MemoryRange]bool // Domains of nodes whose collapse was rejected; copies of a tree share them
+    result   *PruneResult
+}
+
+// evaluate resets the use counts of a tree and scores it
+func (p *pruner) evaluate(wt *WhiskerTree) (float64, error) {
+    wt.ResetCounts()
+    p.result.Evaluations++
+    s, err := p.score(wt)
+    if err != nil {
+        return 0, fmt.Errorf("failed to evaluate whisker tree: %w", err)
+    }
+    return s, nil
+}
+
+// finite checks if a score is neither infinite nor NaN
+func finite(s float64) bool {
+    return !math.IsInf(s, 0) && !math.IsNaN(s)
+}
+
+// exhausted checks if the evaluation budget has been used up
+func (p *pruner) exhausted() bool {
+    return p.opts.MaxEvaluations > 0 && p.result.Evaluations >= p.opts.MaxEvaluations
+}
+
+// tryBatch is a recursive function that collapses a batch of candidates in a copy of the tree and keeps the copy
+// if its score is acceptable; otherwise it tries each half of the batch in turn
+// It returns the resulting tree, a map from the nodes of wt to the nodes of that tree (nil if it is wt itself)
+// and the number of candidates collapsed
+func (p *pruner) tryBatch(wt *WhiskerTree, candidates []*WhiskerNode) (*WhiskerTree, map[*WhiskerNode]*WhiskerNode, int, error) {
+    if p.exhausted() {
+        return wt, nil, 0, nil
+    }
+
+    trial, copies := wt.Clone()
+    for _, node := range candidates {
+        collapse(trial, copies[node])
+    }
+    trial.Invalidate()
+
+    s, err := p.evaluate(trial)
+    if err != nil {
+        return nil, nil, 0, err
+    }
+    if finite(s) && s >= p.baseline-p.opts.ScoreTolerance {
+        p.result.Collapsed += len(candidates)
+        p.result.Score = s
+        return trial, copies, len(candidates), nil
+    }
+    if len(candidates) == 1 {
+        p.rejected[candidates[0].Whisker.Domain] = true
+        p.result.Rejected++
+        return wt, nil, 0, nil
+    }
+
+    half := len(candidates) / 2
+    first, firstCopies, n1, err := p.tryBatch(wt, candidates[:half])
+    if err != nil {
+        return nil, nil, 0, err
+    }
+    rest := translate(candidates[half:], firstCopies)
+    second, secondCopies, n2, err := p.tryBatch(first, rest)
+    if err != nil {
+        return nil, nil, 0, err
+    }
+    return second, compose(firstCopies, secondCopies), n1 + n2, nil
+}
+
+// translate maps nodes through a map from the nodes of one tree to those of its copy; a nil map leaves them as they are
+func translate(nodes []*WhiskerNode, copies map[*WhiskerNode]*WhiskerNode) []*WhiskerNode {
+    if copies == nil {
+        return nodes
+    }
+    translated := make([]*WhiskerNode, len(nodes))
+    for i, node := range nodes {
+        translated[i] = copies[node]
+    }
+    return translated
+}
+
+// compose combines two maps between the nodes of successive copies of a tree into one
+func compose(first, second map[*WhiskerNode]*WhiskerNode) map[*WhiskerNode]*WhiskerNode {
+    if first == nil {
+        return second
+    }
+    if second == nil {
+        return first
+    }
+    composed := make(map[*WhiskerNode]*WhiskerNode, len(first))
+    for from, to := range first {
+        composed[from] = second[to]
+    }
+    return composed
+}
+
+// candidates returns the nodes whose children are all leaves and can be collapsed into them,
+// leaving out those whose collapse was already rejected
+func (p *pruner) candidates(wt *WhiskerTree) []*WhiskerNode {
+    var candidates []*WhiskerNode
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        if len(node.Children) == 0 || p.rejected[node.Whisker.Domain] {
+            return
+        }
+        var used []*Whisker
+        for _, child := range node.Children {
+            if len(child.Children) > 0 {
+                return
+            }
+            if child.Whisker.Count() >= p.opts.MinCount {
+                used = append(used, child.Whisker)
+            }
+        }
+        for i := 1; i < len(used); i++ {
+            if !sameAction(used[0], used[i], p.opts.ActionTolerance) {
+                return
+            }
+        }
+        candidates = append(candidates, node)
+    })
+    return candidates
+}
+
+// collapse removes the children of a node, giving the node the action of its most used child
+func collapse(wt *WhiskerTree, node *WhiskerNode) {
+    chosen := node.Children[0].Whisker
+    for _, child := range node.Children[1:] {
+        if child.Whisker.Count() > chosen.Count() {
+            chosen = child.Whisker
+        }
+    }
+    node.Whisker = NewWhisker(node.Whisker.Generation, chosen.WindowIncrement, chosen.WindowMultiple, chosen.Intersend, node.Whisker.Domain)
+    node.Children = nil
+    if node == wt.Root {
+        wt.placeholder = false
+    }
+}
+
+// sameAction checks if the actions of two whiskers differ by no more than the given relative tolerance
+func sameAction(a, b *Whisker, tolerance float64) bool {
+    return within(float64(a.WindowIncrement), float64(b.WindowIncrement), tolerance) &&
+        within(a.WindowMultiple, b.WindowMultiple, tolerance) &&
+        within(a.Intersend, b.Intersend, tolerance)
+}
+
+// within checks if two values differ by no more than the given fraction of the larger of them
+func within(a, b, tolerance float64) bool {
+    return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,59 @@
+package whisker
+
+import (
+    "math"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// prunableTree returns a root with two leaves of the same action, which Prune collapses if the score allows it
+func prunableTree() *WhiskerTree {
+    tree := NewWhiskerTree()
+    domain := tree.Root.Whisker.Domain
+    lower, upper := copyMemory(domain.Lower), copyMemory(domain.Upper)
+    lower.Set(memory.RecvRate, 10)
+    upper.Set(memory.RecvRate, 10)
+    for _, r := range []*memory.MemoryRange{memory.NewMemoryRange(domain.Lower, upper), memory.NewMemoryRange(lower, domain.Upper)} {
+        tree.Root.Children = append(tree.Root.Children, &WhiskerNode{Whisker: NewWhisker(1, 1, 1, 0.01, r)})
+    }
+    tree.Invalidate()
+    return tree
+}
+
+func TestPruneRefusesNonFiniteBaseline(t *testing.T) {
+    for _, baseline := range []float64{math.Inf(-1), math.Inf(1), math.NaN()} {
+        score := func(*WhiskerTree) (float64, error) { return baseline, nil }
+        if _, _, err := Prune(prunableTree(), score, DefaultPruneOptions()); err == nil {
+            t.Errorf("Prune with a baseline score of %g did not fail", baseline)
+        }
+    }
+}
+
+func TestPruneRejectsNonFiniteCandidates(t *testing.T) {
+    for _, candidate := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+        score := func(wt *WhiskerTree) (float64, error) {
+            if wt.Len() < 3 {
+                return candidate, nil
+            }
+            return -1, nil
+        }
+        pruned, result, err := Prune(prunableTree(), score, DefaultPruneOptions())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if pruned.Len() != 3 || result.Collapsed != 0 || result.Rejected != 1 {
+            t.Errorf("collapse scoring %g was kept: %v", candidate, result)
+        }
+    }
+
+    // A collapse that scores the same as the tree is still kept
+    score := func(*WhiskerTree) (float64, error) { return -1, nil }
+    pruned, result, err := Prune(prunableTree(), score, DefaultPruneOptions())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pruned.Len() != 1 || result.Collapsed != 1 {
+        t.Errorf("collapse with an equal score was rejected: %v", result)
+    }
+}
@@ -0,0 +1,131 @@
+package whisker
+
+import (
+    "fmt"
+    "math"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// QuantizeOptions choose the fixed-point representation a whisker tree is quantized to
+// Signal values and domain bounds share one scale, as a kernel or embedded controller compares them directly
+type QuantizeOptions struct {
+    DomainShift   uint          // Fractional bits of signal values and domain bounds
+    MultipleShift uint          // Fractional bits of window multiples
+    IntersendUnit time.Duration // Resolution of intersend times
+    Bits          uint          // Width of the unsigned integers holding signal values; larger bounds saturate to +Inf
+}
+
+// DefaultQuantizeOptions returns the representation used by generated BPF programs: 64-bit values with 16
+// fractional bits and intersend times in nanoseconds
+func DefaultQuantizeOptions() QuantizeOptions {
+    return QuantizeOptions{
+        DomainShift:   16,
+        MultipleShift: 16,
+        IntersendUnit: time.Nanosecond,
+        Bits:          64,
+    }
+}
+
+// QuantizeResult summarizes how much a tree changed when it was quantized
+type QuantizeResult struct {
+    Whiskers          int     // Number of whiskers quantized
+    MaxBoundError     float64 // Largest change of a finite domain bound
+    MaxMultipleError  float64 // Largest change of a window multiple
+    MaxIntersendError float64 // Largest change of an intersend time in seconds
+    Saturated         int     // Number of finite bounds too large to represent
+    Empty             int     // Number of whiskers whose domain became empty, so that they are never chosen
+}
+
+// String returns a string representation of the quantize result
+func (r *QuantizeResult) String() string {
+    return fmt.Sprintf("%d whiskers, max bound error %g, max multiple error %g, max intersend error %gs, %d saturated bounds, %d empty domains",
+        r.Whiskers, r.MaxBoundError, r.MaxMultipleError, r.MaxIntersendError, r.Saturated, r.Empty)
+}
+
+// Quantize returns a copy of the tree whose domain bounds and actions are rounded to the given fixed-point representation
+// The copy still holds floats, so it runs anywhere a tree does, but every value is exactly representable in fixed point.
+// A controller that truncates signal values to fixed point and compares them with the quantized bounds chooses the
+// same whisker as the copy does for the untruncated values, since floor(v*2^s) >= B exactly when v >= B/2^s.
+// The tree passed in is not modified
+func Quantize(wt *WhiskerTree, opts QuantizeOptions) (*WhiskerTree, *QuantizeResult, error) {
+    if opts.Bits == 0 || opts.Bits > 64 || opts.DomainShift >= opts.Bits {
+        return nil, nil, fmt.Errorf("cannot quantize to %d-bit values with %d fractional bits", opts.Bits, opts.DomainShift)
+    }
+    if opts.MultipleShift > 52 {
+        return nil, nil, fmt.Errorf("window multiples cannot have %d fractional bits", opts.MultipleShift)
+    }
+    if opts.IntersendUnit <= 0 {
+        return nil, nil, fmt.Errorf("intersend unit must be positive, not %v", opts.IntersendUnit)
+    }
+
+    q := &quantizer{
+        opts:   opts,
+        scale:  math.Ldexp(1, int(opts.DomainShift)),
+        max:    math.Ldexp(1, int(opts.Bits)) - 1,
+        result: &QuantizeResult{},
+    }
+    quantized, _ := wt.Clone()
+    quantized.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        node.Whisker = q.whisker(node.Whisker)
+    })
+    quantized.Invalidate()
+    return quantized, q.result, nil
+}
+
+// quantizer holds the state of a run of Quantize
+type quantizer struct {
+    opts   QuantizeOptions
+    scale  float64 // Value of one in fixed point
+    max    float64 // Largest fixed-point value
+    result *QuantizeResult
+}
+
+// whisker returns a quantized copy of a whisker
+func (q *quantizer) whisker(w *Whisker) *Whisker {
+    q.result.Whiskers++
+
+    multiple := math.Round(math.Ldexp(w.WindowMultiple, int(q.opts.MultipleShift)))
+    multiple = math.Ldexp(math.Max(0, multiple), -int(q.opts.MultipleShift))
+    q.result.MaxMultipleError = math.Max(q.result.MaxMultipleError, math.Abs(multiple-w.WindowMultiple))
+
+    unit := q.opts.IntersendUnit.Seconds()
+    intersend := math.Max(0, math.Round(w.Intersend/unit)) * unit
+    q.result.MaxIntersendError = math.Max(q.result.MaxIntersendError, math.Abs(intersend-w.Intersend))
+
+    lower := memory.NewMemory()
+    upper := memory.NewMemory()
+    empty := false
+    for i := range lower.Values {
+        id := memory.SignalID(i)
+        lower.Set(id, q.bound(w.Domain.Lower.Get(id)))
+        upper.Set(id, q.bound(w.Domain.Upper.Get(id)))
+        if lower.Get(id) >= upper.Get(id) {
+            empty = true
+        }
+    }
+    if empty {
+        q.result.Empty++
+    }
+
+    quantized := NewWhisker(w.Generation, w.WindowIncrement, multiple, intersend, memory.NewMemoryRange(lower, upper))
+    quantized.count = w.Count()
+    return quantized
+}
+
+// bound returns a domain bound rounded to the nearest fixed-point value
+// Signals are unsigned, so negative bounds become zero, and bounds beyond the largest value become +Inf
+func (q *quantizer) bound(v memory.DataType) memory.DataType {
+    if math.IsInf(float64(v), 1) {
+        return v
+    }
+    scaled := math.Max(0, math.Round(float64(v)*q.scale))
+    if scaled >= q.max {
+        q.result.Saturated++
+        return memory.DataType(math.Inf(1))
+    }
+    rounded := scaled / q.scale
+    q.result.MaxBoundError = math.Max(q.result.MaxBoundError, math.Abs(rounded-float64(v)))
+    return memory.DataType(rounded)
+}
@@ -0,0 +1,90 @@
+package whisker
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+    "time"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+func TestTruncatedSignalsChooseQuantizedWhisker(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    opts := DefaultQuantizeOptions()
+    opts.DomainShift = 2
+    quantized, _, err := Quantize(randomTree(rng, 500), opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // A controller truncates each signal to a multiple of a quarter; values just below the quantized bounds are
+    // the ones truncation moves
+    truncate := func(m *memory.Memory) *memory.Memory {
+        fixed := memory.NewMemory()
+        for id, v := range m.Values {
+            fixed.Values[id] = memory.DataType(math.Floor(float64(v)*4) / 4)
+        }
+        return fixed
+    }
+    memories := randomMemories(rng, quantized, 2000)
+    for _, m := range memories[:1000] {
+        below := copyMemory(m)
+        for id := range below.Values {
+            below.Values[id] -= 1e-6
+        }
+        memories = append(memories, below)
+    }
+    for _, m := range memories {
+        want, wantErr := quantized.FindWhisker(m)
+        got, err := quantized.FindWhisker(truncate(m))
+        if got != want || (err == nil) != (wantErr == nil) {
+            t.Fatalf("memory %v chose %v, its truncation %v chose %v", m, want, truncate(m), got)
+        }
+    }
+}
+
+func TestQuantizeCountsSaturatedAndEmptyDomains(t *testing.T) {
+    // With 8 bits and 4 of them fractional, values stop at 255/16; the grandchild's send_rate range rounds to nothing
+    tree := NewWhiskerTree()
+    if err := tree.Insert(NewWhisker(0, 1, 1, 0.01, memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory()))); err != nil {
+        t.Fatal(err)
+    }
+    child := memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())
+    child.Upper.Set(memory.RecvRate, 20)
+    grandchild := memory.NewMemoryRange(copyMemory(child.Lower), copyMemory(child.Upper))
+    grandchild.Lower.Set(memory.SendRate, 1.01)
+    grandchild.Upper.Set(memory.SendRate, 1.02)
+    for _, domain := range []*memory.MemoryRange{child, grandchild} {
+        if err := tree.Insert(NewWhisker(1, 1, 0.3, 0.0123456789, domain)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    opts := QuantizeOptions{DomainShift: 4, MultipleShift: 4, IntersendUnit: time.Millisecond, Bits: 8}
+    quantized, result, err := Quantize(tree, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Whiskers != 3 || result.Saturated != 2 || result.Empty != 1 {
+        t.Errorf("got %v, want 3 whiskers, 2 saturated bounds and 1 empty domain", result)
+    }
+    if math.Abs(result.MaxBoundError-0.02) > 1e-9 {
+        t.Errorf("max bound error is %g, want the 0.02 of send_rate's upper bound", result.MaxBoundError)
+    }
+    if math.Abs(result.MaxMultipleError-0.0125) > 1e-9 || math.Abs(result.MaxIntersendError-0.0003456789) > 1e-9 {
+        t.Errorf("got %v, want 0.3 rounded to 0.3125 and 12.3456789 ms to 12 ms", result)
+    }
+    w := quantized.Root.Children[0].Whisker
+    if upper := w.Domain.Upper.Get(memory.RecvRate); !math.IsInf(float64(upper), 1) {
+        t.Errorf("recv_rate bound of 20 became %g, want +Inf", upper)
+    }
+    if tree.Root.Children[0].Whisker.Domain.Upper.Get(memory.RecvRate) != 20 {
+        t.Error("quantizing modified the tree passed in")
+    }
+
+    opts.DomainShift = 8
+    if _, _, err := Quantize(tree, opts); err == nil {
+        t.Error("Quantize accepted as many fractional bits as bits")
+    }
+}
@@ -0,0 +1,309 @@
+package whisker
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+// IssueKind identifies the kind of problem Validate found in a whisker tree
+type IssueKind int
+
+const (
+    Gap                       IssueKind = iota // Part of memory space is covered by no whisker at all
+    Overlap                                    // Two sibling domains intersect
+    OutsideParent                              // A child's domain extends beyond its parent's
+    EmptyDomain                                // A domain has a lower bound at or above its upper bound
+    InvalidAction                              // An action is NaN, infinite or negative
+    WindowMultipleOutOfBounds                  // A window multiple lies outside the configured bounds
+    WindowIncrementOutOfBounds                 // A window increment lies outside the configured bounds
+    DuplicateGeneration                        // Two whiskers share both a domain and a generation
+    HashMismatch                               // The whiskers do not match the content hash in the file's metadata
+    CoverageIncomplete                         // The check for partial splits gave up on a node whose children leave too many pieces uncovered
+    PartialSplit                               // A node's children leave part of its domain to the node's own whisker
+)
+
+// String returns the name of the issue kind
+func (k IssueKind) String() string {
+    switch k {
+    case Gap:
+        return "gap"
+    case Overlap:
+        return "overlap"
+    case OutsideParent:
+        return "outside-parent"
+    case EmptyDomain:
+        return "empty-domain"
+    case InvalidAction:
+        return "invalid-action"
+    case WindowMultipleOutOfBounds:
+        return "window-multiple-out-of-bounds"
+    case WindowIncrementOutOfBounds:
+        return "window-increment-out-of-bounds"
+    case DuplicateGeneration:
+        return "duplicate-generation"
+    case HashMismatch:
+        return "hash-mismatch"
+    case CoverageIncomplete:
+        return "coverage-incomplete"
+    case PartialSplit:
+        return "partial-split"
+    default:
+        return fmt.Sprintf("issue(%d)", int(k))
+    }
+}
+
+// Warning checks if issues of this kind describe an unusual but valid tree, or a check that could not be completed,
+// rather than a tree that is wrong
+func (k IssueKind) Warning() bool {
+    return k == CoverageIncomplete || k == PartialSplit
+}
+
+// Issue describes a single problem found in a whisker tree
+type Issue struct {
+    Kind    IssueKind
+    Whisker *Whisker // Whisker the issue was found in
+    Other   *Whisker // Second whisker involved, for overlaps, parents and duplicates
+    Message string
+}
+
+// String returns a string representation of the issue
+func (i Issue) String() string {
+    return fmt.Sprintf("%s: %s", i.Kind, i.Message)
+}
+
+// ValidationError is returned when a tree is refused because of the issues found in it
+type ValidationError struct {
+    Issues []Issue
+}
+
+// Error returns a summary of the issues
+func (e *ValidationError) Error() string {
+    lines := make([]string, len(e.Issues))
+    for i, issue := range e.Issues {
+        lines[i] = issue.String()
+    }
+    return fmt.Sprintf("invalid whisker tree (%d issues):\n  %s", len(e.Issues), strings.Join(lines, "\n  "))
+}
+
+// ValidationOptions sets the bounds Validate checks actions against
+type ValidationOptions struct {
+    MinWindowMultiple  float64
+    MaxWindowMultiple  float64
+    MinWindowIncrement int
+    MaxWindowIncrement int
+    MaxCoveragePieces  int // Limit on the number of uncovered boxes tracked when checking for partial splits, past which the check gives up
+}
+
+// DefaultValidationOptions returns the bounds used by Remy for whisker actions
+func DefaultValidationOptions() ValidationOptions {
+    return ValidationOptions{
+        MinWindowMultiple:  0,
+        MaxWindowMultiple:  2,
+        MinWindowIncrement: 0,
+        MaxWindowIncrement: 256,
+        MaxCoveragePieces:  100000,
+    }
+}
+
+// Validate checks that the tree partitions memory space and that every action is sane
+// It reports memory no whisker covers, overlapping siblings, children outside their parent, NaN or negative actions,
+// actions outside the configured bounds and whiskers duplicated within a domain. Nodes whose children do not tile
+// their domain are only warned about, since FindWhisker falls back to the deepest node containing the memory, as are
+// nodes whose coverage it gave up checking.
+func (wt *WhiskerTree) Validate(opts ValidationOptions) []Issue {
+    var issues []Issue
+
+    full := memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())
+    if !wt.Root.Whisker.Domain.ContainsRange(full) {
+        issues = append(issues, Issue{
+            Kind:    Gap,
+            Whisker: wt.Root.Whisker,
+            Message: fmt.Sprintf("root domain %v does not cover the whole memory space", wt.Root.Whisker.Domain),
+        })
+    }
+
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        issues = append(issues, validateWhisker(node.Whisker, opts)...)
+        if parent != nil && !parent.Whisker.Domain.ContainsRange(node.Whisker.Domain) {
+            issues = append(issues, Issue{
+                Kind:    OutsideParent,
+                Whisker: node.Whisker,
+                Other:   parent.Whisker,
+                Message: fmt.Sprintf("domain %v extends beyond parent domain %v", node.Whisker.Domain, parent.Whisker.Domain),
+            })
+        }
+        if len(node.Children) > 0 {
+            issues = append(issues, validateSiblings(node.Children)...)
+            // Below the default root whisker of a tree built by NewWhiskerTree, which no file holds, uncovered
+            // memory has no whisker at all
+            kind := PartialSplit
+            if node == wt.Root && wt.placeholder {
+                kind = Gap
+            }
+            issues = append(issues, validateCoverage(node, kind, opts)...)
+        }
+    })
+
+    return issues
+}
+
+// validateWhisker checks the domain and actions of a single whisker
+func validateWhisker(w *Whisker, opts ValidationOptions) []Issue {
+    var issues []Issue
+
+    for i := range w.Domain.Lower.Values {
+        if w.Domain.Lower.Values[i] >= w.Domain.Upper.Get(memory.SignalID(i)) {
+            issues = append(issues, Issue{
+                Kind:    EmptyDomain,
+                Whisker: w,
+                Message: fmt.Sprintf("domain %v is empty along signal %d", w.Domain, i),
+            })
+            break
+        }
+    }
+
+    if math.IsNaN(w.WindowMultiple) || math.IsInf(w.WindowMultiple, 0) || w.WindowMultiple < 0 {
+        issues = append(issues, Issue{Kind: InvalidAction, Whisker: w, Message: fmt.Sprintf("window multiple %v in %v", w.WindowMultiple, w)})
+    } else if w.WindowMultiple < opts.MinWindowMultiple || w.WindowMultiple > opts.MaxWindowMultiple {
+        issues = append(issues, Issue{
+            Kind:    WindowMultipleOutOfBounds,
+            Whisker: w,
+            Message: fmt.Sprintf("window multiple %v outside [%v, %v] in %v", w.WindowMultiple, opts.MinWindowMultiple, opts.MaxWindowMultiple, w),
+        })
+    }
+
+    if w.WindowIncrement < 0 {
+        issues = append(issues, Issue{Kind: InvalidAction, Whisker: w, Message: fmt.Sprintf("window increment %d in %v", w.WindowIncrement, w)})
+    } else if w.WindowIncrement < opts.MinWindowIncrement || w.WindowIncrement > opts.MaxWindowIncrement {
+        issues = append(issues, Issue{
+            Kind:    WindowIncrementOutOfBounds,
+            Whisker: w,
+            Message: fmt.Sprintf("window increment %d outside [%d, %d] in %v", w.WindowIncrement, opts.MinWindowIncrement, opts.MaxWindowIncrement, w),
+        })
+    }
+
+    if math.IsNaN(w.Intersend) || math.IsInf(w.Intersend, 0) || w.Intersend < 0 {
+        issues = append(issues, Issue{Kind: InvalidAction, Whisker: w, Message: fmt.Sprintf("intersend %v in %v", w.Intersend, w)})
+    }
+
+    return issues
+}
+
+// validateSiblings reports sibling domains that intersect, sweeping along the first signal
+func validateSiblings(children []*WhiskerNode) []Issue {
+    var issues []Issue
+
+    sorted := make([]*WhiskerNode, len(children))
+    copy(sorted, children)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i].Whisker.Domain.Lower.Get(0) < sorted[j].Whisker.Domain.Lower.Get(0)
+    })
+
+    for i, a := range sorted {
+        for _, b := range sorted[i+1:] {
+            if b.Whisker.Domain.Lower.Get(0) >= a.Whisker.Domain.Upper.Get(0) {
+                break
+            }
+            if !a.Whisker.Domain.Intersects(b.Whisker.Domain) {
+                continue
+            }
+            if a.Whisker.Domain.Equal(b.Whisker.Domain) && a.Whisker.Generation == b.Whisker.Generation {
+                issues = append(issues, Issue{
+                    Kind:    DuplicateGeneration,
+                    Whisker: b.Whisker,
+                    Other:   a.Whisker,
+                    Message: fmt.Sprintf("generation %d appears twice in domain %v", b.Whisker.Generation, b.Whisker.Domain),
+                })
+                continue
+            }
+            issues = append(issues, Issue{
+                Kind:    Overlap,
+                Whisker: a.Whisker,
+                Other:   b.Whisker,
+                Message: fmt.Sprintf("domain %v overlaps sibling domain %v", a.Whisker.Domain, b.Whisker.Domain),
+            })
+        }
+    }
+
+    return issues
+}
+
+// box is an axis-aligned, half-open region of memory space
+type box struct {
+    lower []memory.DataType
+    upper []memory.DataType
+}
+
+// newBox creates a box covering the given range
+func newBox(mr *memory.MemoryRange) box {
+    b := box{lower: make([]memory.DataType, len(mr.Lower.Values)), upper: make([]memory.DataType, len(mr.Lower.Values))}
+    for i := range b.lower {
+        b.lower[i] = mr.Lower.Values[i]
+        b.upper[i] = mr.Upper.Get(memory.SignalID(i))
+    }
+    return b
+}
+
+// subtract returns the parts of the box that lie outside the given range
+func (b box) subtract(mr *memory.MemoryRange) []box {
+    for i := range b.lower {
+        if b.lower[i] >= mr.Upper.Get(memory.SignalID(i)) || mr.Lower.Get(memory.SignalID(i)) >= b.upper[i] {
+            return []box{b}
+        }
+    }
+
+    var pieces []box
+    rest := box{lower: append([]memory.DataType(nil), b.lower...), upper: append([]memory.DataType(nil), b.upper...)}
+    for i := range rest.lower {
+        lo, hi := mr.Lower.Get(memory.SignalID(i)), mr.Upper.Get(memory.SignalID(i))
+        if rest.lower[i] < lo {
+            piece := box{lower: append([]memory.DataType(nil), rest.lower...), upper: append([]memory.DataType(nil), rest.upper...)}
+            piece.upper[i] = lo
+            pieces = append(pieces, piece)
+            rest.lower[i] = lo
+        }
+        if rest.upper[i] > hi {
+            piece := box{lower: append([]memory.DataType(nil), rest.lower...), upper: append([]memory.DataType(nil), rest.upper...)}
+            piece.lower[i] = hi
+            pieces = append(pieces, piece)
+            rest.upper[i] = hi
+        }
+    }
+    return pieces
+}
+
+// validateCoverage reports, as an issue of the given kind, the parts of a node's domain that none of its children cover
+// The check gives up, saying so, once more than MaxCoveragePieces pieces remain
+func validateCoverage(node *WhiskerNode, kind IssueKind, opts ValidationOptions) []Issue {
+    remaining := []box{newBox(node.Whisker.Domain)}
+    for _, child := range node.Children {
+        var next []box
+        for _, b := range remaining {
+            next = append(next, b.subtract(child.Whisker.Domain)...)
+        }
+        remaining = next
+        if len(remaining) == 0 {
+            return nil
+        }
+        if opts.MaxCoveragePieces > 0 && len(remaining) > opts.MaxCoveragePieces {
+            return []Issue{{
+                Kind:    CoverageIncomplete,
+                Whisker: node.Whisker,
+                Message: fmt.Sprintf("gave up checking that the children of %v cover it after %d uncovered pieces", node.Whisker.Domain, len(remaining)),
+            }}
+        }
+    }
+
+    first := memory.NewMemoryRange(memory.NewMemory(), memory.NewMemory())
+    first.Lower.Update(remaining[0].lower...)
+    first.Upper.Update(remaining[0].upper...)
+    return []Issue{{
+        Kind:    kind,
+        Whisker: node.Whisker,
+        Message: fmt.Sprintf("children of %v leave %d regions uncovered, including %v", node.Whisker.Domain, len(remaining), first),
+    }}
+}
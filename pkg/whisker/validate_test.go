@@ -0,0 +1,91 @@
+package whisker
+
+import (
+    "errors"
+    "path/filepath"
+    "testing"
+
+    "github.com/Aanthord/remy-go/pkg/memory"
+)
+
+func TestValidateCoverageGivesUp(t *testing.T) {
+    // A child in the middle of every signal leaves eight pieces of the default root uncovered
+    tree := NewWhiskerTree()
+    lower, upper := memory.NewMemory(), memory.NewMemory()
+    for id := range lower.Values {
+        lower.Values[id] = 10
+        upper.Values[id] = 20
+    }
+    if err := tree.Insert(NewWhisker(1, 1, 1, 0.01, memory.NewMemoryRange(lower, upper))); err != nil {
+        t.Fatal(err)
+    }
+
+    kinds := func(opts ValidationOptions) []IssueKind {
+        var kinds []IssueKind
+        for _, issue := range tree.Validate(opts) {
+            kinds = append(kinds, issue.Kind)
+        }
+        return kinds
+    }
+    opts := DefaultValidationOptions()
+    if got := kinds(opts); len(got) != 1 || got[0] != Gap {
+        t.Errorf("Validate found %v, want a gap", got)
+    }
+    opts.MaxCoveragePieces = 4
+    got := kinds(opts)
+    if len(got) != 1 || got[0] != CoverageIncomplete {
+        t.Fatalf("Validate with at most 4 pieces found %v, want the check reported incomplete", got)
+    }
+    if !got[0].Warning() || !PartialSplit.Warning() || Gap.Warning() {
+        t.Error("only an incomplete check and a partial split should be warnings")
+    }
+}
+
+func TestStrictLoadAcceptsPartialSplit(t *testing.T) {
+    // The root is split along the first signal only below 100, leaving the rest of memory to the root's whisker
+    full := memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())
+    half := memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())
+    half.Upper.Values[0] = 100
+    filename := filepath.Join(t.TempDir(), "partial.pb")
+    if err := SaveWhiskers([]*Whisker{NewWhisker(0, 1, 1, 0.01, full), NewWhisker(1, 2, 1, 0.01, half)}, filename); err != nil {
+        t.Fatal(err)
+    }
+
+    tree, issues, err := LoadWhiskersWithOptions(filename, LoadOptions{Strict: true, Validation: DefaultValidationOptions()})
+    if err != nil {
+        t.Fatalf("strict loading refused a partial split: %v", err)
+    }
+    if len(issues) != 1 || issues[0].Kind != PartialSplit {
+        t.Errorf("loading found %v, want a partial split", issues)
+    }
+
+    inside, outside := memory.NewMemory(), memory.NewMemory()
+    inside.Values[0] = 50
+    outside.Values[0] = 500
+    for _, test := range []struct {
+        m         *memory.Memory
+        increment int
+    }{{inside, 2}, {outside, 1}} {
+        w, err := tree.FindWhisker(test.m)
+        if err != nil {
+            t.Fatalf("no whisker for %v: %v", test.m, err)
+        }
+        if w.WindowIncrement != test.increment {
+            t.Errorf("memory %v found increment %d, want %d", test.m, w.WindowIncrement, test.increment)
+        }
+    }
+
+    // Memory that only the default root of a tree covers has no whisker, which is still a gap, and refused
+    lower := memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())
+    lower.Upper.Values[0] = 1000
+    if err := SaveWhiskers([]*Whisker{NewWhisker(0, 1, 1, 0.01, lower)}, filename); err != nil {
+        t.Fatal(err)
+    }
+    var invalid *ValidationError
+    if _, _, err := LoadWhiskersWithOptions(filename, LoadOptions{Strict: true, Validation: DefaultValidationOptions()}); !errors.As(err, &invalid) {
+        t.Fatalf("strict loading returned %v for a root that leaves a gap, want a validation error", err)
+    }
+    if len(invalid.Issues) != 1 || invalid.Issues[0].Kind != Gap {
+        t.Errorf("strict loading refused %v, want a gap", invalid.Issues)
+    }
+}
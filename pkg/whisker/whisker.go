@@ -1,9 +1,10 @@
 package whisker
 
 import (
+    "errors"
     "fmt"
-    "io/ioutil"
     "math"
+    "sync/atomic"
 
     "google.golang.org/protobuf/proto"
     "github.com/Aanthord/remy-go/pkg/dna"
@@ -17,6 +18,7 @@ type Whisker struct {
     WindowMultiple  float64
     Intersend       float64
     Domain          *memory.MemoryRange
+    count           uint64 // Number of times the whisker was used while tracking
 }
 
 // NewWhisker is a constructor that creates a new instance of the Whisker struct
@@ -35,12 +37,41 @@ func (w *Whisker) Window(prevWindow uint) uint {
     return uint(math.Max(0, math.Min(float64(prevWindow)*w.WindowMultiple+float64(w.WindowIncrement), 1000000)))
 }
 
+// Use records a use of the whisker, as Remy does when a sender tracks its whiskers
+func (w *Whisker) Use() {
+    atomic.AddUint64(&w.count, 1)
+}
+
+// Count returns the number of times the whisker was used since its count was last reset
+func (w *Whisker) Count() uint64 {
+    return atomic.LoadUint64(&w.count)
+}
+
+// ResetCount sets the use count of the whisker back to zero
+func (w *Whisker) ResetCount() {
+    atomic.StoreUint64(&w.count, 0)
+}
+
 // String returns a string representation of the whisker
 func (w *Whisker) String() string {
     return fmt.Sprintf("Generation=%d, WindowIncrement=%d, WindowMultiple=%f, Intersend=%f, Domain=%v",
         w.Generation, w.WindowIncrement, w.WindowMultiple, w.Intersend, w.Domain)
 }
 
+// ToDNAWhisker converts a Whisker to a dna.Whisker
+func (w *Whisker) ToDNAWhisker() *dna.Whisker {
+    return &dna.Whisker{
+        Generation:      uint32(w.Generation),
+        WindowIncrement: uint32(w.WindowIncrement),
+        WindowMultiple:  float32(w.WindowMultiple),
+        Intersend:       float32(w.Intersend),
+        Domain: &dna.MemoryRange{
+            Lower: w.Domain.Lower.ToDNAMemory(),
+            Upper: w.Domain.Upper.ToDNAMemory(),
+        },
+    }
+}
+
 // GenerateWhiskers generates a slice of whiskers based on the provided configuration
 func GenerateWhiskers(config *dna.ConfigRange) []*Whisker {
     var whiskers []*Whisker
@@ -51,7 +82,7 @@ func GenerateWhiskers(config *dna.ConfigRange) []*Whisker {
             for _, windowMultiple := range config.WindowMultiples {
                 for _, intersend := range config.Intersends {
                     for _, domain := range config.Domains {
-                        memoryDomain := memory.NewMemoryRange(memory.FromDNAMemory(domain.Lower), memory.FromDNAMemory(domain.Upper))
+                        memoryDomain, _ := memory.FromDNAMemoryRange(domain, nil)
                         whisker := NewWhisker(generation, int(windowIncrement), float64(windowMultiple), float64(intersend), memoryDomain)
                         whiskers = append(whiskers, whisker)
                     }
@@ -63,71 +94,144 @@ func GenerateWhiskers(config *dna.ConfigRange) []*Whisker {
     return whiskers
 }
 
+// LoadOptions control how LoadWhiskersWithOptions checks the tree it loads
+type LoadOptions struct {
+    Strict     bool              // Refuse trees with any validation issue other than a warning
+    Validation ValidationOptions // Bounds the whisker actions are checked against
+}
+
 // LoadWhiskers loads whiskers from a file and constructs a WhiskerTree
+// The tree is validated, but issues found in it are not fatal; use LoadWhiskersWithOptions to see or refuse them
 func LoadWhiskers(filename string) (*WhiskerTree, error) {
-    data, err := ioutil.ReadFile(filename)
+    tree, _, err := LoadWhiskersWithOptions(filename, LoadOptions{Validation: DefaultValidationOptions()})
+    return tree, err
+}
+
+// LoadWhiskersWithOptions loads whiskers from a file, constructs a WhiskerTree and validates it
+// In strict mode a tree with any issue other than a warning is refused with a *ValidationError
+func LoadWhiskersWithOptions(filename string, opts LoadOptions) (*WhiskerTree, []Issue, error) {
+    // Read the whiskers in whichever format the file is in
+    dnaWhiskers := &dna.Whiskers{}
+    if err := ReadMessage(filename, dnaWhiskers); err != nil {
+        return nil, nil, err
+    }
+
+    tree, issues, err := TreeFromDNA(dnaWhiskers)
     if err != nil {
-        return nil, err
+        return nil, nil, err
     }
+    issues = append(issues, tree.Validate(opts.Validation)...)
 
-    // Unmarshal the whiskers from the data
-    dnaWhiskers := &dna.Whiskers{}
-    if err := proto.Unmarshal(data, dnaWhiskers); err != nil {
-        return nil, err
+    if opts.Strict {
+        var refused []Issue
+        for _, issue := range issues {
+            if !issue.Kind.Warning() {
+                refused = append(refused, issue)
+            }
+        }
+        if len(refused) > 0 {
+            return nil, issues, &ValidationError{Issues: refused}
+        }
+    }
+    return tree, issues, nil
+}
+
+// TreeFromDNA constructs a WhiskerTree from its protobuf representation
+// Whiskers that duplicate the generation of an earlier whisker in the same domain are skipped and reported
+func TreeFromDNA(dnaWhiskers *dna.Whiskers) (*WhiskerTree, []Issue, error) {
+    // Refuse files of a format version this package does not understand
+    issues, err := checkMetadata(dnaWhiskers)
+    if err != nil {
+        return nil, nil, err
     }
 
     // Create a new WhiskerTree
     tree := NewWhiskerTree()
+    tree.Metadata = dnaWhiskers.Metadata
+
+    // Whisker files list the signals their domains are laid out in; older files use the default four
+    var signals []string
+    if len(dnaWhiskers.Signals) > 0 {
+        signals = dnaWhiskers.Signals
+    }
 
     // Insert the loaded whiskers into the WhiskerTree
     for _, dnaWhisker := range dnaWhiskers.Whiskers {
-        domain := memory.NewMemoryRange(memory.FromDNAMemory(dnaWhisker.Domain.Lower), memory.FromDNAMemory(dnaWhisker.Domain.Upper))
+        domain, err := memory.FromDNAMemoryRange(dnaWhisker.Domain, signals)
+        if err != nil {
+            return nil, nil, err
+        }
         whisker := NewWhisker(uint(dnaWhisker.Generation), int(dnaWhisker.WindowIncrement), float64(dnaWhisker.WindowMultiple), float64(dnaWhisker.Intersend), domain)
         if err := tree.Insert(whisker); err != nil {
-            return nil, err
+            var duplicate *DuplicateError
+            if !errors.As(err, &duplicate) {
+                return nil, nil, err
+            }
+            issues = append(issues, Issue{
+                Kind:    DuplicateGeneration,
+                Whisker: whisker,
+                Other:   duplicate.Existing,
+                Message: fmt.Sprintf("generation %d appears twice in domain %v", duplicate.Existing.Generation, whisker.Domain),
+            })
         }
     }
 
-    return tree, nil
+    return tree, issues, nil
 }
 
+// SaveWhiskers saves whiskers to a file, in the format implied by its extension or binary protobuf if it implies none
+// The file records when and from which revision it was written
 func SaveWhiskers(whiskers []*Whisker, filename string) error {
-    dnaWhiskers := &dna.Whiskers{}
+    return SaveWhiskersWithMetadata(whiskers, NewMetadata(), filename)
+}
+
+// SaveTree saves the whiskers of a tree to a file along with the tree's metadata, or new metadata if it has none
+func SaveTree(tree *WhiskerTree, filename string) error {
+    metadata := tree.Metadata
+    if metadata == nil {
+        metadata = NewMetadata()
+    }
+    return SaveWhiskersWithMetadata(tree.Whiskers(), metadata, filename)
+}
+
+// SaveWhiskersWithMetadata saves whiskers to a file along with the given metadata
+// The format version and content hash of the saved metadata are filled in; the metadata passed in is not modified
+func SaveWhiskersWithMetadata(whiskers []*Whisker, metadata *dna.Metadata, filename string) error {
+    dnaWhiskers := &dna.Whiskers{Signals: memory.SignalNames()}
 
     // Convert Whisker to dna.Whisker
     for _, whisker := range whiskers {
-        dnaWhisker := &dna.Whisker{
-            Generation:      uint32(whisker.Generation),
-            WindowIncrement: uint32(whisker.WindowIncrement),
-            WindowMultiple:  float32(whisker.WindowMultiple),
-            Intersend:       float32(whisker.Intersend),
-            Domain: &dna.MemoryRange{
-                Lower: whisker.Domain.Lower.ToDNAMemory(),
-                Upper: whisker.Domain.Upper.ToDNAMemory(),
-            },
-        }
-        dnaWhiskers.Whiskers = append(dnaWhiskers.Whiskers, dnaWhisker)
+        dnaWhiskers.Whiskers = append(dnaWhiskers.Whiskers, whisker.ToDNAWhisker())
     }
 
-    // Marshal the whiskers to data
-    data, err := proto.Marshal(dnaWhiskers)
+    hash, err := ContentHash(dnaWhiskers)
     if err != nil {
         return err
     }
-    // Write the data to the file
-    if err := ioutil.WriteFile(filename, data, 0644); err != nil {
-        return err
-    }
+    dnaWhiskers.Metadata = proto.Clone(metadata).(*dna.Metadata)
+    dnaWhiskers.Metadata.FormatVersion = FormatVersion
+    dnaWhiskers.Metadata.ContentHash = hash
 
-    return nil
+    // Write the whiskers to the file
+    return WriteMessage(filename, dnaWhiskers, formatOrBinary(filename))
 }
 
 // ParseConfig parses the configuration data and returns a dna.ConfigRange instance
+// The data may be binary protobuf, JSON or text format, detected from its content
 func ParseConfig(configData []byte) (*dna.ConfigRange, error) {
     config := &dna.ConfigRange{}
-    err := proto.Unmarshal(configData, config)
+    err := Unmarshal(configData, DetectFormat(configData), config)
     if err != nil {
         return nil, err
     }
     return config, nil
 }
+
+// LoadConfig loads a configuration from a file, in the format implied by its extension or else detected from its content
+func LoadConfig(filename string) (*dna.ConfigRange, error) {
+    config := &dna.ConfigRange{}
+    if err := ReadMessage(filename, config); err != nil {
+        return nil, err
+    }
+    return config, nil
+}
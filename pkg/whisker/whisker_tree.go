@@ -3,14 +3,20 @@ package whisker
 import (
     "errors"
     "fmt"
+    "sync/atomic"
 
-//    "github.com/Aanthord/remy-go/pkg/dna"
+    "github.com/Aanthord/remy-go/pkg/dna"
     "github.com/Aanthord/remy-go/pkg/memory"
 )
 
 // WhiskerTree represents a tree-like structure that holds multiple whiskers
+// A node's children lie within its domain, and a memory value is handled by the deepest node
+// whose domain contains it
 type WhiskerTree struct {
-    Root *WhiskerNode
+    Root        *WhiskerNode
+    Metadata    *dna.Metadata         // Provenance of the tree, or nil if it was not loaded from a file that records it
+    placeholder bool                  // Root still holds the default whisker, which a whisker inserted with its domain replaces
+    index       atomic.Pointer[index] // Lookup index, rebuilt lazily after the tree changes
 }
 
 // WhiskerNode represents a node in the whisker tree
@@ -24,60 +30,163 @@ func NewWhiskerTree() *WhiskerTree {
     root := &WhiskerNode{
         Whisker: NewWhisker(0, 0, 1.0, 0.0, memory.NewMemoryRange(memory.MinMemory(), memory.MaxMemory())),
     }
-    return &WhiskerTree{Root: root}
+    return &WhiskerTree{Root: root, placeholder: true}
 }
 
 // Insert inserts a new whisker into the whisker tree
 func (wt *WhiskerTree) Insert(whisker *Whisker) error {
     if err := wt.insert(wt.Root, whisker); err != nil {
-        return fmt.Errorf("failed to insert whisker: %v", err)
+        return fmt.Errorf("failed to insert whisker: %w", err)
     }
+    wt.Invalidate()
     return nil
 }
 
-// insert is a recursive function that inserts a new whisker into the whisker tree
+// insert is a recursive function that inserts a new whisker below the deepest node whose domain contains it
 func (wt *WhiskerTree) insert(node *WhiskerNode, whisker *Whisker) error {
-    if node.Whisker.Domain.Intersects(whisker.Domain) {
+    if node.Whisker.Domain.Equal(whisker.Domain) {
+        if node == wt.Root && wt.placeholder {
+            wt.placeholder = false
+            node.Whisker = whisker
+            return nil
+        }
         if node.Whisker.Generation >= whisker.Generation {
-            return fmt.Errorf("whisker with generation %d already exists in the domain", whisker.Generation)
+            return &DuplicateError{Existing: node.Whisker, Whisker: whisker}
         }
         node.Whisker = whisker
         return nil
     }
 
     for _, child := range node.Children {
-        if child.Whisker.Domain.Intersects(whisker.Domain) {
+        if child.Whisker.Domain.ContainsRange(whisker.Domain) {
             return wt.insert(child, whisker)
         }
     }
 
+    // Children that lie within the new whisker's domain move below it
     newNode := &WhiskerNode{Whisker: whisker}
-    node.Children = append(node.Children, newNode)
+    var remaining []*WhiskerNode
+    for _, child := range node.Children {
+        if whisker.Domain.ContainsRange(child.Whisker.Domain) {
+            newNode.Children = append(newNode.Children, child)
+        } else {
+            remaining = append(remaining, child)
+        }
+    }
+    node.Children = append(remaining, newNode)
     return nil
 }
 
+// DuplicateError is returned by Insert when the domain of a whisker already holds one of the same or a later generation
+type DuplicateError struct {
+    Existing *Whisker
+    Whisker  *Whisker
+}
+
+// Error returns a description of the duplicate
+func (e *DuplicateError) Error() string {
+    return fmt.Sprintf("whisker with generation %d already exists in the domain", e.Existing.Generation)
+}
+
+// Invalidate discards the lookup index; it must be called after the tree is modified other than through Insert
+func (wt *WhiskerTree) Invalidate() {
+    wt.index.Store(nil)
+}
+
 // FindWhisker finds the whisker that corresponds to the given memory state
+// This is the whisker of the deepest node whose domain contains the memory
 func (wt *WhiskerTree) FindWhisker(m *memory.Memory) (*Whisker, error) {
-    node, err := wt.findNode(wt.Root, m)
-    if err != nil {
-        return nil, err
+    node := wt.lookupIndex().find(m)
+    if node == nil {
+        return nil, errors.New("memory not found in the tree")
     }
     return node.Whisker, nil
 }
 
-// findNode is a recursive function that finds the node that contains the given memory state
-func (wt *WhiskerTree) findNode(node *WhiskerNode, m *memory.Memory) (*WhiskerNode, error) {
-    if node.Whisker.Domain.Contains(m) {
-        return node, nil
+// Overlapping returns the nodes whose domains intersect the given range
+func (wt *WhiskerTree) Overlapping(mr *memory.MemoryRange) []*WhiskerNode {
+    return wt.lookupIndex().overlapping(mr)
+}
+
+// lookupIndex returns the lookup index, building it if the tree changed since it was last used
+func (wt *WhiskerTree) lookupIndex() *index {
+    idx := wt.index.Load()
+    if idx == nil {
+        idx = newIndex(wt)
+        wt.index.Store(idx)
     }
+    return idx
+}
 
-    for _, child := range node.Children {
-        if child.Whisker.Domain.Contains(m) {
-            return wt.findNode(child, m)
+// Leaves returns the nodes of the tree that have no children
+func (wt *WhiskerTree) Leaves() []*WhiskerNode {
+    var leaves []*WhiskerNode
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        if len(node.Children) == 0 {
+            leaves = append(leaves, node)
+        }
+    })
+    return leaves
+}
+
+// Whiskers returns the whiskers of the tree in depth-first order
+// The default root whisker of a tree built by NewWhiskerTree is left out, so that saving and loading the result gives the same tree
+func (wt *WhiskerTree) Whiskers() []*Whisker {
+    var whiskers []*Whisker
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        if node == wt.Root && wt.placeholder {
+            return
         }
+        whiskers = append(whiskers, node.Whisker)
+    })
+    return whiskers
+}
+
+// ResetCounts sets the use count of every whisker in the tree back to zero
+func (wt *WhiskerTree) ResetCounts() {
+    wt.Walk(func(node *WhiskerNode, depth int, parent *WhiskerNode) {
+        node.Whisker.ResetCount()
+    })
+}
+
+// Clone returns a copy of the tree with copies of its whiskers, along with a map from each node of the tree to its copy
+// Domains and metadata are shared between the tree and its copy
+func (wt *WhiskerTree) Clone() (*WhiskerTree, map[*WhiskerNode]*WhiskerNode) {
+    copies := make(map[*WhiskerNode]*WhiskerNode)
+    clone := &WhiskerTree{Root: wt.clone(wt.Root, copies), Metadata: wt.Metadata, placeholder: wt.placeholder}
+    return clone, copies
+}
+
+// clone is a recursive function that copies a node, its whisker and its descendants
+func (wt *WhiskerTree) clone(node *WhiskerNode, copies map[*WhiskerNode]*WhiskerNode) *WhiskerNode {
+    w := NewWhisker(node.Whisker.Generation, node.Whisker.WindowIncrement, node.Whisker.WindowMultiple, node.Whisker.Intersend, node.Whisker.Domain)
+    w.count = node.Whisker.Count()
+    copied := &WhiskerNode{Whisker: w}
+    for _, child := range node.Children {
+        copied.Children = append(copied.Children, wt.clone(child, copies))
+    }
+    copies[node] = copied
+    return copied
+}
+
+// Walk calls fn for every node of the tree in depth-first order, along with its depth and parent
+func (wt *WhiskerTree) Walk(fn func(node *WhiskerNode, depth int, parent *WhiskerNode)) {
+    wt.walk(wt.Root, 0, nil, fn)
+}
+
+// walk is a recursive function that visits a node and its descendants
+func (wt *WhiskerTree) walk(node *WhiskerNode, depth int, parent *WhiskerNode, fn func(node *WhiskerNode, depth int, parent *WhiskerNode)) {
+    fn(node, depth, parent)
+    for _, child := range node.Children {
+        wt.walk(child, depth+1, node, fn)
     }
+}
 
-    return nil, errors.New("memory not found in the tree")
+// Len returns the number of whiskers in the tree
+func (wt *WhiskerTree) Len() int {
+    count := 0
+    wt.Walk(func(*WhiskerNode, int, *WhiskerNode) { count++ })
+    return count
 }
 
 // String returns a string representation of the whisker tree